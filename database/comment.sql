@@ -1,8 +0,0 @@
-CREATE TABLE `comment` (
-  `id` int(11) NOT NULL AUTO_INCREMENT,
-  `user_id` int(11) NOT NULL,
-  `weibo_id` int(11) NOT NULL,
-  `contebt` varchar(45) CHARACTER SET latin1 NOT NULL,
-  `created_at` int(11) NOT NULL DEFAULT '0',
-  PRIMARY KEY (`id`,`weibo_id`)
-) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin;
\ No newline at end of file
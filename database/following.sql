@@ -1,6 +0,0 @@
-CREATE TABLE `following` (
-  `from_user_id` int(11) NOT NULL,
-  `to_user_id` int(11) NOT NULL,
-  `created_at` int(11) NOT NULL DEFAULT '0',
-  PRIMARY KEY (`from_user_id`,`to_user_id`)
-) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin;
\ No newline at end of file
@@ -0,0 +1,21 @@
+// 缓存的读写, 有redis和进程内LRU两种实现
+// 仓库通过Loader读取缓存, 缓存不存在或者不可用时从数据库加载
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// 缓存中没有这个key
+var ErrMiss = errors.New("缓存不存在")
+
+type Cache interface {
+	// 读取缓存, 不存在时返回ErrMiss
+	Get(ctx context.Context, key string) ([]byte, error)
+	// 写入缓存, ttl之后过期
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Del(ctx context.Context, keys ...string) error
+}
@@ -0,0 +1,237 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+func TestLRU(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1000, 0)
+	c := NewLRU(2)
+	c.now = func() time.Time { return now }
+
+	c.Set(ctx, "a", []byte("1"), time.Minute)
+	c.Set(ctx, "b", []byte("2"), time.Minute)
+	if _, err := c.Get(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	// b最久没有访问, 被淘汰
+	c.Set(ctx, "c", []byte("3"), time.Second)
+	if _, err := c.Get(ctx, "b"); err != ErrMiss {
+		t.Fatal("超过容量时没有淘汰最久没有访问的key", err)
+	}
+	if c.Len() != 2 {
+		t.Fatal("缓存的条数不正确", c.Len())
+	}
+
+	now = now.Add(time.Second)
+	if _, err := c.Get(ctx, "c"); err != ErrMiss {
+		t.Fatal("过期的key仍然可以读到", err)
+	}
+	value, err := c.Get(ctx, "a")
+	if err != nil || string(value) != "1" {
+		t.Fatal("没有过期的key读取不正确", string(value), err)
+	}
+
+	c.Del(ctx, "a")
+	if _, err := c.Get(ctx, "a"); err != ErrMiss {
+		t.Fatal("删除后仍然可以读到", err)
+	}
+}
+
+func TestLoaderCoalesces(t *testing.T) {
+	ctx := context.Background()
+	loader := NewLoader(NewLRU(10))
+
+	var calls int32
+	release := make(chan struct{})
+	load := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return []int64{1, 2, 3}, nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([][]int64, 10)
+	errs := make([]error, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = loader.Load(ctx, "k", time.Minute, &results[i], load)
+		}(i)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Fatal("并发的请求没有合并", calls)
+	}
+	for i := range results {
+		if errs[i] != nil || len(results[i]) != 3 {
+			t.Fatal("共享的结果不正确", results[i], errs[i])
+		}
+	}
+
+	// 之后的读取直接命中缓存
+	var ids []int64
+	if err := loader.Load(ctx, "k", time.Minute, &ids, load); err != nil || len(ids) != 3 || calls != 1 {
+		t.Fatal("没有命中缓存", ids, err, calls)
+	}
+}
+
+func TestLoaderWaiterCanceled(t *testing.T) {
+	loader := NewLoader(NewLRU(10))
+	release := make(chan struct{})
+	defer close(release)
+
+	go loader.Load(context.Background(), "k", time.Minute, new(int), func(ctx context.Context) (interface{}, error) {
+		<-release
+		return 1, nil
+	})
+	time.Sleep(10 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := loader.Load(ctx, "k", time.Minute, new(int), func(ctx context.Context) (interface{}, error) {
+		return 1, nil
+	})
+	if err != context.DeadlineExceeded {
+		t.Fatal("等待其它请求时超时应该返回ctx的错误", err)
+	}
+}
+
+// 可以模拟redis故障的缓存
+type flakyCache struct {
+	*LRU
+	down bool
+	dels [][]string
+}
+
+var errDown = errors.New("down")
+
+func (c *flakyCache) Get(ctx context.Context, key string) ([]byte, error) {
+	if c.down {
+		return nil, errDown
+	}
+	return c.LRU.Get(ctx, key)
+}
+
+func (c *flakyCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if c.down {
+		return errDown
+	}
+	return c.LRU.Set(ctx, key, value, ttl)
+}
+
+func (c *flakyCache) Del(ctx context.Context, keys ...string) error {
+	if c.down {
+		return errDown
+	}
+	c.dels = append(c.dels, keys)
+	return c.LRU.Del(ctx, keys...)
+}
+
+func TestDegrading(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1000, 0)
+	inner := &flakyCache{LRU: NewLRU(10)}
+	d := NewDegrading(inner)
+	d.now = func() time.Time { return now }
+
+	inner.Set(ctx, "a", []byte("1"), time.Hour)
+	inner.down = true
+	if _, err := d.Get(ctx, "a"); err != ErrMiss {
+		t.Fatal("缓存不可用时应该当作不存在", err)
+	}
+	if !d.Degraded() {
+		t.Fatal("出错后没有降级")
+	}
+
+	// 降级期间不访问缓存, 删除记录下来
+	inner.down = false
+	if _, err := d.Get(ctx, "a"); err != ErrMiss {
+		t.Fatal("降级期间仍然读取了缓存", err)
+	}
+	if err := d.Del(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if len(inner.dels) != 0 {
+		t.Fatal("降级期间仍然访问了缓存")
+	}
+
+	// 恢复时先补做删除
+	now = now.Add(d.RetryInterval)
+	if _, err := d.Get(ctx, "a"); err != ErrMiss {
+		t.Fatal("降级期间删除的key恢复后仍然可以读到", err)
+	}
+	if len(inner.dels) != 1 || inner.dels[0][0] != "a" {
+		t.Fatal("恢复时没有补做删除", inner.dels)
+	}
+	if d.Degraded() {
+		t.Fatal("恢复后仍然是降级状态")
+	}
+	d.Set(ctx, "b", []byte("2"), time.Hour)
+	if value, err := d.Get(ctx, "b"); err != nil || string(value) != "2" {
+		t.Fatal("恢复后读写不正确", string(value), err)
+	}
+}
+
+func TestDegradingFlushFails(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1000, 0)
+	inner := &flakyCache{LRU: NewLRU(10), down: true}
+	d := NewDegrading(inner)
+	d.now = func() time.Time { return now }
+
+	d.Del(ctx, "a")
+	now = now.Add(d.RetryInterval)
+	if _, err := d.Get(ctx, "a"); err != ErrMiss || !d.Degraded() {
+		t.Fatal("补做删除失败时应该继续降级", err)
+	}
+
+	inner.down = false
+	now = now.Add(d.RetryInterval)
+	d.Get(ctx, "a")
+	if len(inner.dels) != 1 || inner.dels[0][0] != "a" {
+		t.Fatal("再次恢复时没有补做删除", inner.dels)
+	}
+}
+
+// WEIBO_TEST_REDIS_ADDR=127.0.0.1:6379 go test cache
+func TestRedisCache(t *testing.T) {
+	addr := os.Getenv("WEIBO_TEST_REDIS_ADDR")
+	if addr == "" {
+		t.Skip("没有配置WEIBO_TEST_REDIS_ADDR")
+	}
+	pool := &redis.Pool{
+		MaxIdle: 2,
+		Dial:    func() (redis.Conn, error) { return redis.Dial("tcp", addr) },
+	}
+	defer pool.Close()
+
+	ctx := context.Background()
+	c := NewRedisCache(pool)
+	c.Del(ctx, "cache_test")
+	if _, err := c.Get(ctx, "cache_test"); err != ErrMiss {
+		t.Fatal("不存在的key应该返回ErrMiss", err)
+	}
+	if err := c.Set(ctx, "cache_test", []byte("1"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := c.Get(ctx, "cache_test"); err != nil || string(value) != "1" {
+		t.Fatal("读取不正确", string(value), err)
+	}
+	if err := c.Del(ctx, "cache_test"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,140 @@
+package cache
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+var _ Cache = new(Degrading)
+
+// 缓存不可用时降级: 出错后的RetryInterval内不再访问缓存, 读取返回ErrMiss, 由调用方直接查数据库
+// 降级期间的删除会记录下来, 恢复时先补做这些删除, 避免恢复后读到降级期间被修改过的旧数据
+type Degrading struct {
+	cache Cache
+
+	// 降级后多久重新尝试访问缓存
+	RetryInterval time.Duration
+	// 最多记录的待删除key数, 超过后恢复时只能等这些缓存自然过期
+	MaxPending int
+
+	mu        sync.Mutex
+	downUntil time.Time
+	pending   map[string]struct{}
+	overflow  bool
+	now       func() time.Time
+}
+
+func NewDegrading(cache Cache) *Degrading {
+	return &Degrading{
+		cache:         cache,
+		RetryInterval: 5 * time.Second,
+		MaxPending:    10000,
+		pending:       map[string]struct{}{},
+		now:           time.Now,
+	}
+}
+
+func (d *Degrading) Get(ctx context.Context, key string) ([]byte, error) {
+	if !d.available(ctx) {
+		return nil, ErrMiss
+	}
+	value, err := d.cache.Get(ctx, key)
+	if err != nil && err != ErrMiss {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		d.fail(err)
+		return nil, ErrMiss
+	}
+	return value, err
+}
+
+func (d *Degrading) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if !d.available(ctx) {
+		return nil
+	}
+	if err := d.cache.Set(ctx, key, value, ttl); err != nil && ctx.Err() == nil {
+		d.fail(err)
+	}
+	return nil
+}
+
+func (d *Degrading) Del(ctx context.Context, keys ...string) error {
+	if !d.available(ctx) {
+		d.remember(keys)
+		return nil
+	}
+	if err := d.cache.Del(ctx, keys...); err != nil {
+		d.fail(err)
+		d.remember(keys)
+	}
+	return nil
+}
+
+// 当前是否处于降级状态
+func (d *Degrading) Degraded() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.now().Before(d.downUntil)
+}
+
+func (d *Degrading) available(ctx context.Context) bool {
+	d.mu.Lock()
+	if d.now().Before(d.downUntil) {
+		d.mu.Unlock()
+		return false
+	}
+	if len(d.pending) == 0 && !d.overflow {
+		d.mu.Unlock()
+		return true
+	}
+
+	// 补做降级期间的删除, 完成之前其它请求继续降级
+	keys := make([]string, 0, len(d.pending))
+	for key := range d.pending {
+		keys = append(keys, key)
+	}
+	overflow := d.overflow
+	d.pending = map[string]struct{}{}
+	d.overflow = false
+	d.downUntil = d.now().Add(d.RetryInterval)
+	d.mu.Unlock()
+
+	if err := d.cache.Del(ctx, keys...); err != nil {
+		d.fail(err)
+		d.remember(keys)
+		return false
+	}
+	if overflow {
+		log.Printf("缓存降级期间的删除超过 %d 个, 部分缓存在过期之前可能是旧数据\n", d.MaxPending)
+	}
+	log.Println("缓存已经恢复")
+
+	d.mu.Lock()
+	d.downUntil = time.Time{}
+	d.mu.Unlock()
+	return true
+}
+
+func (d *Degrading) fail(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.now().Before(d.downUntil) {
+		log.Printf("缓存不可用, %v 内改为直接查询数据库: %v\n", d.RetryInterval, err)
+	}
+	d.downUntil = d.now().Add(d.RetryInterval)
+}
+
+func (d *Degrading) remember(keys []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, key := range keys {
+		if len(d.pending) >= d.MaxPending {
+			d.overflow = true
+			return
+		}
+		d.pending[key] = struct{}{}
+	}
+}
@@ -0,0 +1,70 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// 先读缓存, 没有时调用load从数据库加载并写入缓存
+// 同一个key同时只有一个请求在加载, 其它请求等待它的结果
+type Loader struct {
+	cache Cache
+	group Group
+}
+
+func NewLoader(cache Cache) *Loader {
+	return &Loader{cache: cache}
+}
+
+// 结果以json保存在缓存中, 解码到dest
+// 读写缓存失败时只记录日志, 结果以数据库为准
+func (l *Loader) Load(ctx context.Context, key string, ttl time.Duration, dest interface{}, load func(ctx context.Context) (interface{}, error)) error {
+	data, err := l.cache.Get(ctx, key)
+	if err == nil {
+		if err := json.Unmarshal(data, dest); err == nil {
+			return nil
+		}
+		log.Printf("缓存 %s 的内容无法解析, 重新加载\n", key)
+	} else if err != ErrMiss {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Printf("读取缓存 %s 失败: %v\n", key, err)
+	}
+
+	value, shared, err := l.group.Do(ctx, key, func() (interface{}, error) {
+		return l.load(ctx, key, ttl, load)
+	})
+	// 等待的是其它请求的加载, 那个请求取消或者超时了, 自己重新加载
+	if shared && isContextError(err) && ctx.Err() == nil {
+		value, err = l.load(ctx, key, ttl, load)
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(value.([]byte), dest)
+}
+
+func (l *Loader) load(ctx context.Context, key string, ttl time.Duration, load func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	value, err := load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, errors.Wrapf(err, "缓存 %s 编码失败", key)
+	}
+	if err := l.cache.Set(ctx, key, data, ttl); err != nil && ctx.Err() == nil {
+		log.Printf("写入缓存 %s 失败: %v\n", key, err)
+	}
+	return data, nil
+}
+
+func isContextError(err error) bool {
+	cause := errors.Cause(err)
+	return cause == context.Canceled || cause == context.DeadlineExceeded
+}
@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+var _ Cache = new(RedisCache)
+
+// 基于redis连接池的缓存, 每次操作从连接池中取一个连接, 可以在多个goroutine中使用
+type RedisCache struct {
+	pool *redis.Pool
+}
+
+func NewRedisCache(pool *redis.Pool) *RedisCache {
+	return &RedisCache{pool: pool}
+}
+
+func (c *RedisCache) Get(ctx context.Context, key string) ([]byte, error) {
+	conn, err := c.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	value, err := redis.Bytes(DoContext(ctx, conn, "GET", key))
+	if err == redis.ErrNil {
+		return nil, ErrMiss
+	}
+	return value, err
+}
+
+func (c *RedisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	conn, err := c.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = DoContext(ctx, conn, "SET", key, value, "PX", int64(ttl/time.Millisecond))
+	return err
+}
+
+func (c *RedisCache) Del(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	conn, err := c.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = DoContext(ctx, conn, "DEL", redis.Args{}.AddFlat(keys)...)
+	return err
+}
+
+// 执行redis命令, 读取超时不超过ctx的截止时间, ctx已经结束时直接返回ctx的错误
+// 超时后连接不能再使用, 只能用于从连接池中取出的连接
+func DoContext(ctx context.Context, conn redis.Conn, command string, args ...interface{}) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return conn.Do(command, args...)
+	}
+
+	timeout := time.Until(deadline)
+	if timeout <= 0 {
+		return nil, context.DeadlineExceeded
+	}
+	reply, err := redis.DoWithTimeout(conn, timeout, command, args...)
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return reply, err
+}
@@ -0,0 +1,56 @@
+package cache
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+var errPanicked = errors.New("缓存加载时发生panic")
+
+// 合并同一个key上同时进行的调用, 只有第一个调用执行fn, 其它调用等待并共享它的结果
+// 用于缓存失效时避免大量请求同时查询数据库
+type Group struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+type call struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+// 执行fn并返回结果, shared表示结果是否来自其它调用
+// 等待其它调用时ctx结束会直接返回ctx的错误, 不影响正在执行的fn
+func (g *Group) Do(ctx context.Context, key string, fn func() (interface{}, error)) (value interface{}, shared bool, err error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*call{}
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		select {
+		case <-c.done:
+			return c.value, true, c.err
+		case <-ctx.Done():
+			return nil, true, ctx.Err()
+		}
+	}
+
+	c := &call{done: make(chan struct{})}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(c.done)
+	}()
+	// fn发生panic时等待的调用返回这个错误
+	c.err = errPanicked
+	c.value, c.err = fn()
+	return c.value, false, c.err
+}
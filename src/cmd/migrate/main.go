@@ -0,0 +1,87 @@
+// 数据库迁移工具
+// migrate [-profile prod] [-mysql-dsn ...] up|down [n]|status
+package main
+
+import (
+	"config"
+	"database/sql"
+	"fmt"
+	"log"
+	"migrations"
+	"os"
+	"strconv"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+func main() {
+	cfg, args, err := config.LoadArgs(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.Storage != config.StorageMySQL {
+		log.Fatalf("存储为 %s, 不需要迁移", cfg.Storage)
+	}
+	if len(args) == 0 {
+		log.Fatal("用法: migrate [参数] up|down [n]|status")
+	}
+
+	all, err := migrations.All()
+	if err != nil {
+		log.Fatal(err)
+	}
+	db, err := sql.Open("mysql", cfg.MySQL.DSN)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	migrator := migrations.NewMigrator(db, all)
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up()
+		for _, m := range applied {
+			fmt.Printf("已执行 %d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(applied) == 0 {
+			fmt.Println("已经是最新版本")
+		}
+
+	case "down":
+		n := 1
+		if len(args) > 1 {
+			if n, err = strconv.Atoi(args[1]); err != nil || n <= 0 {
+				log.Fatalf("回滚的个数不正确: %s", args[1])
+			}
+		}
+		reverted, err := migrator.Down(n)
+		for _, m := range reverted {
+			fmt.Printf("已回滚 %d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, s := range statuses {
+			state := "未执行"
+			if s.Dirty {
+				state = "执行失败"
+			} else if s.Applied {
+				state = "已执行 " + time.Unix(s.AppliedAt, 0).Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%s\t%s\n", s.Migration.Version, s.Migration.Name, state)
+		}
+
+	default:
+		log.Fatalf("未知的命令: %s", args[0])
+	}
+}
@@ -0,0 +1,494 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// 支持的运行环境
+const (
+	ProfileDev  = "dev"
+	ProfileTest = "test"
+	ProfileProd = "prod"
+)
+
+// 存储的实现
+const (
+	StorageMySQL  = "mysql"
+	StorageMemory = "memory"
+)
+
+// mysql存储使用的缓存
+const (
+	CacheRedis = "redis"
+	CacheLRU   = "lru" // 进程内缓存, 只适合单实例部署
+)
+
+// 密码哈希算法
+const (
+	PasswordBcrypt   = "bcrypt"
+	PasswordArgon2id = "argon2id"
+)
+
+// 默认的session密钥, 只能在开发环境使用
+const defaultSessionSecret = "test"
+
+// 服务的全部配置
+// 加载顺序: 默认值 -> config.yaml -> config.<profile>.yaml -> 环境变量 -> 命令行参数, 后面的覆盖前面的
+type Config struct {
+	Profile string `yaml:"-"`
+	// mysql + redis, 或者全部放在内存中用于本地开发
+	Storage string `yaml:"storage" env:"WEIBO_STORAGE"`
+
+	HTTP     HTTPConfig     `yaml:"http"`
+	MySQL    MySQLConfig    `yaml:"mysql"`
+	Redis    RedisConfig    `yaml:"redis"`
+	Session  SessionConfig  `yaml:"session"`
+	Password PasswordConfig `yaml:"password"`
+	Cache    CacheConfig    `yaml:"cache"`
+	Page     PageConfig     `yaml:"page"`
+	Timeline TimelineConfig `yaml:"timeline"`
+	Fanout   FanoutConfig   `yaml:"fanout"`
+	ID       IDConfig       `yaml:"id"`
+	Trend    TrendConfig    `yaml:"trend"`
+	Stream   StreamConfig   `yaml:"stream"`
+}
+
+type HTTPConfig struct {
+	Addr string `yaml:"addr" env:"WEIBO_HTTP_ADDR"`
+	// html模板和静态文件所在的目录
+	TemplateDir string `yaml:"template_dir" env:"WEIBO_HTTP_TEMPLATE_DIR"`
+	// 每个请求的处理时间上限, 超时后取消mysql和redis的调用
+	RequestTimeout time.Duration `yaml:"request_timeout" env:"WEIBO_HTTP_REQUEST_TIMEOUT"`
+	// 调试接口单独监听的地址, 不经过登录, 只能绑定在内网地址上, 为空时不开启
+	DebugAddr string `yaml:"debug_addr" env:"WEIBO_HTTP_DEBUG_ADDR"`
+}
+
+type MySQLConfig struct {
+	DSN             string        `yaml:"dsn" env:"WEIBO_MYSQL_DSN"`
+	MaxOpenConns    int           `yaml:"max_open_conns" env:"WEIBO_MYSQL_MAX_OPEN_CONNS"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" env:"WEIBO_MYSQL_MAX_IDLE_CONNS"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" env:"WEIBO_MYSQL_CONN_MAX_LIFETIME"`
+}
+
+type RedisConfig struct {
+	Addr        string        `yaml:"addr" env:"WEIBO_REDIS_ADDR"`
+	Password    string        `yaml:"password" env:"WEIBO_REDIS_PASSWORD"`
+	DB          int           `yaml:"db" env:"WEIBO_REDIS_DB"`
+	MaxIdle     int           `yaml:"max_idle" env:"WEIBO_REDIS_MAX_IDLE"`
+	MaxActive   int           `yaml:"max_active" env:"WEIBO_REDIS_MAX_ACTIVE"`
+	IdleTimeout time.Duration `yaml:"idle_timeout" env:"WEIBO_REDIS_IDLE_TIMEOUT"`
+	// 连接和读写的超时
+	Timeout time.Duration `yaml:"timeout" env:"WEIBO_REDIS_TIMEOUT"`
+}
+
+type SessionConfig struct {
+	Secret string `yaml:"secret" env:"WEIBO_SESSION_SECRET"`
+}
+
+// 新密码使用的哈希算法和参数, 已有的哈希在登录成功时按这里的配置重新生成
+type PasswordConfig struct {
+	// 哈希算法: bcrypt, argon2id
+	Algorithm  string `yaml:"algorithm" env:"WEIBO_PASSWORD_ALGORITHM"`
+	BcryptCost int    `yaml:"bcrypt_cost" env:"WEIBO_PASSWORD_BCRYPT_COST"`
+	// argon2id的迭代次数, 内存(KiB)和并行度
+	Argon2Time    int `yaml:"argon2_time" env:"WEIBO_PASSWORD_ARGON2_TIME"`
+	Argon2Memory  int `yaml:"argon2_memory" env:"WEIBO_PASSWORD_ARGON2_MEMORY"`
+	Argon2Threads int `yaml:"argon2_threads" env:"WEIBO_PASSWORD_ARGON2_THREADS"`
+}
+
+type CacheConfig struct {
+	// 缓存的实现: redis, lru
+	Backend string `yaml:"backend" env:"WEIBO_CACHE_BACKEND"`
+	// lru缓存最多保存的条数
+	LRUSize int `yaml:"lru_size" env:"WEIBO_CACHE_LRU_SIZE"`
+	// redis不可用时直接查询mysql, 过多久重新尝试redis
+	DegradeRetry time.Duration `yaml:"degrade_retry" env:"WEIBO_CACHE_DEGRADE_RETRY"`
+	// 粉丝列表第一页的缓存时间
+	FollowersTTL time.Duration `yaml:"followers_ttl" env:"WEIBO_CACHE_FOLLOWERS_TTL"`
+	// 缓存的粉丝数, 第一页超过这个条数时直接查询数据库
+	FollowersSize int64 `yaml:"followers_size" env:"WEIBO_CACHE_FOLLOWERS_SIZE"`
+	// 单条微博的缓存时间
+	WeiboTTL time.Duration `yaml:"weibo_ttl" env:"WEIBO_CACHE_WEIBO_TTL"`
+	// timeline缓存的过期时间和每个用户最多缓存的条数
+	TimelineTTL      time.Duration `yaml:"timeline_ttl" env:"WEIBO_CACHE_TIMELINE_TTL"`
+	TimelineCapacity int64         `yaml:"timeline_capacity" env:"WEIBO_CACHE_TIMELINE_CAPACITY"`
+	// 未读通知数的缓存时间
+	UnreadTTL time.Duration `yaml:"unread_ttl" env:"WEIBO_CACHE_UNREAD_TTL"`
+	// 用户的静音列表的缓存时间
+	MutesTTL time.Duration `yaml:"mutes_ttl" env:"WEIBO_CACHE_MUTES_TTL"`
+}
+
+type PageConfig struct {
+	// 列表默认每页的条数
+	Size int64 `yaml:"size" env:"WEIBO_PAGE_SIZE"`
+	// 接口允许的每页最大条数
+	MaxSize int64 `yaml:"max_size" env:"WEIBO_PAGE_MAX_SIZE"`
+	// 首页展示的粉丝数
+	Followers int64 `yaml:"followers" env:"WEIBO_PAGE_FOLLOWERS"`
+	// 侧边栏展示的话题数
+	Topics int64 `yaml:"topics" env:"WEIBO_PAGE_TOPICS"`
+}
+
+type TimelineConfig struct {
+	// 粉丝数达到这个值的用户改为拉模式, 0表示全部推送
+	PullThreshold int32 `yaml:"pull_threshold" env:"WEIBO_TIMELINE_PULL_THRESHOLD"`
+	// 关注时复制到自己timeline中的微博数
+	FollowBackfill int32 `yaml:"follow_backfill" env:"WEIBO_TIMELINE_FOLLOW_BACKFILL"`
+	// 每个用户最多静音的账号和关键词数, 读取timeline时逐条匹配
+	MaxMutes int `yaml:"max_mutes" env:"WEIBO_TIMELINE_MAX_MUTES"`
+	// 读取一页timeline时为补齐被屏蔽和静音的微博最多扫描的条数
+	ScanLimit int64 `yaml:"scan_limit" env:"WEIBO_TIMELINE_SCAN_LIMIT"`
+}
+
+type IDConfig struct {
+	// snowflake id中的worker id, 0到1023, 多个实例部署时每个实例必须不同
+	WorkerID int64 `yaml:"worker_id" env:"WEIBO_ID_WORKER_ID"`
+	// 时钟回拨不超过这个时间时等待, 超过时生成id失败
+	MaxClockBackwards time.Duration `yaml:"max_clock_backwards" env:"WEIBO_ID_MAX_CLOCK_BACKWARDS"`
+}
+
+type FanoutConfig struct {
+	Concurrency int           `yaml:"concurrency" env:"WEIBO_FANOUT_CONCURRENCY"`
+	BatchSize   int64         `yaml:"batch_size" env:"WEIBO_FANOUT_BATCH_SIZE"`
+	MaxAttempts int           `yaml:"max_attempts" env:"WEIBO_FANOUT_MAX_ATTEMPTS"`
+	RetryDelay  time.Duration `yaml:"retry_delay" env:"WEIBO_FANOUT_RETRY_DELAY"`
+}
+
+type TrendConfig struct {
+	// 热度计数的时间桶长度
+	Bucket time.Duration `yaml:"bucket" env:"WEIBO_TREND_BUCKET"`
+	// 参与排行的时间范围
+	Window time.Duration `yaml:"window" env:"WEIBO_TREND_WINDOW"`
+	// 热度衰减一半需要的时间
+	HalfLife time.Duration `yaml:"half_life" env:"WEIBO_TREND_HALF_LIFE"`
+	// 重新计算排行榜的间隔
+	Interval time.Duration `yaml:"interval" env:"WEIBO_TREND_INTERVAL"`
+	// 排行榜保留的条数
+	Size int64 `yaml:"size" env:"WEIBO_TREND_SIZE"`
+	// 热门微博页展示的微博数
+	HotWeibos int64 `yaml:"hot_weibos" env:"WEIBO_TREND_HOT_WEIBOS"`
+}
+
+type StreamConfig struct {
+	// 没有事件时发送心跳的间隔, 防止代理断开空闲的连接
+	Heartbeat time.Duration `yaml:"heartbeat" env:"WEIBO_STREAM_HEARTBEAT"`
+	// 每个用户保留的最近事件数和保留时间, 断线重连时按Last-Event-ID补发
+	History    int64         `yaml:"history" env:"WEIBO_STREAM_HISTORY"`
+	HistoryTTL time.Duration `yaml:"history_ttl" env:"WEIBO_STREAM_HISTORY_TTL"`
+	// 每个连接缓冲的事件数, 客户端读得太慢写满时断开, 重连后补发
+	Buffer int `yaml:"buffer" env:"WEIBO_STREAM_BUFFER"`
+}
+
+// 默认配置, 和开发环境的本地mysql和redis对应
+func Default() *Config {
+	return &Config{
+		Profile: ProfileDev,
+		Storage: StorageMySQL,
+		HTTP: HTTPConfig{
+			Addr:           ":8080",
+			TemplateDir:    "html",
+			RequestTimeout: 10 * time.Second,
+		},
+		MySQL: MySQLConfig{
+			DSN:             "root:@tcp(127.0.0.1:3306)/weibo",
+			MaxOpenConns:    20,
+			MaxIdleConns:    10,
+			ConnMaxLifetime: time.Hour,
+		},
+		Redis: RedisConfig{
+			Addr:        "127.0.0.1:6379",
+			MaxIdle:     10,
+			MaxActive:   50,
+			IdleTimeout: 240 * time.Second,
+			Timeout:     time.Second,
+		},
+		Session: SessionConfig{
+			Secret: defaultSessionSecret,
+		},
+		Password: PasswordConfig{
+			Algorithm:     PasswordArgon2id,
+			BcryptCost:    10,
+			Argon2Time:    3,
+			Argon2Memory:  64 * 1024,
+			Argon2Threads: 2,
+		},
+		Cache: CacheConfig{
+			Backend:          CacheRedis,
+			LRUSize:          10000,
+			DegradeRetry:     5 * time.Second,
+			FollowersTTL:     300 * time.Second,
+			FollowersSize:    100,
+			WeiboTTL:         60 * time.Second,
+			TimelineTTL:      7 * 24 * time.Hour,
+			TimelineCapacity: 800,
+			UnreadTTL:        300 * time.Second,
+			MutesTTL:         300 * time.Second,
+		},
+		Page: PageConfig{
+			Size:      15,
+			MaxSize:   100,
+			Followers: 20,
+			Topics:    10,
+		},
+		Timeline: TimelineConfig{
+			PullThreshold:  10000,
+			FollowBackfill: 30,
+			MaxMutes:       100,
+			ScanLimit:      200,
+		},
+		Fanout: FanoutConfig{
+			Concurrency: 4,
+			BatchSize:   500,
+			MaxAttempts: 5,
+			RetryDelay:  time.Second,
+		},
+		ID: IDConfig{
+			MaxClockBackwards: 10 * time.Millisecond,
+		},
+		Trend: TrendConfig{
+			Bucket:    5 * time.Minute,
+			Window:    24 * time.Hour,
+			HalfLife:  2 * time.Hour,
+			Interval:  time.Minute,
+			Size:      1000,
+			HotWeibos: 50,
+		},
+		Stream: StreamConfig{
+			Heartbeat:  15 * time.Second,
+			History:    100,
+			HistoryTTL: time.Hour,
+			Buffer:     64,
+		},
+	}
+}
+
+// 可以直接覆盖配置的命令行参数
+var stringFlags = []struct {
+	name   string
+	usage  string
+	target func(cfg *Config) *string
+}{
+	{"storage", "存储的实现: mysql, memory", func(cfg *Config) *string { return &cfg.Storage }},
+	{"addr", "监听地址", func(cfg *Config) *string { return &cfg.HTTP.Addr }},
+	{"template-dir", "html模板所在的目录", func(cfg *Config) *string { return &cfg.HTTP.TemplateDir }},
+	{"mysql-dsn", "mysql连接串", func(cfg *Config) *string { return &cfg.MySQL.DSN }},
+	{"redis-addr", "redis地址", func(cfg *Config) *string { return &cfg.Redis.Addr }},
+}
+
+// 解析命令行参数并加载配置
+// -config 指定配置文件所在的目录, -profile 指定运行环境, 其余参数直接覆盖对应的配置
+func Load(args []string) (*Config, error) {
+	cfg, _, err := LoadArgs(args)
+	return cfg, err
+}
+
+// 和Load一样, 另外返回参数之后剩下的位置参数, 用于带子命令的程序
+func LoadArgs(args []string) (*Config, []string, error) {
+	fs := flag.NewFlagSet("weibo", flag.ContinueOnError)
+	dir := fs.String("config", envOr("WEIBO_CONFIG_DIR", "config"), "配置文件所在的目录")
+	profile := fs.String("profile", envOr("WEIBO_PROFILE", ProfileDev), "运行环境: dev, test, prod")
+	values := make(map[string]*string, len(stringFlags))
+	for _, f := range stringFlags {
+		values[f.name] = fs.String(f.name, "", f.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	cfg, err := LoadFiles(*dir, *profile)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := cfg.applyEnv(os.LookupEnv); err != nil {
+		return nil, nil, err
+	}
+
+	// 只覆盖命令行中出现过的参数
+	fs.Visit(func(visited *flag.Flag) {
+		for _, f := range stringFlags {
+			if f.name == visited.Name {
+				*f.target(cfg) = *values[f.name]
+			}
+		}
+	})
+
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return cfg, fs.Args(), nil
+}
+
+// 从目录中依次读取config.yaml和config.<profile>.yaml, 文件不存在时跳过
+func LoadFiles(dir, profile string) (*Config, error) {
+	switch profile {
+	case ProfileDev, ProfileTest, ProfileProd:
+	default:
+		return nil, errors.Errorf("未知的运行环境: %s", profile)
+	}
+
+	cfg := Default()
+	for _, name := range []string{"config.yaml", "config." + profile + ".yaml"} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "读取配置文件 %s 失败", name)
+		}
+		if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+			return nil, errors.Wrapf(err, "解析配置文件 %s 失败", name)
+		}
+	}
+	cfg.Profile = profile
+	return cfg, nil
+}
+
+// 用环境变量覆盖配置, 环境变量名写在字段的env标签中
+func (cfg *Config) applyEnv(lookup func(string) (string, bool)) error {
+	return applyEnv(reflect.ValueOf(cfg).Elem(), lookup)
+}
+
+func applyEnv(v reflect.Value, lookup func(string) (string, bool)) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			if err := applyEnv(field, lookup); err != nil {
+				return err
+			}
+			continue
+		}
+
+		name := t.Field(i).Tag.Get("env")
+		if name == "" {
+			continue
+		}
+		value, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if err := setValue(field, value); err != nil {
+			return errors.Wrapf(err, "环境变量 %s 的值无效", name)
+		}
+	}
+	return nil
+}
+
+func setValue(field reflect.Value, value string) error {
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	default:
+		return errors.Errorf("不支持的配置类型: %s", field.Type())
+	}
+	return nil
+}
+
+// 启动时检查配置, 一次返回所有的问题
+func (cfg *Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(cfg.Storage == StorageMySQL || cfg.Storage == StorageMemory, "storage 只能是mysql或者memory")
+	check(cfg.Profile != ProfileProd || cfg.Storage != StorageMemory, "prod环境不能使用内存存储")
+	check(cfg.HTTP.Addr != "", "http.addr 不能为空")
+	check(cfg.HTTP.TemplateDir != "", "http.template_dir 不能为空")
+	check(cfg.HTTP.RequestTimeout > 0, "http.request_timeout 必须大于0")
+	check(cfg.HTTP.DebugAddr == "" || cfg.HTTP.DebugAddr != cfg.HTTP.Addr, "http.debug_addr 不能和http.addr相同")
+	// 内存存储不需要mysql和redis
+	if cfg.Storage == StorageMySQL {
+		check(cfg.MySQL.DSN != "", "mysql.dsn 不能为空")
+		check(cfg.MySQL.MaxOpenConns > 0, "mysql.max_open_conns 必须大于0")
+		check(cfg.MySQL.MaxIdleConns >= 0 && cfg.MySQL.MaxIdleConns <= cfg.MySQL.MaxOpenConns, "mysql.max_idle_conns 必须在0到max_open_conns之间")
+		check(cfg.Redis.Addr != "", "redis.addr 不能为空")
+		check(cfg.Redis.MaxIdle > 0, "redis.max_idle 必须大于0")
+		check(cfg.Redis.MaxActive >= 0, "redis.max_active 不能小于0")
+		check(cfg.Redis.Timeout > 0, "redis.timeout 必须大于0")
+		check(cfg.Cache.Backend == CacheRedis || cfg.Cache.Backend == CacheLRU, "cache.backend 只能是redis或者lru")
+		check(cfg.Cache.Backend != CacheLRU || cfg.Cache.LRUSize > 0, "cache.lru_size 必须大于0")
+		check(cfg.Cache.DegradeRetry > 0, "cache.degrade_retry 必须大于0")
+	}
+	check(cfg.Session.Secret != "", "session.secret 不能为空")
+	check(cfg.Profile != ProfileProd || (cfg.Session.Secret != defaultSessionSecret && len(cfg.Session.Secret) >= 32),
+		"prod环境的session.secret至少32个字符, 并且不能使用默认值")
+	check(cfg.Password.Algorithm == PasswordBcrypt || cfg.Password.Algorithm == PasswordArgon2id, "password.algorithm 只能是bcrypt或者argon2id")
+	check(cfg.Password.BcryptCost >= 4 && cfg.Password.BcryptCost <= 31, "password.bcrypt_cost 必须在4到31之间")
+	// 上限和weibo.MaxArgon2Time, weibo.MaxArgon2Memory一致, 超出时生成的哈希无法校验
+	check(cfg.Password.Argon2Time > 0 && cfg.Password.Argon2Time <= 16, "password.argon2_time 必须在1到16之间")
+	check(cfg.Password.Argon2Memory >= 8*cfg.Password.Argon2Threads, "password.argon2_memory 不能小于8倍的argon2_threads")
+	check(cfg.Password.Argon2Memory <= 1024*1024, "password.argon2_memory 不能超过1048576")
+	check(cfg.Password.Argon2Threads > 0 && cfg.Password.Argon2Threads <= 255, "password.argon2_threads 必须在1到255之间")
+	check(cfg.Cache.FollowersTTL > 0, "cache.followers_ttl 必须大于0")
+	check(cfg.Cache.FollowersSize >= cfg.Page.Followers, "cache.followers_size 不能小于page.followers")
+	check(cfg.Cache.WeiboTTL > 0, "cache.weibo_ttl 必须大于0")
+	check(cfg.Cache.TimelineTTL > 0, "cache.timeline_ttl 必须大于0")
+	check(cfg.Cache.TimelineCapacity > 0, "cache.timeline_capacity 必须大于0")
+	check(cfg.Cache.UnreadTTL > 0, "cache.unread_ttl 必须大于0")
+	check(cfg.Cache.MutesTTL > 0, "cache.mutes_ttl 必须大于0")
+	check(cfg.Page.Size > 0, "page.size 必须大于0")
+	check(cfg.Page.MaxSize >= cfg.Page.Size, "page.max_size 不能小于page.size")
+	check(cfg.Page.Followers > 0, "page.followers 必须大于0")
+	check(cfg.Page.Topics > 0, "page.topics 必须大于0")
+	check(cfg.Timeline.PullThreshold >= 0, "timeline.pull_threshold 不能小于0")
+	check(cfg.Timeline.FollowBackfill >= 0, "timeline.follow_backfill 不能小于0")
+	check(cfg.Timeline.MaxMutes > 0, "timeline.max_mutes 必须大于0")
+	check(cfg.Timeline.ScanLimit > 0, "timeline.scan_limit 必须大于0")
+	check(cfg.ID.WorkerID >= 0 && cfg.ID.WorkerID <= 1023, "id.worker_id 必须在0到1023之间")
+	check(cfg.ID.MaxClockBackwards >= 0, "id.max_clock_backwards 不能小于0")
+	check(cfg.Fanout.Concurrency > 0, "fanout.concurrency 必须大于0")
+	check(cfg.Fanout.BatchSize > 0, "fanout.batch_size 必须大于0")
+	check(cfg.Fanout.MaxAttempts > 0, "fanout.max_attempts 必须大于0")
+	check(cfg.Fanout.RetryDelay > 0, "fanout.retry_delay 必须大于0")
+	check(cfg.Trend.Bucket > 0, "trend.bucket 必须大于0")
+	check(cfg.Trend.Window >= cfg.Trend.Bucket, "trend.window 不能小于trend.bucket")
+	check(cfg.Trend.HalfLife > 0, "trend.half_life 必须大于0")
+	check(cfg.Trend.Interval > 0, "trend.interval 必须大于0")
+	check(cfg.Trend.Size >= cfg.Trend.HotWeibos && cfg.Trend.Size >= cfg.Page.Topics, "trend.size 不能小于trend.hot_weibos和page.topics")
+	check(cfg.Trend.HotWeibos > 0, "trend.hot_weibos 必须大于0")
+	check(cfg.Stream.Heartbeat > 0, "stream.heartbeat 必须大于0")
+	check(cfg.Stream.History > 0, "stream.history 必须大于0")
+	check(cfg.Stream.HistoryTTL > 0, "stream.history_ttl 必须大于0")
+	check(cfg.Stream.Buffer > 0, "stream.buffer 必须大于0")
+
+	if len(problems) > 0 {
+		return errors.Errorf("配置错误:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+func envOr(name, value string) string {
+	if v, ok := os.LookupEnv(name); ok {
+		return v
+	}
+	return value
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, dir, name, content string) {
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "weibo-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeConfig(t, dir, "config.yaml", "http:\n  addr: \":9000\"\ncache:\n  followers_ttl: 60s\n")
+	writeConfig(t, dir, "config.test.yaml", "http:\n  addr: \":9001\"\n")
+
+	cfg, err := LoadFiles(dir, ProfileTest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.HTTP.Addr != ":9001" {
+		t.Fatal("profile中的配置没有覆盖公共配置", cfg.HTTP.Addr)
+	}
+	if cfg.Cache.FollowersTTL != time.Minute {
+		t.Fatal("公共配置没有生效", cfg.Cache.FollowersTTL)
+	}
+	if cfg.Page.Size != 15 {
+		t.Fatal("没有配置的项应该使用默认值", cfg.Page.Size)
+	}
+
+	writeConfig(t, dir, "config.dev.yaml", "http:\n  adr: \":9000\"\n")
+	if _, err := LoadFiles(dir, ProfileDev); err == nil {
+		t.Fatal("拼错的配置项应该报错")
+	}
+	if _, err := LoadFiles(dir, "staging"); err == nil {
+		t.Fatal("未知的运行环境应该报错")
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	env := map[string]string{
+		"WEIBO_MYSQL_DSN":               "user:pass@tcp(db:3306)/weibo",
+		"WEIBO_REDIS_DB":                "2",
+		"WEIBO_CACHE_TIMELINE_TTL":      "1h",
+		"WEIBO_TIMELINE_PULL_THRESHOLD": "500",
+	}
+	lookup := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	cfg := Default()
+	if err := cfg.applyEnv(lookup); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MySQL.DSN != env["WEIBO_MYSQL_DSN"] || cfg.Redis.DB != 2 || cfg.Cache.TimelineTTL != time.Hour || cfg.Timeline.PullThreshold != 500 {
+		t.Fatal("环境变量没有覆盖配置", cfg)
+	}
+
+	env["WEIBO_REDIS_DB"] = "two"
+	if err := cfg.applyEnv(lookup); err == nil {
+		t.Fatal("无效的环境变量应该报错")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Fatal("默认配置应该是有效的", err)
+	}
+
+	cfg := Default()
+	cfg.Profile = ProfileProd
+	cfg.MySQL.DSN = ""
+	cfg.HTTP.DebugAddr = cfg.HTTP.Addr
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("缺少必填项时应该报错")
+	}
+	for _, field := range []string{"mysql.dsn", "session.secret", "http.debug_addr"} {
+		if !strings.Contains(err.Error(), field) {
+			t.Fatal("错误中应该包含所有的问题", err)
+		}
+	}
+}
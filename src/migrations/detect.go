@@ -0,0 +1,61 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+// 0002_fix_schema 修改过的结构, 之前以零散的ALTER脚本提供, 可能已经手动执行过其中一部分
+var fixSchemaMarkers = []struct {
+	description string
+	query       string
+}{
+	{"users.password 长度为255", "SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = 'users' AND column_name = 'password' AND character_maximum_length = 255"},
+	{"weibos.comment_num", "SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = 'weibos' AND column_name = 'comment_num'"},
+	{"weibos.idx_user_created", "SELECT COUNT(*) FROM information_schema.statistics WHERE table_schema = DATABASE() AND table_name = 'weibos' AND index_name = 'idx_user_created'"},
+	{"comment.content", "SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = 'comment' AND column_name = 'content'"},
+	{"timeline.uk_user_weibo", "SELECT COUNT(*) FROM information_schema.statistics WHERE table_schema = DATABASE() AND table_name = 'timeline' AND index_name = 'uk_user_weibo'"},
+	{"timeline.idx_weibo_id", "SELECT COUNT(*) FROM information_schema.statistics WHERE table_schema = DATABASE() AND table_name = 'timeline' AND index_name = 'idx_weibo_id'"},
+}
+
+// 根据现有的表结构判断引入迁移之前的数据库相当于哪个版本, 空库返回0
+func detectVersion(conn *sql.Conn) (int64, error) {
+	exists, err := tableExists(conn, "users")
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	var done, missing []string
+	for _, marker := range fixSchemaMarkers {
+		var n int
+		if err := conn.QueryRowContext(context.Background(), marker.query).Scan(&n); err != nil {
+			return 0, errors.Wrap(err, "查询表结构失败")
+		}
+		if n > 0 {
+			done = append(done, marker.description)
+		} else {
+			missing = append(missing, marker.description)
+		}
+	}
+
+	switch {
+	case len(done) == 0:
+		return 1, nil
+	case len(missing) == 0:
+		return 2, nil
+	default:
+		return 0, errors.Errorf("数据库只执行了部分修复脚本, 请先手动补齐 %v", missing)
+	}
+}
+
+func tableExists(conn *sql.Conn, table string) (bool, error) {
+	var n int
+	err := conn.QueryRowContext(context.Background(),
+		"SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?", table).Scan(&n)
+	if err != nil {
+		return false, errors.Wrap(err, "查询表结构失败")
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,340 @@
+// 数据库结构的版本迁移
+// 迁移脚本放在sql目录中, 文件名为 <版本号>_<名称>.up.sql 和 <版本号>_<名称>.down.sql, 编译时打包进程序
+// 已经执行的版本记录在schema_migrations表中
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"io/fs"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// 一个版本的迁移
+type Migration struct {
+	Version int64
+	Name    string
+	Up      []string // 升级执行的语句
+	Down    []string // 回滚执行的语句
+}
+
+// 某个版本的执行状态
+type Status struct {
+	Migration *Migration
+	Applied   bool
+	Dirty     bool // 执行到一半失败了, 需要人工处理
+	AppliedAt int64
+}
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// 返回打包在程序中的所有迁移, 按版本号升序
+func All() ([]*Migration, error) {
+	return load(files, "sql")
+}
+
+func load(fsys fs.FS, dir string) ([]*Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "读取迁移脚本失败")
+	}
+
+	versions := map[int64]*Migration{}
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, errors.Errorf("迁移脚本的文件名不正确: %s", entry.Name())
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil || version <= 0 {
+			return nil, errors.Errorf("迁移脚本的版本号不正确: %s", entry.Name())
+		}
+		data, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, errors.Wrapf(err, "读取迁移脚本 %s 失败", entry.Name())
+		}
+
+		m, ok := versions[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			versions[version] = m
+		} else if m.Name != match[2] {
+			return nil, errors.Errorf("版本 %d 有多个迁移脚本: %s, %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = splitStatements(string(data))
+		} else {
+			m.Down = splitStatements(string(data))
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(versions))
+	for _, m := range versions {
+		if m.Up == nil || m.Down == nil {
+			return nil, errors.Errorf("版本 %d 缺少up或者down脚本", m.Version)
+		}
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// 把脚本拆分成单条语句, mysql驱动默认不允许一次执行多条语句
+// 以分号结尾的行是一条语句的结束, 忽略空行和 -- 开头的注释
+func splitStatements(script string) []string {
+	statements := []string{}
+	var buf []string
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		buf = append(buf, strings.TrimRight(line, " \t\r"))
+		if strings.HasSuffix(trimmed, ";") {
+			statement := strings.Join(buf, "\n")
+			statements = append(statements, strings.TrimSuffix(statement, ";"))
+			buf = nil
+		}
+	}
+	if len(buf) > 0 {
+		statements = append(statements, strings.Join(buf, "\n"))
+	}
+	return statements
+}
+
+// 执行迁移
+// 同一时间只允许一个Migrator执行, 用mysql的GET_LOCK加锁
+type Migrator struct {
+	db         *sql.DB
+	migrations []*Migration
+
+	LockTimeout time.Duration
+	Logf        func(format string, args ...interface{})
+}
+
+func NewMigrator(db *sql.DB, migrations []*Migration) *Migrator {
+	return &Migrator{
+		db:          db,
+		migrations:  migrations,
+		LockTimeout: 10 * time.Second,
+		Logf:        log.Printf,
+	}
+}
+
+// 执行所有还没有执行的迁移, 返回本次执行的迁移
+func (m *Migrator) Up() (applied []*Migration, err error) {
+	err = m.withConn(func(conn *sql.Conn) error {
+		statuses, err := m.status(conn)
+		if err != nil {
+			return err
+		}
+		if err := checkDirty(statuses); err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			if s.Applied {
+				continue
+			}
+			if err := m.apply(conn, s.Migration); err != nil {
+				return err
+			}
+			applied = append(applied, s.Migration)
+		}
+		return nil
+	})
+	return
+}
+
+// 回滚最近执行的n个迁移, 返回本次回滚的迁移
+func (m *Migrator) Down(n int) (reverted []*Migration, err error) {
+	err = m.withConn(func(conn *sql.Conn) error {
+		statuses, err := m.status(conn)
+		if err != nil {
+			return err
+		}
+		if err := checkDirty(statuses); err != nil {
+			return err
+		}
+		for i := len(statuses) - 1; i >= 0 && len(reverted) < n; i-- {
+			if !statuses[i].Applied {
+				continue
+			}
+			if err := m.revert(conn, statuses[i].Migration); err != nil {
+				return err
+			}
+			reverted = append(reverted, statuses[i].Migration)
+		}
+		return nil
+	})
+	return
+}
+
+// 查询每个版本的执行状态
+func (m *Migrator) Status() (statuses []*Status, err error) {
+	err = m.withConn(func(conn *sql.Conn) error {
+		statuses, err = m.status(conn)
+		return err
+	})
+	return
+}
+
+// 迁移必须在同一个连接上执行, GET_LOCK只对当前连接有效
+func (m *Migrator) withConn(fn func(conn *sql.Conn) error) error {
+	ctx := context.Background()
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return errors.Wrap(err, "连接数据库失败")
+	}
+	defer conn.Close()
+
+	var locked sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK('schema_migrations', ?)", int64(m.LockTimeout/time.Second)).Scan(&locked); err != nil {
+		return errors.Wrap(err, "获取迁移锁失败")
+	}
+	if locked.Int64 != 1 {
+		return errors.New("获取迁移锁超时, 可能有其他程序正在执行迁移")
+	}
+	defer conn.ExecContext(ctx, "SELECT RELEASE_LOCK('schema_migrations')")
+
+	if err := m.prepare(conn); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// 创建schema_migrations表
+// 在引入迁移之前建好的数据库会根据现有的表结构记录已经执行的版本, 之后和新建的数据库执行同样的迁移
+func (m *Migrator) prepare(conn *sql.Conn) error {
+	exists, err := tableExists(conn, "schema_migrations")
+	if err != nil || exists {
+		return err
+	}
+
+	// 先判断版本, 失败时不创建表, 修复后重新判断
+	version, err := detectVersion(conn)
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.ExecContext(context.Background(), "CREATE TABLE `schema_migrations` ("+
+		"`version` bigint(20) NOT NULL,"+
+		"`name` varchar(255) NOT NULL,"+
+		"`dirty` tinyint(1) NOT NULL DEFAULT '0',"+
+		"`applied_at` int(11) NOT NULL DEFAULT '0',"+
+		"PRIMARY KEY (`version`)"+
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8")
+	if err != nil {
+		return errors.Wrap(err, "创建schema_migrations表失败")
+	}
+
+	for _, migration := range m.migrations {
+		if migration.Version > version {
+			break
+		}
+		if err := record(conn, migration, false); err != nil {
+			return err
+		}
+		m.Logf("已有的数据库结构相当于版本 %d, 标记 %d_%s 为已执行", version, migration.Version, migration.Name)
+	}
+	return nil
+}
+
+func (m *Migrator) status(conn *sql.Conn) ([]*Status, error) {
+	rows, err := conn.QueryContext(context.Background(), "SELECT version, dirty, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, errors.Wrap(err, "查询schema_migrations失败")
+	}
+	defer rows.Close()
+
+	applied := map[int64]*Status{}
+	for rows.Next() {
+		s := &Status{Applied: true}
+		var version int64
+		if err := rows.Scan(&version, &s.Dirty, &s.AppliedAt); err != nil {
+			return nil, errors.Wrap(err, "查询schema_migrations失败")
+		}
+		applied[version] = s
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "查询schema_migrations失败")
+	}
+
+	statuses := make([]*Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		s, ok := applied[migration.Version]
+		if !ok {
+			s = &Status{}
+		}
+		delete(applied, migration.Version)
+		s.Migration = migration
+		statuses = append(statuses, s)
+	}
+	// 数据库中有程序不认识的版本, 说明程序比数据库旧
+	for version := range applied {
+		return nil, errors.Errorf("数据库已经执行了未知的版本 %d, 请使用更新的程序", version)
+	}
+	return statuses, nil
+}
+
+// mysql的DDL语句会隐式提交, 迁移没法放在事务中
+// 执行前先记录为dirty, 全部成功后再清除, 中途失败时需要人工修复
+func (m *Migrator) apply(conn *sql.Conn, migration *Migration) error {
+	if err := record(conn, migration, true); err != nil {
+		return err
+	}
+	if err := execAll(conn, migration.Up); err != nil {
+		return errors.Wrapf(err, "执行 %d_%s 失败", migration.Version, migration.Name)
+	}
+	_, err := conn.ExecContext(context.Background(), "UPDATE schema_migrations SET dirty = 0 WHERE version = ?", migration.Version)
+	return errors.Wrap(err, "更新schema_migrations失败")
+}
+
+func (m *Migrator) revert(conn *sql.Conn, migration *Migration) error {
+	ctx := context.Background()
+	if _, err := conn.ExecContext(ctx, "UPDATE schema_migrations SET dirty = 1 WHERE version = ?", migration.Version); err != nil {
+		return errors.Wrap(err, "更新schema_migrations失败")
+	}
+	if err := execAll(conn, migration.Down); err != nil {
+		return errors.Wrapf(err, "回滚 %d_%s 失败", migration.Version, migration.Name)
+	}
+	_, err := conn.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = ?", migration.Version)
+	return errors.Wrap(err, "更新schema_migrations失败")
+}
+
+func checkDirty(statuses []*Status) error {
+	for _, s := range statuses {
+		if s.Dirty {
+			return errors.Errorf("版本 %d_%s 上次执行失败, 请手动修复数据库结构后删除或者更新schema_migrations中的记录",
+				s.Migration.Version, s.Migration.Name)
+		}
+	}
+	return nil
+}
+
+func record(conn *sql.Conn, migration *Migration, dirty bool) error {
+	_, err := conn.ExecContext(context.Background(),
+		"INSERT INTO schema_migrations (version, name, dirty, applied_at) VALUES (?, ?, ?, ?)",
+		migration.Version, migration.Name, dirty, time.Now().Unix())
+	return errors.Wrap(err, "写入schema_migrations失败")
+}
+
+func execAll(conn *sql.Conn, statements []string) error {
+	for _, statement := range statements {
+		if _, err := conn.ExecContext(context.Background(), statement); err != nil {
+			return errors.Wrap(err, statement)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package migrations
+
+import (
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestAll(t *testing.T) {
+	all, err := All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) == 0 {
+		t.Fatal("没有迁移脚本")
+	}
+	// 版本号从1开始连续, 每个版本都能回滚
+	for i, m := range all {
+		if m.Version != int64(i+1) {
+			t.Errorf("第%d个迁移的版本号是 %d", i, m.Version)
+		}
+		if len(m.Up) == 0 || len(m.Down) == 0 {
+			t.Errorf("%d_%s 的up或者down脚本为空", m.Version, m.Name)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"sql/0002_second.up.sql":   {Data: []byte("SELECT 2;")},
+		"sql/0002_second.down.sql": {Data: []byte("SELECT -2;")},
+		"sql/0001_first.up.sql":    {Data: []byte("SELECT 1;")},
+		"sql/0001_first.down.sql":  {Data: []byte("SELECT -1;")},
+	}
+	migrations, err := load(fsys, "sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 2 || migrations[0].Name != "first" || migrations[1].Name != "second" {
+		t.Fatalf("迁移的顺序不正确: %+v", migrations)
+	}
+
+	delete(fsys, "sql/0002_second.down.sql")
+	if _, err := load(fsys, "sql"); err == nil {
+		t.Error("缺少down脚本时应该返回错误")
+	}
+
+	fsys["sql/0002_second.down.sql"] = &fstest.MapFile{Data: []byte("SELECT -2;")}
+	fsys["sql/0002_other.up.sql"] = &fstest.MapFile{Data: []byte("SELECT 2;")}
+	if _, err := load(fsys, "sql"); err == nil {
+		t.Error("同一个版本有多个脚本时应该返回错误")
+	}
+
+	if _, err := load(fstest.MapFS{"sql/first.up.sql": {}}, "sql"); err == nil {
+		t.Error("文件名不正确时应该返回错误")
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	script := `-- 注释
+CREATE TABLE a (
+  id int -- 列
+);
+
+ALTER TABLE a ADD b int;
+SELECT 1`
+	want := []string{
+		"CREATE TABLE a (\n  id int -- 列\n)",
+		"ALTER TABLE a ADD b int",
+		"SELECT 1",
+	}
+	if got := splitStatements(script); !reflect.DeepEqual(got, want) {
+		t.Errorf("splitStatements() = %q, want %q", got, want)
+	}
+}
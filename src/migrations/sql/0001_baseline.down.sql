@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS `comment`;
+DROP TABLE IF EXISTS `collect`;
+DROP TABLE IF EXISTS `givelike`;
+DROP TABLE IF EXISTS `timeline`;
+DROP TABLE IF EXISTS `weibos`;
+DROP TABLE IF EXISTS `following`;
+DROP TABLE IF EXISTS `users`;
@@ -0,0 +1,64 @@
+-- 最初的表结构, 和之前database目录中的CREATE TABLE一致, 其中的问题在后面的迁移中修复
+-- 已有的数据库在第一次执行迁移时会直接把这个版本标记为已执行
+
+CREATE TABLE `users` (
+  `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
+  `account` varchar(16) NOT NULL,
+  `avatar` varchar(255) NOT NULL,
+  `password` varchar(64) NOT NULL,
+  `salt` varchar(16) NOT NULL,
+  `following_num` int(11) unsigned NOT NULL DEFAULT '0',
+  `follower_num` int(11) unsigned NOT NULL DEFAULT '0',
+  `weibo_num` int(11) unsigned NOT NULL DEFAULT '0',
+  `created_at` int(11) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE `following` (
+  `from_user_id` int(11) NOT NULL,
+  `to_user_id` int(11) NOT NULL,
+  `created_at` int(11) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`from_user_id`,`to_user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin;
+
+CREATE TABLE `weibos` (
+  `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
+  `user_id` int(11) NOT NULL,
+  `account` varchar(16) COLLATE utf8_bin NOT NULL,
+  `content` varchar(64) COLLATE utf8_bin NOT NULL,
+  `like_num` int(11) NOT NULL,
+  `created_at` int(11) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin;
+
+CREATE TABLE `timeline` (
+  `id` int(11) unsigned NOT NULL AUTO_INCREMENT,
+  `user_id` int(11) NOT NULL,
+  `weibo_user_id` int(11) NOT NULL,
+  `weibo_id` int(11) NOT NULL,
+  `weibo_created_at` int(11) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin;
+
+CREATE TABLE `givelike` (
+  `user_id` int(11) NOT NULL,
+  `weibo_id` int(11) NOT NULL,
+  `created_at` int(11) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`user_id`,`weibo_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin;
+
+CREATE TABLE `collect` (
+  `user_id` int(11) NOT NULL,
+  `weibo_id` int(11) NOT NULL,
+  `created_at` int(11) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`user_id`,`weibo_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin;
+
+CREATE TABLE `comment` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `user_id` int(11) NOT NULL,
+  `weibo_id` int(11) NOT NULL,
+  `contebt` varchar(45) CHARACTER SET latin1 NOT NULL,
+  `created_at` int(11) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`id`,`weibo_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin;
@@ -0,0 +1,16 @@
+ALTER TABLE `timeline`
+  DROP KEY `idx_weibo_id`,
+  DROP KEY `uk_user_weibo`;
+
+-- 转回latin1会丢失中文评论
+ALTER TABLE `comment`
+  CHANGE `content` `contebt` varchar(45) CHARACTER SET latin1 NOT NULL;
+
+ALTER TABLE `weibos`
+  DROP KEY `idx_user_created`,
+  DROP `comment_num`,
+  MODIFY `like_num` int(11) NOT NULL;
+
+ALTER TABLE `users`
+  MODIFY `password` varchar(64) NOT NULL,
+  MODIFY `salt` varchar(16) NOT NULL;
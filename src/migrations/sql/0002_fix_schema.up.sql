@@ -0,0 +1,27 @@
+-- 修复最初表结构中的问题, 代替之前database目录中零散的ALTER脚本
+
+-- password改为保存自描述的哈希编码(bcrypt/argon2id), salt只有旧的sha1哈希还在使用
+ALTER TABLE `users`
+  MODIFY `password` varchar(255) NOT NULL,
+  MODIFY `salt` varchar(16) NOT NULL DEFAULT '';
+
+-- 读取timeline时按作者拉取拉模式账号的微博; 评论数和like_num一样是冗余字段
+ALTER TABLE `weibos`
+  MODIFY `like_num` int(11) NOT NULL DEFAULT '0',
+  ADD `comment_num` int(11) NOT NULL DEFAULT '0' AFTER `like_num`,
+  ADD KEY `idx_user_created` (`user_id`,`created_at`);
+
+-- 评论内容的列名拼错了, 并且是latin1编码, 保存不了中文
+ALTER TABLE `comment`
+  CHANGE `contebt` `content` varchar(255) CHARACTER SET utf8 COLLATE utf8_bin NOT NULL;
+
+UPDATE `weibos` w SET w.comment_num = (SELECT COUNT(*) FROM `comment` c WHERE c.weibo_id = w.id);
+
+-- 扩散任务重试时依赖唯一索引去重, 加索引之前先删掉重复的行
+DELETE t1 FROM `timeline` t1
+  INNER JOIN `timeline` t2 ON t1.user_id = t2.user_id AND t1.weibo_id = t2.weibo_id AND t1.id > t2.id;
+
+-- 删除微博时按weibo_id批量删除
+ALTER TABLE `timeline`
+  ADD UNIQUE KEY `uk_user_weibo` (`user_id`,`weibo_id`),
+  ADD KEY `idx_weibo_id` (`weibo_id`);
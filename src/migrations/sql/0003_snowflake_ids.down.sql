@@ -0,0 +1,18 @@
+-- 已经有snowflake id的数据时无法回滚, id超出了int的范围
+
+ALTER TABLE `collect`
+  MODIFY `weibo_id` int(11) NOT NULL;
+
+ALTER TABLE `givelike`
+  MODIFY `weibo_id` int(11) NOT NULL;
+
+ALTER TABLE `timeline`
+  MODIFY `id` int(11) unsigned NOT NULL AUTO_INCREMENT,
+  MODIFY `weibo_id` int(11) NOT NULL;
+
+ALTER TABLE `comment`
+  MODIFY `id` int(11) NOT NULL AUTO_INCREMENT,
+  MODIFY `weibo_id` int(11) NOT NULL;
+
+ALTER TABLE `weibos`
+  MODIFY `id` int(10) unsigned NOT NULL AUTO_INCREMENT;
@@ -0,0 +1,19 @@
+-- 微博、评论和timeline的id改为程序生成的64位snowflake id, 不再自增
+-- 已有的id都比新生成的id小, 按id排序仍然和按发布时间排序一致
+
+ALTER TABLE `weibos`
+  MODIFY `id` bigint(20) NOT NULL;
+
+ALTER TABLE `comment`
+  MODIFY `id` bigint(20) NOT NULL,
+  MODIFY `weibo_id` bigint(20) NOT NULL;
+
+ALTER TABLE `timeline`
+  MODIFY `id` bigint(20) NOT NULL,
+  MODIFY `weibo_id` bigint(20) NOT NULL;
+
+ALTER TABLE `givelike`
+  MODIFY `weibo_id` bigint(20) NOT NULL;
+
+ALTER TABLE `collect`
+  MODIFY `weibo_id` bigint(20) NOT NULL;
@@ -0,0 +1,5 @@
+-- 转发的微博会变成内容为转发评论的普通微博
+ALTER TABLE `weibos`
+  DROP `repost_root_id`,
+  DROP `repost_of_id`,
+  DROP `repost_num`;
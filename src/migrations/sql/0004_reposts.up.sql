@@ -0,0 +1,5 @@
+-- 转发: 转发的微博也保存在weibos中, 记录转发的微博和转发链最开始的原创微博
+ALTER TABLE `weibos`
+  ADD `repost_num` int(11) NOT NULL DEFAULT '0' AFTER `comment_num`,
+  ADD `repost_of_id` bigint(20) NOT NULL DEFAULT '0' AFTER `repost_num`,
+  ADD `repost_root_id` bigint(20) NOT NULL DEFAULT '0' AFTER `repost_of_id`;
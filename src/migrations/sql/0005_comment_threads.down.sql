@@ -0,0 +1,9 @@
+-- 楼中的回复会变成直接评论微博的评论
+ALTER TABLE `comment`
+  DROP KEY `idx_weibo_root_created`,
+  DROP KEY `idx_root_created`,
+  DROP PRIMARY KEY,
+  ADD PRIMARY KEY (`id`,`weibo_id`),
+  DROP `reply_num`,
+  DROP `root_id`,
+  DROP `parent_id`;
@@ -0,0 +1,10 @@
+-- 评论支持楼中回复: 回复记录回复的评论和所在楼层的第一条评论, 楼中的回复数是冗余字段
+-- id已经是全局唯一的snowflake id, 主键不再需要weibo_id
+ALTER TABLE `comment`
+  ADD `parent_id` bigint(20) NOT NULL DEFAULT '0' AFTER `weibo_id`,
+  ADD `root_id` bigint(20) NOT NULL DEFAULT '0' AFTER `parent_id`,
+  ADD `reply_num` int(11) NOT NULL DEFAULT '0' AFTER `root_id`,
+  DROP PRIMARY KEY,
+  ADD PRIMARY KEY (`id`),
+  ADD KEY `idx_weibo_root_created` (`weibo_id`,`root_id`,`created_at`),
+  ADD KEY `idx_root_created` (`root_id`,`created_at`);
@@ -0,0 +1,2 @@
+DROP TABLE `weibo_topics`;
+DROP TABLE `topics`;
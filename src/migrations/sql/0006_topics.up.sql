@@ -0,0 +1,20 @@
+-- 话题: 微博内容中 #话题# 形式的标记, 微博数是冗余字段
+CREATE TABLE `topics` (
+  `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
+  `name` varchar(32) COLLATE utf8_bin NOT NULL,
+  `weibo_num` int(11) NOT NULL DEFAULT '0',
+  `created_at` int(11) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_name` (`name`),
+  KEY `idx_weibo_num` (`weibo_num`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin;
+
+-- 话题下的微博按发布时间分页, 删除微博时按weibo_id删除
+CREATE TABLE `weibo_topics` (
+  `topic_id` int(11) NOT NULL,
+  `weibo_id` bigint(20) NOT NULL,
+  `weibo_created_at` int(11) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`topic_id`,`weibo_id`),
+  KEY `idx_topic_created` (`topic_id`,`weibo_created_at`),
+  KEY `idx_weibo_id` (`weibo_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin;
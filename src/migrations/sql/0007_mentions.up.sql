@@ -0,0 +1,12 @@
+-- 微博和评论中提到用户的记录, 在微博中提到时comment_id为0, "@我的"按被提到的用户和时间分页
+CREATE TABLE `mentions` (
+  `id` bigint(20) NOT NULL,
+  `user_id` int(11) NOT NULL,
+  `author_id` int(11) NOT NULL,
+  `weibo_id` bigint(20) NOT NULL,
+  `comment_id` bigint(20) NOT NULL DEFAULT '0',
+  `created_at` int(11) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_weibo_comment_user` (`weibo_id`,`comment_id`,`user_id`),
+  KEY `idx_user_created` (`user_id`,`created_at`,`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin;
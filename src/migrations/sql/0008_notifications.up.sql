@@ -0,0 +1,17 @@
+-- 通知: 同一个用户收到的同一种、同一条微博的未读通知聚合成一条
+-- 未读时read_key为0, 已读时等于id, 唯一索引保证每个聚合最多一条未读通知
+CREATE TABLE `notifications` (
+  `id` bigint(20) NOT NULL,
+  `user_id` int(11) NOT NULL,
+  `kind` varchar(16) NOT NULL,
+  `target_id` bigint(20) NOT NULL DEFAULT '0',
+  `actor_id` int(11) NOT NULL,
+  `actor_account` varchar(16) NOT NULL,
+  `actor_num` int(11) NOT NULL DEFAULT '1',
+  `read_key` bigint(20) NOT NULL DEFAULT '0',
+  `created_at` int(11) NOT NULL DEFAULT '0',
+  `updated_at` int(11) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_group` (`user_id`,`kind`,`target_id`,`read_key`),
+  KEY `idx_user_updated` (`user_id`,`updated_at`,`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin;
@@ -0,0 +1,3 @@
+DROP TABLE `conversations`;
+DROP TABLE `messages`;
+ALTER TABLE `users` DROP COLUMN `message_permission`;
@@ -0,0 +1,29 @@
+-- 谁可以给用户发私信: anyone, followers
+ALTER TABLE `users` ADD COLUMN `message_permission` varchar(16) NOT NULL DEFAULT 'anyone';
+
+-- 私信, 两个人之间的私信按 (min_user_id, max_user_id) 分页
+CREATE TABLE `messages` (
+  `id` bigint(20) NOT NULL,
+  `from_user_id` int(11) NOT NULL,
+  `to_user_id` int(11) NOT NULL,
+  `min_user_id` int(11) NOT NULL,
+  `max_user_id` int(11) NOT NULL,
+  `content` varchar(255) NOT NULL,
+  `created_at` int(11) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`id`),
+  KEY `idx_pair_created` (`min_user_id`,`max_user_id`,`created_at`,`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin;
+
+-- 会话, 两个人之间的私信在双方各有一个会话, 分别记录自己的未读数
+CREATE TABLE `conversations` (
+  `id` bigint(20) NOT NULL,
+  `user_id` int(11) NOT NULL,
+  `peer_id` int(11) NOT NULL,
+  `last_message_id` bigint(20) NOT NULL DEFAULT '0',
+  `last_content` varchar(255) NOT NULL DEFAULT '',
+  `unread_num` int(11) NOT NULL DEFAULT '0',
+  `updated_at` int(11) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_user_peer` (`user_id`,`peer_id`),
+  KEY `idx_user_updated` (`user_id`,`updated_at`,`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin;
@@ -0,0 +1,8 @@
+-- 屏蔽关系, 按被屏蔽者查询用于过滤屏蔽了自己的人
+CREATE TABLE `blocks` (
+  `user_id` int(11) NOT NULL,
+  `blocked_user_id` int(11) NOT NULL,
+  `created_at` int(11) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`user_id`,`blocked_user_id`),
+  KEY `idx_blocked_user` (`blocked_user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin;
@@ -0,0 +1,13 @@
+-- 静音: 账号静音时keyword为空, 关键词静音时muted_user_id为0
+-- expires_at为0表示永久静音, 过期的记录在同一个用户添加静音时删除
+CREATE TABLE `mutes` (
+  `id` bigint(20) NOT NULL,
+  `user_id` int(11) NOT NULL,
+  `muted_user_id` int(11) NOT NULL DEFAULT '0',
+  `muted_account` varchar(16) NOT NULL DEFAULT '',
+  `keyword` varchar(32) NOT NULL DEFAULT '',
+  `expires_at` int(11) NOT NULL DEFAULT '0',
+  `created_at` int(11) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uk_target` (`user_id`,`muted_user_id`,`keyword`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin;
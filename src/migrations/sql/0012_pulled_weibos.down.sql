@@ -0,0 +1,3 @@
+-- 回滚后重新按作者当前的粉丝数判断拉模式
+ALTER TABLE `weibos`
+  DROP `pulled`;
@@ -0,0 +1,6 @@
+-- 拉模式在发布时按微博记录, 作者的粉丝数降到阈值以下之后, 之前没有推送的微博仍然在读取时合并
+ALTER TABLE `weibos`
+  ADD `pulled` tinyint(1) NOT NULL DEFAULT '0' AFTER `repost_root_id`;
+
+-- 已有的微博按默认的timeline.pull_threshold标记, 修改过阈值的部署需要按实际的值重新执行
+UPDATE `weibos` w INNER JOIN `users` u ON w.user_id = u.id SET w.pulled = 1 WHERE u.follower_num >= 10000;
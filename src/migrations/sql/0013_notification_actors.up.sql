@@ -0,0 +1,13 @@
+-- 聚合到通知中的用户, 静音或者屏蔽其中一部分用户时, 读取通知时只去掉这些用户
+CREATE TABLE `notification_actors` (
+  `notification_id` bigint(20) NOT NULL,
+  `actor_id` int(11) NOT NULL,
+  `actor_account` varchar(16) NOT NULL,
+  `actor_num` int(11) NOT NULL DEFAULT '1',
+  `updated_at` int(11) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`notification_id`,`actor_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8 COLLATE=utf8_bin;
+
+-- 已有的通知只记录了最近一个用户, 聚合的次数都算在这个用户上
+INSERT INTO `notification_actors`(notification_id, actor_id, actor_account, actor_num, updated_at)
+  SELECT id, actor_id, actor_account, actor_num, updated_at FROM `notifications`;
@@ -0,0 +1,894 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"weibo"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	validator "gopkg.in/go-playground/validator.v8"
+)
+
+type registerRequest struct {
+	Account  string `json:"account" binding:"required,max=16"`
+	Avatar   string `json:"avatar" binding:"required,max=255"`
+	Password string `json:"password" binding:"required,min=6,max=72"`
+}
+
+type loginRequest struct {
+	Account  string `json:"account" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type publishWeiboRequest struct {
+	Content string `json:"content" binding:"required,max=64"`
+}
+
+type repostRequest struct {
+	Comment string `json:"comment" binding:"max=64"`
+}
+
+type followRequest struct {
+	UserID int64 `json:"user_id" binding:"required,gt=0"`
+}
+
+type blockRequest struct {
+	UserID int64 `json:"user_id" binding:"required,gt=0"`
+}
+
+// user_id和keyword二选一, duration为空时永久静音
+type muteRequest struct {
+	UserID   int64  `json:"user_id" binding:"omitempty,gt=0"`
+	Keyword  string `json:"keyword" binding:"max=32"`
+	Duration string `json:"duration"`
+}
+
+type weiboIDRequest struct {
+	WeiboID int64 `json:"weibo_id,string" binding:"required,gt=0"`
+}
+
+type postCommentRequest struct {
+	WeiboID int64  `json:"weibo_id,string" binding:"required,gt=0"`
+	Content string `json:"content" binding:"required,max=255"`
+}
+
+type replyCommentRequest struct {
+	Content string `json:"content" binding:"required,max=255"`
+}
+
+type sendMessageRequest struct {
+	ToUserID int64  `json:"to_user_id" binding:"required,gt=0"`
+	Content  string `json:"content" binding:"required,max=255"`
+}
+
+type messagePermissionRequest struct {
+	Permission string `json:"permission" binding:"required"`
+}
+
+type commentListRequest struct {
+	Sort   string `form:"sort"`
+	Cursor string `form:"cursor"`
+	Page   int64  `form:"page" binding:"omitempty,min=1"`
+	Limit  int64  `form:"limit" binding:"omitempty,min=1"`
+}
+
+type listRequest struct {
+	Cursor string `form:"cursor"`
+	Page   int64  `form:"page" binding:"omitempty,min=1"`
+	Limit  int64  `form:"limit" binding:"omitempty,min=1"`
+}
+
+type trendsRequest struct {
+	Limit int64 `form:"limit" binding:"omitempty,min=1"`
+}
+
+type searchRequest struct {
+	Q      string `form:"q" binding:"required"`
+	Cursor string `form:"cursor"`
+	Page   int64  `form:"page" binding:"omitempty,min=1"`
+	Limit  int64  `form:"limit" binding:"omitempty,min=1"`
+}
+
+// 首页接口的返回
+type homeResponse struct {
+	User       *weibo.User            `json:"user"`
+	Followers  []*weibo.Follower      `json:"followers"`
+	Items      []*weibo.WeiboWithUser `json:"items"`
+	NextCursor string                 `json:"next_cursor"`
+}
+
+// 评论详情接口的返回
+type commentDetailResponse struct {
+	Comment    *weibo.CommentWithUser   `json:"comment"`
+	Items      []*weibo.CommentWithUser `json:"items"`
+	NextCursor string                   `json:"next_cursor"`
+}
+
+// 话题页接口的返回
+type topicResponse struct {
+	Topic      *weibo.Topic           `json:"topic"`
+	Items      []*weibo.WeiboWithUser `json:"items"`
+	NextCursor string                 `json:"next_cursor"`
+}
+
+// 私信接口的返回
+type messagesResponse struct {
+	Peer       *weibo.User      `json:"peer"`
+	Items      []*weibo.Message `json:"items"`
+	NextCursor string           `json:"next_cursor"`
+}
+
+type unreadCountResponse struct {
+	Count int64 `json:"count"`
+}
+
+type trendsResponse struct {
+	Topics []*weibo.TrendingTopic `json:"topics"`
+	Weibos []*weibo.WeiboWithUser `json:"weibos"`
+}
+
+// 列表接口的返回
+type listResponse struct {
+	Items      interface{} `json:"items"`
+	NextCursor string      `json:"next_cursor"`
+}
+
+func (s *Server) registerAPI(r *gin.Engine) {
+	api := r.Group("/api/v1", handleErrors)
+
+	api.POST("/users", s.apiRegister)
+	api.POST("/sessions", s.apiLogin)
+	api.DELETE("/sessions", s.apiLogout)
+
+	authed := api.Group("", s.apiRequireUser)
+	authed.GET("/home", s.apiHome)
+	authed.GET("/users/:id/followers", s.apiFollowers)
+	authed.GET("/timeline", s.apiTimeline)
+	authed.POST("/weibos", s.apiPublishWeibo)
+	authed.DELETE("/weibos/:id", s.apiDeleteWeibo)
+	authed.POST("/weibos/:id/reposts", s.apiRepost)
+	authed.GET("/weibos/:id/comments", s.apiCommentList)
+	authed.POST("/follows", s.apiFollow)
+	authed.DELETE("/follows/:user_id", s.apiUnFollow)
+	authed.POST("/likes", s.apiGivelike)
+	authed.POST("/collections", s.apiCollect)
+	authed.POST("/comments", s.apiPostComment)
+	authed.GET("/comments/:id", s.apiCommentDetail)
+	authed.POST("/comments/:id/replies", s.apiReplyComment)
+	authed.DELETE("/comments/:id", s.apiDeleteComment)
+	authed.GET("/topics", s.apiTopTopics)
+	authed.GET("/topics/:name/weibos", s.apiTopicWeibos)
+	authed.GET("/trends", s.apiTrends)
+	authed.GET("/mentions", s.apiMentions)
+	authed.GET("/notifications", s.apiNotifications)
+	authed.GET("/notifications/unread_count", s.apiUnreadNotificationCount)
+	authed.POST("/notifications/read", s.apiMarkNotificationsRead)
+	authed.GET("/conversations", s.apiConversations)
+	authed.GET("/conversations/unread_count", s.apiUnreadMessageCount)
+	authed.GET("/conversations/:peer_id/messages", s.apiMessages)
+	authed.POST("/conversations/:peer_id/read", s.apiMarkConversationRead)
+	authed.POST("/messages", s.apiSendMessage)
+	authed.PUT("/settings/messages", s.apiSetMessagePermission)
+	authed.GET("/blocks", s.apiBlockedUsers)
+	authed.POST("/blocks", s.apiBlock)
+	authed.DELETE("/blocks/:user_id", s.apiUnblock)
+	authed.GET("/mutes", s.apiMutes)
+	authed.POST("/mutes", s.apiMute)
+	authed.DELETE("/mutes/:id", s.apiUnmute)
+	authed.GET("/search/weibos", s.apiSearchWeibo)
+	authed.GET("/search/users", s.apiSearchUser)
+	authed.GET("/stream", s.apiStream)
+}
+
+// 绑定并校验请求参数
+func bind(c *gin.Context, obj interface{}, b binding.Binding) error {
+	err := c.ShouldBindWith(obj, b)
+	if err == nil {
+		return nil
+	}
+
+	errs, ok := err.(validator.ValidationErrors)
+	if !ok {
+		return &validationError{message: "请求格式错误: " + err.Error()}
+	}
+
+	e := &validationError{message: "参数错误"}
+	for _, fe := range errs {
+		e.details = append(e.details, &fieldError{Field: fieldName(obj, fe.Name), Rule: fe.Tag, Param: fe.Param})
+	}
+	sort.Slice(e.details, func(i, j int) bool { return e.details[i].Field < e.details[j].Field })
+	return e
+}
+
+// 校验错误中的字段名换成请求中使用的json或form名字
+func fieldName(obj interface{}, name string) string {
+	t := reflect.TypeOf(obj)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	field, ok := t.FieldByName(name)
+	if !ok {
+		return name
+	}
+	for _, key := range []string{"json", "form"} {
+		if tag := strings.Split(field.Tag.Get(key), ",")[0]; tag != "" && tag != "-" {
+			return tag
+		}
+	}
+	return name
+}
+
+// 路径中的id参数
+func pathID(c *gin.Context, name string) (int64, error) {
+	id, err := strconv.ParseInt(c.Param(name), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, &validationError{message: "无效的" + name}
+	}
+	return id, nil
+}
+
+// 分页参数, 没有指定每页条数时使用配置中的默认值
+func (s *Server) parsePage(cursor string, page, limit int64) (weibo.Page, error) {
+	if limit == 0 {
+		limit = s.config.Page.Size
+	}
+	if limit > s.config.Page.MaxSize {
+		return weibo.Page{}, &validationError{
+			message: "参数错误",
+			details: []*fieldError{{Field: "limit", Rule: "max", Param: strconv.FormatInt(s.config.Page.MaxSize, 10)}},
+		}
+	}
+	return weibo.ParsePage(cursor, page, limit)
+}
+
+func (s *Server) bindPage(c *gin.Context) (weibo.Page, error) {
+	var req listRequest
+	if err := bind(c, &req, binding.Query); err != nil {
+		return weibo.Page{}, err
+	}
+	return s.parsePage(req.Cursor, req.Page, req.Limit)
+}
+
+// 需要登录的接口, 当前用户保存在请求的ctx中
+func (s *Server) apiRequireUser(c *gin.Context) {
+	user := s.getUserFromSession(c)
+	if user == nil {
+		abortWithError(c, weibo.ErrUnauthenticated)
+		return
+	}
+	c.Request = c.Request.WithContext(weibo.WithViewer(c.Request.Context(), user))
+	c.Next()
+}
+
+func currentUser(c *gin.Context) *weibo.User {
+	return weibo.Viewer(c.Request.Context())
+}
+
+func (s *Server) apiRegister(c *gin.Context) {
+	var req registerRequest
+	if err := bind(c, &req, binding.JSON); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	user, err := s.service.Register(c.Request.Context(), req.Account, req.Avatar, req.Password)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	s.saveUserToSession(c, user)
+	c.JSON(http.StatusCreated, user)
+}
+
+func (s *Server) apiLogin(c *gin.Context) {
+	var req loginRequest
+	if err := bind(c, &req, binding.JSON); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	user, err := s.service.Login(c.Request.Context(), req.Account, req.Password)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	s.saveUserToSession(c, user)
+	c.JSON(http.StatusOK, user)
+}
+
+func (s *Server) apiLogout(c *gin.Context) {
+	s.removeUserFromSession(c)
+	c.Status(http.StatusNoContent)
+}
+
+func (s *Server) apiFollowers(c *gin.Context) {
+	userID, err := pathID(c, "id")
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	page, err := s.bindPage(c)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	followers, next, err := s.service.FollowerList(c.Request.Context(), userID, page)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, listResponse{Items: followers, NextCursor: next})
+}
+
+func (s *Server) apiHome(c *gin.Context) {
+	page, err := s.bindPage(c)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	user, followers, weibos, next, err := s.service.WeiboList(c.Request.Context(), currentUser(c), page)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, homeResponse{User: user, Followers: followers, Items: weibos, NextCursor: next})
+}
+
+func (s *Server) apiTimeline(c *gin.Context) {
+	page, err := s.bindPage(c)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	_, weibos, next, err := s.service.FollowersShow(c.Request.Context(), currentUser(c), page)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, listResponse{Items: weibos, NextCursor: next})
+}
+
+func (s *Server) apiPublishWeibo(c *gin.Context) {
+	var req publishWeiboRequest
+	if err := bind(c, &req, binding.JSON); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	user := currentUser(c)
+	w := &weibo.Weibo{
+		UserID:    user.ID,
+		Account:   user.Account,
+		Content:   req.Content,
+		CreatedAt: time.Now().Unix(),
+	}
+	if err := s.service.PublishWeibo(c.Request.Context(), user, w); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, w)
+}
+
+func (s *Server) apiDeleteWeibo(c *gin.Context) {
+	weiboID, err := pathID(c, "id")
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	if err := s.service.DeleteWeibo(c.Request.Context(), currentUser(c), weiboID); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (s *Server) apiRepost(c *gin.Context) {
+	weiboID, err := pathID(c, "id")
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	var req repostRequest
+	if err := bind(c, &req, binding.JSON); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	w, err := s.service.Repost(c.Request.Context(), currentUser(c), weiboID, req.Comment)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, w)
+}
+
+func (s *Server) apiFollow(c *gin.Context) {
+	var req followRequest
+	if err := bind(c, &req, binding.JSON); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	if err := s.service.Follow(c.Request.Context(), currentUser(c), req.UserID); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (s *Server) apiUnFollow(c *gin.Context) {
+	userID, err := pathID(c, "user_id")
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	if err := s.service.UnFollow(c.Request.Context(), currentUser(c), userID); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (s *Server) apiGivelike(c *gin.Context) {
+	var req weiboIDRequest
+	if err := bind(c, &req, binding.JSON); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	if err := s.service.Givelike(c.Request.Context(), currentUser(c), req.WeiboID); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (s *Server) apiCollect(c *gin.Context) {
+	var req weiboIDRequest
+	if err := bind(c, &req, binding.JSON); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	if err := s.service.Collect(c.Request.Context(), currentUser(c), req.WeiboID); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (s *Server) apiPostComment(c *gin.Context) {
+	var req postCommentRequest
+	if err := bind(c, &req, binding.JSON); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	comment, err := s.service.PostComment(c.Request.Context(), currentUser(c), req.WeiboID, req.Content)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, comment)
+}
+
+func (s *Server) apiReplyComment(c *gin.Context) {
+	commentID, err := pathID(c, "id")
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	var req replyCommentRequest
+	if err := bind(c, &req, binding.JSON); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	reply, err := s.service.ReplyComment(c.Request.Context(), currentUser(c), commentID, req.Content)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, reply)
+}
+
+func (s *Server) apiCommentList(c *gin.Context) {
+	weiboID, err := pathID(c, "id")
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	var req commentListRequest
+	if err := bind(c, &req, binding.Query); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	sort, err := weibo.ParseCommentSort(req.Sort)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	page, err := s.parsePage(req.Cursor, req.Page, req.Limit)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	comments, next, err := s.service.CommentList(c.Request.Context(), weiboID, sort, page)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, listResponse{Items: comments, NextCursor: next})
+}
+
+func (s *Server) apiCommentDetail(c *gin.Context) {
+	commentID, err := pathID(c, "id")
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	page, err := s.bindPage(c)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	comment, replies, next, err := s.service.CommentDetail(c.Request.Context(), commentID, page)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, commentDetailResponse{Comment: comment, Items: replies, NextCursor: next})
+}
+
+func (s *Server) apiDeleteComment(c *gin.Context) {
+	commentID, err := pathID(c, "id")
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	if err := s.service.DeleteComment(c.Request.Context(), currentUser(c), commentID); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (s *Server) apiTopTopics(c *gin.Context) {
+	topics, err := s.service.TopTopics(c.Request.Context())
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, listResponse{Items: topics})
+}
+
+func (s *Server) apiTopicWeibos(c *gin.Context) {
+	page, err := s.bindPage(c)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	topic, weibos, next, err := s.service.TopicWeibos(c.Request.Context(), c.Param("name"), page)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, topicResponse{Topic: topic, Items: weibos, NextCursor: next})
+}
+
+func (s *Server) apiMentions(c *gin.Context) {
+	page, err := s.bindPage(c)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	mentions, next, err := s.service.Mentions(c.Request.Context(), currentUser(c), page)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, listResponse{Items: mentions, NextCursor: next})
+}
+
+func (s *Server) apiNotifications(c *gin.Context) {
+	page, err := s.bindPage(c)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	notifications, next, err := s.service.Notifications(c.Request.Context(), currentUser(c), page)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, listResponse{Items: notifications, NextCursor: next})
+}
+
+func (s *Server) apiUnreadNotificationCount(c *gin.Context) {
+	count, err := s.service.UnreadNotificationCount(c.Request.Context(), currentUser(c))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, unreadCountResponse{Count: count})
+}
+
+func (s *Server) apiMarkNotificationsRead(c *gin.Context) {
+	if err := s.service.MarkNotificationsRead(c.Request.Context(), currentUser(c)); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (s *Server) apiConversations(c *gin.Context) {
+	page, err := s.bindPage(c)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	conversations, next, err := s.service.Conversations(c.Request.Context(), currentUser(c), page)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, listResponse{Items: conversations, NextCursor: next})
+}
+
+func (s *Server) apiUnreadMessageCount(c *gin.Context) {
+	count, err := s.service.UnreadMessageCount(c.Request.Context(), currentUser(c))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, unreadCountResponse{Count: count})
+}
+
+// 和对方之间的私信, 不会把会话标记为已读
+func (s *Server) apiMessages(c *gin.Context) {
+	peerID, err := pathID(c, "peer_id")
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	page, err := s.bindPage(c)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	peer, messages, next, err := s.service.Messages(c.Request.Context(), currentUser(c), peerID, page)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, messagesResponse{Peer: peer, Items: messages, NextCursor: next})
+}
+
+func (s *Server) apiMarkConversationRead(c *gin.Context) {
+	peerID, err := pathID(c, "peer_id")
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	if err := s.service.MarkConversationRead(c.Request.Context(), currentUser(c), peerID); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (s *Server) apiSendMessage(c *gin.Context) {
+	var req sendMessageRequest
+	if err := bind(c, &req, binding.JSON); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	message, err := s.service.SendMessage(c.Request.Context(), currentUser(c), req.ToUserID, req.Content)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, message)
+}
+
+func (s *Server) apiSetMessagePermission(c *gin.Context) {
+	var req messagePermissionRequest
+	if err := bind(c, &req, binding.JSON); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	user := currentUser(c)
+	if err := s.service.SetMessagePermission(c.Request.Context(), user, req.Permission); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	s.saveUserToSession(c, user)
+	c.Status(http.StatusNoContent)
+}
+
+func (s *Server) apiBlockedUsers(c *gin.Context) {
+	page, err := s.bindPage(c)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	blocked, next, err := s.service.BlockedUsers(c.Request.Context(), currentUser(c), page)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, listResponse{Items: blocked, NextCursor: next})
+}
+
+// 屏蔽用户, 双方之间的关注关系一起解除
+func (s *Server) apiBlock(c *gin.Context) {
+	var req blockRequest
+	if err := bind(c, &req, binding.JSON); err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	if err := s.service.Block(c.Request.Context(), currentUser(c), req.UserID); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (s *Server) apiUnblock(c *gin.Context) {
+	userID, err := pathID(c, "user_id")
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	if err := s.service.Unblock(c.Request.Context(), currentUser(c), userID); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (s *Server) apiMutes(c *gin.Context) {
+	mutes, err := s.service.Mutes(c.Request.Context(), currentUser(c))
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, listResponse{Items: mutes})
+}
+
+func (s *Server) apiMute(c *gin.Context) {
+	var req muteRequest
+	if err := bind(c, &req, binding.JSON); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	d, err := weibo.ParseMuteDuration(req.Duration)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	var mute *weibo.Mute
+	if req.UserID != 0 {
+		mute, err = s.service.MuteUser(c.Request.Context(), currentUser(c), req.UserID, d)
+	} else {
+		mute, err = s.service.MuteKeyword(c.Request.Context(), currentUser(c), req.Keyword, d)
+	}
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, mute)
+}
+
+func (s *Server) apiUnmute(c *gin.Context) {
+	muteID, err := pathID(c, "id")
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	if err := s.service.Unmute(c.Request.Context(), currentUser(c), muteID); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// 热门话题和热门微博, limit默认为每页的条数
+func (s *Server) apiTrends(c *gin.Context) {
+	var req trendsRequest
+	if err := bind(c, &req, binding.Query); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	limit := req.Limit
+	if limit == 0 {
+		limit = s.config.Page.Size
+	}
+	if limit > s.config.Page.MaxSize {
+		abortWithError(c, &validationError{
+			message: "参数错误",
+			details: []*fieldError{{Field: "limit", Rule: "max", Param: strconv.FormatInt(s.config.Page.MaxSize, 10)}},
+		})
+		return
+	}
+
+	topics, err := s.service.TrendingTopics(c.Request.Context(), limit)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	weibos, err := s.service.HotWeibos(c.Request.Context(), limit)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, trendsResponse{Topics: topics, Weibos: weibos})
+}
+
+func (s *Server) apiSearchWeibo(c *gin.Context) {
+	var req searchRequest
+	if err := bind(c, &req, binding.Query); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	page, err := s.parsePage(req.Cursor, req.Page, req.Limit)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	weibos, next, err := s.service.SearchWeibo(c.Request.Context(), req.Q, page)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, listResponse{Items: weibos, NextCursor: next})
+}
+
+func (s *Server) apiSearchUser(c *gin.Context) {
+	var req searchRequest
+	if err := bind(c, &req, binding.Query); err != nil {
+		abortWithError(c, err)
+		return
+	}
+	page, err := s.parsePage(req.Cursor, req.Page, req.Limit)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	users, next, err := s.service.SearchUser(c.Request.Context(), req.Q, page)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, listResponse{Items: users, NextCursor: next})
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"cache"
+	"config"
+	"storage"
+	"storage/memory"
+	"weibo"
+
+	"github.com/boj/redistore"
+	"github.com/gomodule/redigo/redis"
+	"github.com/gorilla/sessions"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+)
+
+// 服务依赖的存储, 根据配置选择mysql + redis或者内存实现
+type backend struct {
+	users        weibo.UserRepository
+	weibos       weibo.WeiboRepository
+	comments     weibo.CommentRepository
+	topics       weibo.TopicRepository
+	mentions     weibo.MentionRepository
+	notices      weibo.NotificationRepository
+	messages     weibo.MessageRepository
+	blocks       weibo.BlockRepository
+	mutes        weibo.MuteRepository
+	timelines    weibo.TimeLineRepository
+	uow          weibo.UnitOfWork
+	fanoutQueue  weibo.FanoutQueue
+	trendStore   weibo.TrendStore
+	events       weibo.EventBroker
+	sessionStore sessions.Store
+
+	// 释放连接
+	closers []func() error
+}
+
+func (b *backend) close() {
+	for i := len(b.closers) - 1; i >= 0; i-- {
+		b.closers[i]()
+	}
+}
+
+// 按配置选择密码哈希算法, 参数已经在加载配置时检查过
+func newPasswordHasher(cfg config.PasswordConfig) weibo.PasswordHasher {
+	if cfg.Algorithm == config.PasswordBcrypt {
+		return weibo.NewBcryptHasher(cfg.BcryptCost)
+	}
+	hasher := weibo.NewArgon2idHasher()
+	hasher.Time = uint32(cfg.Argon2Time)
+	hasher.Memory = uint32(cfg.Argon2Memory)
+	hasher.Threads = uint8(cfg.Argon2Threads)
+	return hasher
+}
+
+func openMySQLBackend(cfg *config.Config) (*backend, error) {
+	b := &backend{}
+
+	db, err := sqlx.Open("mysql", cfg.MySQL.DSN)
+	if err != nil {
+		return nil, err
+	}
+	b.closers = append(b.closers, db.Close)
+	db.SetMaxOpenConns(cfg.MySQL.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MySQL.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.MySQL.ConnMaxLifetime)
+
+	// redis连接不能在多个goroutine之间共用, 每次从连接池中取出
+	redisPool := &redis.Pool{
+		MaxIdle:     cfg.Redis.MaxIdle,
+		MaxActive:   cfg.Redis.MaxActive,
+		IdleTimeout: cfg.Redis.IdleTimeout,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", cfg.Redis.Addr,
+				redis.DialPassword(cfg.Redis.Password),
+				redis.DialDatabase(cfg.Redis.DB),
+				redis.DialConnectTimeout(cfg.Redis.Timeout),
+				redis.DialReadTimeout(cfg.Redis.Timeout),
+				redis.DialWriteTimeout(cfg.Redis.Timeout))
+		},
+	}
+	b.closers = append(b.closers, redisPool.Close)
+
+	var c cache.Cache
+	if cfg.Cache.Backend == config.CacheLRU {
+		c = cache.NewLRU(cfg.Cache.LRUSize)
+	} else {
+		degrading := cache.NewDegrading(cache.NewRedisCache(redisPool))
+		degrading.RetryInterval = cfg.Cache.DegradeRetry
+		c = degrading
+	}
+
+	userRepo := storage.NewUserRepository(db, c)
+	userRepo.FollowersTTL = cfg.Cache.FollowersTTL
+	userRepo.FollowersCacheSize = cfg.Cache.FollowersSize
+	b.users = userRepo
+	weiboRepo := storage.NewWeiboRepository(db, c)
+	weiboRepo.WeiboTTL = cfg.Cache.WeiboTTL
+	b.weibos = weiboRepo
+	b.comments = storage.NewCommentRepository(db)
+	b.topics = storage.NewTopicRepository(db)
+	b.mentions = storage.NewMentionRepository(db)
+	noticeRepo := storage.NewNotificationRepository(db, c)
+	noticeRepo.UnreadTTL = cfg.Cache.UnreadTTL
+	b.notices = noticeRepo
+	b.messages = storage.NewMessageRepository(db)
+	b.blocks = storage.NewBlockRepository(db)
+	muteRepo := storage.NewMuteRepository(db, c)
+	muteRepo.MutesTTL = cfg.Cache.MutesTTL
+	b.mutes = muteRepo
+
+	timelineRepo := storage.NewRedisTimeLineRepository(db, redisPool)
+	timelineRepo.Capacity = cfg.Cache.TimelineCapacity
+	timelineRepo.TTL = cfg.Cache.TimelineTTL
+	b.timelines = timelineRepo
+
+	uow := storage.NewUnitOfWork(db, c, redisPool)
+	uow.FollowersTTL = cfg.Cache.FollowersTTL
+	uow.WeiboTTL = cfg.Cache.WeiboTTL
+	uow.TimelineCapacity = cfg.Cache.TimelineCapacity
+	uow.TimelineTTL = cfg.Cache.TimelineTTL
+	b.uow = uow
+
+	b.fanoutQueue = storage.NewRedisFanoutQueue(redisPool)
+	b.trendStore = storage.NewRedisTrendStore(redisPool)
+
+	events := storage.NewRedisEventBroker(redisPool)
+	events.History = cfg.Stream.History
+	events.HistoryTTL = cfg.Stream.HistoryTTL
+	events.Buffer = cfg.Stream.Buffer
+	events.Start()
+	b.closers = append(b.closers, func() error {
+		events.Stop()
+		return nil
+	})
+	b.events = events
+
+	store, err := redistore.NewRediStoreWithPool(redisPool, []byte(cfg.Session.Secret))
+	if err != nil {
+		b.close()
+		return nil, err
+	}
+	b.sessionStore = store
+	return b, nil
+}
+
+// 全部数据保存在进程内, 不需要mysql和redis
+func openMemoryBackend(cfg *config.Config) *backend {
+	store := memory.NewStore()
+	events := weibo.NewMemoryEventBroker()
+	events.History = int(cfg.Stream.History)
+	events.Buffer = cfg.Stream.Buffer
+	return &backend{
+		users:        memory.NewUserRepository(store),
+		weibos:       memory.NewWeiboRepository(store),
+		comments:     memory.NewCommentRepository(store),
+		topics:       memory.NewTopicRepository(store),
+		mentions:     memory.NewMentionRepository(store),
+		notices:      memory.NewNotificationRepository(store),
+		messages:     memory.NewMessageRepository(store),
+		blocks:       memory.NewBlockRepository(store),
+		mutes:        memory.NewMuteRepository(store),
+		timelines:    memory.NewTimeLineRepository(store),
+		uow:          memory.NewUnitOfWork(store),
+		fanoutQueue:  weibo.NewMemoryFanoutQueue(10000),
+		trendStore:   weibo.NewMemoryTrendStore(),
+		events:       events,
+		sessionStore: sessions.NewCookieStore([]byte(cfg.Session.Secret)),
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+	"weibo"
+
+	"github.com/gin-gonic/gin"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// 给每个请求设置请求id和处理时间上限
+// 客户端断开或者超时后ctx结束, 正在执行的mysql和redis调用会被取消
+// streams中的长连接接口只在客户端断开时结束
+func requestContext(timeout time.Duration, streams ...string) gin.HandlerFunc {
+	longLived := make(map[string]bool, len(streams))
+	for _, path := range streams {
+		longLived[path] = true
+	}
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(requestIDHeader)
+		if requestID == "" || len(requestID) > 64 {
+			requestID = newRequestID()
+		}
+		c.Header(requestIDHeader, requestID)
+
+		ctx := c.Request.Context()
+		if !longLived[c.Request.URL.Path] {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		c.Request = c.Request.WithContext(weibo.WithRequestID(ctx, requestID))
+		c.Next()
+	}
+}
+
+func newRequestID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"weibo"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// 客户端在返回之前断开了连接, 和nginx使用的状态码一致
+const statusClientClosedRequest = 499
+
+// 错误类型对应的http状态码
+var errorStatus = map[weibo.ErrorKind]int{
+	weibo.NotFound:        http.StatusNotFound,
+	weibo.AlreadyExists:   http.StatusConflict,
+	weibo.Forbidden:       http.StatusForbidden,
+	weibo.InvalidArgument: http.StatusBadRequest,
+	weibo.Unauthenticated: http.StatusUnauthorized,
+	weibo.Timeout:         http.StatusGatewayTimeout,
+	weibo.Internal:        http.StatusInternalServerError,
+}
+
+// 按错误码翻译的提示, 中文直接使用weibo.Error中的提示
+var errorMessages = map[string]map[string]string{
+	"en": {
+		"user_not_found":             "User not found",
+		"weibo_not_found":            "Weibo not found",
+		"comment_not_found":          "Comment not found",
+		"topic_not_found":            "Topic not found",
+		"mute_not_found":             "Mute not found",
+		"account_exists":             "Account name is already taken",
+		"already_following":          "You are already following this user",
+		"already_liked":              "You have already liked this weibo",
+		"already_collected":          "You have already collected this weibo",
+		"already_blocked":            "You have already blocked this user",
+		"already_muted":              "You have already muted this",
+		"not_weibo_owner":            "You can only delete your own weibos",
+		"not_comment_owner":          "You can only delete your own comments",
+		"message_denied":             "This user only accepts messages from their followers",
+		"blocked":                    "You cannot interact with this user because of a block",
+		"not_following":              "You are not following this user",
+		"follow_self":                "You cannot follow yourself",
+		"empty_content":              "Content must not be empty",
+		"invalid_cursor":             "Invalid pagination cursor",
+		"invalid_page":               "Page number is too large, please use the cursor",
+		"empty_search_key":           "Search keyword must not be empty",
+		"invalid_comment_sort":       "Unsupported comment sort order",
+		"message_self":               "You cannot message yourself",
+		"block_self":                 "You cannot block yourself",
+		"not_blocked":                "You have not blocked this user",
+		"invalid_message_permission": "Unsupported message permission",
+		"mute_self":                  "You cannot mute yourself",
+		"invalid_mute_keyword":       "Keyword must be 1 to 32 characters",
+		"invalid_mute_duration":      "Unsupported mute duration",
+		"too_many_mutes":             "You have muted too many accounts and keywords",
+		"unauthenticated":            "Please log in first",
+		"wrong_password":             "Wrong password",
+		"timeout":                    "Request timed out, please try again later",
+		"invalid_argument":           "Invalid request parameters",
+		"internal":                   "Internal server error",
+	},
+}
+
+// 接口返回的错误
+type errorBody struct {
+	// 错误类型, 和http状态码一一对应
+	Kind weibo.ErrorKind `json:"kind"`
+	// 具体的错误码
+	Code    string        `json:"code"`
+	Message string        `json:"message"`
+	Details []*fieldError `json:"details,omitempty"`
+}
+
+// 参数校验失败的字段
+type fieldError struct {
+	Field string `json:"field"`
+	Rule  string `json:"rule"`
+	Param string `json:"param,omitempty"`
+}
+
+// 请求参数校验失败
+type validationError struct {
+	message string
+	details []*fieldError
+}
+
+func (e *validationError) Error() string {
+	return e.message
+}
+
+// 终止请求, 由handleErrors返回错误
+func abortWithError(c *gin.Context, err error) {
+	c.Abort()
+	c.Error(err)
+}
+
+// 把handler中记录的错误转换成统一格式的返回
+func handleErrors(c *gin.Context) {
+	c.Next()
+
+	if len(c.Errors) == 0 || c.Writer.Written() {
+		return
+	}
+	err := c.Errors.Last().Err
+
+	// 客户端已经断开, 不需要返回内容
+	if errors.Cause(err) == context.Canceled {
+		c.AbortWithStatus(statusClientClosedRequest)
+		return
+	}
+
+	body := &errorBody{Kind: weibo.Internal, Code: string(weibo.Internal)}
+	switch e := err.(type) {
+	case *validationError:
+		body.Kind = weibo.InvalidArgument
+		body.Code = string(weibo.InvalidArgument)
+		body.Message = e.message
+		body.Details = e.details
+	default:
+		if de := weibo.AsError(err); de != nil {
+			body.Kind = de.Kind
+			body.Code = de.Code
+			body.Message = de.Message
+		} else {
+			log.Printf("[%s] %s %s 处理失败: %v\n", weibo.RequestID(c.Request.Context()), c.Request.Method, c.Request.URL.Path, err)
+			body.Message = "服务器内部错误"
+		}
+	}
+	body.Message = localize(c.GetHeader("Accept-Language"), body.Code, body.Message)
+
+	c.JSON(errorStatus[body.Kind], gin.H{"error": body})
+}
+
+// 根据Accept-Language选择提示的语言, 没有翻译时使用默认的中文提示
+func localize(acceptLanguage, code, message string) string {
+	for _, lang := range strings.Split(acceptLanguage, ",") {
+		lang = strings.TrimSpace(strings.SplitN(lang, ";", 2)[0])
+		lang = strings.ToLower(strings.SplitN(lang, "-", 2)[0])
+		if lang == "zh" {
+			return message
+		}
+		if messages, ok := errorMessages[lang]; ok {
+			if m, ok := messages[code]; ok {
+				return m
+			}
+			return message
+		}
+	}
+	return message
+}
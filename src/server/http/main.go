@@ -1,53 +1,100 @@
 package main
 
 import (
+	"config"
 	"encoding/gob"
 	"errors"
-	"storage"
+	"log"
+	"os"
+	"path/filepath"
 	"strconv"
 	"time"
 	"weibo"
 
-	"github.com/gomodule/redigo/redis"
-
-	"github.com/boj/redistore"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/sessions"
-
-	_ "github.com/go-sql-driver/mysql"
-	"github.com/jmoiron/sqlx"
 )
 
 func main() {
-	db, err := sqlx.Open("mysql", "root:@tcp(127.0.0.1:3306)/weibo")
+	cfg, err := config.Load(os.Args[1:])
 	if err != nil {
-		panic(err)
+		log.Fatal(err)
 	}
-	defer db.Close()
 
-	redisClient, err := redis.Dial("tcp", "127.0.0.1:6379")
-	if err != nil {
-		panic(err)
+	var backend *backend
+	if cfg.Storage == config.StorageMemory {
+		log.Println("使用内存存储, 重启后数据会丢失")
+		backend = openMemoryBackend(cfg)
+	} else {
+		backend, err = openMySQLBackend(cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
-	defer redisClient.Close()
-
-	userRepo := storage.NewUserRepository(db, redisClient)
-	timelineRepo := storage.NewTimeLineRepository(db)
-	weiboReppo := storage.NewWeiboRepository(db, redisClient)
-	service := weibo.NewService(userRepo, weiboReppo, timelineRepo)
+	defer backend.close()
 
-	gob.Register(new(weibo.User))
-	// var store = sessions.NewCookieStore([]byte("test"))
-	store, err := redistore.NewRediStore(10, "tcp", "127.0.0.1:6379", "", []byte("test"))
+	ids, err := weibo.NewSnowflake(cfg.ID.WorkerID)
 	if err != nil {
-		panic(err)
+		log.Fatal(err)
 	}
-	defer store.Close()
+	ids.MaxClockBackwards = cfg.ID.MaxClockBackwards
+
+	fanoutWorker := weibo.NewFanoutWorker(backend.fanoutQueue, backend.users, backend.weibos, backend.mutes, backend.timelines, ids, backend.events)
+	fanoutWorker.Concurrency = cfg.Fanout.Concurrency
+	fanoutWorker.BatchSize = cfg.Fanout.BatchSize
+	fanoutWorker.MaxAttempts = cfg.Fanout.MaxAttempts
+	fanoutWorker.RetryDelay = cfg.Fanout.RetryDelay
+	fanoutWorker.Start()
+	defer fanoutWorker.Stop()
+
+	trending := weibo.NewTrending(backend.trendStore)
+	trending.BucketSize = cfg.Trend.Bucket
+	trending.Window = cfg.Trend.Window
+	trending.HalfLife = cfg.Trend.HalfLife
+	trending.Interval = cfg.Trend.Interval
+	trending.Size = cfg.Trend.Size
+	trending.Start()
+	defer trending.Stop()
+
+	service := weibo.NewService(weibo.Dependencies{
+		Repositories: weibo.Repositories{
+			Users:         backend.users,
+			Weibos:        backend.weibos,
+			Comments:      backend.comments,
+			Topics:        backend.topics,
+			Mentions:      backend.mentions,
+			Notifications: backend.notices,
+			Messages:      backend.messages,
+			Blocks:        backend.blocks,
+			Mutes:         backend.mutes,
+			TimeLines:     backend.timelines,
+		},
+		UnitOfWork:  backend.uow,
+		Hasher:      newPasswordHasher(cfg.Password),
+		FanoutQueue: backend.fanoutQueue,
+		IDs:         ids,
+		Trends:      trending,
+		Events:      backend.events,
+	})
+	service.PullThreshold = cfg.Timeline.PullThreshold
+	service.FollowBackfill = cfg.Timeline.FollowBackfill
+	service.FollowerPreviewSize = cfg.Page.Followers
+	service.TopicPreviewSize = cfg.Page.Topics
+	service.MaxMutes = cfg.Timeline.MaxMutes
+	service.TimelineScanLimit = cfg.Timeline.ScanLimit
+
+	gob.Register(new(weibo.User))
 
-	server := &Server{service: service, sessionStore: store}
+	server := &Server{config: cfg, service: service, sessionStore: backend.sessionStore, fanoutWorker: fanoutWorker}
 
+	if cfg.Profile == config.ProfileProd {
+		gin.SetMode(gin.ReleaseMode)
+	}
 	r := gin.Default()
-	r.LoadHTMLGlob("C:/code/weibo/html/*")
+	r.Use(requestContext(cfg.HTTP.RequestTimeout, streamPath))
+	r.Use(server.sessionViewer)
+	r.SetFuncMap(templateFuncs)
+	r.LoadHTMLGlob(filepath.Join(cfg.HTTP.TemplateDir, "*"))
 
 	r.Any("/login", server.login)
 	r.Any("/register", server.register)
@@ -56,24 +103,51 @@ func main() {
 	r.GET("/weibo/follow", server.follow)
 	r.GET("/weibo/unfollow", server.unFollow)
 	r.GET("/weibo/givelike", server.givelike)
+	r.GET("/weibo/repost", server.repost)
 	r.GET("/weibo/collect", server.collect)
 	r.GET("/weibo/postComment", server.postComment)
+	r.GET("/weibo/replyComment", server.replyComment)
 	r.GET("/weibo/deleteComment", server.deleteComment)
+	r.GET("/weibo/comments", server.commentList)
+	r.GET("/weibo/comment", server.commentDetail)
 	r.GET("/weibo/weiboList", server.weiboList)
 	r.GET("/weibo/followersShow", server.followersShow)
 	r.POST("/weibo/searchWeibo", server.searchWeibo)
+	r.GET("/topic/:name", server.topicPage)
+	r.GET("/weibo/hot", server.hotPage)
+	r.GET("/weibo/mentions", server.mentionsPage)
+	r.GET("/weibo/notifications", server.notificationsPage)
+	r.GET("/weibo/messages", server.messagesPage)
+	r.GET("/weibo/conversation", server.conversationPage)
+	r.GET("/weibo/sendMessage", server.sendMessage)
+	r.GET("/weibo/messagePermission", server.setMessagePermission)
+	r.GET("/weibo/block", server.block)
+	r.GET("/weibo/unblock", server.unblock)
+	r.GET("/weibo/blocks", server.blocksPage)
+	r.GET("/weibo/mute", server.mute)
+	r.GET("/weibo/unmute", server.unmute)
+	r.GET("/weibo/mutes", server.mutesPage)
+	r.GET("/user/:account", server.userPage)
 	r.POST("/weibo/searchUser", server.searchUser)
 	r.GET("/notification", server.notificationPage)
+	server.registerAPI(r)
 	r.GET("/")
-	r.Static("/html", "C:/code/weibo/html")
+	r.Static("/html", cfg.HTTP.TemplateDir)
 	// r.POST("/weibo/weiboList", server.weiboList)
 
-	r.Run()
+	if cfg.HTTP.DebugAddr != "" {
+		go server.serveDebug(cfg.HTTP.DebugAddr)
+	}
+	if err := r.Run(cfg.HTTP.Addr); err != nil {
+		log.Fatal(err)
+	}
 }
 
 type Server struct {
+	config       *config.Config
 	service      *weibo.Service
 	sessionStore sessions.Store
+	fanoutWorker *weibo.FanoutWorker
 }
 
 func (s *Server) login(c *gin.Context) {
@@ -92,7 +166,7 @@ func (s *Server) login(c *gin.Context) {
 			return errors.New("参数错误")
 		}
 
-		user, err := s.service.Login(account, password)
+		user, err := s.service.Login(c.Request.Context(), account, password)
 		if err != nil {
 			return err
 		}
@@ -138,7 +212,7 @@ func (s *Server) register(c *gin.Context) {
 			return errors.New("二次密码不正确")
 		}
 
-		user, err := s.service.Register(account, avatar, password)
+		user, err := s.service.Register(c.Request.Context(), account, avatar, password)
 		if err != nil {
 			return err
 		}
@@ -170,7 +244,7 @@ func (s *Server) publishWeibo(c *gin.Context) {
 
 	user := s.getUserFromSession(c)
 	if user == nil {
-		s.redirectToNotificationPageWithError(c, errors.New("先登录"))
+		s.redirectToNotificationPageWithError(c, weibo.ErrUnauthenticated)
 		return
 	}
 
@@ -187,7 +261,7 @@ func (s *Server) publishWeibo(c *gin.Context) {
 		CreatedAt: time.Now().Unix(),
 	}
 
-	err = s.service.PublishWeibo(user, w)
+	err = s.service.PublishWeibo(c.Request.Context(), user, w)
 	if err != nil {
 		return
 	}
@@ -205,7 +279,7 @@ func (s *Server) deleteWeibo(c *gin.Context) {
 
 	user := s.getUserFromSession(c)
 	if user == nil {
-		s.redirectToNotificationPageWithError(c, errors.New("先登录"))
+		s.redirectToNotificationPageWithError(c, weibo.ErrUnauthenticated)
 		return
 	}
 
@@ -216,7 +290,7 @@ func (s *Server) deleteWeibo(c *gin.Context) {
 		return
 	}
 
-	err = s.service.DeleteWeibo(user, weiboID)
+	err = s.service.DeleteWeibo(c.Request.Context(), user, weiboID)
 	if err != nil {
 		return
 	}
@@ -236,7 +310,7 @@ func (s *Server) follow(c *gin.Context) {
 
 	user := s.getUserFromSession(c)
 	if user == nil {
-		s.redirectToNotificationPageWithError(c, errors.New("先登录"))
+		s.redirectToNotificationPageWithError(c, weibo.ErrUnauthenticated)
 		return
 	}
 
@@ -247,7 +321,7 @@ func (s *Server) follow(c *gin.Context) {
 		return
 	}
 
-	err = s.service.Follow(user, toUserID)
+	err = s.service.Follow(c.Request.Context(), user, toUserID)
 	if err != nil {
 		return
 	}
@@ -267,7 +341,7 @@ func (s *Server) unFollow(c *gin.Context) {
 
 	user := s.getUserFromSession(c)
 	if user == nil {
-		s.redirectToNotificationPageWithError(c, errors.New("先登录"))
+		s.redirectToNotificationPageWithError(c, weibo.ErrUnauthenticated)
 		return
 	}
 
@@ -278,7 +352,7 @@ func (s *Server) unFollow(c *gin.Context) {
 		return
 	}
 
-	err = s.service.UnFollow(user, toUserID)
+	err = s.service.UnFollow(c.Request.Context(), user, toUserID)
 	if err != nil {
 		return
 	}
@@ -290,7 +364,38 @@ func (s *Server) unFollow(c *gin.Context) {
 func (s *Server) givelike(c *gin.Context) {
 	user := s.getUserFromSession(c)
 	if user == nil {
-		s.redirectToNotificationPageWithError(c, errors.New("先登录"))
+		s.redirectToNotificationPageWithError(c, weibo.ErrUnauthenticated)
+		return
+	}
+
+	err := func() error {
+		weiboIDStr := c.Query("weiboID")
+		weiboID, _ := strconv.ParseInt(weiboIDStr, 10, 64)
+		if weiboID == 0 {
+			return errors.New("微博不存在")
+		}
+
+		err := s.service.Givelike(c.Request.Context(), user, weiboID)
+		if err != nil {
+			return err
+		}
+
+		s.saveUserToSession(c, nil)
+		return nil
+	}()
+
+	if err != nil {
+		s.redirectToNotificationPageWithError(c, err)
+		return
+	}
+
+	c.Redirect(302, "/weibo/weiboList")
+}
+
+func (s *Server) repost(c *gin.Context) {
+	user := s.getUserFromSession(c)
+	if user == nil {
+		s.redirectToNotificationPageWithError(c, weibo.ErrUnauthenticated)
 		return
 	}
 
@@ -301,7 +406,7 @@ func (s *Server) givelike(c *gin.Context) {
 			return errors.New("微博不存在")
 		}
 
-		err := s.service.Givelike(user, weiboID)
+		_, err := s.service.Repost(c.Request.Context(), user, weiboID, c.Query("comment"))
 		if err != nil {
 			return err
 		}
@@ -321,7 +426,7 @@ func (s *Server) givelike(c *gin.Context) {
 func (s *Server) collect(c *gin.Context) {
 	user := s.getUserFromSession(c)
 	if user == nil {
-		s.redirectToNotificationPageWithError(c, errors.New("先登录"))
+		s.redirectToNotificationPageWithError(c, weibo.ErrUnauthenticated)
 		return
 	}
 
@@ -332,7 +437,7 @@ func (s *Server) collect(c *gin.Context) {
 			return errors.New("微博不存在")
 		}
 
-		err := s.service.Collect(user, weiboID)
+		err := s.service.Collect(c.Request.Context(), user, weiboID)
 		if err != nil {
 			return err
 		}
@@ -351,7 +456,7 @@ func (s *Server) collect(c *gin.Context) {
 func (s *Server) postComment(c *gin.Context) {
 	user := s.getUserFromSession(c)
 	if user == nil {
-		s.redirectToNotificationPageWithError(c, errors.New("先登录"))
+		s.redirectToNotificationPageWithError(c, weibo.ErrUnauthenticated)
 		return
 	}
 
@@ -363,7 +468,7 @@ func (s *Server) postComment(c *gin.Context) {
 		}
 
 		commentContent := c.Query("commentContent")
-		err := s.service.PostComment(user, weiboID, commentContent)
+		_, err := s.service.PostComment(c.Request.Context(), user, weiboID, commentContent)
 		if err != nil {
 			return err
 		}
@@ -379,10 +484,39 @@ func (s *Server) postComment(c *gin.Context) {
 	s.redirectToNotificationPageWithMessage(c, "评论成功")
 }
 
+func (s *Server) replyComment(c *gin.Context) {
+	user := s.getUserFromSession(c)
+	if user == nil {
+		s.redirectToNotificationPageWithError(c, weibo.ErrUnauthenticated)
+		return
+	}
+
+	var reply *weibo.Comment
+	err := func() error {
+		commentIDStr := c.Query("commentID")
+		commentID, _ := strconv.ParseInt(commentIDStr, 10, 64)
+		if commentID == 0 {
+			return weibo.ErrCommentNotFound
+		}
+
+		var err error
+		reply, err = s.service.ReplyComment(c.Request.Context(), user, commentID, c.Query("commentContent"))
+		return err
+	}()
+
+	if err != nil {
+		s.redirectToNotificationPageWithError(c, err)
+		return
+	}
+
+	// 回到所在楼层的详情页
+	c.Redirect(302, "/weibo/comment?commentID="+strconv.FormatInt(reply.RootID, 10))
+}
+
 func (s *Server) deleteComment(c *gin.Context) {
 	user := s.getUserFromSession(c)
 	if user == nil {
-		s.redirectToNotificationPageWithError(c, errors.New("先登录"))
+		s.redirectToNotificationPageWithError(c, weibo.ErrUnauthenticated)
 		return
 	}
 
@@ -393,7 +527,7 @@ func (s *Server) deleteComment(c *gin.Context) {
 			return errors.New("微博不存在")
 		}
 
-		err := s.service.DeleteComment(user, commentID)
+		err := s.service.DeleteComment(c.Request.Context(), user, commentID)
 		if err != nil {
 			return err
 		}
@@ -409,6 +543,72 @@ func (s *Server) deleteComment(c *gin.Context) {
 	s.redirectToNotificationPageWithMessage(c, "删除评论成功")
 }
 
+// 微博下的评论, sort=hot时按楼中的回复数排序
+func (s *Server) commentList(c *gin.Context) {
+	user := s.getUserFromSession(c)
+	if user == nil {
+		c.Redirect(302, "/login")
+		return
+	}
+
+	weiboID, _ := strconv.ParseInt(c.Query("weiboID"), 10, 64)
+	sort, err := weibo.ParseCommentSort(c.Query("sort"))
+	if err != nil {
+		s.redirectToNotificationPageWithError(c, err)
+		return
+	}
+	page, err := getPage(c, s.config.Page.Size)
+	if err != nil {
+		s.redirectToNotificationPageWithError(c, err)
+		return
+	}
+
+	comments, next, err := s.service.CommentList(c.Request.Context(), weiboID, sort, page)
+	if err != nil {
+		s.redirectToNotificationPageWithError(c, err)
+		return
+	}
+
+	c.HTML(200, "comments.html", gin.H{
+		"user":        user,
+		"weibo_id":    weiboID,
+		"sort":        string(sort),
+		"comments":    comments,
+		"next_cursor": next,
+		"next_page":   nextPageURL(c, next),
+	})
+}
+
+// 评论详情: 所在楼层的第一条评论和楼中的回复
+func (s *Server) commentDetail(c *gin.Context) {
+	user := s.getUserFromSession(c)
+	if user == nil {
+		c.Redirect(302, "/login")
+		return
+	}
+
+	commentID, _ := strconv.ParseInt(c.Query("commentID"), 10, 64)
+	page, err := getPage(c, s.config.Page.Size)
+	if err != nil {
+		s.redirectToNotificationPageWithError(c, err)
+		return
+	}
+
+	comment, replies, next, err := s.service.CommentDetail(c.Request.Context(), commentID, page)
+	if err != nil {
+		s.redirectToNotificationPageWithError(c, err)
+		return
+	}
+
+	c.HTML(200, "comment.html", gin.H{
+		"user":        user,
+		"comment":     comment,
+		"replies":     replies,
+		"next_cursor": next,
+		"next_page":   nextPageURL(c, next),
+	})
+}
+
 func (s *Server) weiboList(c *gin.Context) {
 
 	user := s.getUserFromSession(c)
@@ -417,25 +617,43 @@ func (s *Server) weiboList(c *gin.Context) {
 		return
 	}
 
-	var page int64 = 1
-	pageStr := c.Query("page")
-	if len(pageStr) > 0 {
-		page, _ = strconv.ParseInt(pageStr, 10, 64)
-		if page == 0 {
-			page = 1
-		}
+	page, err := getPage(c, s.config.Page.Size)
+	if err != nil {
+		s.redirectToNotificationPageWithError(c, err)
+		return
 	}
 
-	user, followers, weibos, err := s.service.WeiboList(user, page, 15)
+	user, followers, weibos, next, err := s.service.WeiboList(c.Request.Context(), user, page)
+	if err != nil {
+		s.redirectToNotificationPageWithError(c, err)
+		return
+	}
+	topics, err := s.service.TrendingTopics(c.Request.Context(), s.config.Page.Topics)
+	if err != nil {
+		s.redirectToNotificationPageWithError(c, err)
+		return
+	}
+	unread, err := s.service.UnreadNotificationCount(c.Request.Context(), user)
+	if err != nil {
+		s.redirectToNotificationPageWithError(c, err)
+		return
+	}
+	unreadMessages, err := s.service.UnreadMessageCount(c.Request.Context(), user)
 	if err != nil {
 		s.redirectToNotificationPageWithError(c, err)
 		return
 	}
 
 	c.HTML(200, "listNew.html", gin.H{
-		"user":      user,
-		"weibos":    weibos,
-		"followers": followers,
+		"user":            user,
+		"unread":          unread,
+		"unread_messages": unreadMessages,
+		"refresh":         "/weibo/weiboList",
+		"weibos":          weibos,
+		"followers":       followers,
+		"topics":          topics,
+		"next_cursor":     next,
+		"next_page":       nextPageURL(c, next),
 	})
 }
 
@@ -446,45 +664,413 @@ func (s *Server) followersShow(c *gin.Context) {
 		return
 	}
 
-	var page int64 = 1
-	pageStr := c.Query("page")
-	if len(pageStr) > 0 {
-		page, _ = strconv.ParseInt(pageStr, 10, 64)
-		if page == 0 {
-			page = 1
-		}
+	page, err := getPage(c, s.config.Page.Size)
+	if err != nil {
+		s.redirectToNotificationPageWithError(c, err)
+		return
 	}
 
-	user, weibos, err := s.service.FollowersShow(user, page, 15)
+	user, weibos, next, err := s.service.FollowersShow(c.Request.Context(), user, page)
+	if err != nil {
+		s.redirectToNotificationPageWithError(c, err)
+		return
+	}
+	topics, err := s.service.TrendingTopics(c.Request.Context(), s.config.Page.Topics)
+	if err != nil {
+		s.redirectToNotificationPageWithError(c, err)
+		return
+	}
+	unread, err := s.service.UnreadNotificationCount(c.Request.Context(), user)
+	if err != nil {
+		s.redirectToNotificationPageWithError(c, err)
+		return
+	}
+	unreadMessages, err := s.service.UnreadMessageCount(c.Request.Context(), user)
 	if err != nil {
 		s.redirectToNotificationPageWithError(c, err)
 		return
 	}
 
 	c.HTML(200, "home.html", gin.H{
+		"user":            user,
+		"unread":          unread,
+		"unread_messages": unreadMessages,
+		"refresh":         "/weibo/followersShow",
+		"weibos":          weibos,
+		"topics":          topics,
+		"next_cursor":     next,
+		"next_page":       nextPageURL(c, next),
+	})
+}
+
+// 话题页: 话题下的微博, 按发布时间倒序
+func (s *Server) topicPage(c *gin.Context) {
+	user := s.getUserFromSession(c)
+	if user == nil {
+		c.Redirect(302, "/login")
+		return
+	}
+
+	page, err := getPage(c, s.config.Page.Size)
+	if err != nil {
+		s.redirectToNotificationPageWithError(c, err)
+		return
+	}
+
+	topic, weibos, next, err := s.service.TopicWeibos(c.Request.Context(), c.Param("name"), page)
+	if err != nil {
+		s.redirectToNotificationPageWithError(c, err)
+		return
+	}
+
+	c.HTML(200, "topic.html", gin.H{
+		"user":        user,
+		"topic":       topic,
+		"weibos":      weibos,
+		"next_cursor": next,
+		"next_page":   nextPageURL(c, next),
+	})
+}
+
+// 通知列表, 打开之后全部标记为已读, 这一页中未读的通知仍然高亮显示
+func (s *Server) notificationsPage(c *gin.Context) {
+	user := s.getUserFromSession(c)
+	if user == nil {
+		c.Redirect(302, "/login")
+		return
+	}
+
+	page, err := getPage(c, s.config.Page.Size)
+	if err != nil {
+		s.redirectToNotificationPageWithError(c, err)
+		return
+	}
+
+	notifications, next, err := s.service.Notifications(c.Request.Context(), user, page)
+	if err != nil {
+		s.redirectToNotificationPageWithError(c, err)
+		return
+	}
+	if err := s.service.MarkNotificationsRead(c.Request.Context(), user); err != nil {
+		s.redirectToNotificationPageWithError(c, err)
+		return
+	}
+
+	c.HTML(200, "notifications.html", gin.H{
+		"user":          user,
+		"notifications": notifications,
+		"next_cursor":   next,
+		"next_page":     nextPageURL(c, next),
+	})
+}
+
+// 私信: 会话列表和谁可以给自己发私信的设置
+func (s *Server) messagesPage(c *gin.Context) {
+	user := s.getUserFromSession(c)
+	if user == nil {
+		c.Redirect(302, "/login")
+		return
+	}
+
+	page, err := getPage(c, s.config.Page.Size)
+	if err != nil {
+		s.redirectToNotificationPageWithError(c, err)
+		return
+	}
+
+	conversations, next, err := s.service.Conversations(c.Request.Context(), user, page)
+	if err != nil {
+		s.redirectToNotificationPageWithError(c, err)
+		return
+	}
+
+	c.HTML(200, "messages.html", gin.H{
+		"user":          user,
+		"conversations": conversations,
+		"next_cursor":   next,
+		"next_page":     nextPageURL(c, next),
+	})
+}
+
+// 和一个人的私信, 打开之后会话标记为已读
+func (s *Server) conversationPage(c *gin.Context) {
+	user := s.getUserFromSession(c)
+	if user == nil {
+		c.Redirect(302, "/login")
+		return
+	}
+
+	page, err := getPage(c, s.config.Page.Size)
+	if err != nil {
+		s.redirectToNotificationPageWithError(c, err)
+		return
+	}
+
+	peerID, _ := strconv.ParseInt(c.Query("peerID"), 10, 64)
+	peer, messages, next, err := s.service.Messages(c.Request.Context(), user, peerID, page)
+	if err != nil {
+		s.redirectToNotificationPageWithError(c, err)
+		return
+	}
+	if err := s.service.MarkConversationRead(c.Request.Context(), user, peerID); err != nil {
+		s.redirectToNotificationPageWithError(c, err)
+		return
+	}
+
+	c.HTML(200, "conversation.html", gin.H{
+		"user":        user,
+		"peer":        peer,
+		"messages":    messages,
+		"next_cursor": next,
+		"next_page":   nextPageURL(c, next),
+	})
+}
+
+func (s *Server) sendMessage(c *gin.Context) {
+	user := s.getUserFromSession(c)
+	if user == nil {
+		s.redirectToNotificationPageWithError(c, weibo.ErrUnauthenticated)
+		return
+	}
+
+	toUserID, _ := strconv.ParseInt(c.Query("toUserID"), 10, 64)
+	if _, err := s.service.SendMessage(c.Request.Context(), user, toUserID, c.Query("content")); err != nil {
+		s.redirectToNotificationPageWithError(c, err)
+		return
+	}
+
+	c.Redirect(302, "/weibo/conversation?peerID="+strconv.FormatInt(toUserID, 10))
+}
+
+func (s *Server) setMessagePermission(c *gin.Context) {
+	user := s.getUserFromSession(c)
+	if user == nil {
+		s.redirectToNotificationPageWithError(c, weibo.ErrUnauthenticated)
+		return
+	}
+
+	if err := s.service.SetMessagePermission(c.Request.Context(), user, c.Query("permission")); err != nil {
+		s.redirectToNotificationPageWithError(c, err)
+		return
+	}
+	// session中保存的是登录时的用户信息
+	s.saveUserToSession(c, user)
+
+	s.redirectToNotificationPageWithMessage(c, "私信设置已保存")
+}
+
+// 屏蔽用户, 双方之间的关注关系一起解除
+func (s *Server) block(c *gin.Context) {
+	user := s.getUserFromSession(c)
+	if user == nil {
+		s.redirectToNotificationPageWithError(c, weibo.ErrUnauthenticated)
+		return
+	}
+
+	targetUserID, _ := strconv.ParseInt(c.Query("id"), 10, 64)
+	if err := s.service.Block(c.Request.Context(), user, targetUserID); err != nil {
+		s.redirectToNotificationPageWithError(c, err)
+		return
+	}
+
+	s.redirectToNotificationPageWithMessage(c, "已屏蔽该用户")
+}
+
+func (s *Server) unblock(c *gin.Context) {
+	user := s.getUserFromSession(c)
+	if user == nil {
+		s.redirectToNotificationPageWithError(c, weibo.ErrUnauthenticated)
+		return
+	}
+
+	targetUserID, _ := strconv.ParseInt(c.Query("id"), 10, 64)
+	if err := s.service.Unblock(c.Request.Context(), user, targetUserID); err != nil {
+		s.redirectToNotificationPageWithError(c, err)
+		return
+	}
+
+	c.Redirect(302, "/weibo/blocks")
+}
+
+// 屏蔽列表
+func (s *Server) blocksPage(c *gin.Context) {
+	user := s.getUserFromSession(c)
+	if user == nil {
+		c.Redirect(302, "/login")
+		return
+	}
+
+	page, err := getPage(c, s.config.Page.Size)
+	if err != nil {
+		s.redirectToNotificationPageWithError(c, err)
+		return
+	}
+
+	blocked, next, err := s.service.BlockedUsers(c.Request.Context(), user, page)
+	if err != nil {
+		s.redirectToNotificationPageWithError(c, err)
+		return
+	}
+
+	c.HTML(200, "blocks.html", gin.H{
+		"user":        user,
+		"blocked":     blocked,
+		"next_cursor": next,
+		"next_page":   nextPageURL(c, next),
+	})
+}
+
+// 静音账号或者关键词, 有id时静音账号, 否则静音keyword
+func (s *Server) mute(c *gin.Context) {
+	user := s.getUserFromSession(c)
+	if user == nil {
+		s.redirectToNotificationPageWithError(c, weibo.ErrUnauthenticated)
+		return
+	}
+
+	d, err := weibo.ParseMuteDuration(c.Query("duration"))
+	if err != nil {
+		s.redirectToNotificationPageWithError(c, err)
+		return
+	}
+	if targetUserID, _ := strconv.ParseInt(c.Query("id"), 10, 64); targetUserID != 0 {
+		_, err = s.service.MuteUser(c.Request.Context(), user, targetUserID, d)
+	} else {
+		_, err = s.service.MuteKeyword(c.Request.Context(), user, c.Query("keyword"), d)
+	}
+	if err != nil {
+		s.redirectToNotificationPageWithError(c, err)
+		return
+	}
+
+	c.Redirect(302, "/weibo/mutes")
+}
+
+func (s *Server) unmute(c *gin.Context) {
+	user := s.getUserFromSession(c)
+	if user == nil {
+		s.redirectToNotificationPageWithError(c, weibo.ErrUnauthenticated)
+		return
+	}
+
+	muteID, _ := strconv.ParseInt(c.Query("id"), 10, 64)
+	if err := s.service.Unmute(c.Request.Context(), user, muteID); err != nil {
+		s.redirectToNotificationPageWithError(c, err)
+		return
+	}
+
+	c.Redirect(302, "/weibo/mutes")
+}
+
+// 静音的账号和关键词
+func (s *Server) mutesPage(c *gin.Context) {
+	user := s.getUserFromSession(c)
+	if user == nil {
+		c.Redirect(302, "/login")
+		return
+	}
+
+	mutes, err := s.service.Mutes(c.Request.Context(), user)
+	if err != nil {
+		s.redirectToNotificationPageWithError(c, err)
+		return
+	}
+
+	c.HTML(200, "mutes.html", gin.H{
+		"user":  user,
+		"mutes": mutes,
+	})
+}
+
+// @我的: 提到当前用户的微博和评论
+func (s *Server) mentionsPage(c *gin.Context) {
+	user := s.getUserFromSession(c)
+	if user == nil {
+		c.Redirect(302, "/login")
+		return
+	}
+
+	page, err := getPage(c, s.config.Page.Size)
+	if err != nil {
+		s.redirectToNotificationPageWithError(c, err)
+		return
+	}
+
+	mentions, next, err := s.service.Mentions(c.Request.Context(), user, page)
+	if err != nil {
+		s.redirectToNotificationPageWithError(c, err)
+		return
+	}
+
+	c.HTML(200, "mentions.html", gin.H{
+		"user":        user,
+		"mentions":    mentions,
+		"next_cursor": next,
+		"next_page":   nextPageURL(c, next),
+	})
+}
+
+// 用户主页, 内容中提到的账号链接到这里
+func (s *Server) userPage(c *gin.Context) {
+	user := s.getUserFromSession(c)
+	if user == nil {
+		c.Redirect(302, "/login")
+		return
+	}
+
+	profile, err := s.service.UserProfile(c.Request.Context(), c.Param("account"))
+	if err != nil {
+		s.redirectToNotificationPageWithError(c, err)
+		return
+	}
+
+	c.HTML(200, "user.html", gin.H{
+		"user":    user,
+		"profile": profile,
+	})
+}
+
+// 热门微博: 滑动窗口内点赞、评论和转发最多的微博
+func (s *Server) hotPage(c *gin.Context) {
+	user := s.getUserFromSession(c)
+	if user == nil {
+		c.Redirect(302, "/login")
+		return
+	}
+
+	weibos, err := s.service.HotWeibos(c.Request.Context(), s.config.Trend.HotWeibos)
+	if err != nil {
+		s.redirectToNotificationPageWithError(c, err)
+		return
+	}
+	topics, err := s.service.TrendingTopics(c.Request.Context(), s.config.Page.Topics)
+	if err != nil {
+		s.redirectToNotificationPageWithError(c, err)
+		return
+	}
+
+	c.HTML(200, "hot.html", gin.H{
 		"user":   user,
 		"weibos": weibos,
+		"topics": topics,
 	})
 }
 
 func (s *Server) searchWeibo(c *gin.Context) {
 	user := s.getUserFromSession(c)
 	if user == nil {
-		s.redirectToNotificationPageWithError(c, errors.New("先登录"))
+		s.redirectToNotificationPageWithError(c, weibo.ErrUnauthenticated)
 		return
 	}
 
-	var page int64 = 1
-	pageStr := c.Query("page")
-	if len(pageStr) > 0 {
-		page, _ = strconv.ParseInt(pageStr, 10, 64)
-		if page == 0 {
-			page = 1
-		}
+	page, err := getPage(c, s.config.Page.Size)
+	if err != nil {
+		s.redirectToNotificationPageWithError(c, err)
+		return
 	}
 
 	accountOrContent := c.Query("accountOrContent")
-	weibos, err := s.service.SearchWeibo(accountOrContent, page, 15)
+	weibos, next, err := s.service.SearchWeibo(c.Request.Context(), accountOrContent, page)
 	// weibos, err := s.service.SearchWeibo(accountOrContent, page, perPage)
 	if err != nil {
 		s.redirectToNotificationPageWithError(c, err)
@@ -492,34 +1078,70 @@ func (s *Server) searchWeibo(c *gin.Context) {
 	}
 
 	c.HTML(200, "listNew.html", gin.H{
-		"user":   user,
-		"weibos": weibos,
+		"user":        user,
+		"weibos":      weibos,
+		"next_cursor": next,
+		"next_page":   nextPageURL(c, next),
 	})
 }
 
 func (s *Server) searchUser(c *gin.Context) {
 	user := s.getUserFromSession(c)
 	if user == nil {
-		s.redirectToNotificationPageWithError(c, errors.New("先登录"))
+		s.redirectToNotificationPageWithError(c, weibo.ErrUnauthenticated)
 		return
 	}
 
-	var page int64 = 1
-	pageStr := c.Query("page")
-	if len(pageStr) > 0 {
-		page, _ = strconv.ParseInt(pageStr, 10, 64)
-		if page == 0 {
-			page = 1
-		}
+	page, err := getPage(c, s.config.Page.Size)
+	if err != nil {
+		s.redirectToNotificationPageWithError(c, err)
+		return
 	}
 
-	users, err := s.service.SearchUser("", page, 15)
+	users, next, err := s.service.SearchUser(c.Request.Context(), c.Query("account"), page)
 	if err != nil {
 		s.redirectToNotificationPageWithError(c, err)
 		return
 	}
 
-	c.HTML(200, "list.html", users)
+	c.HTML(200, "list.html", gin.H{
+		"users":       users,
+		"next_cursor": next,
+		"next_page":   nextPageURL(c, next),
+	})
+}
+
+// 从请求中读取分页参数, 优先使用cursor, 没有时使用page
+func getPage(c *gin.Context, perPage int64) (weibo.Page, error) {
+	page, _ := strconv.ParseInt(c.Query("page"), 10, 64)
+	return weibo.ParsePage(c.Query("cursor"), page, perPage)
+}
+
+// 下一页的链接, 保留当前请求的其它参数
+func nextPageURL(c *gin.Context, next string) string {
+	if len(next) == 0 {
+		return ""
+	}
+	query := c.Request.URL.Query()
+	query.Del("page")
+	query.Set("cursor", next)
+	return c.Request.URL.Path + "?" + query.Encode()
+}
+
+// 调试接口不经过登录, 和对外的接口分开监听
+func (s *Server) serveDebug(addr string) {
+	r := gin.New()
+	r.Use(gin.Recovery())
+	r.GET("/debug/fanout", s.fanoutStats)
+	log.Println("调试接口监听在", addr)
+	if err := r.Run(addr); err != nil {
+		log.Println("调试接口启动失败:", err)
+	}
+}
+
+// 扩散任务的积压和延迟
+func (s *Server) fanoutStats(c *gin.Context) {
+	c.JSON(200, s.fanoutWorker.Stats())
 }
 
 func (s *Server) notificationPage(c *gin.Context) {
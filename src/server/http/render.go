@@ -0,0 +1,44 @@
+package main
+
+import (
+	"html/template"
+	"net/url"
+	"strings"
+	"time"
+	"weibo"
+)
+
+// 模板中使用的函数
+var templateFuncs = template.FuncMap{
+	"content":  renderContent,
+	"datetime": formatUnix,
+}
+
+// 显示unix时间戳, 精确到分钟
+func formatUnix(unix int64) string {
+	return time.Unix(unix, 0).Format("2006-01-02 15:04")
+}
+
+// 渲染微博内容, 其中的话题显示成话题页的链接, 提到的账号显示成用户主页的链接
+func renderContent(content string) template.HTML {
+	var b strings.Builder
+	for _, segment := range weibo.SplitContent(content) {
+		switch {
+		case segment.Topic != "":
+			writeLink(&b, "/topic/"+url.PathEscape(segment.Topic), segment.Text)
+		case segment.Mention != "":
+			writeLink(&b, "/user/"+url.PathEscape(segment.Mention), segment.Text)
+		default:
+			b.WriteString(template.HTMLEscapeString(segment.Text))
+		}
+	}
+	return template.HTML(b.String())
+}
+
+func writeLink(b *strings.Builder, href, text string) {
+	b.WriteString(`<a href="`)
+	b.WriteString(template.HTMLEscapeString(href))
+	b.WriteString(`">`)
+	b.WriteString(template.HTMLEscapeString(text))
+	b.WriteString(`</a>`)
+}
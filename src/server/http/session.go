@@ -17,6 +17,14 @@ func (s *Server) getUserFromSession(c *gin.Context) *weibo.User {
 	return user.(*weibo.User)
 }
 
+// 页面请求也把登录的用户保存在ctx中, 列表按当前用户过滤屏蔽关系中的人
+func (s *Server) sessionViewer(c *gin.Context) {
+	if user := s.getUserFromSession(c); user != nil {
+		c.Request = c.Request.WithContext(weibo.WithViewer(c.Request.Context(), user))
+	}
+	c.Next()
+}
+
 func (s *Server) saveUserToSession(c *gin.Context, user *weibo.User) {
 	session, _ := s.sessionStore.Get(c.Request, "weibo")
 	session.Values["user"] = user
@@ -25,6 +33,14 @@ func (s *Server) saveUserToSession(c *gin.Context, user *weibo.User) {
 	}
 }
 
+func (s *Server) removeUserFromSession(c *gin.Context) {
+	session, _ := s.sessionStore.Get(c.Request, "weibo")
+	delete(session.Values, "user")
+	if err := session.Save(c.Request, c.Writer); err != nil {
+		log.Println(err)
+	}
+}
+
 func (s *Server) redirectToNotificationPageWithError(c *gin.Context, err error) {
 	session, _ := s.sessionStore.Get(c.Request, "weibo")
 	session.Values["err"] = err.Error()
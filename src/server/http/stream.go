@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+// 推送接口的路径, 不受请求处理时间上限的限制
+const streamPath = "/api/v1/stream"
+
+// 浏览器断线之后重连的等待时间, 单位毫秒
+const streamRetry = 3000
+
+// 用Server-Sent Events推送新微博、通知和未读数
+// 浏览器重连时带上Last-Event-ID, 补发断线期间错过的事件
+func (s *Server) apiStream(c *gin.Context) {
+	var lastEventID int64
+	if id := c.GetHeader("Last-Event-ID"); id != "" {
+		parsed, err := strconv.ParseInt(id, 10, 64)
+		if err != nil || parsed < 0 {
+			abortWithError(c, &validationError{message: "无效的Last-Event-ID"})
+			return
+		}
+		lastEventID = parsed
+	}
+
+	ctx := c.Request.Context()
+	user := currentUser(c)
+	events, err := s.service.Subscribe(ctx, user, lastEventID)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+	unread, err := s.service.UnreadCount(ctx, user)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.Header("Content-Type", sse.ContentType)
+	c.Header("Cache-Control", "no-cache")
+	// nginx默认会缓冲响应, 事件要立即送到浏览器
+	c.Header("X-Accel-Buffering", "no")
+
+	heartbeat := time.NewTicker(s.config.Stream.Heartbeat)
+	defer heartbeat.Stop()
+
+	// 连接建立后先发送当前的未读数, 不带id, 不影响断线重连时的补发
+	first := &sse.Event{Event: "unread_count", Retry: streamRetry, Data: unread}
+	c.Stream(func(w io.Writer) bool {
+		if first != nil {
+			err := sse.Encode(w, *first)
+			first = nil
+			return err == nil
+		}
+
+		select {
+		case event, ok := <-events:
+			if !ok {
+				// 读得太慢被断开, 浏览器会带上Last-Event-ID重连
+				return false
+			}
+			return sse.Encode(w, sse.Event{Id: strconv.FormatInt(event.ID, 10), Event: string(event.Type), Data: event.Data}) == nil
+		case <-heartbeat.C:
+			return sse.Encode(w, sse.Event{Event: "heartbeat", Data: time.Now().Unix()}) == nil
+		case <-ctx.Done():
+			return false
+		}
+	})
+}
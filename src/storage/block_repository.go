@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"weibo"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var _ weibo.BlockRepository = new(BlockRepository)
+
+// 屏蔽关系
+type BlockRepository struct {
+	db dbtx
+}
+
+func NewBlockRepository(db *sqlx.DB) *BlockRepository {
+	return &BlockRepository{db: db}
+}
+
+// 保存屏蔽关系
+func (br *BlockRepository) CreateBlock(ctx context.Context, block *weibo.Block) error {
+	_, err := br.db.NamedExecContext(ctx, "INSERT INTO `blocks`(user_id, blocked_user_id, created_at) VALUES(:user_id, :blocked_user_id, :created_at)", block)
+	return err
+}
+
+// 删除屏蔽关系
+func (br *BlockRepository) DeleteBlock(ctx context.Context, block *weibo.Block) error {
+	_, err := br.db.ExecContext(ctx, "DELETE FROM `blocks` WHERE user_id = ? AND blocked_user_id = ?", block.UserID, block.BlockedUserID)
+	return err
+}
+
+// 查询userID是否屏蔽了blockedUserID
+func (br *BlockRepository) GetBlock(ctx context.Context, userID, blockedUserID int64) (*weibo.Block, error) {
+	var block weibo.Block
+	if err := br.db.GetContext(ctx, &block, "SELECT * FROM `blocks` WHERE user_id = ? AND blocked_user_id = ?", userID, blockedUserID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &block, nil
+}
+
+// 两个人中任意一方屏蔽了另一方
+func (br *BlockRepository) IsBlocked(ctx context.Context, userID, otherUserID int64) (bool, error) {
+	var count int64
+	err := br.db.GetContext(ctx, &count, "SELECT COUNT(*) FROM `blocks` WHERE (user_id = ? AND blocked_user_id = ?) OR (user_id = ? AND blocked_user_id = ?)",
+		userID, otherUserID, otherUserID, userID)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// 用户屏蔽了的人和屏蔽了用户的人的id
+func (br *BlockRepository) GetBlockRelatedUserIDs(ctx context.Context, userID int64) ([]int64, error) {
+	ids := []int64{}
+	err := br.db.SelectContext(ctx, &ids, `
+		SELECT blocked_user_id FROM blocks WHERE user_id = ?
+		UNION
+		SELECT user_id FROM blocks WHERE blocked_user_id = ?`, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// 分页查询用户屏蔽了的人
+func (br *BlockRepository) GetBlockedUsers(ctx context.Context, userID int64, page weibo.Page) ([]*weibo.BlockedUser, error) {
+	query, args := pageQuery(`
+		SELECT u.id, u.account, u.avatar, b.created_at AS blocked_at FROM users u
+		INNER JOIN blocks b ON b.blocked_user_id = u.id
+		WHERE b.user_id = ? AND %s`, []interface{}{userID}, page, "b.created_at", "b.blocked_user_id")
+
+	users := []*weibo.BlockedUser{}
+	if err := br.db.SelectContext(ctx, &users, query, args...); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
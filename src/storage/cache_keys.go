@@ -0,0 +1,60 @@
+package storage
+
+import "fmt"
+
+// 所有缓存的key都在这里生成, 读取和失效使用同一个函数, 避免两边的key不一致
+// key包含决定缓存内容的全部参数, 分页参数不同的查询不能共用同一个key
+
+// 单条微博, 插入、删除、点赞、评论时删除
+func weiboKey(weiboID int64) string {
+	return fmt.Sprintf("weibo:%d", weiboID)
+}
+
+// 用户最近的FollowersCacheSize个粉丝, 不同的limit都从这份缓存中截取, 关注和取消关注时删除
+func followersKey(userID int64) string {
+	return fmt.Sprintf("followers:%d", userID)
+}
+
+// 用户的timeline, 保存在redis sorted set中, 由RedisTimeLineRepository维护
+func timelineKey(userID int64) string {
+	return fmt.Sprintf("timeline:%d", userID)
+}
+
+// timeline正在从mysql重建的标记, 重建期间新增的timeline先写入timelinePendingKey, 重建完成时合并
+func timelineRebuildKey(userID int64) string {
+	return fmt.Sprintf("timeline:%d:rebuild", userID)
+}
+
+func timelinePendingKey(userID int64) string {
+	return fmt.Sprintf("timeline:%d:pending", userID)
+}
+
+// 热度计数的时间桶, 由RedisTrendStore维护, 窗口过去之后自动过期
+func trendBucketKey(kind string, bucket int64) string {
+	return fmt.Sprintf("trend:%s:%d", kind, bucket)
+}
+
+// 合并时间桶得到的排行榜
+func trendRankKey(kind string) string {
+	return fmt.Sprintf("trend:%s:rank", kind)
+}
+
+// 用户的未读通知数, 增加通知和标记已读时删除
+func unreadNotificationsKey(userID int64) string {
+	return fmt.Sprintf("notifications:unread:%d", userID)
+}
+
+// 推送给用户的最近事件, 由RedisEventBroker维护, 断线重连时补发
+func eventsKey(userID int64) string {
+	return fmt.Sprintf("events:%d", userID)
+}
+
+// 用户在某个实例上有事件连接, 由连接所在的实例定时续期, 实例退出后自动过期
+func eventsOnlineKey(userID int64) string {
+	return fmt.Sprintf("events:online:%d", userID)
+}
+
+// 用户的静音列表, 包括已经过期的, 添加和删除静音时删除
+func mutesKey(userID int64) string {
+	return fmt.Sprintf("mutes:%d", userID)
+}
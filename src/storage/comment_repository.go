@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"weibo"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var _ weibo.CommentRepository = new(CommentRepository)
+
+// 评论仓库, 评论不经过缓存
+type CommentRepository struct {
+	db dbtx
+}
+
+func NewCommentRepository(db *sqlx.DB) *CommentRepository {
+	return &CommentRepository{db: db}
+}
+
+// 保存评论, id由调用方生成
+func (cr *CommentRepository) CreateComment(ctx context.Context, comment *weibo.Comment) error {
+	_, err := cr.db.NamedExecContext(ctx, "INSERT INTO `comment`(id, user_id, weibo_id, parent_id, root_id, reply_num, content, created_at) VALUES(:id, :user_id, :weibo_id, :parent_id, :root_id, :reply_num, :content, :created_at)", comment)
+	return err
+}
+
+func (cr *CommentRepository) GetCommentByID(ctx context.Context, commentID int64) (*weibo.Comment, error) {
+	var comment weibo.Comment
+	if err := cr.db.GetContext(ctx, &comment, "SELECT * FROM `comment` WHERE `id` = ?", commentID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &comment, nil
+}
+
+// 删除评论和楼中的回复
+func (cr *CommentRepository) DeleteComment(ctx context.Context, commentID int64) (int64, error) {
+	result, err := cr.db.ExecContext(ctx, "DELETE FROM `comment` WHERE id = ? OR root_id = ?", commentID, commentID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// 增加楼中的回复数
+func (cr *CommentRepository) AddReplyNumByCommentID(ctx context.Context, commentID int64, num int32) error {
+	_, err := cr.db.ExecContext(ctx, "UPDATE `comment` SET reply_num = reply_num + ? WHERE id = ?", num, commentID)
+	return err
+}
+
+// 分页查询直接评论微博的评论
+func (cr *CommentRepository) GetCommentsByWeiboID(ctx context.Context, weiboID int64, sort weibo.CommentSort, page weibo.Page) ([]*weibo.CommentWithUser, error) {
+	orderBy := "c.created_at"
+	if sort == weibo.CommentSortHot {
+		orderBy = "c.reply_num"
+	}
+	query, args := pageQuery(`
+		SELECT c.*, u.account, u.avatar FROM comment c
+		INNER JOIN users u ON c.user_id = u.id
+		WHERE c.weibo_id = ? AND c.root_id = 0 AND %s`, []interface{}{weiboID}, page, orderBy, "c.id")
+
+	comments := []*weibo.CommentWithUser{}
+	if err := cr.db.SelectContext(ctx, &comments, query, args...); err != nil {
+		return nil, err
+	}
+	return comments, nil
+}
+
+// 分页查询楼中的回复
+func (cr *CommentRepository) GetRepliesByRootID(ctx context.Context, rootID int64, page weibo.Page) ([]*weibo.CommentWithUser, error) {
+	query, args := pageQuery(`
+		SELECT c.*, u.account, u.avatar FROM comment c
+		INNER JOIN users u ON c.user_id = u.id
+		WHERE c.root_id = ? AND %s`, []interface{}{rootID}, page, "c.created_at", "c.id")
+
+	comments := []*weibo.CommentWithUser{}
+	if err := cr.db.SelectContext(ctx, &comments, query, args...); err != nil {
+		return nil, err
+	}
+	return comments, nil
+}
+
+// 根据id批量查询评论
+func (cr *CommentRepository) GetCommentsByIDs(ctx context.Context, commentIDs []int64) ([]*weibo.CommentWithUser, error) {
+	comments := []*weibo.CommentWithUser{}
+	if len(commentIDs) == 0 {
+		return comments, nil
+	}
+
+	query, args, err := sqlx.In(`
+		SELECT c.*, u.account, u.avatar FROM comment c
+		INNER JOIN users u ON c.user_id = u.id
+		WHERE c.id IN (?)`, commentIDs)
+	if err != nil {
+		return nil, err
+	}
+	if err := cr.db.SelectContext(ctx, &comments, query, args...); err != nil {
+		return nil, err
+	}
+	return comments, nil
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"encoding/json"
+	"time"
+	"weibo"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+var _ weibo.FanoutQueue = new(RedisFanoutQueue)
+
+const (
+	fanoutQueueKey = "fanout:queue"
+	fanoutRetryKey = "fanout:retry"
+	fanoutDeadKey  = "fanout:dead"
+)
+
+// 把到期的重试任务移回队列
+var promoteRetryScript = redis.NewScript(2, `
+local jobs = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, 100)
+for _, job in ipairs(jobs) do
+	redis.call('ZREM', KEYS[1], job)
+	redis.call('LPUSH', KEYS[2], job)
+end
+return #jobs
+`)
+
+// 基于redis list的扩散任务队列, 重试任务按到期时间保存在sorted set中
+type RedisFanoutQueue struct {
+	pool *redis.Pool
+}
+
+func NewRedisFanoutQueue(pool *redis.Pool) *RedisFanoutQueue {
+	return &RedisFanoutQueue{pool: pool}
+}
+
+func (q *RedisFanoutQueue) Enqueue(job *weibo.FanoutJob) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	conn := q.pool.Get()
+	defer conn.Close()
+	_, err = conn.Do("LPUSH", fanoutQueueKey, data)
+	return err
+}
+
+func (q *RedisFanoutQueue) Dequeue(timeout time.Duration) (*weibo.FanoutJob, error) {
+	conn := q.pool.Get()
+	defer conn.Close()
+
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	if _, err := promoteRetryScript.Do(conn, fanoutRetryKey, fanoutQueueKey, now); err != nil {
+		return nil, err
+	}
+
+	seconds := int(timeout / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	// 连接的读取超时比阻塞的时间短, 单独指定这次读取的超时
+	values, err := redis.ByteSlices(redis.DoWithTimeout(conn, time.Duration(seconds+1)*time.Second, "BRPOP", fanoutQueueKey, seconds))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var job weibo.FanoutJob
+	if err := json.Unmarshal(values[1], &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (q *RedisFanoutQueue) Retry(job *weibo.FanoutJob, delay time.Duration) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	conn := q.pool.Get()
+	defer conn.Close()
+	retryAt := time.Now().Add(delay).UnixNano() / int64(time.Millisecond)
+	_, err = conn.Do("ZADD", fanoutRetryKey, retryAt, data)
+	return err
+}
+
+func (q *RedisFanoutQueue) DeadLetter(job *weibo.FanoutJob) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	conn := q.pool.Get()
+	defer conn.Close()
+	_, err = conn.Do("LPUSH", fanoutDeadKey, data)
+	return err
+}
+
+// 等待处理的任务数, 包括等待重试的任务
+func (q *RedisFanoutQueue) Len() (int64, error) {
+	conn := q.pool.Get()
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	conn.Send("LLEN", fanoutQueueKey)
+	conn.Send("ZCARD", fanoutRetryKey)
+	values, err := redis.Int64s(conn.Do("EXEC"))
+	if err != nil {
+		return 0, err
+	}
+	return values[0] + values[1], nil
+}
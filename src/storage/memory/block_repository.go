@@ -0,0 +1,109 @@
+package memory
+
+import (
+	"context"
+	"weibo"
+)
+
+var _ weibo.BlockRepository = new(BlockRepository)
+
+// 屏蔽关系
+type BlockRepository struct {
+	store *Store
+}
+
+func NewBlockRepository(store *Store) *BlockRepository {
+	return &BlockRepository{store: store}
+}
+
+// 保存屏蔽关系
+func (br *BlockRepository) CreateBlock(ctx context.Context, block *weibo.Block) (err error) {
+	br.store.write(func(d *data) {
+		key := pair{block.UserID, block.BlockedUserID}
+		if _, ok := d.blocks[key]; ok {
+			err = errDuplicate("blocks")
+			return
+		}
+		created := *block
+		d.blocks[key] = &created
+	})
+	return
+}
+
+// 删除屏蔽关系
+func (br *BlockRepository) DeleteBlock(ctx context.Context, block *weibo.Block) error {
+	br.store.write(func(d *data) {
+		delete(d.blocks, pair{block.UserID, block.BlockedUserID})
+	})
+	return nil
+}
+
+// 查询userID是否屏蔽了blockedUserID
+func (br *BlockRepository) GetBlock(ctx context.Context, userID, blockedUserID int64) (block *weibo.Block, err error) {
+	br.store.read(func(d *data) {
+		if b, ok := d.blocks[pair{userID, blockedUserID}]; ok {
+			copied := *b
+			block = &copied
+		}
+	})
+	return
+}
+
+// 两个人中任意一方屏蔽了另一方
+func (br *BlockRepository) IsBlocked(ctx context.Context, userID, otherUserID int64) (blocked bool, err error) {
+	br.store.read(func(d *data) {
+		_, a := d.blocks[pair{userID, otherUserID}]
+		_, b := d.blocks[pair{otherUserID, userID}]
+		blocked = a || b
+	})
+	return
+}
+
+// 用户屏蔽了的人和屏蔽了用户的人的id
+func (br *BlockRepository) GetBlockRelatedUserIDs(ctx context.Context, userID int64) ([]int64, error) {
+	ids := []int64{}
+	br.store.read(func(d *data) {
+		seen := map[int64]bool{}
+		for key := range d.blocks {
+			other := int64(0)
+			switch userID {
+			case key.a:
+				other = key.b
+			case key.b:
+				other = key.a
+			}
+			if other != 0 && !seen[other] {
+				seen[other] = true
+				ids = append(ids, other)
+			}
+		}
+	})
+	return ids, nil
+}
+
+// 分页查询用户屏蔽了的人
+func (br *BlockRepository) GetBlockedUsers(ctx context.Context, userID int64, page weibo.Page) ([]*weibo.BlockedUser, error) {
+	users := []*weibo.BlockedUser{}
+	br.store.read(func(d *data) {
+		for key, b := range d.blocks {
+			if key.a != userID {
+				continue
+			}
+			user, ok := d.users[b.BlockedUserID]
+			if !ok {
+				continue
+			}
+			users = append(users, &weibo.BlockedUser{
+				ID:        user.ID,
+				Account:   user.Account,
+				Avatar:    user.Avatar,
+				BlockedAt: b.CreatedAt,
+			})
+		}
+	})
+
+	key := func(i int) (int64, int64) { return users[i].BlockedAt, users[i].ID }
+	sortDesc(len(users), key, func(i, j int) { users[i], users[j] = users[j], users[i] })
+	start, end := pageRange(len(users), key, page)
+	return users[start:end], nil
+}
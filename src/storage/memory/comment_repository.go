@@ -0,0 +1,113 @@
+package memory
+
+import (
+	"context"
+	"weibo"
+)
+
+var _ weibo.CommentRepository = new(CommentRepository)
+
+// 评论仓库
+type CommentRepository struct {
+	store *Store
+}
+
+func NewCommentRepository(store *Store) *CommentRepository {
+	return &CommentRepository{store: store}
+}
+
+// 保存评论, id由调用方生成
+func (cr *CommentRepository) CreateComment(ctx context.Context, comment *weibo.Comment) (err error) {
+	cr.store.write(func(d *data) {
+		if _, ok := d.comments[comment.ID]; ok {
+			err = errDuplicate("comment")
+			return
+		}
+		created := *comment
+		d.comments[comment.ID] = &created
+	})
+	return
+}
+
+func (cr *CommentRepository) GetCommentByID(ctx context.Context, commentID int64) (comment *weibo.Comment, err error) {
+	cr.store.read(func(d *data) {
+		if c, ok := d.comments[commentID]; ok {
+			copied := *c
+			comment = &copied
+		}
+	})
+	return
+}
+
+// 删除评论和楼中的回复
+func (cr *CommentRepository) DeleteComment(ctx context.Context, commentID int64) (deleted int64, err error) {
+	cr.store.write(func(d *data) {
+		for id, c := range d.comments {
+			if id == commentID || c.RootID == commentID {
+				delete(d.comments, id)
+				deleted++
+			}
+		}
+	})
+	return
+}
+
+// 增加楼中的回复数
+func (cr *CommentRepository) AddReplyNumByCommentID(ctx context.Context, commentID int64, num int32) error {
+	cr.store.write(func(d *data) {
+		if c, ok := d.comments[commentID]; ok {
+			c.ReplyNum += num
+		}
+	})
+	return nil
+}
+
+// 分页查询直接评论微博的评论
+func (cr *CommentRepository) GetCommentsByWeiboID(ctx context.Context, weiboID int64, sort weibo.CommentSort, page weibo.Page) ([]*weibo.CommentWithUser, error) {
+	comments := cr.find(func(c *weibo.Comment) bool { return c.WeiboID == weiboID && c.RootID == 0 })
+
+	key := func(i int) (int64, int64) { return comments[i].CreatedAt, comments[i].ID }
+	if sort == weibo.CommentSortHot {
+		key = func(i int) (int64, int64) { return int64(comments[i].ReplyNum), comments[i].ID }
+	}
+	sortDesc(len(comments), key, func(i, j int) { comments[i], comments[j] = comments[j], comments[i] })
+	start, end := pageRange(len(comments), key, page)
+	return comments[start:end], nil
+}
+
+// 分页查询楼中的回复
+func (cr *CommentRepository) GetRepliesByRootID(ctx context.Context, rootID int64, page weibo.Page) ([]*weibo.CommentWithUser, error) {
+	comments := cr.find(func(c *weibo.Comment) bool { return c.RootID == rootID })
+
+	key := func(i int) (int64, int64) { return comments[i].CreatedAt, comments[i].ID }
+	sortDesc(len(comments), key, func(i, j int) { comments[i], comments[j] = comments[j], comments[i] })
+	start, end := pageRange(len(comments), key, page)
+	return comments[start:end], nil
+}
+
+// 根据id批量查询评论
+func (cr *CommentRepository) GetCommentsByIDs(ctx context.Context, commentIDs []int64) ([]*weibo.CommentWithUser, error) {
+	wanted := make(map[int64]bool, len(commentIDs))
+	for _, id := range commentIDs {
+		wanted[id] = true
+	}
+	return cr.find(func(c *weibo.Comment) bool { return wanted[c.ID] }), nil
+}
+
+// 查询满足条件的评论和评论者的信息, 评论者不存在时忽略
+func (cr *CommentRepository) find(match func(c *weibo.Comment) bool) []*weibo.CommentWithUser {
+	comments := []*weibo.CommentWithUser{}
+	cr.store.read(func(d *data) {
+		for _, c := range d.comments {
+			if !match(c) {
+				continue
+			}
+			user, ok := d.users[c.UserID]
+			if !ok {
+				continue
+			}
+			comments = append(comments, &weibo.CommentWithUser{Comment: *c, Account: user.Account, Avatar: user.Avatar})
+		}
+	})
+	return comments
+}
@@ -0,0 +1,26 @@
+package memory
+
+import (
+	"storage/storagetest"
+	"testing"
+	"weibo"
+)
+
+func TestRepositoryContract(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) (*weibo.Repositories, weibo.UnitOfWork) {
+		store := NewStore()
+		repos := &weibo.Repositories{
+			Users:         NewUserRepository(store),
+			Weibos:        NewWeiboRepository(store),
+			Comments:      NewCommentRepository(store),
+			Topics:        NewTopicRepository(store),
+			Mentions:      NewMentionRepository(store),
+			Notifications: NewNotificationRepository(store),
+			Messages:      NewMessageRepository(store),
+			Blocks:        NewBlockRepository(store),
+			Mutes:         NewMuteRepository(store),
+			TimeLines:     NewTimeLineRepository(store),
+		}
+		return repos, NewUnitOfWork(store)
+	})
+}
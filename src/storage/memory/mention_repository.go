@@ -0,0 +1,77 @@
+package memory
+
+import (
+	"context"
+	"weibo"
+)
+
+var _ weibo.MentionRepository = new(MentionRepository)
+
+// 提到用户的记录
+type MentionRepository struct {
+	store *Store
+}
+
+func NewMentionRepository(store *Store) *MentionRepository {
+	return &MentionRepository{store: store}
+}
+
+// 保存提到用户的记录, 同一条微博或者评论重复提到同一个用户时报错
+func (mr *MentionRepository) CreateMentions(ctx context.Context, mentions []*weibo.Mention) (err error) {
+	mr.store.write(func(d *data) {
+		for _, m := range mentions {
+			if _, ok := d.mentions[m.ID]; ok {
+				err = errDuplicate("mentions")
+				return
+			}
+			for _, existing := range d.mentions {
+				if existing.WeiboID == m.WeiboID && existing.CommentID == m.CommentID && existing.UserID == m.UserID {
+					err = errDuplicate("mentions")
+					return
+				}
+			}
+		}
+		for _, m := range mentions {
+			created := *m
+			d.mentions[m.ID] = &created
+		}
+	})
+	return
+}
+
+// 删除微博和微博下的评论中提到用户的记录
+func (mr *MentionRepository) DeleteMentionsByWeiboID(ctx context.Context, weiboID int64) error {
+	mr.store.write(func(d *data) {
+		for id, m := range d.mentions {
+			if m.WeiboID == weiboID {
+				delete(d.mentions, id)
+			}
+		}
+	})
+	return nil
+}
+
+// 分页查询提到某个用户的记录, 所在的微博或者评论已经删除的不返回
+func (mr *MentionRepository) GetMentionsByUserID(ctx context.Context, userID int64, page weibo.Page) ([]*weibo.Mention, error) {
+	mentions := []*weibo.Mention{}
+	mr.store.read(func(d *data) {
+		for _, m := range d.mentions {
+			if m.UserID != userID {
+				continue
+			}
+			if _, ok := d.weibos[m.WeiboID]; !ok {
+				continue
+			}
+			if _, ok := d.comments[m.CommentID]; m.InComment() && !ok {
+				continue
+			}
+			copied := *m
+			mentions = append(mentions, &copied)
+		}
+	})
+
+	key := func(i int) (int64, int64) { return mentions[i].CreatedAt, mentions[i].ID }
+	sortDesc(len(mentions), key, func(i, j int) { mentions[i], mentions[j] = mentions[j], mentions[i] })
+	start, end := pageRange(len(mentions), key, page)
+	return mentions[start:end], nil
+}
@@ -0,0 +1,108 @@
+package memory
+
+import (
+	"context"
+	"weibo"
+)
+
+var _ weibo.MessageRepository = new(MessageRepository)
+
+// 私信仓库
+type MessageRepository struct {
+	store *Store
+}
+
+func NewMessageRepository(store *Store) *MessageRepository {
+	return &MessageRepository{store: store}
+}
+
+// 保存私信
+func (mr *MessageRepository) CreateMessage(ctx context.Context, message *weibo.Message) (err error) {
+	mr.store.write(func(d *data) {
+		if _, ok := d.messages[message.ID]; ok {
+			err = errDuplicate("messages")
+			return
+		}
+		created := *message
+		d.messages[message.ID] = &created
+	})
+	return
+}
+
+// 会话已经存在时更新最后一条私信并增加未读数
+func (mr *MessageRepository) SaveConversation(ctx context.Context, c *weibo.Conversation) error {
+	mr.store.write(func(d *data) {
+		if existing, ok := d.conversations[pair{c.UserID, c.PeerID}]; ok {
+			existing.LastMessageID = c.LastMessageID
+			existing.LastContent = c.LastContent
+			existing.UnreadNum += c.UnreadNum
+			existing.UpdatedAt = c.UpdatedAt
+			return
+		}
+		created := *c
+		d.conversations[pair{c.UserID, c.PeerID}] = &created
+	})
+	return nil
+}
+
+// 分页查询用户的会话
+func (mr *MessageRepository) GetConversationsByUserID(ctx context.Context, userID int64, page weibo.Page) ([]*weibo.ConversationWithUser, error) {
+	conversations := []*weibo.ConversationWithUser{}
+	mr.store.read(func(d *data) {
+		for key, c := range d.conversations {
+			if key.a != userID {
+				continue
+			}
+			peer, ok := d.users[c.PeerID]
+			if !ok {
+				continue
+			}
+			conversations = append(conversations, &weibo.ConversationWithUser{Conversation: *c, PeerAccount: peer.Account, PeerAvatar: peer.Avatar})
+		}
+	})
+
+	key := func(i int) (int64, int64) { return conversations[i].UpdatedAt, conversations[i].ID }
+	sortDesc(len(conversations), key, func(i, j int) { conversations[i], conversations[j] = conversations[j], conversations[i] })
+	start, end := pageRange(len(conversations), key, page)
+	return conversations[start:end], nil
+}
+
+// 分页查询两个人之间的私信
+func (mr *MessageRepository) GetMessages(ctx context.Context, userID, peerID int64, page weibo.Page) ([]*weibo.Message, error) {
+	messages := []*weibo.Message{}
+	mr.store.read(func(d *data) {
+		for _, m := range d.messages {
+			if (m.FromUserID == userID && m.ToUserID == peerID) || (m.FromUserID == peerID && m.ToUserID == userID) {
+				copied := *m
+				messages = append(messages, &copied)
+			}
+		}
+	})
+
+	key := func(i int) (int64, int64) { return messages[i].CreatedAt, messages[i].ID }
+	sortDesc(len(messages), key, func(i, j int) { messages[i], messages[j] = messages[j], messages[i] })
+	start, end := pageRange(len(messages), key, page)
+	return messages[start:end], nil
+}
+
+// 把会话的未读数清零
+func (mr *MessageRepository) MarkConversationRead(ctx context.Context, userID, peerID int64) error {
+	mr.store.write(func(d *data) {
+		if c, ok := d.conversations[pair{userID, peerID}]; ok {
+			c.UnreadNum = 0
+		}
+	})
+	return nil
+}
+
+// 用户所有会话的未读数之和
+func (mr *MessageRepository) CountUnreadMessages(ctx context.Context, userID int64) (count int64, err error) {
+	mr.store.read(func(d *data) {
+		for key, c := range d.conversations {
+			if key.a == userID {
+				count += int64(c.UnreadNum)
+			}
+		}
+	})
+	return
+}
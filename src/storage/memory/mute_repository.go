@@ -0,0 +1,88 @@
+package memory
+
+import (
+	"context"
+	"weibo"
+)
+
+var _ weibo.MuteRepository = new(MuteRepository)
+
+// 静音
+type MuteRepository struct {
+	store *Store
+}
+
+func NewMuteRepository(store *Store) *MuteRepository {
+	return &MuteRepository{store: store}
+}
+
+// 同一个用户对同一个账号或者关键词只能有一条静音
+func (mr *MuteRepository) CreateMute(ctx context.Context, mute *weibo.Mute) (err error) {
+	mr.store.write(func(d *data) {
+		for _, m := range d.mutes {
+			if m.UserID == mute.UserID && m.MutedUserID == mute.MutedUserID && m.Keyword == mute.Keyword {
+				err = errDuplicate("mutes")
+				return
+			}
+		}
+		created := *mute
+		d.mutes[mute.ID] = &created
+	})
+	return
+}
+
+// 只能删除自己的静音
+func (mr *MuteRepository) DeleteMute(ctx context.Context, userID, muteID int64) (deleted int64, err error) {
+	mr.store.write(func(d *data) {
+		if m, ok := d.mutes[muteID]; ok && m.UserID == userID {
+			delete(d.mutes, muteID)
+			deleted = 1
+		}
+	})
+	return
+}
+
+func (mr *MuteRepository) DeleteExpiredMutes(ctx context.Context, userID, now int64) error {
+	mr.store.write(func(d *data) {
+		for id, m := range d.mutes {
+			if m.UserID == userID && m.Expired(now) {
+				delete(d.mutes, id)
+			}
+		}
+	})
+	return nil
+}
+
+// 用户全部的静音, 按创建时间倒序
+func (mr *MuteRepository) GetMutesByUserID(ctx context.Context, userID int64) ([]*weibo.Mute, error) {
+	mutes := []*weibo.Mute{}
+	mr.store.read(func(d *data) {
+		for _, m := range d.mutes {
+			if m.UserID == userID {
+				copied := *m
+				mutes = append(mutes, &copied)
+			}
+		}
+	})
+
+	key := func(i int) (int64, int64) { return mutes[i].CreatedAt, mutes[i].ID }
+	sortDesc(len(mutes), key, func(i, j int) { mutes[i], mutes[j] = mutes[j], mutes[i] })
+	return mutes, nil
+}
+
+func (mr *MuteRepository) GetMutesByUserIDs(ctx context.Context, userIDs []int64) ([]*weibo.Mute, error) {
+	wanted := make(map[int64]bool, len(userIDs))
+	for _, userID := range userIDs {
+		wanted[userID] = true
+	}
+	mutes := []*weibo.Mute{}
+	mr.store.read(func(d *data) {
+		for _, m := range d.mutes {
+			if wanted[m.UserID] {
+				copied := *m
+				mutes = append(mutes, &copied)
+			}
+		}
+	})
+	return mutes, nil
+}
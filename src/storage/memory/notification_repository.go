@@ -0,0 +1,113 @@
+package memory
+
+import (
+	"context"
+	"weibo"
+)
+
+var _ weibo.NotificationRepository = new(NotificationRepository)
+
+// 通知仓库
+type NotificationRepository struct {
+	store *Store
+}
+
+func NewNotificationRepository(store *Store) *NotificationRepository {
+	return &NotificationRepository{store: store}
+}
+
+// 已经有同一个聚合的未读通知时合并进去
+func (nr *NotificationRepository) AddNotification(ctx context.Context, n *weibo.Notification) error {
+	nr.store.write(func(d *data) {
+		merged := false
+		for _, existing := range d.notices {
+			if existing.UserID == n.UserID && existing.Kind == n.Kind && existing.TargetID == n.TargetID && !existing.IsRead() {
+				existing.ActorID = n.ActorID
+				existing.ActorAccount = n.ActorAccount
+				existing.ActorNum += n.ActorNum
+				existing.UpdatedAt = n.UpdatedAt
+				n.ID = existing.ID
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			created := *n
+			created.ReadKey = 0
+			d.notices[n.ID] = &created
+		}
+
+		key := pair{n.ID, n.ActorID}
+		actor, ok := d.noticeActors[key]
+		if !ok {
+			actor = &weibo.NotificationActor{NotificationID: n.ID, ActorID: n.ActorID}
+			d.noticeActors[key] = actor
+		}
+		actor.ActorAccount = n.ActorAccount
+		actor.ActorNum += n.ActorNum
+		actor.UpdatedAt = n.UpdatedAt
+	})
+	return nil
+}
+
+func (nr *NotificationRepository) GetNotificationActors(ctx context.Context, notificationIDs []int64) ([]*weibo.NotificationActor, error) {
+	actors := []*weibo.NotificationActor{}
+	ids := make(map[int64]bool, len(notificationIDs))
+	for _, id := range notificationIDs {
+		ids[id] = true
+	}
+	nr.store.read(func(d *data) {
+		for key, actor := range d.noticeActors {
+			if ids[key.a] {
+				copied := *actor
+				actors = append(actors, &copied)
+			}
+		}
+	})
+
+	sortDesc(len(actors), func(i int) (int64, int64) { return actors[i].UpdatedAt, actors[i].ActorID },
+		func(i, j int) { actors[i], actors[j] = actors[j], actors[i] })
+	return actors, nil
+}
+
+// 分页查询用户的通知
+func (nr *NotificationRepository) GetNotificationsByUserID(ctx context.Context, userID int64, page weibo.Page) ([]*weibo.Notification, error) {
+	notifications := []*weibo.Notification{}
+	nr.store.read(func(d *data) {
+		for _, n := range d.notices {
+			if n.UserID == userID {
+				copied := *n
+				notifications = append(notifications, &copied)
+			}
+		}
+	})
+
+	key := func(i int) (int64, int64) { return notifications[i].UpdatedAt, notifications[i].ID }
+	sortDesc(len(notifications), key, func(i, j int) { notifications[i], notifications[j] = notifications[j], notifications[i] })
+	start, end := pageRange(len(notifications), key, page)
+	return notifications[start:end], nil
+}
+
+// 未读通知数
+func (nr *NotificationRepository) CountUnreadNotifications(ctx context.Context, userID int64) (count int64, err error) {
+	nr.store.read(func(d *data) {
+		for _, n := range d.notices {
+			if n.UserID == userID && !n.IsRead() {
+				count++
+			}
+		}
+	})
+	return
+}
+
+// 把用户的全部通知标记为已读
+func (nr *NotificationRepository) MarkNotificationsRead(ctx context.Context, userID int64) error {
+	nr.store.write(func(d *data) {
+		for _, n := range d.notices {
+			if n.UserID == userID && !n.IsRead() {
+				n.ReadKey = n.ID
+			}
+		}
+	})
+	return nil
+}
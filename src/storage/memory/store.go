@@ -0,0 +1,247 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"weibo"
+
+	"github.com/pkg/errors"
+)
+
+var _ weibo.UnitOfWork = new(UnitOfWork)
+
+// 两个id组成的联合主键
+type pair struct {
+	a, b int64
+}
+
+// 违反唯一约束时返回的错误, 对应mysql的Duplicate entry
+func errDuplicate(table string) error {
+	return errors.Wrap(weibo.ErrDuplicate, table)
+}
+
+// 所有表的数据, 结构和mysql中的表一一对应
+type data struct {
+	users         map[int64]*weibo.User
+	followings    map[pair]*weibo.Following // (from_user_id, to_user_id)
+	weibos        map[int64]*weibo.Weibo
+	givelikes     map[pair]*weibo.Givelike // (user_id, weibo_id)
+	collects      map[pair]*weibo.Collect  // (user_id, weibo_id)
+	comments      map[int64]*weibo.Comment
+	timelines     map[pair]*weibo.TimeLine // (user_id, weibo_id)
+	topics        map[int64]*weibo.Topic
+	weiboTopics   map[pair]*weibo.WeiboTopic // (topic_id, weibo_id)
+	mentions      map[int64]*weibo.Mention
+	notices       map[int64]*weibo.Notification
+	noticeActors  map[pair]*weibo.NotificationActor // (notification_id, actor_id)
+	messages      map[int64]*weibo.Message
+	conversations map[pair]*weibo.Conversation // (user_id, peer_id)
+	blocks        map[pair]*weibo.Block        // (user_id, blocked_user_id)
+	mutes         map[int64]*weibo.Mute
+
+	// 自增id
+	lastUserID  int64
+	lastTopicID int64
+}
+
+func newData() *data {
+	return &data{
+		users:         map[int64]*weibo.User{},
+		followings:    map[pair]*weibo.Following{},
+		weibos:        map[int64]*weibo.Weibo{},
+		givelikes:     map[pair]*weibo.Givelike{},
+		collects:      map[pair]*weibo.Collect{},
+		comments:      map[int64]*weibo.Comment{},
+		timelines:     map[pair]*weibo.TimeLine{},
+		topics:        map[int64]*weibo.Topic{},
+		weiboTopics:   map[pair]*weibo.WeiboTopic{},
+		mentions:      map[int64]*weibo.Mention{},
+		notices:       map[int64]*weibo.Notification{},
+		noticeActors:  map[pair]*weibo.NotificationActor{},
+		messages:      map[int64]*weibo.Message{},
+		conversations: map[pair]*weibo.Conversation{},
+		blocks:        map[pair]*weibo.Block{},
+		mutes:         map[int64]*weibo.Mute{},
+	}
+}
+
+// 复制一份数据给事务使用, 事务提交时整体替换
+func (d *data) clone() *data {
+	c := newData()
+	for k, v := range d.users {
+		user := *v
+		c.users[k] = &user
+	}
+	for k, v := range d.followings {
+		following := *v
+		c.followings[k] = &following
+	}
+	for k, v := range d.weibos {
+		weibo := *v
+		c.weibos[k] = &weibo
+	}
+	for k, v := range d.givelikes {
+		givelike := *v
+		c.givelikes[k] = &givelike
+	}
+	for k, v := range d.collects {
+		collect := *v
+		c.collects[k] = &collect
+	}
+	for k, v := range d.comments {
+		comment := *v
+		c.comments[k] = &comment
+	}
+	for k, v := range d.timelines {
+		timeline := *v
+		c.timelines[k] = &timeline
+	}
+	for k, v := range d.topics {
+		topic := *v
+		c.topics[k] = &topic
+	}
+	for k, v := range d.weiboTopics {
+		weiboTopic := *v
+		c.weiboTopics[k] = &weiboTopic
+	}
+	for k, v := range d.mentions {
+		mention := *v
+		c.mentions[k] = &mention
+	}
+	for k, v := range d.notices {
+		notice := *v
+		c.notices[k] = &notice
+	}
+	for k, v := range d.noticeActors {
+		actor := *v
+		c.noticeActors[k] = &actor
+	}
+	for k, v := range d.messages {
+		message := *v
+		c.messages[k] = &message
+	}
+	for k, v := range d.conversations {
+		conversation := *v
+		c.conversations[k] = &conversation
+	}
+	for k, v := range d.blocks {
+		block := *v
+		c.blocks[k] = &block
+	}
+	for k, v := range d.mutes {
+		mute := *v
+		c.mutes[k] = &mute
+	}
+	c.lastUserID = d.lastUserID
+	c.lastTopicID = d.lastTopicID
+	return c
+}
+
+// 内存中的数据库, 用于本地开发和测试, 所有仓库共享同一份数据
+type Store struct {
+	mu   *sync.RWMutex
+	data *data
+}
+
+func NewStore() *Store {
+	return &Store{mu: new(sync.RWMutex), data: newData()}
+}
+
+func (s *Store) read(fn func(d *data)) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	fn(s.data)
+}
+
+func (s *Store) write(fn func(d *data)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(s.data)
+}
+
+// 基于数据快照的工作单元
+// 事务执行期间独占整个Store, fn中只能使用传入的仓库, 使用事务外的仓库会死锁
+// 内存中的操作不会阻塞, 仓库方法不检查ctx, 只有事务在ctx结束时和数据库一样不提交
+type UnitOfWork struct {
+	store *Store
+}
+
+func NewUnitOfWork(store *Store) *UnitOfWork {
+	return &UnitOfWork{store: store}
+}
+
+func (u *UnitOfWork) Do(ctx context.Context, fn func(repos *weibo.Repositories) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	u.store.mu.Lock()
+	defer u.store.mu.Unlock()
+
+	tx := &Store{mu: new(sync.RWMutex), data: u.store.data.clone()}
+	repos := &weibo.Repositories{
+		Users:         NewUserRepository(tx),
+		Weibos:        NewWeiboRepository(tx),
+		Comments:      NewCommentRepository(tx),
+		Topics:        NewTopicRepository(tx),
+		Mentions:      NewMentionRepository(tx),
+		Notifications: NewNotificationRepository(tx),
+		Messages:      NewMessageRepository(tx),
+		Blocks:        NewBlockRepository(tx),
+		Mutes:         NewMuteRepository(tx),
+		TimeLines:     NewTimeLineRepository(tx),
+	}
+	if err := fn(repos); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	u.store.data = tx.data
+	return nil
+}
+
+// 按 (createdAt, id) 倒序排序
+func sortDesc(n int, key func(i int) (int64, int64), swap func(i, j int)) {
+	sort.Sort(&sorter{n: n, key: key, swap: swap})
+}
+
+type sorter struct {
+	n    int
+	key  func(i int) (int64, int64)
+	swap func(i, j int)
+}
+
+func (s *sorter) Len() int      { return s.n }
+func (s *sorter) Swap(i, j int) { s.swap(i, j) }
+func (s *sorter) Less(i, j int) bool {
+	ci, ii := s.key(i)
+	cj, ij := s.key(j)
+	if ci != cj {
+		return ci > cj
+	}
+	return ii > ij
+}
+
+// 在已经按 (createdAt, id) 倒序排好的列表中取出一页, 返回这一页的起止下标
+func pageRange(n int, key func(i int) (int64, int64), page weibo.Page) (int, int) {
+	start := 0
+	if page.Cursor != nil {
+		start = sort.Search(n, func(i int) bool {
+			createdAt, id := key(i)
+			return createdAt < page.Cursor.CreatedAt || (createdAt == page.Cursor.CreatedAt && id < page.Cursor.ID)
+		})
+	} else if page.Offset < int64(n) {
+		start = int(page.Offset)
+	} else {
+		start = n
+	}
+
+	end := n
+	if int64(end-start) > page.Limit {
+		end = start + int(page.Limit)
+	}
+	return start, end
+}
@@ -0,0 +1,122 @@
+package memory
+
+import (
+	"context"
+	"weibo"
+)
+
+var _ weibo.TimeLineRepository = new(TimeLineRepository)
+
+// timeline仓库
+type TimeLineRepository struct {
+	store *Store
+}
+
+func NewTimeLineRepository(store *Store) *TimeLineRepository {
+	return &TimeLineRepository{store: store}
+}
+
+// 查询某个用户自己发布的最近的微博
+func (tl *TimeLineRepository) GetRecentlyWeiboIDsByUserID(ctx context.Context, userID int64, limit int32) ([]*weibo.TimeLine, error) {
+	timelines := []*weibo.TimeLine{}
+	tl.store.read(func(d *data) {
+		for _, t := range d.timelines {
+			if t.UserID == userID && t.WeiboUserID == userID {
+				copied := *t
+				timelines = append(timelines, &copied)
+			}
+		}
+	})
+
+	key := func(i int) (int64, int64) { return timelines[i].WeiboCreatedAt, timelines[i].WeiboID }
+	sortDesc(len(timelines), key, func(i, j int) { timelines[i], timelines[j] = timelines[j], timelines[i] })
+	if int64(len(timelines)) > int64(limit) {
+		timelines = timelines[:limit]
+	}
+	return timelines, nil
+}
+
+// 查询某个用户的timeline, 按微博发布时间倒序
+func (tl *TimeLineRepository) GetTimeLinesByUserID(ctx context.Context, userID int64, page weibo.Page) ([]*weibo.TimeLine, error) {
+	timelines := []*weibo.TimeLine{}
+	tl.store.read(func(d *data) {
+		for _, t := range d.timelines {
+			if t.UserID == userID {
+				copied := *t
+				timelines = append(timelines, &copied)
+			}
+		}
+	})
+
+	key := func(i int) (int64, int64) { return timelines[i].WeiboCreatedAt, timelines[i].WeiboID }
+	sortDesc(len(timelines), key, func(i, j int) { timelines[i], timelines[j] = timelines[j], timelines[i] })
+	start, end := pageRange(len(timelines), key, page)
+	return timelines[start:end], nil
+}
+
+// 批量把一批微博id插入到timeline中, 已经存在的会被忽略
+func (tl *TimeLineRepository) BatchCreateTimeLines(ctx context.Context, timelines []*weibo.TimeLine) error {
+	tl.store.write(func(d *data) {
+		for _, timeline := range timelines {
+			insertTimeLine(d, timeline)
+		}
+	})
+	return nil
+}
+
+// 删除某个用户的timeline中某人发的微博
+func (tl *TimeLineRepository) DeleteWeiboByUserIDAndWeiboUserID(ctx context.Context, userID int64, weiboUserID int64) error {
+	tl.store.write(func(d *data) {
+		for key, t := range d.timelines {
+			if t.UserID == userID && t.WeiboUserID == weiboUserID {
+				delete(d.timelines, key)
+			}
+		}
+	})
+	return nil
+}
+
+// 删除某个用户的timeline中的某条微博
+func (tl *TimeLineRepository) DeleteWeiboByUserIDAndWeiboID(ctx context.Context, userID int64, weiboID int64) error {
+	tl.store.write(func(d *data) {
+		delete(d.timelines, pair{userID, weiboID})
+	})
+	return nil
+}
+
+// 插入新的timeline
+func (tl *TimeLineRepository) CreateTimeLine(ctx context.Context, timeline *weibo.TimeLine) (err error) {
+	tl.store.write(func(d *data) {
+		if !insertTimeLine(d, timeline) {
+			err = errDuplicate("timeline")
+		}
+	})
+	return
+}
+
+// 从所有用户的timeline中删除某条微博
+func (tl *TimeLineRepository) DeleteTimeLinesByWeiboID(ctx context.Context, weiboID int64, limit int64) (n int64, err error) {
+	tl.store.write(func(d *data) {
+		for key, t := range d.timelines {
+			if n >= limit {
+				return
+			}
+			if t.WeiboID == weiboID {
+				delete(d.timelines, key)
+				n++
+			}
+		}
+	})
+	return
+}
+
+// 插入一条timeline, (user_id, weibo_id) 已经存在时返回false
+func insertTimeLine(d *data, timeline *weibo.TimeLine) bool {
+	key := pair{timeline.UserID, timeline.WeiboID}
+	if _, ok := d.timelines[key]; ok {
+		return false
+	}
+	created := *timeline
+	d.timelines[key] = &created
+	return true
+}
@@ -0,0 +1,179 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"weibo"
+)
+
+var _ weibo.TopicRepository = new(TopicRepository)
+
+// 话题仓库
+type TopicRepository struct {
+	store *Store
+}
+
+func NewTopicRepository(store *Store) *TopicRepository {
+	return &TopicRepository{store: store}
+}
+
+// 保存话题, 已经存在的话题不会重复创建
+func (tr *TopicRepository) CreateTopics(ctx context.Context, names []string, createdAt int64) ([]*weibo.Topic, error) {
+	topics := []*weibo.Topic{}
+	tr.store.write(func(d *data) {
+		for _, name := range names {
+			topic := findTopic(d, name)
+			if topic == nil {
+				d.lastTopicID++
+				topic = &weibo.Topic{ID: d.lastTopicID, Name: name, CreatedAt: createdAt}
+				d.topics[topic.ID] = topic
+			}
+			copied := *topic
+			topics = append(topics, &copied)
+		}
+	})
+	return topics, nil
+}
+
+func findTopic(d *data, name string) *weibo.Topic {
+	for _, topic := range d.topics {
+		if topic.Name == name {
+			return topic
+		}
+	}
+	return nil
+}
+
+func (tr *TopicRepository) GetTopicByName(ctx context.Context, name string) (topic *weibo.Topic, err error) {
+	tr.store.read(func(d *data) {
+		if t := findTopic(d, name); t != nil {
+			copied := *t
+			topic = &copied
+		}
+	})
+	return
+}
+
+// 根据id批量查询话题
+func (tr *TopicRepository) GetTopicsByIDs(ctx context.Context, topicIDs []int64) ([]*weibo.Topic, error) {
+	topics := []*weibo.Topic{}
+	tr.store.read(func(d *data) {
+		seen := map[int64]bool{}
+		for _, id := range topicIDs {
+			if topic, ok := d.topics[id]; ok && !seen[id] {
+				seen[id] = true
+				copied := *topic
+				topics = append(topics, &copied)
+			}
+		}
+	})
+	return topics, nil
+}
+
+// 增加话题下的微博数
+func (tr *TopicRepository) AddWeiboNumByTopicIDs(ctx context.Context, topicIDs []int64, num int32) error {
+	tr.store.write(func(d *data) {
+		for _, id := range topicIDs {
+			if topic, ok := d.topics[id]; ok {
+				topic.WeiboNum += num
+			}
+		}
+	})
+	return nil
+}
+
+// 保存微博和话题的关联
+func (tr *TopicRepository) CreateWeiboTopics(ctx context.Context, weiboTopics []*weibo.WeiboTopic) (err error) {
+	tr.store.write(func(d *data) {
+		for _, wt := range weiboTopics {
+			if _, ok := d.weiboTopics[pair{wt.TopicID, wt.WeiboID}]; ok {
+				err = errDuplicate("weibo_topics")
+				return
+			}
+		}
+		for _, wt := range weiboTopics {
+			created := *wt
+			d.weiboTopics[pair{wt.TopicID, wt.WeiboID}] = &created
+		}
+	})
+	return
+}
+
+// 查询微博关联的话题
+func (tr *TopicRepository) GetTopicsByWeiboID(ctx context.Context, weiboID int64) ([]*weibo.Topic, error) {
+	topics := []*weibo.Topic{}
+	tr.store.read(func(d *data) {
+		for key := range d.weiboTopics {
+			if key.b != weiboID {
+				continue
+			}
+			if topic, ok := d.topics[key.a]; ok {
+				copied := *topic
+				topics = append(topics, &copied)
+			}
+		}
+	})
+	return topics, nil
+}
+
+// 删除微博和话题的关联
+func (tr *TopicRepository) DeleteWeiboTopicsByWeiboID(ctx context.Context, weiboID int64) error {
+	tr.store.write(func(d *data) {
+		for key := range d.weiboTopics {
+			if key.b == weiboID {
+				delete(d.weiboTopics, key)
+			}
+		}
+	})
+	return nil
+}
+
+// 分页查询话题下的微博
+func (tr *TopicRepository) GetWeibosByTopicID(ctx context.Context, topicID int64, page weibo.Page) ([]*weibo.WeiboWithUser, error) {
+	weibos := []*weibo.WeiboWithUser{}
+	tr.store.read(func(d *data) {
+		for key := range d.weiboTopics {
+			if key.a != topicID {
+				continue
+			}
+			w, ok := d.weibos[key.b]
+			if !ok {
+				continue
+			}
+			user, ok := d.users[w.UserID]
+			if !ok {
+				continue
+			}
+			weibos = append(weibos, &weibo.WeiboWithUser{Weibo: *w, Avatar: user.Avatar})
+		}
+	})
+
+	key := func(i int) (int64, int64) { return weibos[i].CreatedAt, weibos[i].ID }
+	sortDesc(len(weibos), key, func(i, j int) { weibos[i], weibos[j] = weibos[j], weibos[i] })
+	start, end := pageRange(len(weibos), key, page)
+	return weibos[start:end], nil
+}
+
+// 微博数最多的话题
+func (tr *TopicRepository) GetTopTopics(ctx context.Context, limit int64) ([]*weibo.Topic, error) {
+	topics := []*weibo.Topic{}
+	tr.store.read(func(d *data) {
+		for _, topic := range d.topics {
+			if topic.WeiboNum > 0 {
+				copied := *topic
+				topics = append(topics, &copied)
+			}
+		}
+	})
+
+	sort.Slice(topics, func(i, j int) bool {
+		if topics[i].WeiboNum != topics[j].WeiboNum {
+			return topics[i].WeiboNum > topics[j].WeiboNum
+		}
+		return topics[i].ID > topics[j].ID
+	})
+	if int64(len(topics)) > limit {
+		topics = topics[:limit]
+	}
+	return topics, nil
+}
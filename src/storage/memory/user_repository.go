@@ -0,0 +1,235 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"weibo"
+)
+
+var _ weibo.UserRepository = new(UserRepository)
+
+// 用户仓库
+type UserRepository struct {
+	store *Store
+}
+
+func NewUserRepository(store *Store) *UserRepository {
+	return &UserRepository{store: store}
+}
+
+// 通过账号名查找用户
+func (ur *UserRepository) GetUserByAccount(ctx context.Context, account string) (user *weibo.User, err error) {
+	ur.store.read(func(d *data) {
+		for _, u := range d.users {
+			if u.Account == account {
+				copied := *u
+				user = &copied
+				return
+			}
+		}
+	})
+	return
+}
+
+// 创建用户
+func (ur *UserRepository) CreateUser(ctx context.Context, user *weibo.User) error {
+	ur.store.write(func(d *data) {
+		d.lastUserID++
+		user.ID = d.lastUserID
+		created := *user
+		d.users[user.ID] = &created
+	})
+	return nil
+}
+
+// 更新用户的密码哈希
+func (ur *UserRepository) UpdatePassword(ctx context.Context, userID int64, password, salt string) error {
+	ur.store.write(func(d *data) {
+		if user, ok := d.users[userID]; ok {
+			user.Password = password
+			user.Salt = salt
+		}
+	})
+	return nil
+}
+
+// 更新谁可以给用户发私信
+func (ur *UserRepository) UpdateMessagePermission(ctx context.Context, userID int64, permission weibo.MessagePermission) error {
+	ur.store.write(func(d *data) {
+		if user, ok := d.users[userID]; ok {
+			user.MessagePermission = permission
+		}
+	})
+	return nil
+}
+
+// 根据用户id查询相应用户信息
+func (ur *UserRepository) GetUserByID(ctx context.Context, userID int64) (user *weibo.User, err error) {
+	ur.store.read(func(d *data) {
+		if u, ok := d.users[userID]; ok {
+			copied := *u
+			user = &copied
+		}
+	})
+	return
+}
+
+// 增加用户所关注的人数
+func (ur *UserRepository) AddFollowingNumByUserID(ctx context.Context, userID int64, num int32) error {
+	ur.store.write(func(d *data) {
+		if user, ok := d.users[userID]; ok {
+			user.FollowingNum += num
+		}
+	})
+	return nil
+}
+
+// 增加用户的粉丝数
+func (ur *UserRepository) AddFollowerNumByUserID(ctx context.Context, userID int64, num int32) error {
+	ur.store.write(func(d *data) {
+		if user, ok := d.users[userID]; ok {
+			user.FollowerNum += num
+		}
+	})
+	return nil
+}
+
+// 查询某个用户关注另一个用户的记录
+func (ur *UserRepository) GetFollowing(ctx context.Context, fromUserID, toUserID int64) (following *weibo.Following, err error) {
+	ur.store.read(func(d *data) {
+		if f, ok := d.followings[pair{fromUserID, toUserID}]; ok {
+			copied := *f
+			following = &copied
+		}
+	})
+	return
+}
+
+// 记录关注信息
+func (ur *UserRepository) CreateFollowing(ctx context.Context, following *weibo.Following) (err error) {
+	ur.store.write(func(d *data) {
+		key := pair{following.FromUserID, following.ToUserID}
+		if _, ok := d.followings[key]; ok {
+			err = errDuplicate("following")
+			return
+		}
+		created := *following
+		d.followings[key] = &created
+	})
+	return
+}
+
+// 删除关注信息
+func (ur *UserRepository) DeleteFollowing(ctx context.Context, following *weibo.Following) error {
+	ur.store.write(func(d *data) {
+		delete(d.followings, pair{following.FromUserID, following.ToUserID})
+	})
+	return nil
+}
+
+// 增加用户所发布的微博数量
+func (ur *UserRepository) AddWeiboNumByUserID(ctx context.Context, userID int64, num int32) error {
+	ur.store.write(func(d *data) {
+		if user, ok := d.users[userID]; ok {
+			user.WeiboNum += num
+		}
+	})
+	return nil
+}
+
+// 获取用户所粉丝
+func (ur *UserRepository) GetUserFollowers(ctx context.Context, userID int64) ([]*weibo.Following, error) {
+	followings := []*weibo.Following{}
+	ur.store.read(func(d *data) {
+		for _, f := range d.followings {
+			if f.ToUserID == userID {
+				copied := *f
+				followings = append(followings, &copied)
+			}
+		}
+	})
+	return followings, nil
+}
+
+// 分页获取粉丝的信息, 按关注时间倒序
+func (ur *UserRepository) GetUserFollowers2(ctx context.Context, userID int64, page weibo.Page) ([]*weibo.Follower, error) {
+	followers := []*weibo.Follower{}
+	ur.store.read(func(d *data) {
+		for _, f := range d.followings {
+			if f.ToUserID != userID {
+				continue
+			}
+			user, ok := d.users[f.FromUserID]
+			if !ok {
+				continue
+			}
+			followers = append(followers, &weibo.Follower{
+				ID:         user.ID,
+				Account:    user.Account,
+				Avatar:     user.Avatar,
+				FollowedAt: f.CreatedAt,
+			})
+		}
+	})
+
+	key := func(i int) (int64, int64) { return followers[i].FollowedAt, followers[i].ID }
+	sortDesc(len(followers), key, func(i, j int) { followers[i], followers[j] = followers[j], followers[i] })
+	start, end := pageRange(len(followers), key, page)
+	return followers[start:end], nil
+}
+
+// 按id顺序分批获取粉丝的id
+func (ur *UserRepository) GetUserFollowerIDs(ctx context.Context, userID, afterID, limit int64) ([]int64, error) {
+	ids := []int64{}
+	ur.store.read(func(d *data) {
+		for _, f := range d.followings {
+			if f.ToUserID == userID && f.FromUserID > afterID {
+				ids = append(ids, f.FromUserID)
+			}
+		}
+	})
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	if int64(len(ids)) > limit {
+		ids = ids[:limit]
+	}
+	return ids, nil
+}
+
+// 按账号前缀搜索用户, 和mysql的默认排序规则一样不区分大小写
+func (ur *UserRepository) GetUsersByAccount(ctx context.Context, account string, page weibo.Page) ([]*weibo.User, error) {
+	prefix := strings.ToLower(account)
+	users := []*weibo.User{}
+	ur.store.read(func(d *data) {
+		for _, u := range d.users {
+			if strings.HasPrefix(strings.ToLower(u.Account), prefix) {
+				copied := *u
+				users = append(users, &copied)
+			}
+		}
+	})
+
+	key := func(i int) (int64, int64) { return users[i].CreatedAt, users[i].ID }
+	sortDesc(len(users), key, func(i, j int) { users[i], users[j] = users[j], users[i] })
+	start, end := pageRange(len(users), key, page)
+	return users[start:end], nil
+}
+
+// 根据账号批量精确查询用户
+func (ur *UserRepository) GetUsersByAccounts(ctx context.Context, accounts []string) ([]*weibo.User, error) {
+	wanted := make(map[string]bool, len(accounts))
+	for _, account := range accounts {
+		wanted[account] = true
+	}
+	users := []*weibo.User{}
+	ur.store.read(func(d *data) {
+		for _, u := range d.users {
+			if wanted[u.Account] {
+				copied := *u
+				users = append(users, &copied)
+			}
+		}
+	})
+	return users, nil
+}
@@ -0,0 +1,195 @@
+package memory
+
+import (
+	"context"
+	"strings"
+	"weibo"
+)
+
+var _ weibo.WeiboRepository = new(WeiboRepository)
+
+// 微博仓库
+type WeiboRepository struct {
+	store *Store
+}
+
+func NewWeiboRepository(store *Store) *WeiboRepository {
+	return &WeiboRepository{store: store}
+}
+
+// 根据id查找微博
+func (wb *WeiboRepository) GetWeiboByID(ctx context.Context, weiboID int64) (weibo *weibo.Weibo, err error) {
+	wb.store.read(func(d *data) {
+		if w, ok := d.weibos[weiboID]; ok {
+			copied := *w
+			weibo = &copied
+		}
+	})
+	return
+}
+
+// 保存微博, id由调用方生成
+func (wb *WeiboRepository) InsertWeibo(ctx context.Context, weibo *weibo.Weibo) (err error) {
+	wb.store.write(func(d *data) {
+		if _, ok := d.weibos[weibo.ID]; ok {
+			err = errDuplicate("weibo")
+			return
+		}
+		created := *weibo
+		d.weibos[weibo.ID] = &created
+	})
+	return
+}
+
+// 删除微博
+func (wb *WeiboRepository) DeleteWeibo(ctx context.Context, weibo *weibo.Weibo) error {
+	wb.store.write(func(d *data) {
+		delete(d.weibos, weibo.ID)
+	})
+	return nil
+}
+
+// 保存点赞记录
+func (wb *WeiboRepository) CreateGivelike(ctx context.Context, givelike *weibo.Givelike) (err error) {
+	wb.store.write(func(d *data) {
+		key := pair{givelike.UserID, givelike.WeiboID}
+		if _, ok := d.givelikes[key]; ok {
+			err = errDuplicate("givelike")
+			return
+		}
+		created := *givelike
+		d.givelikes[key] = &created
+	})
+	return
+}
+
+// 增加点赞数
+func (wb *WeiboRepository) AddLikeNumByWeiboID(ctx context.Context, weiboID int64, num int32) error {
+	wb.store.write(func(d *data) {
+		if w, ok := d.weibos[weiboID]; ok {
+			w.LikeNum += num
+		}
+	})
+	return nil
+}
+
+// 增加转发数
+func (wb *WeiboRepository) AddRepostNumByWeiboID(ctx context.Context, weiboID int64, num int32) error {
+	wb.store.write(func(d *data) {
+		if w, ok := d.weibos[weiboID]; ok {
+			w.RepostNum += num
+		}
+	})
+	return nil
+}
+
+func (wb *WeiboRepository) GetGivelikeByUseIDAndWeiboID(ctx context.Context, userID int64, weiboID int64) (givelike *weibo.Givelike, err error) {
+	wb.store.read(func(d *data) {
+		if g, ok := d.givelikes[pair{userID, weiboID}]; ok {
+			copied := *g
+			givelike = &copied
+		}
+	})
+	return
+}
+
+func (wb *WeiboRepository) CollectByUseIDAndWeiboID(ctx context.Context, userID int64, weiboID int64) (collect *weibo.Collect, err error) {
+	wb.store.read(func(d *data) {
+		if c, ok := d.collects[pair{userID, weiboID}]; ok {
+			copied := *c
+			collect = &copied
+		}
+	})
+	return
+}
+
+func (wb *WeiboRepository) CreateCollect(ctx context.Context, collect *weibo.Collect) (err error) {
+	wb.store.write(func(d *data) {
+		key := pair{collect.UserID, collect.WeiboID}
+		if _, ok := d.collects[key]; ok {
+			err = errDuplicate("collect")
+			return
+		}
+		created := *collect
+		d.collects[key] = &created
+	})
+	return
+}
+
+// 增加微博的评论数
+func (wb *WeiboRepository) AddCommentNumByWeiboID(ctx context.Context, weiboID int64, num int32) error {
+	wb.store.write(func(d *data) {
+		if w, ok := d.weibos[weiboID]; ok {
+			w.CommentNum += num
+		}
+	})
+	return nil
+}
+
+// 根据id批量查询微博和作者头像
+func (wb *WeiboRepository) GetWeibosByIDs(ctx context.Context, weiboIDs []int64) ([]*weibo.WeiboWithUser, error) {
+	weibos := []*weibo.WeiboWithUser{}
+	wb.store.read(func(d *data) {
+		seen := map[int64]bool{}
+		for _, id := range weiboIDs {
+			w, ok := d.weibos[id]
+			if !ok || seen[id] {
+				continue
+			}
+			user, ok := d.users[w.UserID]
+			if !ok {
+				continue
+			}
+			seen[id] = true
+			weibos = append(weibos, &weibo.WeiboWithUser{Weibo: *w, Avatar: user.Avatar})
+		}
+	})
+	return weibos, nil
+}
+
+// 查询用户所关注的账号以拉模式发布的微博
+func (wb *WeiboRepository) GetPullTimeLinesByUserID(ctx context.Context, userID int64, page weibo.Page) ([]*weibo.TimeLine, error) {
+	timelines := []*weibo.TimeLine{}
+	wb.store.read(func(d *data) {
+		following := map[int64]bool{}
+		for _, f := range d.followings {
+			if f.FromUserID == userID {
+				following[f.ToUserID] = true
+			}
+		}
+
+		for _, w := range d.weibos {
+			if w.Pulled && following[w.UserID] {
+				timelines = append(timelines, &weibo.TimeLine{
+					UserID:         userID,
+					WeiboUserID:    w.UserID,
+					WeiboID:        w.ID,
+					WeiboCreatedAt: w.CreatedAt,
+				})
+			}
+		}
+	})
+
+	key := func(i int) (int64, int64) { return timelines[i].WeiboCreatedAt, timelines[i].WeiboID }
+	sortDesc(len(timelines), key, func(i, j int) { timelines[i], timelines[j] = timelines[j], timelines[i] })
+	start, end := pageRange(len(timelines), key, page)
+	return timelines[start:end], nil
+}
+
+// 根据账号或者内容搜索微博
+func (wb *WeiboRepository) GetWeibosByAccountOrContent(ctx context.Context, accountOrContent string, page weibo.Page) ([]*weibo.Weibo, error) {
+	weibos := []*weibo.Weibo{}
+	wb.store.read(func(d *data) {
+		for _, w := range d.weibos {
+			if w.Account == accountOrContent || strings.Contains(w.Content, accountOrContent) {
+				copied := *w
+				weibos = append(weibos, &copied)
+			}
+		}
+	})
+
+	key := func(i int) (int64, int64) { return weibos[i].CreatedAt, weibos[i].ID }
+	sortDesc(len(weibos), key, func(i, j int) { weibos[i], weibos[j] = weibos[j], weibos[i] })
+	start, end := pageRange(len(weibos), key, page)
+	return weibos[start:end], nil
+}
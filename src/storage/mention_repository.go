@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"weibo"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var _ weibo.MentionRepository = new(MentionRepository)
+
+// 提到用户的记录
+type MentionRepository struct {
+	db dbtx
+}
+
+func NewMentionRepository(db *sqlx.DB) *MentionRepository {
+	return &MentionRepository{db: db}
+}
+
+// 保存提到用户的记录, id由调用方生成
+func (mr *MentionRepository) CreateMentions(ctx context.Context, mentions []*weibo.Mention) error {
+	if len(mentions) == 0 {
+		return nil
+	}
+
+	placeholders := make([]string, 0, len(mentions))
+	args := make([]interface{}, 0, len(mentions)*6)
+	for _, m := range mentions {
+		placeholders = append(placeholders, "(?, ?, ?, ?, ?, ?)")
+		args = append(args, m.ID, m.UserID, m.AuthorID, m.WeiboID, m.CommentID, m.CreatedAt)
+	}
+	_, err := mr.db.ExecContext(ctx, "INSERT INTO `mentions`(id, user_id, author_id, weibo_id, comment_id, created_at) VALUES "+strings.Join(placeholders, ", "), args...)
+	return err
+}
+
+// 删除微博和微博下的评论中提到用户的记录
+func (mr *MentionRepository) DeleteMentionsByWeiboID(ctx context.Context, weiboID int64) error {
+	_, err := mr.db.ExecContext(ctx, "DELETE FROM `mentions` WHERE weibo_id = ?", weiboID)
+	return err
+}
+
+// 分页查询提到某个用户的记录, 评论删除时不删除提到的记录, 查询时过滤
+func (mr *MentionRepository) GetMentionsByUserID(ctx context.Context, userID int64, page weibo.Page) ([]*weibo.Mention, error) {
+	query, args := pageQuery(`
+		SELECT m.* FROM mentions m
+		INNER JOIN weibos w ON m.weibo_id = w.id
+		LEFT JOIN comment c ON m.comment_id = c.id
+		WHERE m.user_id = ? AND (m.comment_id = 0 OR c.id IS NOT NULL) AND %s`, []interface{}{userID}, page, "m.created_at", "m.id")
+
+	mentions := []*weibo.Mention{}
+	if err := mr.db.SelectContext(ctx, &mentions, query, args...); err != nil {
+		return nil, err
+	}
+	return mentions, nil
+}
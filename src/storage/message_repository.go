@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"context"
+	"weibo"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var _ weibo.MessageRepository = new(MessageRepository)
+
+// 私信仓库, 两个人之间的私信用 (min_user_id, max_user_id) 查询
+type MessageRepository struct {
+	db dbtx
+}
+
+func NewMessageRepository(db *sqlx.DB) *MessageRepository {
+	return &MessageRepository{db: db}
+}
+
+func userPair(a, b int64) (int64, int64) {
+	if a < b {
+		return a, b
+	}
+	return b, a
+}
+
+// 保存私信
+func (mr *MessageRepository) CreateMessage(ctx context.Context, message *weibo.Message) error {
+	minUserID, maxUserID := userPair(message.FromUserID, message.ToUserID)
+	_, err := mr.db.ExecContext(ctx, "INSERT INTO `messages`(id, from_user_id, to_user_id, min_user_id, max_user_id, content, created_at) VALUES(?, ?, ?, ?, ?, ?, ?)",
+		message.ID, message.FromUserID, message.ToUserID, minUserID, maxUserID, message.Content, message.CreatedAt)
+	return err
+}
+
+// 依赖 (user_id, peer_id) 上的唯一索引更新已经存在的会话
+func (mr *MessageRepository) SaveConversation(ctx context.Context, c *weibo.Conversation) error {
+	_, err := mr.db.NamedExecContext(ctx, `
+		INSERT INTO conversations(id, user_id, peer_id, last_message_id, last_content, unread_num, updated_at)
+		VALUES(:id, :user_id, :peer_id, :last_message_id, :last_content, :unread_num, :updated_at)
+		ON DUPLICATE KEY UPDATE last_message_id = VALUES(last_message_id), last_content = VALUES(last_content),
+			unread_num = unread_num + VALUES(unread_num), updated_at = VALUES(updated_at)`, c)
+	return err
+}
+
+// 分页查询用户的会话
+func (mr *MessageRepository) GetConversationsByUserID(ctx context.Context, userID int64, page weibo.Page) ([]*weibo.ConversationWithUser, error) {
+	query, args := pageQuery(`
+		SELECT c.*, u.account AS peer_account, u.avatar AS peer_avatar FROM conversations c
+		INNER JOIN users u ON c.peer_id = u.id
+		WHERE c.user_id = ? AND %s`, []interface{}{userID}, page, "c.updated_at", "c.id")
+
+	conversations := []*weibo.ConversationWithUser{}
+	if err := mr.db.SelectContext(ctx, &conversations, query, args...); err != nil {
+		return nil, err
+	}
+	return conversations, nil
+}
+
+// 分页查询两个人之间的私信
+func (mr *MessageRepository) GetMessages(ctx context.Context, userID, peerID int64, page weibo.Page) ([]*weibo.Message, error) {
+	minUserID, maxUserID := userPair(userID, peerID)
+	query, args := pageQuery(`
+		SELECT id, from_user_id, to_user_id, content, created_at FROM messages
+		WHERE min_user_id = ? AND max_user_id = ? AND %s`, []interface{}{minUserID, maxUserID}, page, "created_at", "id")
+
+	messages := []*weibo.Message{}
+	if err := mr.db.SelectContext(ctx, &messages, query, args...); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// 把会话的未读数清零
+func (mr *MessageRepository) MarkConversationRead(ctx context.Context, userID, peerID int64) error {
+	_, err := mr.db.ExecContext(ctx, "UPDATE `conversations` SET unread_num = 0 WHERE user_id = ? AND peer_id = ? AND unread_num > 0", userID, peerID)
+	return err
+}
+
+// 用户所有会话的未读数之和
+func (mr *MessageRepository) CountUnreadMessages(ctx context.Context, userID int64) (int64, error) {
+	var count int64
+	if err := mr.db.GetContext(ctx, &count, "SELECT COALESCE(SUM(unread_num), 0) FROM `conversations` WHERE user_id = ? AND unread_num > 0", userID); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"cache"
+	"context"
+	"time"
+	"weibo"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var _ weibo.MuteRepository = new(MuteRepository)
+
+// 静音仓库, 每次读取timeline都要用到用户的静音列表, 整个列表经过缓存
+type MuteRepository struct {
+	db     dbtx
+	loader *cache.Loader // 事务中为nil, 不读缓存
+	cache  *cacheInvalidator
+
+	// 静音列表的缓存时间
+	MutesTTL time.Duration
+}
+
+const defaultMutesTTL = 300 * time.Second
+
+func NewMuteRepository(db *sqlx.DB, c cache.Cache) *MuteRepository {
+	return &MuteRepository{
+		db:       db,
+		loader:   cache.NewLoader(c),
+		cache:    &cacheInvalidator{cache: c},
+		MutesTTL: defaultMutesTTL,
+	}
+}
+
+// 依赖唯一索引uk_target保证不重复
+func (mr *MuteRepository) CreateMute(ctx context.Context, mute *weibo.Mute) error {
+	_, err := mr.db.NamedExecContext(ctx, `
+		INSERT INTO mutes(id, user_id, muted_user_id, muted_account, keyword, expires_at, created_at)
+		VALUES(:id, :user_id, :muted_user_id, :muted_account, :keyword, :expires_at, :created_at)`, mute)
+	if err != nil {
+		return duplicateError(err)
+	}
+	return mr.cache.Del(mutesKey(mute.UserID))
+}
+
+// 只能删除自己的静音
+func (mr *MuteRepository) DeleteMute(ctx context.Context, userID, muteID int64) (int64, error) {
+	result, err := mr.db.ExecContext(ctx, "DELETE FROM `mutes` WHERE id = ? AND user_id = ?", muteID, userID)
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return deleted, mr.cache.Del(mutesKey(userID))
+}
+
+func (mr *MuteRepository) DeleteExpiredMutes(ctx context.Context, userID, now int64) error {
+	result, err := mr.db.ExecContext(ctx, "DELETE FROM `mutes` WHERE user_id = ? AND expires_at > 0 AND expires_at <= ?", userID, now)
+	if err != nil {
+		return err
+	}
+	// 没有过期的静音时缓存不用删除
+	if deleted, err := result.RowsAffected(); err != nil || deleted == 0 {
+		return err
+	}
+	return mr.cache.Del(mutesKey(userID))
+}
+
+// 用户的静音列表, 先读缓存
+func (mr *MuteRepository) GetMutesByUserID(ctx context.Context, userID int64) ([]*weibo.Mute, error) {
+	if mr.loader == nil {
+		return mr.getMutesByUserID(ctx, userID)
+	}
+
+	mutes := []*weibo.Mute{}
+	err := mr.loader.Load(ctx, mutesKey(userID), mr.MutesTTL, &mutes, func(ctx context.Context) (interface{}, error) {
+		return mr.getMutesByUserID(ctx, userID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mutes, nil
+}
+
+// 推送时按批读取, 不经过缓存
+func (mr *MuteRepository) GetMutesByUserIDs(ctx context.Context, userIDs []int64) ([]*weibo.Mute, error) {
+	mutes := []*weibo.Mute{}
+	if len(userIDs) == 0 {
+		return mutes, nil
+	}
+	query, args, err := sqlx.In("SELECT * FROM `mutes` WHERE user_id IN (?)", userIDs)
+	if err != nil {
+		return nil, err
+	}
+	if err := mr.db.SelectContext(ctx, &mutes, query, args...); err != nil {
+		return nil, err
+	}
+	return mutes, nil
+}
+
+func (mr *MuteRepository) getMutesByUserID(ctx context.Context, userID int64) ([]*weibo.Mute, error) {
+	mutes := []*weibo.Mute{}
+	if err := mr.db.SelectContext(ctx, &mutes, "SELECT * FROM `mutes` WHERE user_id = ? ORDER BY created_at DESC, id DESC", userID); err != nil {
+		return nil, err
+	}
+	return mutes, nil
+}
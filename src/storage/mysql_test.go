@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"cache"
+	"context"
+	"migrations"
+	"os"
+	"reflect"
+	"storage/storagetest"
+	"testing"
+	"weibo"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/gomodule/redigo/redis"
+	"github.com/jmoiron/sqlx"
+)
+
+// 需要一个可以清空的mysql库和redis库, 没有配置时跳过, 表结构由迁移创建
+// WEIBO_TEST_MYSQL_DSN=root:@tcp(127.0.0.1:3306)/weibo_test WEIBO_TEST_REDIS_ADDR=127.0.0.1:6379 go test storage
+func openTestStores(t *testing.T) (*sqlx.DB, *redis.Pool) {
+	t.Helper()
+	dsn := os.Getenv("WEIBO_TEST_MYSQL_DSN")
+	redisAddr := os.Getenv("WEIBO_TEST_REDIS_ADDR")
+	if dsn == "" || redisAddr == "" {
+		t.Skip("没有配置WEIBO_TEST_MYSQL_DSN和WEIBO_TEST_REDIS_ADDR")
+	}
+
+	db, err := sqlx.Open("mysql", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	all, err := migrations.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrations.NewMigrator(db.DB, all).Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	pool := &redis.Pool{
+		MaxIdle: 4,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", redisAddr)
+		},
+	}
+	t.Cleanup(func() { pool.Close() })
+	return db, pool
+}
+
+func TestRepositoryContract(t *testing.T) {
+	db, pool := openTestStores(t)
+	storagetest.Run(t, func(t *testing.T) (*weibo.Repositories, weibo.UnitOfWork) {
+		for _, table := range []string{"users", "following", "weibos", "givelike", "collect", "comment", "timeline", "topics", "weibo_topics", "mentions", "notifications", "notification_actors", "conversations", "messages", "blocks", "mutes"} {
+			if _, err := db.Exec("TRUNCATE TABLE `" + table + "`"); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		conn := pool.Get()
+		defer conn.Close()
+		if _, err := conn.Do("FLUSHDB"); err != nil {
+			t.Fatal(err)
+		}
+
+		c := cache.NewRedisCache(pool)
+		repos := &weibo.Repositories{
+			Users:         NewUserRepository(db, c),
+			Weibos:        NewWeiboRepository(db, c),
+			Comments:      NewCommentRepository(db),
+			Topics:        NewTopicRepository(db),
+			Mentions:      NewMentionRepository(db),
+			Notifications: NewNotificationRepository(db, c),
+			Messages:      NewMessageRepository(db),
+			Blocks:        NewBlockRepository(db),
+			Mutes:         NewMuteRepository(db, c),
+			TimeLines:     NewRedisTimeLineRepository(db, pool),
+		}
+		return repos, NewUnitOfWork(db, c, pool)
+	})
+}
+
+// 重建缓存时读取mysql之后提交的timeline不能丢失
+func TestRedisTimeLineRebuild(t *testing.T) {
+	ctx := context.Background()
+	db, pool := openTestStores(t)
+	if _, err := db.Exec("TRUNCATE TABLE `timeline`"); err != nil {
+		t.Fatal(err)
+	}
+	conn := pool.Get()
+	defer conn.Close()
+	if _, err := conn.Do("FLUSHDB"); err != nil {
+		t.Fatal(err)
+	}
+
+	tl := NewRedisTimeLineRepository(db, pool)
+	if err := tl.CreateTimeLine(ctx, &weibo.TimeLine{ID: 1, UserID: 1, WeiboUserID: 2, WeiboID: 10, WeiboCreatedAt: 100}); err != nil {
+		t.Fatal(err)
+	}
+	// 模拟重建读取mysql之后才提交的timeline, 只写入了缓存
+	if _, err := conn.Do("SET", timelineRebuildKey(1), 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := tl.add([]*weibo.TimeLine{{UserID: 1, WeiboID: 11, WeiboCreatedAt: 101}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := tl.rebuild(ctx, conn, 1, weibo.Page{Limit: 10}); err != nil {
+		t.Fatal(err)
+	}
+
+	members, err := redis.Int64s(conn.Do("ZREVRANGE", timelineKey(1), 0, -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(members, []int64{11, 10}) {
+		t.Fatal("重建期间提交的timeline丢失了", members)
+	}
+	if exists, _ := redis.Bool(conn.Do("EXISTS", timelineRebuildKey(1), timelinePendingKey(1))); exists {
+		t.Fatal("重建完成之后应该清除重建标记和暂存的timeline")
+	}
+}
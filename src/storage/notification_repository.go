@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"cache"
+	"context"
+	"time"
+	"weibo"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var _ weibo.NotificationRepository = new(NotificationRepository)
+
+// 通知仓库, 未读通知数经过缓存
+type NotificationRepository struct {
+	db     dbtx
+	loader *cache.Loader // 事务中为nil, 不读缓存
+	cache  *cacheInvalidator
+
+	// 未读通知数的缓存时间
+	UnreadTTL time.Duration
+}
+
+const defaultUnreadTTL = 300 * time.Second
+
+func NewNotificationRepository(db *sqlx.DB, c cache.Cache) *NotificationRepository {
+	return &NotificationRepository{
+		db:        db,
+		loader:    cache.NewLoader(c),
+		cache:     &cacheInvalidator{cache: c},
+		UnreadTTL: defaultUnreadTTL,
+	}
+}
+
+// 同一个聚合的未读通知的read_key都是0, 依赖唯一索引合并到已有的未读通知中
+func (nr *NotificationRepository) AddNotification(ctx context.Context, n *weibo.Notification) error {
+	_, err := nr.db.NamedExecContext(ctx, `
+		INSERT INTO notifications(id, user_id, kind, target_id, actor_id, actor_account, actor_num, read_key, created_at, updated_at)
+		VALUES(:id, :user_id, :kind, :target_id, :actor_id, :actor_account, :actor_num, 0, :created_at, :updated_at)
+		ON DUPLICATE KEY UPDATE actor_id = VALUES(actor_id), actor_account = VALUES(actor_account),
+			actor_num = actor_num + VALUES(actor_num), updated_at = VALUES(updated_at)`, n)
+	if err != nil {
+		return err
+	}
+
+	// 合并到已有的通知时, 触发的用户记在已有的通知上
+	if err := nr.db.GetContext(ctx, &n.ID, "SELECT id FROM `notifications` WHERE user_id = ? AND kind = ? AND target_id = ? AND read_key = 0",
+		n.UserID, n.Kind, n.TargetID); err != nil {
+		return err
+	}
+	_, err = nr.db.ExecContext(ctx, `
+		INSERT INTO notification_actors(notification_id, actor_id, actor_account, actor_num, updated_at) VALUES(?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE actor_account = VALUES(actor_account), actor_num = actor_num + VALUES(actor_num), updated_at = VALUES(updated_at)`,
+		n.ID, n.ActorID, n.ActorAccount, n.ActorNum, n.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	return nr.cache.Del(unreadNotificationsKey(n.UserID))
+}
+
+func (nr *NotificationRepository) GetNotificationActors(ctx context.Context, notificationIDs []int64) ([]*weibo.NotificationActor, error) {
+	actors := []*weibo.NotificationActor{}
+	if len(notificationIDs) == 0 {
+		return actors, nil
+	}
+	query, args, err := sqlx.In("SELECT * FROM `notification_actors` WHERE notification_id IN (?) ORDER BY updated_at DESC, actor_id DESC", notificationIDs)
+	if err != nil {
+		return nil, err
+	}
+	if err := nr.db.SelectContext(ctx, &actors, query, args...); err != nil {
+		return nil, err
+	}
+	return actors, nil
+}
+
+// 分页查询用户的通知
+func (nr *NotificationRepository) GetNotificationsByUserID(ctx context.Context, userID int64, page weibo.Page) ([]*weibo.Notification, error) {
+	query, args := pageQuery("SELECT * FROM `notifications` WHERE user_id = ? AND %s", []interface{}{userID}, page, "updated_at", "id")
+	notifications := []*weibo.Notification{}
+	if err := nr.db.SelectContext(ctx, &notifications, query, args...); err != nil {
+		return nil, err
+	}
+	return notifications, nil
+}
+
+// 未读通知数, 先读缓存
+func (nr *NotificationRepository) CountUnreadNotifications(ctx context.Context, userID int64) (int64, error) {
+	if nr.loader == nil {
+		return nr.countUnreadNotifications(ctx, userID)
+	}
+
+	var count int64
+	err := nr.loader.Load(ctx, unreadNotificationsKey(userID), nr.UnreadTTL, &count, func(ctx context.Context) (interface{}, error) {
+		return nr.countUnreadNotifications(ctx, userID)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (nr *NotificationRepository) countUnreadNotifications(ctx context.Context, userID int64) (int64, error) {
+	var count int64
+	if err := nr.db.GetContext(ctx, &count, "SELECT COUNT(*) FROM `notifications` WHERE user_id = ? AND read_key = 0", userID); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// 把用户的全部通知标记为已读
+func (nr *NotificationRepository) MarkNotificationsRead(ctx context.Context, userID int64) error {
+	if _, err := nr.db.ExecContext(ctx, "UPDATE `notifications` SET read_key = id WHERE user_id = ? AND read_key = 0", userID); err != nil {
+		return err
+	}
+	return nr.cache.Del(unreadNotificationsKey(userID))
+}
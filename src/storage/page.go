@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"weibo"
+)
+
+// 转义LIKE中的通配符, 查询中要加上 ESCAPE '\\', 用户输入的%和_按普通字符匹配
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// 游标分页的查询条件, 列表按 (createdAt, id) 倒序排列
+func pageWhere(page weibo.Page, createdAt, id string) (string, []interface{}) {
+	if page.Cursor == nil {
+		return "1 = 1", nil
+	}
+	where := fmt.Sprintf("(%s < ? OR (%s = ? AND %s < ?))", createdAt, createdAt, id)
+	return where, []interface{}{page.Cursor.CreatedAt, page.Cursor.CreatedAt, page.Cursor.ID}
+}
+
+// 分页的LIMIT子句, 使用游标时不再需要offset
+func pageLimit(page weibo.Page) (string, []interface{}) {
+	if page.Cursor != nil {
+		return "LIMIT ?", []interface{}{page.Limit}
+	}
+	return "LIMIT ?, ?", []interface{}{page.Offset, page.Limit}
+}
+
+// 拼接分页查询, query中用 %s 占位WHERE条件, 末尾自动加上ORDER BY和LIMIT
+func pageQuery(query string, args []interface{}, page weibo.Page, createdAt, id string) (string, []interface{}) {
+	where, whereArgs := pageWhere(page, createdAt, id)
+	limit, limitArgs := pageLimit(page)
+
+	query = fmt.Sprintf(query, where) + fmt.Sprintf(" ORDER BY %s DESC, %s DESC ", createdAt, id) + limit
+	args = append(append(append([]interface{}{}, args...), whereArgs...), limitArgs...)
+	return query, args
+}
@@ -0,0 +1,18 @@
+package storage
+
+import "testing"
+
+func TestEscapeLike(t *testing.T) {
+	cases := map[string]string{
+		"alice":  "alice",
+		"100%":   `100\%`,
+		"a_b":    `a\_b`,
+		`a\%`:    `a\\\%`,
+		"天气_%\\": `天气\_\%\\`,
+	}
+	for in, want := range cases {
+		if got := escapeLike(in); got != want {
+			t.Fatal("LIKE通配符转义不正确", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package storage
+
+import (
+	"cache"
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+	"weibo"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+var _ weibo.EventBroker = new(RedisEventBroker)
+
+// 所有实例共用一个频道, 每个实例只把事件分发给自己的连接
+const eventChannel = "events"
+
+// 事件通过redis pub/sub送到所有实例, 每个用户最近的事件保存在redis list中用于补发
+type RedisEventBroker struct {
+	*weibo.EventHub
+	pool *redis.Pool
+
+	// 每个用户保留的最近事件数和保留时间
+	History    int64
+	HistoryTTL time.Duration
+	// 订阅连接上ping的间隔, 两个间隔内没有收到任何回复时重新连接
+	PingInterval time.Duration
+	// 订阅连接断开后重连的间隔
+	RetryInterval time.Duration
+	// 在线登记的过期时间, 每过一半时间续期一次
+	OnlineTTL time.Duration
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewRedisEventBroker(pool *redis.Pool) *RedisEventBroker {
+	return &RedisEventBroker{
+		EventHub:      weibo.NewEventHub(),
+		pool:          pool,
+		History:       100,
+		HistoryTTL:    time.Hour,
+		PingInterval:  30 * time.Second,
+		RetryInterval: time.Second,
+		OnlineTTL:     time.Minute,
+	}
+}
+
+func (b *RedisEventBroker) Publish(ctx context.Context, events ...*weibo.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	conn := b.pool.Get()
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		key := eventsKey(event.UserID)
+		conn.Send("LPUSH", key, data)
+		conn.Send("LTRIM", key, 0, b.History-1)
+		conn.Send("EXPIRE", key, int64(b.HistoryTTL/time.Second))
+		conn.Send("PUBLISH", eventChannel, data)
+	}
+	_, err := cache.DoContext(ctx, conn, "EXEC")
+	return err
+}
+
+// 订阅之前先登记在线, 其他实例扩散新微博时才会给这个用户生成事件
+func (b *RedisEventBroker) Subscribe(ctx context.Context, userID, lastEventID int64) (<-chan *weibo.Event, error) {
+	if err := b.markOnline(ctx, []int64{userID}); err != nil {
+		return nil, err
+	}
+	return b.EventHub.Subscribe(ctx, userID, func() ([]*weibo.Event, error) {
+		if lastEventID == 0 {
+			return nil, nil
+		}
+		conn := b.pool.Get()
+		defer conn.Close()
+
+		values, err := redis.ByteSlices(cache.DoContext(ctx, conn, "LRANGE", eventsKey(userID), 0, -1))
+		if err != nil {
+			return nil, err
+		}
+		// list中最新的在前面, 补发时按时间顺序
+		var missed []*weibo.Event
+		for i := len(values) - 1; i >= 0; i-- {
+			var event weibo.Event
+			if err := json.Unmarshal(values[i], &event); err != nil {
+				return nil, err
+			}
+			if event.ID > lastEventID {
+				missed = append(missed, &event)
+			}
+		}
+		return missed, nil
+	})
+}
+
+// 在所有实例中有连接的用户
+func (b *RedisEventBroker) Online(ctx context.Context, userIDs []int64) (map[int64]bool, error) {
+	online := make(map[int64]bool, len(userIDs))
+	if len(userIDs) == 0 {
+		return online, nil
+	}
+	conn := b.pool.Get()
+	defer conn.Close()
+
+	keys := make([]interface{}, 0, len(userIDs))
+	for _, userID := range userIDs {
+		keys = append(keys, eventsOnlineKey(userID))
+	}
+	values, err := redis.Values(cache.DoContext(ctx, conn, "MGET", keys...))
+	if err != nil {
+		return nil, err
+	}
+	for i, value := range values {
+		if value != nil {
+			online[userIDs[i]] = true
+		}
+	}
+	return online, nil
+}
+
+func (b *RedisEventBroker) markOnline(ctx context.Context, userIDs []int64) error {
+	conn := b.pool.Get()
+	defer conn.Close()
+	for _, userID := range userIDs {
+		conn.Send("SET", eventsOnlineKey(userID), 1, "EX", int64(b.OnlineTTL/time.Second))
+	}
+	_, err := cache.DoContext(ctx, conn, "")
+	return err
+}
+
+// 在后台订阅事件频道, 连接断开时重连, 同时定时续期本实例上的在线登记
+func (b *RedisEventBroker) Start() {
+	b.stop = make(chan struct{})
+	b.wg.Add(2)
+	go b.loop()
+	go b.heartbeat()
+}
+
+// 断开的连接不再续期, 最多OnlineTTL之后不再给这个用户生成事件
+func (b *RedisEventBroker) heartbeat() {
+	defer b.wg.Done()
+	ticker := time.NewTicker(b.OnlineTTL / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+			users := b.Users()
+			if len(users) == 0 {
+				continue
+			}
+			if err := b.markOnline(context.Background(), users); err != nil {
+				log.Printf("续期在线登记失败: %v\n", err)
+			}
+		}
+	}
+}
+
+func (b *RedisEventBroker) Stop() {
+	close(b.stop)
+	b.wg.Wait()
+}
+
+func (b *RedisEventBroker) loop() {
+	defer b.wg.Done()
+	for {
+		err := b.receive()
+		select {
+		case <-b.stop:
+			return
+		default:
+		}
+
+		// 重连之前发布的事件收不到, 客户端重连时可以从保存的事件中补发
+		log.Printf("订阅事件频道失败, %v后重试: %v\n", b.RetryInterval, err)
+		select {
+		case <-b.stop:
+			return
+		case <-time.After(b.RetryInterval):
+		}
+	}
+}
+
+// 订阅事件频道并分发给本进程的连接, 直到连接出错或者停止
+func (b *RedisEventBroker) receive() error {
+	conn := b.pool.Get()
+	defer conn.Close()
+
+	psc := redis.PubSubConn{Conn: conn}
+	if err := psc.Subscribe(eventChannel); err != nil {
+		return err
+	}
+
+	// 定时ping检查连接, 停止时取消订阅让ReceiveWithTimeout返回
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(b.PingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-b.stop:
+				psc.Unsubscribe()
+				return
+			case <-ticker.C:
+				if err := psc.Ping(""); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	defer wg.Wait()
+	defer close(done)
+
+	for {
+		switch v := psc.ReceiveWithTimeout(2 * b.PingInterval).(type) {
+		case redis.Message:
+			var event weibo.Event
+			if err := json.Unmarshal(v.Data, &event); err != nil {
+				log.Printf("解析事件失败: %v\n", err)
+				continue
+			}
+			b.Dispatch(&event)
+		case redis.Subscription:
+			if v.Count == 0 {
+				return nil
+			}
+		case error:
+			return v
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package storage
+
+import (
+	"cache"
+	"context"
+	"fmt"
+	"log"
+	"time"
+	"weibo"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/jmoiron/sqlx"
+)
+
+var _ weibo.TimeLineRepository = new(RedisTimeLineRepository)
+
+// 只在timeline已经缓存时写入, 并裁剪到最大长度
+// 缓存正在重建时写入重建期间的新增, 由重建完成时合并, 否则重建读取mysql之后提交的timeline会丢失
+// KEYS: timeline, 重建标记, 重建期间的新增; ARGV: 最大长度, 重建标记的过期时间, 之后是score和member
+var timelineAddScript = redis.NewScript(3, `
+local key = KEYS[1]
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	if redis.call('EXISTS', KEYS[2]) == 0 then
+		return 0
+	end
+	key = KEYS[3]
+end
+for i = 3, #ARGV, 2 do
+	redis.call('ZADD', key, ARGV[i], ARGV[i + 1])
+end
+if key == KEYS[3] then
+	redis.call('EXPIRE', key, ARGV[2])
+else
+	redis.call('ZREMRANGEBYRANK', key, 0, -tonumber(ARGV[1]) - 1)
+end
+return 1
+`)
+
+// 写入从mysql读出的timeline, 合并重建期间的新增, 然后去掉重建标记
+// KEYS同timelineAddScript; ARGV: 最大长度, 缓存的过期时间, 之后是score和member
+var timelineRebuildScript = redis.NewScript(3, `
+for i = 3, #ARGV, 2 do
+	redis.call('ZADD', KEYS[1], ARGV[i], ARGV[i + 1])
+end
+if redis.call('EXISTS', KEYS[3]) == 1 then
+	redis.call('ZUNIONSTORE', KEYS[1], 2, KEYS[1], KEYS[3], 'AGGREGATE', 'MAX')
+	redis.call('DEL', KEYS[3])
+end
+redis.call('DEL', KEYS[2])
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	redis.call('ZREMRANGEBYRANK', KEYS[1], 0, -tonumber(ARGV[1]) - 1)
+	redis.call('EXPIRE', KEYS[1], ARGV[2])
+end
+return 1
+`)
+
+// 用redis sorted set保存每个用户最近的timeline, score为微博的发布时间, member为微博id
+// mysql中的timeline表仍然是完整的数据, 缓存不存在时从mysql重建, 超出缓存长度的分页直接查mysql
+type RedisTimeLineRepository struct {
+	*TimeLineRepository
+	pool  *redis.Pool
+	cache *cacheInvalidator
+
+	// 每个用户最多缓存的条数
+	Capacity int64
+	// 缓存的过期时间, 读取时会续期
+	TTL time.Duration
+}
+
+const (
+	defaultTimelineCapacity = 800
+	defaultTimelineTTL      = 7 * 24 * time.Hour
+	// 重建标记的过期时间, 重建失败时标记和重建期间的新增自动清除
+	timelineRebuildTTL = time.Minute
+)
+
+func NewRedisTimeLineRepository(db *sqlx.DB, pool *redis.Pool) *RedisTimeLineRepository {
+	return newRedisTimeLineRepository(db, pool, &cacheInvalidator{})
+}
+
+func newRedisTimeLineRepository(db dbtx, pool *redis.Pool, invalidator *cacheInvalidator) *RedisTimeLineRepository {
+	return &RedisTimeLineRepository{
+		TimeLineRepository: &TimeLineRepository{db: db},
+		pool:               pool,
+		cache:              invalidator,
+		Capacity:           defaultTimelineCapacity,
+		TTL:                defaultTimelineTTL,
+	}
+}
+
+// 查询某个用户的timeline, 按微博发布时间倒序
+// 从缓存中读出的timeline只有微博id和发布时间
+func (tl *RedisTimeLineRepository) GetTimeLinesByUserID(ctx context.Context, userID int64, page weibo.Page) ([]*weibo.TimeLine, error) {
+	if page.Cursor == nil && page.Offset+page.Limit > tl.Capacity {
+		return tl.TimeLineRepository.GetTimeLinesByUserID(ctx, userID, page)
+	}
+
+	timelines, full, err := tl.getCachedTimeLines(ctx, userID, page)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		log.Printf("从redis读取用户 %d 的timeline失败, 改为查询mysql: %v\n", userID, err)
+		return tl.TimeLineRepository.GetTimeLinesByUserID(ctx, userID, page)
+	}
+
+	// 缓存被裁剪过, 游标翻到缓存之外时查mysql
+	if full && int64(len(timelines)) < page.Limit {
+		return tl.TimeLineRepository.GetTimeLinesByUserID(ctx, userID, page)
+	}
+	return timelines, nil
+}
+
+// 从缓存中读取一页timeline, 同时返回缓存是否已经达到最大长度
+func (tl *RedisTimeLineRepository) getCachedTimeLines(ctx context.Context, userID int64, page weibo.Page) ([]*weibo.TimeLine, bool, error) {
+	conn, err := tl.pool.GetContext(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	defer conn.Close()
+
+	key := timelineKey(userID)
+	exists, err := redis.Bool(cache.DoContext(ctx, conn, "EXPIRE", key, int64(tl.TTL/time.Second)))
+	if err != nil {
+		return nil, false, err
+	}
+	if !exists {
+		return tl.rebuild(ctx, conn, userID, page)
+	}
+
+	size, err := redis.Int64(cache.DoContext(ctx, conn, "ZCARD", key))
+	if err != nil {
+		return nil, false, err
+	}
+	full := size >= tl.Capacity
+
+	if page.Cursor == nil {
+		values, err := redis.Int64s(cache.DoContext(ctx, conn, "ZREVRANGE", key, page.Offset, page.Offset+page.Limit-1, "WITHSCORES"))
+		if err != nil {
+			return nil, false, err
+		}
+		return parseTimeLines(userID, values), full, nil
+	}
+
+	// 和游标发布时间相同的微博按id过滤, 其余的从更早的时间开始取
+	values, err := redis.Int64s(cache.DoContext(ctx, conn, "ZREVRANGEBYSCORE", key, page.Cursor.CreatedAt, page.Cursor.CreatedAt, "WITHSCORES"))
+	if err != nil {
+		return nil, false, err
+	}
+	timelines := []*weibo.TimeLine{}
+	for _, timeline := range parseTimeLines(userID, values) {
+		if timeline.WeiboID < page.Cursor.ID {
+			timelines = append(timelines, timeline)
+		}
+	}
+
+	values, err = redis.Int64s(cache.DoContext(ctx, conn, "ZREVRANGEBYSCORE", key, fmt.Sprintf("(%d", page.Cursor.CreatedAt), "-inf", "WITHSCORES", "LIMIT", 0, page.Limit))
+	if err != nil {
+		return nil, false, err
+	}
+	timelines = weibo.MergeTimeLines(timelines, parseTimeLines(userID, values))
+	if int64(len(timelines)) > page.Limit {
+		timelines = timelines[:page.Limit]
+	}
+	return timelines, full, nil
+}
+
+func parseTimeLines(userID int64, values []int64) []*weibo.TimeLine {
+	timelines := make([]*weibo.TimeLine, 0, len(values)/2)
+	for i := 0; i+1 < len(values); i += 2 {
+		timelines = append(timelines, &weibo.TimeLine{
+			UserID:         userID,
+			WeiboID:        values[i],
+			WeiboCreatedAt: values[i+1],
+		})
+	}
+	return timelines
+}
+
+// 从mysql读取最近的timeline写入缓存
+// 读取mysql之前先设置重建标记, 之后提交的timeline由timelineAddScript暂存, 写入缓存时一起合并
+func (tl *RedisTimeLineRepository) rebuild(ctx context.Context, conn redis.Conn, userID int64, page weibo.Page) ([]*weibo.TimeLine, bool, error) {
+	if _, err := cache.DoContext(ctx, conn, "SET", timelineRebuildKey(userID), 1, "EX", int64(timelineRebuildTTL/time.Second)); err != nil {
+		return nil, false, err
+	}
+	timelines, err := tl.TimeLineRepository.GetTimeLinesByUserID(ctx, userID, weibo.Page{Limit: tl.Capacity})
+	if err != nil {
+		return nil, false, err
+	}
+
+	args := redis.Args{}.Add(timelineKey(userID), timelineRebuildKey(userID), timelinePendingKey(userID))
+	args = args.Add(tl.Capacity, int64(tl.TTL/time.Second))
+	for _, timeline := range timelines {
+		args = args.Add(timeline.WeiboCreatedAt, timeline.WeiboID)
+	}
+	if _, err := timelineRebuildScript.Do(conn, args...); err != nil {
+		return nil, false, err
+	}
+
+	full := int64(len(timelines)) >= tl.Capacity
+	if page.Cursor != nil {
+		for i, timeline := range timelines {
+			if timeline.WeiboCreatedAt < page.Cursor.CreatedAt ||
+				(timeline.WeiboCreatedAt == page.Cursor.CreatedAt && timeline.WeiboID < page.Cursor.ID) {
+				timelines = timelines[i:]
+				break
+			}
+			if i == len(timelines)-1 {
+				timelines = nil
+			}
+		}
+	} else if page.Offset < int64(len(timelines)) {
+		timelines = timelines[page.Offset:]
+	} else {
+		timelines = nil
+	}
+
+	if int64(len(timelines)) > page.Limit {
+		timelines = timelines[:page.Limit]
+	}
+	return timelines, full, nil
+}
+
+// 批量把一批微博id插入到timeline中
+func (tl *RedisTimeLineRepository) BatchCreateTimeLines(ctx context.Context, timelines []*weibo.TimeLine) error {
+	if err := tl.TimeLineRepository.BatchCreateTimeLines(ctx, timelines); err != nil {
+		return err
+	}
+	return tl.cache.After(func() error { return tl.add(timelines) })
+}
+
+// 插入新的timeline
+func (tl *RedisTimeLineRepository) CreateTimeLine(ctx context.Context, timeline *weibo.TimeLine) error {
+	if err := tl.TimeLineRepository.CreateTimeLine(ctx, timeline); err != nil {
+		return err
+	}
+	added := *timeline
+	return tl.cache.After(func() error { return tl.add([]*weibo.TimeLine{&added}) })
+}
+
+// 删除某个用户的timeline中某人发的微博
+// 缓存中没有作者信息, 直接删除缓存, 下次读取时重建
+func (tl *RedisTimeLineRepository) DeleteWeiboByUserIDAndWeiboUserID(ctx context.Context, userID int64, weiboUserID int64) error {
+	if err := tl.TimeLineRepository.DeleteWeiboByUserIDAndWeiboUserID(ctx, userID, weiboUserID); err != nil {
+		return err
+	}
+	return tl.cache.After(func() error { return tl.do("DEL", timelineKey(userID)) })
+}
+
+// 删除某个用户的timeline中的某条微博
+func (tl *RedisTimeLineRepository) DeleteWeiboByUserIDAndWeiboID(ctx context.Context, userID int64, weiboID int64) error {
+	if err := tl.TimeLineRepository.DeleteWeiboByUserIDAndWeiboID(ctx, userID, weiboID); err != nil {
+		return err
+	}
+	return tl.cache.After(func() error { return tl.do("ZREM", timelineKey(userID), weiboID) })
+}
+
+// 从所有用户的timeline中删除某条微博
+// 先查出这一批timeline的拥有者, 删除后再从他们的缓存中移除
+func (tl *RedisTimeLineRepository) DeleteTimeLinesByWeiboID(ctx context.Context, weiboID int64, limit int64) (int64, error) {
+	userIDs := []int64{}
+	if err := tl.db.SelectContext(ctx, &userIDs, "SELECT user_id FROM `timeline` WHERE weibo_id = ? LIMIT ?", weiboID, limit); err != nil {
+		return 0, err
+	}
+	if len(userIDs) == 0 {
+		return 0, nil
+	}
+
+	query, args, err := sqlx.In("DELETE FROM `timeline` WHERE weibo_id = ? AND user_id IN (?)", weiboID, userIDs)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tl.db.ExecContext(ctx, query, args...); err != nil {
+		return 0, err
+	}
+
+	err = tl.cache.After(func() error {
+		conn := tl.pool.Get()
+		defer conn.Close()
+		for _, userID := range userIDs {
+			conn.Send("ZREM", timelineKey(userID), weiboID)
+		}
+		_, err := conn.Do("")
+		return err
+	})
+	return int64(len(userIDs)), err
+}
+
+func (tl *RedisTimeLineRepository) add(timelines []*weibo.TimeLine) error {
+	conn := tl.pool.Get()
+	defer conn.Close()
+
+	for _, timeline := range timelines {
+		err := timelineAddScript.Send(conn, timelineKey(timeline.UserID), timelineRebuildKey(timeline.UserID), timelinePendingKey(timeline.UserID),
+			tl.Capacity, int64(timelineRebuildTTL/time.Second), timeline.WeiboCreatedAt, timeline.WeiboID)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := conn.Do("")
+	return err
+}
+
+func (tl *RedisTimeLineRepository) do(command string, args ...interface{}) error {
+	conn := tl.pool.Get()
+	defer conn.Close()
+	_, err := conn.Do(command, args...)
+	return err
+}
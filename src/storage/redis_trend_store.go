@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"cache"
+	"context"
+	"time"
+	"weibo"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+var _ weibo.TrendStore = new(RedisTrendStore)
+
+// 按权重合并时间桶到临时key, 裁剪之后替换排行榜, 读取排行榜的请求不会看到合并到一半的结果
+// KEYS: 排行榜, 临时key, 时间桶...; ARGV: 保留的条数, 每个时间桶的权重...
+var trendRebuildScript = redis.NewScript(-1, `
+local args = {'ZUNIONSTORE', KEYS[2], #KEYS - 2}
+for i = 3, #KEYS do
+	table.insert(args, KEYS[i])
+end
+table.insert(args, 'WEIGHTS')
+for i = 2, #ARGV do
+	table.insert(args, ARGV[i])
+end
+if redis.call(unpack(args)) == 0 then
+	redis.call('DEL', KEYS[1])
+	return 0
+end
+redis.call('ZREMRANGEBYRANK', KEYS[2], 0, -tonumber(ARGV[1]) - 1)
+redis.call('RENAME', KEYS[2], KEYS[1])
+return 1
+`)
+
+// 热度计数保存在redis sorted set中, 每个时间桶一个key, member为话题或者微博id, score为计数
+type RedisTrendStore struct {
+	pool *redis.Pool
+}
+
+func NewRedisTrendStore(pool *redis.Pool) *RedisTrendStore {
+	return &RedisTrendStore{pool: pool}
+}
+
+func (s *RedisTrendStore) Incr(ctx context.Context, kind weibo.TrendKind, bucket, id int64, delta float64, ttl time.Duration) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	key := trendBucketKey(string(kind), bucket)
+	conn.Send("MULTI")
+	conn.Send("ZINCRBY", key, delta, id)
+	conn.Send("EXPIRE", key, int64(ttl/time.Second))
+	_, err := cache.DoContext(ctx, conn, "EXEC")
+	return err
+}
+
+func (s *RedisTrendStore) Rebuild(ctx context.Context, kind weibo.TrendKind, buckets []int64, weights []float64, size int64) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	rank := trendRankKey(string(kind))
+	args := make([]interface{}, 0, 3+2*len(buckets))
+	args = append(args, 2+len(buckets), rank, rank+":tmp")
+	for _, bucket := range buckets {
+		args = append(args, trendBucketKey(string(kind), bucket))
+	}
+	args = append(args, size)
+	for _, weight := range weights {
+		args = append(args, weight)
+	}
+	_, err := trendRebuildScript.Do(conn, args...)
+	return err
+}
+
+func (s *RedisTrendStore) Top(ctx context.Context, kind weibo.TrendKind, limit int64) ([]*weibo.TrendScore, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	values, err := redis.Values(cache.DoContext(ctx, conn, "ZREVRANGE", trendRankKey(string(kind)), 0, limit-1, "WITHSCORES"))
+	if err != nil {
+		return nil, err
+	}
+	scores := make([]*weibo.TrendScore, 0, len(values)/2)
+	for len(values) > 0 {
+		var score weibo.TrendScore
+		if values, err = redis.Scan(values, &score.ID, &score.Score); err != nil {
+			return nil, err
+		}
+		scores = append(scores, &score)
+	}
+	return scores, nil
+}
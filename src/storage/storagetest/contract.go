@@ -0,0 +1,1200 @@
+// 仓库接口的契约测试, mysql和内存实现都要通过同一套测试
+package storagetest
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"weibo"
+
+	"github.com/pkg/errors"
+)
+
+// 创建一组空的仓库, 每个测试都会重新创建
+type Opener func(t *testing.T) (*weibo.Repositories, weibo.UnitOfWork)
+
+func Run(t *testing.T, open Opener) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, repos *weibo.Repositories, uow weibo.UnitOfWork)
+	}{
+		{"Users", testUsers},
+		{"Followings", testFollowings},
+		{"Weibos", testWeibos},
+		{"Givelikes", testGivelikes},
+		{"Comments", testComments},
+		{"Topics", testTopics},
+		{"Mentions", testMentions},
+		{"Notifications", testNotifications},
+		{"Messages", testMessages},
+		{"Blocks", testBlocks},
+		{"Mutes", testMutes},
+		{"PullTimeLines", testPullTimeLines},
+		{"Search", testSearch},
+		{"TimeLines", testTimeLines},
+		{"UnitOfWork", testUnitOfWork},
+		{"ReadYourWrites", testReadYourWrites},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			repos, uow := open(t)
+			test.fn(t, repos, uow)
+		})
+	}
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func createUser(t *testing.T, repos *weibo.Repositories, account string, createdAt int64) *weibo.User {
+	t.Helper()
+	ctx := context.Background()
+	user := &weibo.User{Account: account, Avatar: account + ".jpg", Password: "hash", CreatedAt: createdAt}
+	must(t, repos.Users.CreateUser(ctx, user))
+	if user.ID == 0 {
+		t.Fatal("创建用户后应该设置id")
+	}
+	return user
+}
+
+func follow(t *testing.T, repos *weibo.Repositories, from, to *weibo.User, createdAt int64) {
+	t.Helper()
+	ctx := context.Background()
+	must(t, repos.Users.CreateFollowing(ctx, &weibo.Following{FromUserID: from.ID, ToUserID: to.ID, CreatedAt: createdAt}))
+}
+
+// 微博、评论和timeline的id和服务中一样由snowflake生成
+var idGenerator, _ = weibo.NewSnowflake(weibo.MaxWorkerID)
+
+func nextID(t *testing.T) int64 {
+	t.Helper()
+	id, err := idGenerator.NextID()
+	must(t, err)
+	return id
+}
+
+func withID(t *testing.T, timeline *weibo.TimeLine) *weibo.TimeLine {
+	t.Helper()
+	timeline.ID = nextID(t)
+	return timeline
+}
+
+func withIDs(t *testing.T, timelines ...*weibo.TimeLine) []*weibo.TimeLine {
+	t.Helper()
+	for _, timeline := range timelines {
+		withID(t, timeline)
+	}
+	return timelines
+}
+
+func insertWeibo(t *testing.T, repos *weibo.Repositories, user *weibo.User, content string, createdAt int64) *weibo.Weibo {
+	t.Helper()
+	ctx := context.Background()
+	w := &weibo.Weibo{ID: nextID(t), UserID: user.ID, Account: user.Account, Content: content, CreatedAt: createdAt}
+	must(t, repos.Weibos.InsertWeibo(ctx, w))
+	return w
+}
+
+func testUsers(t *testing.T, repos *weibo.Repositories, uow weibo.UnitOfWork) {
+	ctx := context.Background()
+	user, err := repos.Users.GetUserByAccount(ctx, "alice")
+	must(t, err)
+	if user != nil {
+		t.Fatal("不存在的账号应该返回nil", user)
+	}
+
+	alice := createUser(t, repos, "alice", 100)
+	user, err = repos.Users.GetUserByAccount(ctx, "alice")
+	must(t, err)
+	if user == nil || user.ID != alice.ID || user.Avatar != "alice.jpg" || user.CreatedAt != 100 {
+		t.Fatal("按账号查询的用户不对", user)
+	}
+
+	must(t, repos.Users.UpdatePassword(ctx, alice.ID, "new-hash", ""))
+	must(t, repos.Users.AddFollowingNumByUserID(ctx, alice.ID, 2))
+	must(t, repos.Users.AddFollowerNumByUserID(ctx, alice.ID, 3))
+	must(t, repos.Users.AddWeiboNumByUserID(ctx, alice.ID, 4))
+	must(t, repos.Users.AddWeiboNumByUserID(ctx, alice.ID, -1))
+
+	user, err = repos.Users.GetUserByID(ctx, alice.ID)
+	must(t, err)
+	if user == nil || user.Password != "new-hash" || user.FollowingNum != 2 || user.FollowerNum != 3 || user.WeiboNum != 3 {
+		t.Fatal("用户的更新没有生效", user)
+	}
+
+	must(t, repos.Users.UpdateMessagePermission(ctx, alice.ID, weibo.MessageFollowers))
+	user, err = repos.Users.GetUserByID(ctx, alice.ID)
+	must(t, err)
+	if user == nil || user.MessagePermission != weibo.MessageFollowers {
+		t.Fatal("私信权限的更新没有生效", user)
+	}
+
+	user, err = repos.Users.GetUserByID(ctx, alice.ID+1000)
+	must(t, err)
+	if user != nil {
+		t.Fatal("不存在的id应该返回nil", user)
+	}
+}
+
+func testFollowings(t *testing.T, repos *weibo.Repositories, uow weibo.UnitOfWork) {
+	ctx := context.Background()
+	star := createUser(t, repos, "star", 1)
+	fans := []*weibo.User{}
+	for i, account := range []string{"fan1", "fan2", "fan3", "fan4"} {
+		fan := createUser(t, repos, account, int64(10+i))
+		follow(t, repos, fan, star, int64(100+i))
+		fans = append(fans, fan)
+	}
+
+	following, err := repos.Users.GetFollowing(ctx, fans[0].ID, star.ID)
+	must(t, err)
+	if following == nil || following.CreatedAt != 100 {
+		t.Fatal("关注记录不对", following)
+	}
+	following, err = repos.Users.GetFollowing(ctx, star.ID, fans[0].ID)
+	must(t, err)
+	if following != nil {
+		t.Fatal("关注是单向的", following)
+	}
+	if err := repos.Users.CreateFollowing(ctx, &weibo.Following{FromUserID: fans[0].ID, ToUserID: star.ID}); err == nil {
+		t.Fatal("重复关注应该报错")
+	}
+
+	followings, err := repos.Users.GetUserFollowers(ctx, star.ID)
+	must(t, err)
+	if len(followings) != 4 {
+		t.Fatal("粉丝数不对", len(followings))
+	}
+
+	// 按关注时间倒序分页
+	first, err := repos.Users.GetUserFollowers2(ctx, star.ID, weibo.Page{Limit: 3})
+	must(t, err)
+	if len(first) != 3 || first[0].ID != fans[3].ID || first[2].ID != fans[1].ID || first[0].FollowedAt != 103 || first[0].Account != "fan4" {
+		t.Fatal("粉丝列表的第一页不对", first)
+	}
+	last := first[len(first)-1]
+	second, err := repos.Users.GetUserFollowers2(ctx, star.ID, weibo.Page{Cursor: &weibo.Cursor{CreatedAt: last.FollowedAt, ID: last.ID}, Limit: 3})
+	must(t, err)
+	if len(second) != 1 || second[0].ID != fans[0].ID {
+		t.Fatal("粉丝列表的第二页不对", second)
+	}
+	second, err = repos.Users.GetUserFollowers2(ctx, star.ID, weibo.Page{Offset: 3, Limit: 3})
+	must(t, err)
+	if len(second) != 1 || second[0].ID != fans[0].ID {
+		t.Fatal("按offset分页的第二页不对", second)
+	}
+
+	// 按粉丝id顺序分批
+	ids, err := repos.Users.GetUserFollowerIDs(ctx, star.ID, 0, 2)
+	must(t, err)
+	if len(ids) != 2 || ids[0] != fans[0].ID || ids[1] != fans[1].ID {
+		t.Fatal("第一批粉丝id不对", ids)
+	}
+	ids, err = repos.Users.GetUserFollowerIDs(ctx, star.ID, ids[1], 10)
+	must(t, err)
+	if len(ids) != 2 || ids[0] != fans[2].ID || ids[1] != fans[3].ID {
+		t.Fatal("第二批粉丝id不对", ids)
+	}
+
+	must(t, repos.Users.DeleteFollowing(ctx, &weibo.Following{FromUserID: fans[0].ID, ToUserID: star.ID}))
+	following, err = repos.Users.GetFollowing(ctx, fans[0].ID, star.ID)
+	must(t, err)
+	if following != nil {
+		t.Fatal("取消关注后关注记录应该删除", following)
+	}
+	ids, err = repos.Users.GetUserFollowerIDs(ctx, star.ID, 0, 10)
+	must(t, err)
+	if len(ids) != 3 {
+		t.Fatal("取消关注后粉丝id不对", ids)
+	}
+}
+
+func testWeibos(t *testing.T, repos *weibo.Repositories, uow weibo.UnitOfWork) {
+	ctx := context.Background()
+	alice := createUser(t, repos, "alice", 1)
+	first := insertWeibo(t, repos, alice, "hello", 100)
+	second := insertWeibo(t, repos, alice, "world", 200)
+	if err := repos.Weibos.InsertWeibo(ctx, &weibo.Weibo{ID: first.ID, UserID: alice.ID, Account: "alice", Content: "dup", CreatedAt: 300}); err == nil {
+		t.Fatal("id重复的微博应该报错")
+	}
+
+	w, err := repos.Weibos.GetWeiboByID(ctx, first.ID)
+	must(t, err)
+	if w == nil || w.UserID != alice.ID || w.Account != "alice" || w.Content != "hello" || w.CreatedAt != 100 {
+		t.Fatal("查询的微博不对", w)
+	}
+
+	weibos, err := repos.Weibos.GetWeibosByIDs(ctx, []int64{first.ID, second.ID, second.ID + 1000})
+	must(t, err)
+	if len(weibos) != 2 {
+		t.Fatal("批量查询的微博数不对", weibos)
+	}
+	for _, w := range weibos {
+		if w.Avatar != "alice.jpg" {
+			t.Fatal("批量查询的微博应该带上作者头像", w)
+		}
+	}
+	weibos, err = repos.Weibos.GetWeibosByIDs(ctx, nil)
+	must(t, err)
+	if len(weibos) != 0 {
+		t.Fatal("没有id时应该返回空列表", weibos)
+	}
+
+	// 转发的微博记录原微博, 原微博的转发数增加
+	repost := &weibo.Weibo{ID: nextID(t), UserID: alice.ID, Account: "alice", RepostOfID: second.ID, RepostRootID: second.ID, CreatedAt: 300}
+	must(t, repos.Weibos.InsertWeibo(ctx, repost))
+	must(t, repos.Weibos.AddRepostNumByWeiboID(ctx, second.ID, 1))
+	weibos, err = repos.Weibos.GetWeibosByIDs(ctx, []int64{repost.ID, second.ID})
+	must(t, err)
+	for _, w := range weibos {
+		if w.ID == repost.ID && (!w.IsRepost() || w.RepostOfID != second.ID || w.RepostRootID != second.ID) {
+			t.Fatal("转发的微博没有记录原微博", w)
+		}
+		if w.ID == second.ID && w.RepostNum != 1 {
+			t.Fatal("原微博的转发数不对", w)
+		}
+	}
+	w, err = repos.Weibos.GetWeiboByID(ctx, second.ID)
+	must(t, err)
+	if w.RepostNum != 1 {
+		t.Fatal("原微博的转发数不对", w)
+	}
+
+	must(t, repos.Weibos.DeleteWeibo(ctx, first))
+	w, err = repos.Weibos.GetWeiboByID(ctx, first.ID)
+	must(t, err)
+	if w != nil {
+		t.Fatal("删除的微博不应该查到", w)
+	}
+	weibos, err = repos.Weibos.GetWeibosByIDs(ctx, []int64{first.ID, second.ID})
+	must(t, err)
+	if len(weibos) != 1 || weibos[0].ID != second.ID {
+		t.Fatal("删除的微博不应该返回", weibos)
+	}
+}
+
+func testGivelikes(t *testing.T, repos *weibo.Repositories, uow weibo.UnitOfWork) {
+	ctx := context.Background()
+	alice := createUser(t, repos, "alice", 1)
+	bob := createUser(t, repos, "bob", 2)
+	w := insertWeibo(t, repos, alice, "hello", 100)
+
+	givelike, err := repos.Weibos.GetGivelikeByUseIDAndWeiboID(ctx, bob.ID, w.ID)
+	must(t, err)
+	if givelike != nil {
+		t.Fatal("没有点赞过", givelike)
+	}
+	must(t, repos.Weibos.CreateGivelike(ctx, &weibo.Givelike{UserID: bob.ID, WeiboID: w.ID, CreatedAt: 200}))
+	must(t, repos.Weibos.AddLikeNumByWeiboID(ctx, w.ID, 1))
+	givelike, err = repos.Weibos.GetGivelikeByUseIDAndWeiboID(ctx, bob.ID, w.ID)
+	must(t, err)
+	if givelike == nil || givelike.CreatedAt != 200 {
+		t.Fatal("点赞记录不对", givelike)
+	}
+	if err := repos.Weibos.CreateGivelike(ctx, &weibo.Givelike{UserID: bob.ID, WeiboID: w.ID}); errors.Cause(err) != weibo.ErrDuplicate {
+		t.Fatal("重复点赞应该返回ErrDuplicate", err)
+	}
+
+	collect, err := repos.Weibos.CollectByUseIDAndWeiboID(ctx, bob.ID, w.ID)
+	must(t, err)
+	if collect != nil {
+		t.Fatal("没有收藏过", collect)
+	}
+	must(t, repos.Weibos.CreateCollect(ctx, &weibo.Collect{UserID: bob.ID, WeiboID: w.ID, CreatedAt: 300}))
+	collect, err = repos.Weibos.CollectByUseIDAndWeiboID(ctx, bob.ID, w.ID)
+	must(t, err)
+	if collect == nil || collect.CreatedAt != 300 {
+		t.Fatal("收藏记录不对", collect)
+	}
+	if err := repos.Weibos.CreateCollect(ctx, &weibo.Collect{UserID: bob.ID, WeiboID: w.ID}); errors.Cause(err) != weibo.ErrDuplicate {
+		t.Fatal("重复收藏应该返回ErrDuplicate", err)
+	}
+
+	got, err := repos.Weibos.GetWeiboByID(ctx, w.ID)
+	must(t, err)
+	if got.LikeNum != 1 {
+		t.Fatal("点赞数不对", got.LikeNum)
+	}
+}
+
+func testComments(t *testing.T, repos *weibo.Repositories, uow weibo.UnitOfWork) {
+	ctx := context.Background()
+	alice := createUser(t, repos, "alice", 1)
+	bob := createUser(t, repos, "bob", 2)
+	w := insertWeibo(t, repos, alice, "hello", 100)
+
+	comment := &weibo.Comment{ID: nextID(t), UserID: alice.ID, WeiboID: w.ID, Content: "第一条评论", CreatedAt: 200}
+	must(t, repos.Comments.CreateComment(ctx, comment))
+	if err := repos.Comments.CreateComment(ctx, comment); err == nil {
+		t.Fatal("id重复的评论应该报错")
+	}
+	must(t, repos.Weibos.AddCommentNumByWeiboID(ctx, w.ID, 1))
+
+	got, err := repos.Comments.GetCommentByID(ctx, comment.ID)
+	must(t, err)
+	if got == nil || got.Content != "第一条评论" || got.WeiboID != w.ID || got.UserID != alice.ID || got.IsReply() {
+		t.Fatal("查询的评论不对", got)
+	}
+	gotWeibo, err := repos.Weibos.GetWeiboByID(ctx, w.ID)
+	must(t, err)
+	if gotWeibo.CommentNum != 1 {
+		t.Fatal("评论数不对", gotWeibo.CommentNum)
+	}
+
+	// 第二个楼层有两条回复, 按回复数排序时排在前面
+	second := &weibo.Comment{ID: nextID(t), UserID: bob.ID, WeiboID: w.ID, Content: "第二条评论", CreatedAt: 150}
+	must(t, repos.Comments.CreateComment(ctx, second))
+	reply := &weibo.Comment{ID: nextID(t), UserID: alice.ID, WeiboID: w.ID, ParentID: second.ID, RootID: second.ID, Content: "回复", CreatedAt: 300}
+	replyToReply := &weibo.Comment{ID: nextID(t), UserID: bob.ID, WeiboID: w.ID, ParentID: reply.ID, RootID: second.ID, Content: "回复的回复", CreatedAt: 400}
+	must(t, repos.Comments.CreateComment(ctx, reply))
+	must(t, repos.Comments.CreateComment(ctx, replyToReply))
+	must(t, repos.Comments.AddReplyNumByCommentID(ctx, second.ID, 2))
+
+	byTime, err := repos.Comments.GetCommentsByWeiboID(ctx, w.ID, weibo.CommentSortTime, weibo.Page{Limit: 10})
+	must(t, err)
+	if len(byTime) != 2 || byTime[0].ID != comment.ID || byTime[1].ID != second.ID || byTime[1].Account != "bob" || byTime[1].Avatar != "bob.jpg" {
+		t.Fatal("按时间排序的评论不对", byTime)
+	}
+	byHot, err := repos.Comments.GetCommentsByWeiboID(ctx, w.ID, weibo.CommentSortHot, weibo.Page{Limit: 1})
+	must(t, err)
+	if len(byHot) != 1 || byHot[0].ID != second.ID || byHot[0].ReplyNum != 2 {
+		t.Fatal("按回复数排序的评论不对", byHot)
+	}
+	byHot, err = repos.Comments.GetCommentsByWeiboID(ctx, w.ID, weibo.CommentSortHot, weibo.Page{Cursor: &weibo.Cursor{CreatedAt: 2, ID: second.ID}, Limit: 10})
+	must(t, err)
+	if len(byHot) != 1 || byHot[0].ID != comment.ID {
+		t.Fatal("按回复数排序的第二页不对", byHot)
+	}
+
+	replies, err := repos.Comments.GetRepliesByRootID(ctx, second.ID, weibo.Page{Limit: 10})
+	must(t, err)
+	if len(replies) != 2 || replies[0].ID != replyToReply.ID || replies[0].ParentID != reply.ID || replies[1].ID != reply.ID || replies[1].Account != "alice" {
+		t.Fatal("楼中的回复不对", replies)
+	}
+
+	// 删除楼层的第一条评论时楼中的回复一起删除
+	deleted, err := repos.Comments.DeleteComment(ctx, second.ID)
+	must(t, err)
+	if deleted != 3 {
+		t.Fatal("删除的评论数不对", deleted)
+	}
+	got, err = repos.Comments.GetCommentByID(ctx, reply.ID)
+	must(t, err)
+	if got != nil {
+		t.Fatal("楼中的回复应该一起删除", got)
+	}
+
+	deleted, err = repos.Comments.DeleteComment(ctx, comment.ID)
+	must(t, err)
+	if deleted != 1 {
+		t.Fatal("删除的评论数不对", deleted)
+	}
+	got, err = repos.Comments.GetCommentByID(ctx, comment.ID)
+	must(t, err)
+	if got != nil {
+		t.Fatal("删除的评论不应该查到", got)
+	}
+	deleted, err = repos.Comments.DeleteComment(ctx, comment.ID)
+	must(t, err)
+	if deleted != 0 {
+		t.Fatal("重复删除不应该删除任何评论", deleted)
+	}
+}
+
+func testTopics(t *testing.T, repos *weibo.Repositories, uow weibo.UnitOfWork) {
+	ctx := context.Background()
+	alice := createUser(t, repos, "alice", 1)
+
+	topics, err := repos.Topics.CreateTopics(ctx, []string{"周末", "天气"}, 100)
+	must(t, err)
+	if len(topics) != 2 || topics[0].ID == 0 || topics[0].ID == topics[1].ID {
+		t.Fatal("创建的话题不对", topics)
+	}
+	// 已经存在的话题不会重复创建
+	again, err := repos.Topics.CreateTopics(ctx, []string{"周末", "读书"}, 200)
+	must(t, err)
+	byName := map[string]*weibo.Topic{}
+	for _, topic := range append(topics, again...) {
+		if existing, ok := byName[topic.Name]; ok && existing.ID != topic.ID {
+			t.Fatal("同名的话题重复创建了", existing, topic)
+		}
+		byName[topic.Name] = topic
+	}
+	if len(again) != 2 || len(byName) != 3 || byName["周末"].CreatedAt != 100 {
+		t.Fatal("创建的话题不对", again)
+	}
+
+	weekend := byName["周末"]
+	first := insertWeibo(t, repos, alice, "#周末# 第一条", 300)
+	second := insertWeibo(t, repos, alice, "#周末# #天气# 第二条", 400)
+	must(t, repos.Topics.CreateWeiboTopics(ctx, []*weibo.WeiboTopic{{TopicID: weekend.ID, WeiboID: first.ID, WeiboCreatedAt: first.CreatedAt}}))
+	must(t, repos.Topics.CreateWeiboTopics(ctx, []*weibo.WeiboTopic{
+		{TopicID: weekend.ID, WeiboID: second.ID, WeiboCreatedAt: second.CreatedAt},
+		{TopicID: byName["天气"].ID, WeiboID: second.ID, WeiboCreatedAt: second.CreatedAt},
+	}))
+	if err := repos.Topics.CreateWeiboTopics(ctx, []*weibo.WeiboTopic{{TopicID: weekend.ID, WeiboID: first.ID, WeiboCreatedAt: first.CreatedAt}}); err == nil {
+		t.Fatal("重复的关联应该报错")
+	}
+	must(t, repos.Topics.AddWeiboNumByTopicIDs(ctx, []int64{weekend.ID}, 2))
+	must(t, repos.Topics.AddWeiboNumByTopicIDs(ctx, []int64{byName["天气"].ID}, 1))
+
+	got, err := repos.Topics.GetTopicByName(ctx, "周末")
+	must(t, err)
+	if got == nil || got.ID != weekend.ID || got.WeiboNum != 2 {
+		t.Fatal("查询的话题不对", got)
+	}
+	byIDs, err := repos.Topics.GetTopicsByIDs(ctx, []int64{weekend.ID, byName["天气"].ID, 0})
+	must(t, err)
+	if len(byIDs) != 2 {
+		t.Fatal("根据id批量查询的话题不对", byIDs)
+	}
+	got, err = repos.Topics.GetTopicByName(ctx, "不存在")
+	must(t, err)
+	if got != nil {
+		t.Fatal("不存在的话题应该返回nil", got)
+	}
+
+	weibos, err := repos.Topics.GetWeibosByTopicID(ctx, weekend.ID, weibo.Page{Limit: 1})
+	must(t, err)
+	if len(weibos) != 1 || weibos[0].ID != second.ID || weibos[0].Avatar != "alice.jpg" {
+		t.Fatal("话题下的微博不对", weibos)
+	}
+	weibos, err = repos.Topics.GetWeibosByTopicID(ctx, weekend.ID, weibo.Page{Cursor: &weibo.Cursor{CreatedAt: second.CreatedAt, ID: second.ID}, Limit: 10})
+	must(t, err)
+	if len(weibos) != 1 || weibos[0].ID != first.ID {
+		t.Fatal("话题下微博的第二页不对", weibos)
+	}
+
+	top, err := repos.Topics.GetTopTopics(ctx, 10)
+	must(t, err)
+	if len(top) != 2 || top[0].ID != weekend.ID || top[1].Name != "天气" {
+		t.Fatal("微博数最多的话题不对, 没有微博的话题不应该返回", top)
+	}
+
+	linked, err := repos.Topics.GetTopicsByWeiboID(ctx, second.ID)
+	must(t, err)
+	if len(linked) != 2 {
+		t.Fatal("微博关联的话题不对", linked)
+	}
+	must(t, repos.Topics.DeleteWeiboTopicsByWeiboID(ctx, second.ID))
+	linked, err = repos.Topics.GetTopicsByWeiboID(ctx, second.ID)
+	must(t, err)
+	if len(linked) != 0 {
+		t.Fatal("删除的关联不应该查到", linked)
+	}
+	weibos, err = repos.Topics.GetWeibosByTopicID(ctx, weekend.ID, weibo.Page{Limit: 10})
+	must(t, err)
+	if len(weibos) != 1 || weibos[0].ID != first.ID {
+		t.Fatal("删除关联后话题下的微博不对", weibos)
+	}
+}
+
+func testMentions(t *testing.T, repos *weibo.Repositories, uow weibo.UnitOfWork) {
+	ctx := context.Background()
+	alice := createUser(t, repos, "alice", 1)
+	bob := createUser(t, repos, "bob", 2)
+
+	users, err := repos.Users.GetUsersByAccounts(ctx, []string{"alice", "bob", "不存在"})
+	must(t, err)
+	if len(users) != 2 {
+		t.Fatal("根据账号批量查询的用户不对", users)
+	}
+
+	w := insertWeibo(t, repos, alice, "@bob 你好", 100)
+	other := insertWeibo(t, repos, alice, "@bob 再见", 150)
+	comment := &weibo.Comment{ID: nextID(t), UserID: alice.ID, WeiboID: w.ID, Content: "@bob 评论", CreatedAt: 200}
+	must(t, repos.Comments.CreateComment(ctx, comment))
+
+	inWeibo := &weibo.Mention{ID: nextID(t), UserID: bob.ID, AuthorID: alice.ID, WeiboID: w.ID, CreatedAt: 100}
+	inOther := &weibo.Mention{ID: nextID(t), UserID: bob.ID, AuthorID: alice.ID, WeiboID: other.ID, CreatedAt: 150}
+	inComment := &weibo.Mention{ID: nextID(t), UserID: bob.ID, AuthorID: alice.ID, WeiboID: w.ID, CommentID: comment.ID, CreatedAt: 200}
+	must(t, repos.Mentions.CreateMentions(ctx, []*weibo.Mention{inWeibo, inOther}))
+	must(t, repos.Mentions.CreateMentions(ctx, []*weibo.Mention{inComment}))
+	duplicate := *inWeibo
+	duplicate.ID = nextID(t)
+	if err := repos.Mentions.CreateMentions(ctx, []*weibo.Mention{&duplicate}); err == nil {
+		t.Fatal("同一条微博重复提到同一个用户应该报错")
+	}
+
+	comments, err := repos.Comments.GetCommentsByIDs(ctx, []int64{comment.ID, 0})
+	must(t, err)
+	if len(comments) != 1 || comments[0].Account != "alice" || comments[0].Avatar != "alice.jpg" {
+		t.Fatal("根据id批量查询的评论不对", comments)
+	}
+
+	mentions, err := repos.Mentions.GetMentionsByUserID(ctx, bob.ID, weibo.Page{Limit: 2})
+	must(t, err)
+	if len(mentions) != 2 || mentions[0].ID != inComment.ID || !mentions[0].InComment() || mentions[1].ID != inOther.ID {
+		t.Fatal("提到用户的记录不对", mentions)
+	}
+	mentions, err = repos.Mentions.GetMentionsByUserID(ctx, bob.ID, weibo.Page{Cursor: &weibo.Cursor{CreatedAt: inOther.CreatedAt, ID: inOther.ID}, Limit: 10})
+	must(t, err)
+	if len(mentions) != 1 || mentions[0].ID != inWeibo.ID {
+		t.Fatal("提到用户的记录的第二页不对", mentions)
+	}
+
+	// 评论和微博删除之后不再返回
+	_, err = repos.Comments.DeleteComment(ctx, comment.ID)
+	must(t, err)
+	must(t, repos.Weibos.DeleteWeibo(ctx, other))
+	mentions, err = repos.Mentions.GetMentionsByUserID(ctx, bob.ID, weibo.Page{Limit: 10})
+	must(t, err)
+	if len(mentions) != 1 || mentions[0].ID != inWeibo.ID {
+		t.Fatal("删除的评论和微博中提到的记录不应该返回", mentions)
+	}
+
+	must(t, repos.Mentions.DeleteMentionsByWeiboID(ctx, w.ID))
+	mentions, err = repos.Mentions.GetMentionsByUserID(ctx, bob.ID, weibo.Page{Limit: 10})
+	must(t, err)
+	if len(mentions) != 0 {
+		t.Fatal("删除的记录不应该查到", mentions)
+	}
+}
+
+func testNotifications(t *testing.T, repos *weibo.Repositories, uow weibo.UnitOfWork) {
+	ctx := context.Background()
+	alice := createUser(t, repos, "alice", 1)
+	bob := createUser(t, repos, "bob", 2)
+	carol := createUser(t, repos, "carol", 3)
+
+	like := func(actor *weibo.User, weiboID, at int64) *weibo.Notification {
+		return &weibo.Notification{ID: nextID(t), UserID: alice.ID, Kind: weibo.NotifyLike, TargetID: weiboID, ActorID: actor.ID, ActorAccount: actor.Account, ActorNum: 1, CreatedAt: at, UpdatedAt: at}
+	}
+	first := like(bob, 100, 10)
+	must(t, repos.Notifications.AddNotification(ctx, first))
+	merged := like(carol, 100, 20)
+	must(t, repos.Notifications.AddNotification(ctx, merged))
+	if merged.ID != first.ID {
+		t.Fatal("合并的通知应该返回已有通知的id", merged.ID, first.ID)
+	}
+	other := like(bob, 200, 15)
+	must(t, repos.Notifications.AddNotification(ctx, other))
+
+	// 聚合的每个用户都有记录, 按最近触发的时间倒序
+	actors, err := repos.Notifications.GetNotificationActors(ctx, []int64{first.ID, other.ID})
+	must(t, err)
+	if len(actors) != 3 || actors[0].ActorID != carol.ID || actors[0].NotificationID != first.ID ||
+		actors[1].NotificationID != other.ID || actors[2].ActorID != bob.ID || actors[2].ActorNum != 1 || actors[2].UpdatedAt != 10 {
+		t.Fatal("通知中的用户不对", actors)
+	}
+
+	count, err := repos.Notifications.CountUnreadNotifications(ctx, alice.ID)
+	must(t, err)
+	if count != 2 {
+		t.Fatal("未读通知数不对", count)
+	}
+
+	// 同一条微博的点赞合并成一条, 按最近一次合并的时间排在前面
+	notifications, err := repos.Notifications.GetNotificationsByUserID(ctx, alice.ID, weibo.Page{Limit: 1})
+	must(t, err)
+	if len(notifications) != 1 || notifications[0].ID != first.ID || notifications[0].ActorNum != 2 || notifications[0].ActorAccount != "carol" || notifications[0].UpdatedAt != 20 || notifications[0].IsRead() {
+		t.Fatal("合并的通知不对", notifications)
+	}
+	notifications, err = repos.Notifications.GetNotificationsByUserID(ctx, alice.ID, weibo.Page{Cursor: &weibo.Cursor{CreatedAt: 20, ID: first.ID}, Limit: 10})
+	must(t, err)
+	if len(notifications) != 1 || notifications[0].ID != other.ID {
+		t.Fatal("通知的第二页不对", notifications)
+	}
+
+	// 已读之后同样的动作产生新的通知
+	must(t, repos.Notifications.MarkNotificationsRead(ctx, alice.ID))
+	count, err = repos.Notifications.CountUnreadNotifications(ctx, alice.ID)
+	must(t, err)
+	if count != 0 {
+		t.Fatal("标记已读之后未读通知数应该为0", count)
+	}
+	again := like(bob, 100, 30)
+	must(t, repos.Notifications.AddNotification(ctx, again))
+	notifications, err = repos.Notifications.GetNotificationsByUserID(ctx, alice.ID, weibo.Page{Limit: 10})
+	must(t, err)
+	if len(notifications) != 3 || notifications[0].ID != again.ID || notifications[0].ActorNum != 1 || !notifications[1].IsRead() {
+		t.Fatal("已读之后的新通知不对", notifications)
+	}
+	count, err = repos.Notifications.CountUnreadNotifications(ctx, alice.ID)
+	must(t, err)
+	if count != 1 {
+		t.Fatal("新通知之后的未读通知数不对", count)
+	}
+}
+
+func testMessages(t *testing.T, repos *weibo.Repositories, uow weibo.UnitOfWork) {
+	ctx := context.Background()
+	alice := createUser(t, repos, "alice", 1)
+	bob := createUser(t, repos, "bob", 2)
+	carol := createUser(t, repos, "carol", 3)
+
+	send := func(from, to *weibo.User, content string, at int64) *weibo.Message {
+		message := &weibo.Message{ID: nextID(t), FromUserID: from.ID, ToUserID: to.ID, Content: content, CreatedAt: at}
+		must(t, repos.Messages.CreateMessage(ctx, message))
+		must(t, repos.Messages.SaveConversation(ctx, &weibo.Conversation{ID: nextID(t), UserID: from.ID, PeerID: to.ID, LastMessageID: message.ID, LastContent: content, UpdatedAt: at}))
+		must(t, repos.Messages.SaveConversation(ctx, &weibo.Conversation{ID: nextID(t), UserID: to.ID, PeerID: from.ID, LastMessageID: message.ID, LastContent: content, UnreadNum: 1, UpdatedAt: at}))
+		return message
+	}
+	m1 := send(bob, alice, "1", 10)
+	m2 := send(alice, bob, "2", 20)
+	m3 := send(bob, alice, "3", 30)
+	send(carol, alice, "4", 25)
+	send(carol, bob, "5", 40)
+
+	count, err := repos.Messages.CountUnreadMessages(ctx, alice.ID)
+	must(t, err)
+	if count != 3 {
+		t.Fatal("未读私信数不对", count)
+	}
+
+	// 双方看到的是同样的私信
+	for _, user := range []*weibo.User{alice, bob} {
+		peer := bob
+		if user == bob {
+			peer = alice
+		}
+		messages, err := repos.Messages.GetMessages(ctx, user.ID, peer.ID, weibo.Page{Limit: 2})
+		must(t, err)
+		if len(messages) != 2 || messages[0].ID != m3.ID || messages[1].ID != m2.ID {
+			t.Fatal("私信的第一页不对", messages)
+		}
+		messages, err = repos.Messages.GetMessages(ctx, user.ID, peer.ID, weibo.Page{Cursor: &weibo.Cursor{CreatedAt: m2.CreatedAt, ID: m2.ID}, Limit: 2})
+		must(t, err)
+		if len(messages) != 1 || messages[0].ID != m1.ID || messages[0].FromUserID != bob.ID || messages[0].Content != "1" {
+			t.Fatal("私信的第二页不对", messages)
+		}
+	}
+
+	// 同一个人的私信只有一个会话, 按最后一条私信的时间排序
+	conversations, err := repos.Messages.GetConversationsByUserID(ctx, alice.ID, weibo.Page{Limit: 1})
+	must(t, err)
+	if len(conversations) != 1 || conversations[0].PeerID != bob.ID || conversations[0].PeerAccount != "bob" || conversations[0].PeerAvatar != "bob.jpg" ||
+		conversations[0].LastMessageID != m3.ID || conversations[0].LastContent != "3" || conversations[0].UnreadNum != 2 || conversations[0].UpdatedAt != 30 {
+		t.Fatal("会话不对", conversations)
+	}
+	first := conversations[0]
+	conversations, err = repos.Messages.GetConversationsByUserID(ctx, alice.ID, weibo.Page{Cursor: &weibo.Cursor{CreatedAt: first.UpdatedAt, ID: first.ID}, Limit: 10})
+	must(t, err)
+	if len(conversations) != 1 || conversations[0].PeerID != carol.ID || conversations[0].UnreadNum != 1 {
+		t.Fatal("会话的第二页不对", conversations)
+	}
+
+	must(t, repos.Messages.MarkConversationRead(ctx, alice.ID, bob.ID))
+	count, err = repos.Messages.CountUnreadMessages(ctx, alice.ID)
+	must(t, err)
+	if count != 1 {
+		t.Fatal("标记已读之后的未读私信数不对", count)
+	}
+	// 对方的未读数不受影响
+	count, err = repos.Messages.CountUnreadMessages(ctx, bob.ID)
+	must(t, err)
+	if count != 2 {
+		t.Fatal("对方的未读私信数不对", count)
+	}
+}
+
+func testBlocks(t *testing.T, repos *weibo.Repositories, uow weibo.UnitOfWork) {
+	ctx := context.Background()
+	alice := createUser(t, repos, "alice", 1)
+	bob := createUser(t, repos, "bob", 2)
+	carol := createUser(t, repos, "carol", 3)
+	dave := createUser(t, repos, "dave", 4)
+
+	must(t, repos.Blocks.CreateBlock(ctx, &weibo.Block{UserID: alice.ID, BlockedUserID: bob.ID, CreatedAt: 10}))
+	must(t, repos.Blocks.CreateBlock(ctx, &weibo.Block{UserID: alice.ID, BlockedUserID: carol.ID, CreatedAt: 20}))
+	must(t, repos.Blocks.CreateBlock(ctx, &weibo.Block{UserID: dave.ID, BlockedUserID: alice.ID, CreatedAt: 30}))
+	if err := repos.Blocks.CreateBlock(ctx, &weibo.Block{UserID: alice.ID, BlockedUserID: bob.ID, CreatedAt: 40}); err == nil {
+		t.Fatal("重复的屏蔽应该失败")
+	}
+
+	block, err := repos.Blocks.GetBlock(ctx, alice.ID, bob.ID)
+	must(t, err)
+	if block == nil || block.CreatedAt != 10 {
+		t.Fatal("屏蔽记录不对", block)
+	}
+	// 屏蔽是单向的记录
+	block, err = repos.Blocks.GetBlock(ctx, bob.ID, alice.ID)
+	must(t, err)
+	if block != nil {
+		t.Fatal("对方没有屏蔽", block)
+	}
+
+	// 任意一方屏蔽了另一方
+	for _, ids := range [][2]int64{{alice.ID, bob.ID}, {bob.ID, alice.ID}, {alice.ID, dave.ID}} {
+		blocked, err := repos.Blocks.IsBlocked(ctx, ids[0], ids[1])
+		must(t, err)
+		if !blocked {
+			t.Fatal("应该存在屏蔽关系", ids)
+		}
+	}
+	blocked, err := repos.Blocks.IsBlocked(ctx, bob.ID, carol.ID)
+	must(t, err)
+	if blocked {
+		t.Fatal("不应该存在屏蔽关系")
+	}
+
+	related, err := repos.Blocks.GetBlockRelatedUserIDs(ctx, alice.ID)
+	must(t, err)
+	relatedIDs := map[int64]bool{}
+	for _, id := range related {
+		relatedIDs[id] = true
+	}
+	if len(related) != 3 || !relatedIDs[bob.ID] || !relatedIDs[carol.ID] || !relatedIDs[dave.ID] {
+		t.Fatal("有屏蔽关系的人不对", related)
+	}
+
+	users, err := repos.Blocks.GetBlockedUsers(ctx, alice.ID, weibo.Page{Limit: 1})
+	must(t, err)
+	if len(users) != 1 || users[0].ID != carol.ID || users[0].Account != "carol" || users[0].Avatar != "carol.jpg" || users[0].BlockedAt != 20 {
+		t.Fatal("屏蔽列表的第一页不对", users)
+	}
+	users, err = repos.Blocks.GetBlockedUsers(ctx, alice.ID, weibo.Page{Cursor: &weibo.Cursor{CreatedAt: users[0].BlockedAt, ID: users[0].ID}, Limit: 10})
+	must(t, err)
+	if len(users) != 1 || users[0].ID != bob.ID {
+		t.Fatal("屏蔽列表的第二页不对", users)
+	}
+
+	must(t, repos.Blocks.DeleteBlock(ctx, &weibo.Block{UserID: alice.ID, BlockedUserID: bob.ID}))
+	blocked, err = repos.Blocks.IsBlocked(ctx, alice.ID, bob.ID)
+	must(t, err)
+	if blocked {
+		t.Fatal("取消屏蔽之后不应该存在屏蔽关系")
+	}
+}
+
+func testMutes(t *testing.T, repos *weibo.Repositories, uow weibo.UnitOfWork) {
+	ctx := context.Background()
+	alice := createUser(t, repos, "alice", 1)
+	bob := createUser(t, repos, "bob", 2)
+
+	byUser := &weibo.Mute{ID: nextID(t), UserID: alice.ID, MutedUserID: bob.ID, MutedAccount: "bob", CreatedAt: 10}
+	byKeyword := &weibo.Mute{ID: nextID(t), UserID: alice.ID, Keyword: "剧透", ExpiresAt: 100, CreatedAt: 20}
+	must(t, repos.Mutes.CreateMute(ctx, byUser))
+	must(t, repos.Mutes.CreateMute(ctx, byKeyword))
+	must(t, repos.Mutes.CreateMute(ctx, &weibo.Mute{ID: nextID(t), UserID: bob.ID, Keyword: "剧透", CreatedAt: 30}))
+	if err := repos.Mutes.CreateMute(ctx, &weibo.Mute{ID: nextID(t), UserID: alice.ID, Keyword: "剧透", CreatedAt: 40}); errors.Cause(err) != weibo.ErrDuplicate {
+		t.Fatal("重复的静音应该返回ErrDuplicate", err)
+	}
+
+	mutes, err := repos.Mutes.GetMutesByUserID(ctx, alice.ID)
+	must(t, err)
+	if len(mutes) != 2 || !reflect.DeepEqual(mutes[0], byKeyword) || !reflect.DeepEqual(mutes[1], byUser) {
+		t.Fatal("静音列表不对", mutes)
+	}
+	mutes, err = repos.Mutes.GetMutesByUserIDs(ctx, []int64{alice.ID, bob.ID, 999})
+	must(t, err)
+	if len(mutes) != 3 {
+		t.Fatal("批量查询的静音列表不对", mutes)
+	}
+
+	// 只能删除自己的静音
+	deleted, err := repos.Mutes.DeleteMute(ctx, bob.ID, byUser.ID)
+	must(t, err)
+	if deleted != 0 {
+		t.Fatal("不能删除别人的静音")
+	}
+	deleted, err = repos.Mutes.DeleteMute(ctx, alice.ID, byUser.ID)
+	must(t, err)
+	if deleted != 1 {
+		t.Fatal("删除静音失败")
+	}
+
+	// 没有过期时不删除, 永久静音不会过期
+	must(t, repos.Mutes.DeleteExpiredMutes(ctx, alice.ID, 99))
+	mutes, err = repos.Mutes.GetMutesByUserID(ctx, alice.ID)
+	must(t, err)
+	if len(mutes) != 1 || mutes[0].ID != byKeyword.ID {
+		t.Fatal("删除之后的静音列表不对", mutes)
+	}
+	must(t, repos.Mutes.DeleteExpiredMutes(ctx, alice.ID, 100))
+	must(t, repos.Mutes.DeleteExpiredMutes(ctx, bob.ID, 1000))
+	mutes, err = repos.Mutes.GetMutesByUserID(ctx, alice.ID)
+	must(t, err)
+	if len(mutes) != 0 {
+		t.Fatal("过期的静音应该被删除", mutes)
+	}
+	mutes, err = repos.Mutes.GetMutesByUserID(ctx, bob.ID)
+	must(t, err)
+	if len(mutes) != 1 {
+		t.Fatal("永久静音不应该被删除", mutes)
+	}
+}
+
+func testPullTimeLines(t *testing.T, repos *weibo.Repositories, uow weibo.UnitOfWork) {
+	ctx := context.Background()
+	star := createUser(t, repos, "star", 1)
+	normal := createUser(t, repos, "normal", 2)
+	viewer := createUser(t, repos, "viewer", 3)
+	follow(t, repos, viewer, star, 10)
+	follow(t, repos, viewer, normal, 11)
+	pull := func(user *weibo.User, content string, createdAt int64) *weibo.Weibo {
+		w := &weibo.Weibo{ID: nextID(t), UserID: user.ID, Account: user.Account, Content: content, Pulled: true, CreatedAt: createdAt}
+		must(t, repos.Weibos.InsertWeibo(ctx, w))
+		return w
+	}
+
+	// 只返回以拉模式发布的微博, 和作者现在的粉丝数无关
+	w1 := pull(star, "1", 100)
+	insertWeibo(t, repos, normal, "2", 150)
+	w3 := pull(star, "3", 200)
+	w4 := pull(star, "4", 200)
+	insertWeibo(t, repos, star, "5", 250)
+
+	timelines, err := repos.Weibos.GetPullTimeLinesByUserID(ctx, viewer.ID, weibo.Page{Limit: 2})
+	must(t, err)
+	if len(timelines) != 2 || timelines[0].WeiboID != w4.ID || timelines[1].WeiboID != w3.ID {
+		t.Fatal("拉模式账号的微博不对", timelines)
+	}
+	if timelines[0].UserID != viewer.ID || timelines[0].WeiboUserID != star.ID || timelines[0].WeiboCreatedAt != 200 {
+		t.Fatal("拉取的timeline字段不对", timelines[0])
+	}
+
+	last := timelines[1]
+	timelines, err = repos.Weibos.GetPullTimeLinesByUserID(ctx, viewer.ID, weibo.Page{Cursor: &weibo.Cursor{CreatedAt: last.WeiboCreatedAt, ID: last.WeiboID}, Limit: 2})
+	must(t, err)
+	if len(timelines) != 1 || timelines[0].WeiboID != w1.ID {
+		t.Fatal("拉模式微博的第二页不对", timelines)
+	}
+
+	timelines, err = repos.Weibos.GetPullTimeLinesByUserID(ctx, star.ID, weibo.Page{Limit: 10})
+	must(t, err)
+	if len(timelines) != 0 {
+		t.Fatal("没有关注拉模式账号时应该为空", timelines)
+	}
+}
+
+func testSearch(t *testing.T, repos *weibo.Repositories, uow weibo.UnitOfWork) {
+	ctx := context.Background()
+	alice := createUser(t, repos, "alice", 100)
+	alan := createUser(t, repos, "alan", 200)
+	createUser(t, repos, "bob", 300)
+
+	users, err := repos.Users.GetUsersByAccount(ctx, "al", weibo.Page{Limit: 10})
+	must(t, err)
+	if len(users) != 2 || users[0].ID != alan.ID || users[1].ID != alice.ID {
+		t.Fatal("按账号前缀搜索的用户不对", users)
+	}
+	users, err = repos.Users.GetUsersByAccount(ctx, "al", weibo.Page{Offset: 1, Limit: 10})
+	must(t, err)
+	if len(users) != 1 || users[0].ID != alice.ID {
+		t.Fatal("搜索用户的第二页不对", users)
+	}
+	// 通配符按普通字符匹配
+	for _, key := range []string{"_", "%", "a%", "\\"} {
+		users, err = repos.Users.GetUsersByAccount(ctx, key, weibo.Page{Limit: 10})
+		must(t, err)
+		if len(users) != 0 {
+			t.Fatal("搜索中的通配符应该按普通字符匹配", key, users)
+		}
+	}
+	alUnderscore := createUser(t, repos, "al_x", 400)
+	users, err = repos.Users.GetUsersByAccount(ctx, "al_", weibo.Page{Limit: 10})
+	must(t, err)
+	if len(users) != 1 || users[0].ID != alUnderscore.ID {
+		t.Fatal("搜索中的_应该只匹配_本身", users)
+	}
+
+	w1 := insertWeibo(t, repos, alice, "今天天气不错", 100)
+	w2 := insertWeibo(t, repos, alan, "weather is fine", 200)
+	insertWeibo(t, repos, alan, "天气", 300)
+
+	weibos, err := repos.Weibos.GetWeibosByAccountOrContent(ctx, "alice", weibo.Page{Limit: 10})
+	must(t, err)
+	if len(weibos) != 1 || weibos[0].ID != w1.ID {
+		t.Fatal("按账号搜索微博不对", weibos)
+	}
+	weibos, err = repos.Weibos.GetWeibosByAccountOrContent(ctx, "天气", weibo.Page{Limit: 1})
+	must(t, err)
+	if len(weibos) != 1 || weibos[0].Content != "天气" {
+		t.Fatal("按内容搜索微博的第一页不对", weibos)
+	}
+	weibos, err = repos.Weibos.GetWeibosByAccountOrContent(ctx, "天气", weibo.Page{Cursor: &weibo.Cursor{CreatedAt: weibos[0].CreatedAt, ID: weibos[0].ID}, Limit: 10})
+	must(t, err)
+	if len(weibos) != 1 || weibos[0].ID != w1.ID {
+		t.Fatal("按内容搜索微博的第二页不对", weibos)
+	}
+	weibos, err = repos.Weibos.GetWeibosByAccountOrContent(ctx, "fine", weibo.Page{Limit: 10})
+	must(t, err)
+	if len(weibos) != 1 || weibos[0].ID != w2.ID {
+		t.Fatal("按英文内容搜索微博不对", weibos)
+	}
+	for _, key := range []string{"_", "%"} {
+		weibos, err = repos.Weibos.GetWeibosByAccountOrContent(ctx, key, weibo.Page{Limit: 10})
+		must(t, err)
+		if len(weibos) != 0 {
+			t.Fatal("搜索中的通配符应该按普通字符匹配", key, weibos)
+		}
+	}
+	w3 := insertWeibo(t, repos, alan, "进度100%_完成", 400)
+	for _, key := range []string{"%", "0%_"} {
+		weibos, err = repos.Weibos.GetWeibosByAccountOrContent(ctx, key, weibo.Page{Limit: 10})
+		must(t, err)
+		if len(weibos) != 1 || weibos[0].ID != w3.ID {
+			t.Fatal("搜索中的通配符应该只匹配它本身", key, weibos)
+		}
+	}
+}
+
+func testTimeLines(t *testing.T, repos *weibo.Repositories, uow weibo.UnitOfWork) {
+	ctx := context.Background()
+	const (
+		owner  = int64(1)
+		author = int64(2)
+		other  = int64(3)
+	)
+
+	must(t, repos.TimeLines.CreateTimeLine(ctx, withID(t, &weibo.TimeLine{UserID: owner, WeiboUserID: owner, WeiboID: 1, WeiboCreatedAt: 100})))
+	must(t, repos.TimeLines.BatchCreateTimeLines(ctx, withIDs(t,
+		&weibo.TimeLine{UserID: owner, WeiboUserID: author, WeiboID: 2, WeiboCreatedAt: 200},
+		&weibo.TimeLine{UserID: owner, WeiboUserID: author, WeiboID: 3, WeiboCreatedAt: 200},
+		&weibo.TimeLine{UserID: other, WeiboUserID: author, WeiboID: 3, WeiboCreatedAt: 200},
+	)))
+	// 重复写入会被忽略, 扩散任务重试时依赖这一点
+	must(t, repos.TimeLines.BatchCreateTimeLines(ctx, withIDs(t,
+		&weibo.TimeLine{UserID: owner, WeiboUserID: author, WeiboID: 3, WeiboCreatedAt: 200},
+		&weibo.TimeLine{UserID: owner, WeiboUserID: owner, WeiboID: 4, WeiboCreatedAt: 300},
+	)))
+	must(t, repos.TimeLines.BatchCreateTimeLines(ctx, nil))
+
+	timelines, err := repos.TimeLines.GetTimeLinesByUserID(ctx, owner, weibo.Page{Limit: 3})
+	must(t, err)
+	if ids := weiboIDs(timelines); len(ids) != 3 || ids[0] != 4 || ids[1] != 3 || ids[2] != 2 {
+		t.Fatal("timeline的第一页不对", ids)
+	}
+	if timelines[0].UserID != owner || timelines[0].WeiboCreatedAt != 300 {
+		t.Fatal("timeline的字段不对", timelines[0])
+	}
+
+	// 游标落在两条发布时间相同的微博之间
+	timelines, err = repos.TimeLines.GetTimeLinesByUserID(ctx, owner, weibo.Page{Cursor: &weibo.Cursor{CreatedAt: 200, ID: 3}, Limit: 3})
+	must(t, err)
+	if ids := weiboIDs(timelines); len(ids) != 2 || ids[0] != 2 || ids[1] != 1 {
+		t.Fatal("timeline按游标翻页不对", ids)
+	}
+	timelines, err = repos.TimeLines.GetTimeLinesByUserID(ctx, owner, weibo.Page{Offset: 3, Limit: 3})
+	must(t, err)
+	if ids := weiboIDs(timelines); len(ids) != 1 || ids[0] != 1 {
+		t.Fatal("timeline按offset翻页不对", ids)
+	}
+
+	recent, err := repos.TimeLines.GetRecentlyWeiboIDsByUserID(ctx, owner, 10)
+	must(t, err)
+	if ids := weiboIDs(recent); len(ids) != 2 || ids[0] != 4 || ids[1] != 1 {
+		t.Fatal("最近发布的微博只包括自己发的", ids)
+	}
+	recent, err = repos.TimeLines.GetRecentlyWeiboIDsByUserID(ctx, owner, 1)
+	must(t, err)
+	if ids := weiboIDs(recent); len(ids) != 1 || ids[0] != 4 {
+		t.Fatal("最近发布的微博数量限制不对", ids)
+	}
+
+	must(t, repos.TimeLines.DeleteWeiboByUserIDAndWeiboID(ctx, owner, 4))
+	must(t, repos.TimeLines.DeleteWeiboByUserIDAndWeiboUserID(ctx, owner, author))
+	timelines, err = repos.TimeLines.GetTimeLinesByUserID(ctx, owner, weibo.Page{Limit: 10})
+	must(t, err)
+	if ids := weiboIDs(timelines); len(ids) != 1 || ids[0] != 1 {
+		t.Fatal("删除后的timeline不对", ids)
+	}
+
+	// 分批从所有人的timeline中删除一条微博
+	must(t, repos.TimeLines.BatchCreateTimeLines(ctx, withIDs(t,
+		&weibo.TimeLine{UserID: owner, WeiboUserID: author, WeiboID: 5, WeiboCreatedAt: 500},
+		&weibo.TimeLine{UserID: other, WeiboUserID: author, WeiboID: 5, WeiboCreatedAt: 500},
+		&weibo.TimeLine{UserID: author, WeiboUserID: author, WeiboID: 5, WeiboCreatedAt: 500},
+	)))
+	n, err := repos.TimeLines.DeleteTimeLinesByWeiboID(ctx, 5, 2)
+	must(t, err)
+	if n != 2 {
+		t.Fatal("第一批删除的行数不对", n)
+	}
+	n, err = repos.TimeLines.DeleteTimeLinesByWeiboID(ctx, 5, 2)
+	must(t, err)
+	if n != 1 {
+		t.Fatal("第二批删除的行数不对", n)
+	}
+	for _, userID := range []int64{owner, other, author} {
+		timelines, err := repos.TimeLines.GetTimeLinesByUserID(ctx, userID, weibo.Page{Limit: 10})
+		must(t, err)
+		for _, timeline := range timelines {
+			if timeline.WeiboID == 5 {
+				t.Fatal("微博没有从所有人的timeline中删除", userID)
+			}
+		}
+	}
+}
+
+func testUnitOfWork(t *testing.T, repos *weibo.Repositories, uow weibo.UnitOfWork) {
+	ctx := context.Background()
+	alice := createUser(t, repos, "alice", 1)
+
+	errRollback := errors.New("rollback")
+	err := uow.Do(ctx, func(tx *weibo.Repositories) error {
+		must(t, tx.Users.AddWeiboNumByUserID(ctx, alice.ID, 1))
+		if err := tx.Weibos.InsertWeibo(ctx, &weibo.Weibo{ID: nextID(t), UserID: alice.ID, Account: "alice", Content: "rollback", CreatedAt: 100}); err != nil {
+			return err
+		}
+		must(t, tx.TimeLines.CreateTimeLine(ctx, withID(t, &weibo.TimeLine{UserID: alice.ID, WeiboUserID: alice.ID, WeiboID: 1000, WeiboCreatedAt: 100})))
+
+		// 事务中能读到自己的写入
+		user, err := tx.Users.GetUserByID(ctx, alice.ID)
+		must(t, err)
+		if user.WeiboNum != 1 {
+			t.Fatal("事务中应该能读到自己的写入", user.WeiboNum)
+		}
+		return errRollback
+	})
+	if err != errRollback {
+		t.Fatal("应该返回fn的错误", err)
+	}
+
+	user, err := repos.Users.GetUserByID(ctx, alice.ID)
+	must(t, err)
+	if user.WeiboNum != 0 {
+		t.Fatal("回滚后的修改不应该生效", user.WeiboNum)
+	}
+	weibos, err := repos.Weibos.GetWeibosByAccountOrContent(ctx, "alice", weibo.Page{Limit: 10})
+	must(t, err)
+	if len(weibos) != 0 {
+		t.Fatal("回滚后的微博不应该存在", weibos)
+	}
+	timelines, err := repos.TimeLines.GetTimeLinesByUserID(ctx, alice.ID, weibo.Page{Limit: 10})
+	must(t, err)
+	if len(timelines) != 0 {
+		t.Fatal("回滚后的timeline不应该存在", timelines)
+	}
+
+	must(t, uow.Do(ctx, func(tx *weibo.Repositories) error {
+		if err := tx.Users.AddWeiboNumByUserID(ctx, alice.ID, 1); err != nil {
+			return err
+		}
+		return tx.TimeLines.CreateTimeLine(ctx, withID(t, &weibo.TimeLine{UserID: alice.ID, WeiboUserID: alice.ID, WeiboID: 1000, WeiboCreatedAt: 100}))
+	}))
+
+	user, err = repos.Users.GetUserByID(ctx, alice.ID)
+	must(t, err)
+	if user.WeiboNum != 1 {
+		t.Fatal("提交后的修改应该生效", user.WeiboNum)
+	}
+	timelines, err = repos.TimeLines.GetTimeLinesByUserID(ctx, alice.ID, weibo.Page{Limit: 10})
+	must(t, err)
+	if ids := weiboIDs(timelines); len(ids) != 1 || ids[0] != 1000 {
+		t.Fatal("提交后的timeline应该存在", ids)
+	}
+
+	// ctx已经结束时事务不会提交
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	err = uow.Do(canceled, func(tx *weibo.Repositories) error {
+		return tx.Users.AddWeiboNumByUserID(canceled, alice.ID, 1)
+	})
+	if errors.Cause(err) != context.Canceled {
+		t.Fatal("ctx取消后应该返回context.Canceled", err)
+	}
+	user, err = repos.Users.GetUserByID(ctx, alice.ID)
+	must(t, err)
+	if user.WeiboNum != 1 {
+		t.Fatal("ctx取消后的修改不应该生效", user.WeiboNum)
+	}
+}
+
+// 先读一次让实现缓存结果, 修改后马上再读必须看到修改
+func testReadYourWrites(t *testing.T, repos *weibo.Repositories, uow weibo.UnitOfWork) {
+	ctx := context.Background()
+	star := createUser(t, repos, "star", 1)
+	fan1 := createUser(t, repos, "fan1", 2)
+	fan2 := createUser(t, repos, "fan2", 3)
+	follow(t, repos, fan1, star, 100)
+
+	followerIDs := func(limit int64) []int64 {
+		t.Helper()
+		followers, err := repos.Users.GetUserFollowers2(ctx, star.ID, weibo.Page{Limit: limit})
+		must(t, err)
+		ids := []int64{}
+		for _, follower := range followers {
+			ids = append(ids, follower.ID)
+		}
+		return ids
+	}
+	if ids := followerIDs(10); len(ids) != 1 || ids[0] != fan1.ID {
+		t.Fatal("粉丝列表不对", ids)
+	}
+	follow(t, repos, fan2, star, 200)
+	if ids := followerIDs(10); len(ids) != 2 || ids[0] != fan2.ID {
+		t.Fatal("关注后粉丝列表没有更新", ids)
+	}
+	// 不同的limit不能读到其它limit的结果
+	if ids := followerIDs(1); len(ids) != 1 || ids[0] != fan2.ID {
+		t.Fatal("limit不同的第一页不对", ids)
+	}
+	must(t, uow.Do(ctx, func(repos *weibo.Repositories) error {
+		return repos.Users.DeleteFollowing(ctx, &weibo.Following{FromUserID: fan2.ID, ToUserID: star.ID})
+	}))
+	if ids := followerIDs(10); len(ids) != 1 || ids[0] != fan1.ID {
+		t.Fatal("在事务中取消关注后粉丝列表没有更新", ids)
+	}
+
+	w := insertWeibo(t, repos, star, "hello", 100)
+	getWeibo := func() *weibo.Weibo {
+		t.Helper()
+		w, err := repos.Weibos.GetWeiboByID(ctx, w.ID)
+		must(t, err)
+		return w
+	}
+	if got := getWeibo(); got == nil || got.LikeNum != 0 {
+		t.Fatal("查询的微博不对", got)
+	}
+	must(t, repos.Weibos.AddLikeNumByWeiboID(ctx, w.ID, 1))
+	must(t, uow.Do(ctx, func(repos *weibo.Repositories) error {
+		return repos.Weibos.AddCommentNumByWeiboID(ctx, w.ID, 1)
+	}))
+	if got := getWeibo(); got == nil || got.LikeNum != 1 || got.CommentNum != 1 {
+		t.Fatal("点赞和评论后微博没有更新", got)
+	}
+	must(t, uow.Do(ctx, func(repos *weibo.Repositories) error {
+		return repos.Weibos.DeleteWeibo(ctx, w)
+	}))
+	if got := getWeibo(); got != nil {
+		t.Fatal("删除后仍然可以查到微博", got)
+	}
+
+	// timeline的每一页都要看到新发布和删除的微博
+	timelineIDs := func(page weibo.Page) []int64 {
+		t.Helper()
+		timelines, err := repos.TimeLines.GetTimeLinesByUserID(ctx, fan1.ID, page)
+		must(t, err)
+		return weiboIDs(timelines)
+	}
+	must(t, repos.TimeLines.BatchCreateTimeLines(ctx, withIDs(t,
+		&weibo.TimeLine{UserID: fan1.ID, WeiboUserID: star.ID, WeiboID: 1, WeiboCreatedAt: 100},
+		&weibo.TimeLine{UserID: fan1.ID, WeiboUserID: star.ID, WeiboID: 2, WeiboCreatedAt: 200},
+		&weibo.TimeLine{UserID: fan1.ID, WeiboUserID: star.ID, WeiboID: 3, WeiboCreatedAt: 300},
+	)))
+	if ids := timelineIDs(weibo.Page{Limit: 2}); len(ids) != 2 || ids[0] != 3 {
+		t.Fatal("timeline的第一页不对", ids)
+	}
+	if ids := timelineIDs(weibo.Page{Offset: 2, Limit: 2}); len(ids) != 1 || ids[0] != 1 {
+		t.Fatal("timeline的第二页不对", ids)
+	}
+	must(t, uow.Do(ctx, func(repos *weibo.Repositories) error {
+		return repos.TimeLines.CreateTimeLine(ctx, withID(t, &weibo.TimeLine{UserID: fan1.ID, WeiboUserID: fan1.ID, WeiboID: 4, WeiboCreatedAt: 400}))
+	}))
+	if ids := timelineIDs(weibo.Page{Limit: 2}); len(ids) != 2 || ids[0] != 4 || ids[1] != 3 {
+		t.Fatal("发布后timeline的第一页没有更新", ids)
+	}
+	if ids := timelineIDs(weibo.Page{Offset: 2, Limit: 2}); len(ids) != 2 || ids[0] != 2 || ids[1] != 1 {
+		t.Fatal("发布后timeline的第二页没有更新", ids)
+	}
+	must(t, repos.TimeLines.DeleteWeiboByUserIDAndWeiboID(ctx, fan1.ID, 4))
+	must(t, repos.TimeLines.DeleteWeiboByUserIDAndWeiboUserID(ctx, fan1.ID, star.ID))
+	if ids := timelineIDs(weibo.Page{Limit: 2}); len(ids) != 0 {
+		t.Fatal("删除和取消关注后timeline没有更新", ids)
+	}
+}
+
+func weiboIDs(timelines []*weibo.TimeLine) []int64 {
+	ids := make([]int64, 0, len(timelines))
+	for _, timeline := range timelines {
+		ids = append(ids, timeline.WeiboID)
+	}
+	return ids
+}
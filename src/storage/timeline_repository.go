@@ -1,13 +1,15 @@
 package storage
 
 import (
+	"context"
+	"strings"
 	"weibo"
 
 	"github.com/jmoiron/sqlx"
 )
 
 type TimeLineRepository struct {
-	db *sqlx.DB
+	db dbtx
 }
 
 func NewTimeLineRepository(db *sqlx.DB) *TimeLineRepository {
@@ -15,42 +17,67 @@ func NewTimeLineRepository(db *sqlx.DB) *TimeLineRepository {
 }
 
 // 查询某个用户最近七天的微博id
-func (tl *TimeLineRepository) GetRecentlyWeiboIDsByUserID(userID int64, limit int32) ([]*weibo.TimeLine, error) {
+func (tl *TimeLineRepository) GetRecentlyWeiboIDsByUserID(ctx context.Context, userID int64, limit int32) ([]*weibo.TimeLine, error) {
 	timeLines := []*weibo.TimeLine{}
-	if err := tl.db.Select(&timeLines, "SELECT * FROM `timeline` WHERE `user_id` = ? ORDER BY created_id DESC LIMIT ?", userID, limit); err != nil {
+	if err := tl.db.SelectContext(ctx, &timeLines, "SELECT * FROM `timeline` WHERE `user_id` = ? AND `weibo_user_id` = ? ORDER BY weibo_created_at DESC LIMIT ?", userID, userID, limit); err != nil {
 		return nil, err
 	}
 	return timeLines, nil
 }
 
-// 批量把一批微博id插入到某个用户的timeline中
-func (tl *TimeLineRepository) BatchCreateTimeLines(timelines []*weibo.TimeLine) error {
+// 查询某个用户的timeline, 按微博发布时间倒序
+func (tl *TimeLineRepository) GetTimeLinesByUserID(ctx context.Context, userID int64, page weibo.Page) ([]*weibo.TimeLine, error) {
+	query, args := pageQuery("SELECT * FROM `timeline` WHERE user_id = ? AND %s", []interface{}{userID}, page, "weibo_created_at", "weibo_id")
+	timelines := []*weibo.TimeLine{}
+	if err := tl.db.SelectContext(ctx, &timelines, query, args...); err != nil {
+		return nil, err
+	}
+	return timelines, nil
+}
+
+// 批量把一批微博id插入到timeline中, 一条语句写入所有行, 已经存在的行会被忽略
+func (tl *TimeLineRepository) BatchCreateTimeLines(ctx context.Context, timelines []*weibo.TimeLine) error {
+	if len(timelines) == 0 {
+		return nil
+	}
+
+	placeholders := make([]string, 0, len(timelines))
+	args := make([]interface{}, 0, len(timelines)*5)
 	for _, timeline := range timelines {
-		_, err := tl.db.NamedExec("INSERT INTO `timeline`(id, user_id, weibo_user_id, weibo_id, weibo_created_at) VALUES(:id, :user_id, :weibo_user_id, :weibo_id, :weibo_created_at)", timeline)
-		if err != nil {
-			return err
-		}
+		placeholders = append(placeholders, "(?, ?, ?, ?, ?)")
+		args = append(args, timeline.ID, timeline.UserID, timeline.WeiboUserID, timeline.WeiboID, timeline.WeiboCreatedAt)
 	}
-	return nil
+
+	_, err := tl.db.ExecContext(ctx, "INSERT IGNORE INTO `timeline`(id, user_id, weibo_user_id, weibo_id, weibo_created_at) VALUES "+strings.Join(placeholders, ", "), args...)
+	return err
 }
 
 // 删除某个用户的timeline中某人发的微博
-func (tl *TimeLineRepository) DeleteWeiboByUserIDAndWeiboUserID(userID int64, weiboUserID int64) error {
-	_, err := tl.db.Exec("DELETE FROM `timeline` WHERE user_id = ? AND weibo_user_id = ?", userID, weiboUserID)
+func (tl *TimeLineRepository) DeleteWeiboByUserIDAndWeiboUserID(ctx context.Context, userID int64, weiboUserID int64) error {
+	_, err := tl.db.ExecContext(ctx, "DELETE FROM `timeline` WHERE user_id = ? AND weibo_user_id = ?", userID, weiboUserID)
 	return err
 }
 
 // 删除某个用户的timeline中的某条微博
-func (tl *TimeLineRepository) DeleteWeiboByUserIDAndWeiboID(userID int64, weiboID int64) error {
-	_, err := tl.db.Exec("DELETE FROM `timeline` WHERE user_id = ? AND weibo_id = ?", userID, weiboID)
+func (tl *TimeLineRepository) DeleteWeiboByUserIDAndWeiboID(ctx context.Context, userID int64, weiboID int64) error {
+	_, err := tl.db.ExecContext(ctx, "DELETE FROM `timeline` WHERE user_id = ? AND weibo_id = ?", userID, weiboID)
 	return err
 }
 
 // 插入新的timeline
-func (tl *TimeLineRepository) CreateTimeLine(timeline *weibo.TimeLine) error {
-	_, err := tl.db.NamedExec("INSERT INTO `timeline`(user_id, weibo_user_id, weibo_id, weibo_created_at) VALUES(:user_id, :weibo_user_id, :weibo_id, :weibo_created_at)", timeline)
+func (tl *TimeLineRepository) CreateTimeLine(ctx context.Context, timeline *weibo.TimeLine) error {
+	_, err := tl.db.NamedExecContext(ctx, "INSERT INTO `timeline`(id, user_id, weibo_user_id, weibo_id, weibo_created_at) VALUES(:id, :user_id, :weibo_user_id, :weibo_id, :weibo_created_at)", timeline)
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+// 从所有用户的timeline中删除某条微博
+func (tl *TimeLineRepository) DeleteTimeLinesByWeiboID(ctx context.Context, weiboID int64, limit int64) (int64, error) {
+	result, err := tl.db.ExecContext(ctx, "DELETE FROM `timeline` WHERE weibo_id = ? LIMIT ?", weiboID, limit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"weibo"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var _ weibo.TopicRepository = new(TopicRepository)
+
+// 话题仓库
+type TopicRepository struct {
+	db dbtx
+}
+
+func NewTopicRepository(db *sqlx.DB) *TopicRepository {
+	return &TopicRepository{db: db}
+}
+
+// 保存话题, 依赖name上的唯一索引忽略已经存在的话题
+func (tr *TopicRepository) CreateTopics(ctx context.Context, names []string, createdAt int64) ([]*weibo.Topic, error) {
+	topics := []*weibo.Topic{}
+	if len(names) == 0 {
+		return topics, nil
+	}
+
+	placeholders := make([]string, 0, len(names))
+	args := make([]interface{}, 0, len(names)*2)
+	for _, name := range names {
+		placeholders = append(placeholders, "(?, ?)")
+		args = append(args, name, createdAt)
+	}
+	if _, err := tr.db.ExecContext(ctx, "INSERT IGNORE INTO `topics`(name, created_at) VALUES "+strings.Join(placeholders, ", "), args...); err != nil {
+		return nil, err
+	}
+
+	query, args, err := sqlx.In("SELECT * FROM `topics` WHERE name IN (?)", names)
+	if err != nil {
+		return nil, err
+	}
+	if err := tr.db.SelectContext(ctx, &topics, query, args...); err != nil {
+		return nil, err
+	}
+	return topics, nil
+}
+
+func (tr *TopicRepository) GetTopicByName(ctx context.Context, name string) (*weibo.Topic, error) {
+	var topic weibo.Topic
+	if err := tr.db.GetContext(ctx, &topic, "SELECT * FROM `topics` WHERE `name` = ?", name); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &topic, nil
+}
+
+// 根据id批量查询话题
+func (tr *TopicRepository) GetTopicsByIDs(ctx context.Context, topicIDs []int64) ([]*weibo.Topic, error) {
+	topics := []*weibo.Topic{}
+	if len(topicIDs) == 0 {
+		return topics, nil
+	}
+
+	query, args, err := sqlx.In("SELECT * FROM `topics` WHERE id IN (?)", topicIDs)
+	if err != nil {
+		return nil, err
+	}
+	if err := tr.db.SelectContext(ctx, &topics, query, args...); err != nil {
+		return nil, err
+	}
+	return topics, nil
+}
+
+// 增加话题下的微博数
+func (tr *TopicRepository) AddWeiboNumByTopicIDs(ctx context.Context, topicIDs []int64, num int32) error {
+	if len(topicIDs) == 0 {
+		return nil
+	}
+	query, args, err := sqlx.In("UPDATE `topics` SET weibo_num = weibo_num + ? WHERE id IN (?)", num, topicIDs)
+	if err != nil {
+		return err
+	}
+	_, err = tr.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// 保存微博和话题的关联
+func (tr *TopicRepository) CreateWeiboTopics(ctx context.Context, weiboTopics []*weibo.WeiboTopic) error {
+	if len(weiboTopics) == 0 {
+		return nil
+	}
+
+	placeholders := make([]string, 0, len(weiboTopics))
+	args := make([]interface{}, 0, len(weiboTopics)*3)
+	for _, wt := range weiboTopics {
+		placeholders = append(placeholders, "(?, ?, ?)")
+		args = append(args, wt.TopicID, wt.WeiboID, wt.WeiboCreatedAt)
+	}
+	_, err := tr.db.ExecContext(ctx, "INSERT INTO `weibo_topics`(topic_id, weibo_id, weibo_created_at) VALUES "+strings.Join(placeholders, ", "), args...)
+	return err
+}
+
+// 查询微博关联的话题
+func (tr *TopicRepository) GetTopicsByWeiboID(ctx context.Context, weiboID int64) ([]*weibo.Topic, error) {
+	topics := []*weibo.Topic{}
+	if err := tr.db.SelectContext(ctx, &topics, "SELECT t.* FROM topics t INNER JOIN weibo_topics wt ON wt.topic_id = t.id WHERE wt.weibo_id = ?", weiboID); err != nil {
+		return nil, err
+	}
+	return topics, nil
+}
+
+// 删除微博和话题的关联
+func (tr *TopicRepository) DeleteWeiboTopicsByWeiboID(ctx context.Context, weiboID int64) error {
+	_, err := tr.db.ExecContext(ctx, "DELETE FROM `weibo_topics` WHERE weibo_id = ?", weiboID)
+	return err
+}
+
+// 分页查询话题下的微博
+func (tr *TopicRepository) GetWeibosByTopicID(ctx context.Context, topicID int64, page weibo.Page) ([]*weibo.WeiboWithUser, error) {
+	query, args := pageQuery(`
+		SELECT w.*, u.avatar FROM weibo_topics wt
+		INNER JOIN weibos w ON wt.weibo_id = w.id
+		INNER JOIN users u ON w.user_id = u.id
+		WHERE wt.topic_id = ? AND %s`, []interface{}{topicID}, page, "wt.weibo_created_at", "wt.weibo_id")
+
+	weibos := []*weibo.WeiboWithUser{}
+	if err := tr.db.SelectContext(ctx, &weibos, query, args...); err != nil {
+		return nil, err
+	}
+	return weibos, nil
+}
+
+// 微博数最多的话题
+func (tr *TopicRepository) GetTopTopics(ctx context.Context, limit int64) ([]*weibo.Topic, error) {
+	topics := []*weibo.Topic{}
+	if err := tr.db.SelectContext(ctx, &topics, "SELECT * FROM `topics` WHERE weibo_num > 0 ORDER BY weibo_num DESC, id DESC LIMIT ?", limit); err != nil {
+		return nil, err
+	}
+	return topics, nil
+}
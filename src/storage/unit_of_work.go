@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"cache"
+	"context"
+	"database/sql"
+	"log"
+	"time"
+	"weibo"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/gomodule/redigo/redis"
+	"github.com/jmoiron/sqlx"
+)
+
+var _ weibo.UnitOfWork = new(UnitOfWork)
+
+// *sqlx.DB 和 *sqlx.Tx 共有的方法, 仓库通过它访问数据库
+type dbtx interface {
+	GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	NamedExecContext(ctx context.Context, query string, arg interface{}) (sql.Result, error)
+}
+
+// mysql的Duplicate entry错误码
+const errDupEntry = 1062
+
+// 违反唯一索引的错误转换为weibo.ErrDuplicate, 其它错误原样返回
+func duplicateError(err error) error {
+	if e, ok := err.(*mysql.MySQLError); ok && e.Number == errDupEntry {
+		return weibo.ErrDuplicate
+	}
+	return err
+}
+
+// 基于数据库事务的工作单元
+type UnitOfWork struct {
+	db        *sqlx.DB
+	cache     cache.Cache
+	redisPool *redis.Pool
+
+	// 事务中仓库使用的缓存设置, 需要和事务外的仓库一致
+	FollowersTTL     time.Duration
+	WeiboTTL         time.Duration
+	TimelineCapacity int64
+	TimelineTTL      time.Duration
+}
+
+func NewUnitOfWork(db *sqlx.DB, c cache.Cache, redisPool *redis.Pool) *UnitOfWork {
+	return &UnitOfWork{
+		db:               db,
+		cache:            c,
+		redisPool:        redisPool,
+		FollowersTTL:     defaultFollowersTTL,
+		WeiboTTL:         defaultWeiboTTL,
+		TimelineCapacity: defaultTimelineCapacity,
+		TimelineTTL:      defaultTimelineTTL,
+	}
+}
+
+// ctx结束时database/sql会回滚事务, Commit返回错误
+func (u *UnitOfWork) Do(ctx context.Context, fn func(repos *weibo.Repositories) error) (err error) {
+	tx, err := u.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	// 事务中的读取不经过缓存, 否则可能把未提交的数据写进缓存
+	invalidator := &cacheInvalidator{cache: u.cache, deferred: true}
+	timelines := newRedisTimeLineRepository(tx, u.redisPool, invalidator)
+	timelines.Capacity = u.TimelineCapacity
+	timelines.TTL = u.TimelineTTL
+	repos := &weibo.Repositories{
+		Users:         &UserRepository{db: tx, cache: invalidator, FollowersTTL: u.FollowersTTL},
+		Weibos:        &WeiboRepository{db: tx, cache: invalidator, WeiboTTL: u.WeiboTTL},
+		Comments:      &CommentRepository{db: tx},
+		Topics:        &TopicRepository{db: tx},
+		Mentions:      &MentionRepository{db: tx},
+		Notifications: &NotificationRepository{db: tx, cache: invalidator},
+		Messages:      &MessageRepository{db: tx},
+		Blocks:        &BlockRepository{db: tx},
+		Mutes:         &MuteRepository{db: tx, cache: invalidator},
+		TimeLines:     timelines,
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(repos); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			log.Printf("事务回滚失败: %v\n", rbErr)
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// 事务提交后再让缓存失效, 避免其它请求在提交前把旧数据重新写回缓存
+	invalidator.flush()
+	return nil
+}
+
+// 缓存的失效和更新, 在事务中时推迟到提交后执行
+// 失效不受请求的ctx影响, 请求取消时数据库已经修改了, 缓存也必须删除
+type cacheInvalidator struct {
+	cache    cache.Cache
+	deferred bool
+	keys     []string
+	ops      []func() error
+}
+
+func (c *cacheInvalidator) Del(keys ...string) error {
+	if c.deferred {
+		c.keys = append(c.keys, keys...)
+		return nil
+	}
+	return c.del(keys)
+}
+
+// 执行缓存的更新操作
+func (c *cacheInvalidator) After(op func() error) error {
+	if c.deferred {
+		c.ops = append(c.ops, op)
+		return nil
+	}
+	return op()
+}
+
+func (c *cacheInvalidator) flush() {
+	if len(c.keys) > 0 {
+		if err := c.del(c.keys); err != nil {
+			log.Printf("删除缓存 %v 失败: %v\n", c.keys, err)
+		}
+		c.keys = nil
+	}
+
+	for _, op := range c.ops {
+		if err := op(); err != nil {
+			log.Printf("更新缓存失败: %v\n", err)
+		}
+	}
+	c.ops = nil
+}
+
+func (c *cacheInvalidator) del(keys []string) error {
+	if c.cache == nil {
+		return nil
+	}
+	return c.cache.Del(context.Background(), keys...)
+}
@@ -1,12 +1,12 @@
 package storage
 
 import (
+	"cache"
+	"context"
 	"database/sql"
-	"encoding/json"
-	"fmt"
+	"time"
 	"weibo"
 
-	"github.com/gomodule/redigo/redis"
 	"github.com/jmoiron/sqlx"
 )
 
@@ -14,19 +14,35 @@ var _ weibo.UserRepository = new(UserRepository)
 
 // 用户仓库
 type UserRepository struct {
-	db          *sqlx.DB
-	redisClient redis.Conn
+	db     dbtx
+	loader *cache.Loader // 事务中为nil, 不读缓存
+	cache  *cacheInvalidator
+
+	// 粉丝列表第一页的缓存时间
+	FollowersTTL time.Duration
+	// 缓存的粉丝数, 第一页的条数超过它时直接查询数据库
+	FollowersCacheSize int64
 }
 
-// todo: 增加redis client
-func NewUserRepository(db *sqlx.DB, redisClient redis.Conn) *UserRepository {
-	return &UserRepository{db: db, redisClient: redisClient}
+const (
+	defaultFollowersTTL       = 300 * time.Second
+	defaultFollowersCacheSize = 100
+)
+
+func NewUserRepository(db *sqlx.DB, c cache.Cache) *UserRepository {
+	return &UserRepository{
+		db:                 db,
+		loader:             cache.NewLoader(c),
+		cache:              &cacheInvalidator{cache: c},
+		FollowersTTL:       defaultFollowersTTL,
+		FollowersCacheSize: defaultFollowersCacheSize,
+	}
 }
 
 // 通过账号名查找用户
-func (ur *UserRepository) GetUserByAccount(account string) (*weibo.User, error) {
+func (ur *UserRepository) GetUserByAccount(ctx context.Context, account string) (*weibo.User, error) {
 	var user weibo.User
-	if err := ur.db.Get(&user, "SELECT * FROM `users` WHERE `account` = ?", account); err != nil {
+	if err := ur.db.GetContext(ctx, &user, "SELECT * FROM `users` WHERE `account` = ?", account); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
@@ -36,8 +52,8 @@ func (ur *UserRepository) GetUserByAccount(account string) (*weibo.User, error)
 }
 
 // 创建用户
-func (ur *UserRepository) CreateUser(user *weibo.User) error {
-	result, err := ur.db.NamedExec("INSERT INTO `users`(account, avatar, password, salt, created_at) VALUES(:account, :avatar, :password, :salt, :created_at)", user)
+func (ur *UserRepository) CreateUser(ctx context.Context, user *weibo.User) error {
+	result, err := ur.db.NamedExecContext(ctx, "INSERT INTO `users`(account, avatar, password, salt, message_permission, created_at) VALUES(:account, :avatar, :password, :salt, :message_permission, :created_at)", user)
 	if err != nil {
 		return err
 	}
@@ -46,10 +62,22 @@ func (ur *UserRepository) CreateUser(user *weibo.User) error {
 	return err
 }
 
+// 更新用户的密码哈希
+func (ur *UserRepository) UpdatePassword(ctx context.Context, userID int64, password, salt string) error {
+	_, err := ur.db.ExecContext(ctx, "UPDATE `users` SET password = ?, salt = ? WHERE id = ?", password, salt, userID)
+	return err
+}
+
+// 更新谁可以给用户发私信
+func (ur *UserRepository) UpdateMessagePermission(ctx context.Context, userID int64, permission weibo.MessagePermission) error {
+	_, err := ur.db.ExecContext(ctx, "UPDATE `users` SET message_permission = ? WHERE id = ?", permission, userID)
+	return err
+}
+
 // 根据用户id查询相应用户信息
-func (ur *UserRepository) GetUserByID(userID int64) (*weibo.User, error) {
+func (ur *UserRepository) GetUserByID(ctx context.Context, userID int64) (*weibo.User, error) {
 	var user weibo.User
-	if err := ur.db.Get(&user, "SELECT * FROM `users` WHERE `id` = ?", userID); err != nil {
+	if err := ur.db.GetContext(ctx, &user, "SELECT * FROM `users` WHERE `id` = ?", userID); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
@@ -59,21 +87,21 @@ func (ur *UserRepository) GetUserByID(userID int64) (*weibo.User, error) {
 }
 
 // 增加用户所关注的人数
-func (ur *UserRepository) AddFollowingNumByUserID(userID int64, num int32) error {
-	_, err := ur.db.Exec("UPDATE `users` SET following_num = following_num + ? WHERE id = ?", num, userID)
+func (ur *UserRepository) AddFollowingNumByUserID(ctx context.Context, userID int64, num int32) error {
+	_, err := ur.db.ExecContext(ctx, "UPDATE `users` SET following_num = following_num + ? WHERE id = ?", num, userID)
 	return err
 }
 
 // 增加用户的粉丝数
-func (ur *UserRepository) AddFollowerNumByUserID(userID int64, num int32) error {
-	_, err := ur.db.Exec("UPDATE `users` SET follower_num = follower_num + ? WHERE id = ?", num, userID)
+func (ur *UserRepository) AddFollowerNumByUserID(ctx context.Context, userID int64, num int32) error {
+	_, err := ur.db.ExecContext(ctx, "UPDATE `users` SET follower_num = follower_num + ? WHERE id = ?", num, userID)
 	return err
 }
 
 // 查询某个用户关注另一个用户的记录
-func (ur *UserRepository) GetFollowing(fromUserID, toUserID int64) (*weibo.Following, error) {
+func (ur *UserRepository) GetFollowing(ctx context.Context, fromUserID, toUserID int64) (*weibo.Following, error) {
 	var following weibo.Following
-	if err := ur.db.Get(&following, "SELECT * FROM `following` WHERE `from_user_id` = ? AND `to_user_id` = ?", fromUserID, toUserID); err != nil {
+	if err := ur.db.GetContext(ctx, &following, "SELECT * FROM `following` WHERE `from_user_id` = ? AND `to_user_id` = ?", fromUserID, toUserID); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
@@ -83,91 +111,110 @@ func (ur *UserRepository) GetFollowing(fromUserID, toUserID int64) (*weibo.Follo
 }
 
 // 记录关注信息
-func (ur *UserRepository) CreateFollowing(following *weibo.Following) error {
-	_, err := ur.db.NamedExec("INSERT INTO `following`(from_user_id, to_user_id, created_at) VALUES(:from_user_id, :to_user_id, :created_at)", following)
+func (ur *UserRepository) CreateFollowing(ctx context.Context, following *weibo.Following) error {
+	_, err := ur.db.NamedExecContext(ctx, "INSERT INTO `following`(from_user_id, to_user_id, created_at) VALUES(:from_user_id, :to_user_id, :created_at)", following)
 	if err != nil {
 		return err
 	}
 
-	key := fmt.Sprintf("followers:%d", following.ToUserID)
-	_, err = ur.redisClient.Do("DEL", key)
-	if err != nil {
-		return err
-	}
-
-	return err
+	return ur.cache.Del(followersKey(following.ToUserID))
 }
 
 // 删除关注信息
-func (ur *UserRepository) DeleteFollowing(following *weibo.Following) error {
-	_, err := ur.db.Exec("DELETE FROM `following` WHERE from_user_id = ? AND to_user_id = ?", following.FromUserID, following.ToUserID)
-
-	key := fmt.Sprintf("followers:%d", following.ToUserID)
-	_, err = ur.redisClient.Do("DEL", key)
+func (ur *UserRepository) DeleteFollowing(ctx context.Context, following *weibo.Following) error {
+	_, err := ur.db.ExecContext(ctx, "DELETE FROM `following` WHERE from_user_id = ? AND to_user_id = ?", following.FromUserID, following.ToUserID)
 	if err != nil {
 		return err
 	}
-	return nil
+
+	return ur.cache.Del(followersKey(following.ToUserID))
 }
 
 // 增加用户所发布的微博数量
-func (ur *UserRepository) AddWeiboNumByUserID(userID int64, num int32) error {
-	_, err := ur.db.Exec("UPDATE `users` SET weibo_num = weibo_num + ? WHERE id = ?", num, userID)
+func (ur *UserRepository) AddWeiboNumByUserID(ctx context.Context, userID int64, num int32) error {
+	_, err := ur.db.ExecContext(ctx, "UPDATE `users` SET weibo_num = weibo_num + ? WHERE id = ?", num, userID)
 	return err
 }
 
 // 获取用户所粉丝
-func (ur *UserRepository) GetUserFollowers(userID int64) ([]*weibo.Following, error) {
+func (ur *UserRepository) GetUserFollowers(ctx context.Context, userID int64) ([]*weibo.Following, error) {
 	followings := []*weibo.Following{}
-	if err := ur.db.Select(&followings, "SELECT * FROM `following` WHERE to_user_id = ?", userID); err != nil {
+	if err := ur.db.SelectContext(ctx, &followings, "SELECT * FROM `following` WHERE to_user_id = ?", userID); err != nil {
 		return nil, err
 	}
 	return followings, nil
 }
 
-func (ur *UserRepository) GetUsersByAccount(account string, offset, perPage int64) ([]*weibo.User, error) {
+// 按id顺序分批获取粉丝的id
+func (ur *UserRepository) GetUserFollowerIDs(ctx context.Context, userID, afterID, limit int64) ([]int64, error) {
+	ids := []int64{}
+	if err := ur.db.SelectContext(ctx, &ids, "SELECT from_user_id FROM `following` WHERE to_user_id = ? AND from_user_id > ? ORDER BY from_user_id LIMIT ?", userID, afterID, limit); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// 按账号前缀搜索用户
+func (ur *UserRepository) GetUsersByAccount(ctx context.Context, account string, page weibo.Page) ([]*weibo.User, error) {
+	query, args := pageQuery("SELECT * FROM `users` WHERE `account` LIKE ? ESCAPE '\\\\' AND %s", []interface{}{escapeLike(account) + "%"}, page, "created_at", "id")
 	users := []*weibo.User{}
-	if err := ur.db.Select(&users, "SELECT * FROM `users` WHERE `account` = ?", account); err != nil {
+	if err := ur.db.SelectContext(ctx, &users, query, args...); err != nil {
 		return nil, err
 	}
 	return users, nil
 }
 
-func (ur *UserRepository) GetUserFollowers2(userID int64) ([]*weibo.Follower, error) {
-	followers := []*weibo.Follower{}
-	key := fmt.Sprintf("follower:%d", userID)
-
-	// 使用redis client, 先从redis中读数据， 如果没有则从mysql读并写入缓存
-	data, err := redis.Bytes(ur.redisClient.Do("GET", key))
-	if err == nil {
-		if err = json.Unmarshal(data, &followers); err != nil {
-			return nil, err
-		}
-		return followers, nil
+// 根据账号批量精确查询用户
+func (ur *UserRepository) GetUsersByAccounts(ctx context.Context, accounts []string) ([]*weibo.User, error) {
+	users := []*weibo.User{}
+	if len(accounts) == 0 {
+		return users, nil
 	}
 
-	if err != redis.ErrNil {
+	query, args, err := sqlx.In("SELECT * FROM `users` WHERE `account` IN (?)", accounts)
+	if err != nil {
 		return nil, err
 	}
-
-	query := `
-		SELECT u.id, u.account, u.avatar FROM users u
-		INNER JOIN following f ON f.from_user_id = u.id
-		WHERE f.to_user_id = ?
-	`
-	if err := ur.db.Select(&followers, query, userID); err != nil {
+	if err := ur.db.SelectContext(ctx, &users, query, args...); err != nil {
 		return nil, err
 	}
+	return users, nil
+}
 
-	data, err = json.Marshal(followers)
-	if err != nil {
-		return nil, err
+// 分页获取粉丝的信息, 只缓存第一页
+func (ur *UserRepository) GetUserFollowers2(ctx context.Context, userID int64, page weibo.Page) ([]*weibo.Follower, error) {
+	if page.Cursor != nil || page.Offset > 0 {
+		return ur.getUserFollowers(ctx, userID, page)
 	}
 
-	_, err = ur.redisClient.Do("SETEX", key, 300, data)
+	if ur.loader == nil || page.Limit > ur.FollowersCacheSize {
+		return ur.getUserFollowers(ctx, userID, page)
+	}
+
+	// 先从缓存中读, 没有时从mysql读并写入缓存, 同时只有一个请求查询mysql
+	// 缓存固定的条数, 再按limit截取, 这样所有limit的第一页只需要删除一个key
+	followers := []*weibo.Follower{}
+	err := ur.loader.Load(ctx, followersKey(userID), ur.FollowersTTL, &followers, func(ctx context.Context) (interface{}, error) {
+		return ur.getUserFollowers(ctx, userID, weibo.Page{Limit: ur.FollowersCacheSize})
+	})
 	if err != nil {
 		return nil, err
 	}
+	if int64(len(followers)) > page.Limit {
+		followers = followers[:page.Limit]
+	}
+	return followers, nil
+}
 
+func (ur *UserRepository) getUserFollowers(ctx context.Context, userID int64, page weibo.Page) ([]*weibo.Follower, error) {
+	query, args := pageQuery(`
+		SELECT u.id, u.account, u.avatar, f.created_at AS followed_at FROM users u
+		INNER JOIN following f ON f.from_user_id = u.id
+		WHERE f.to_user_id = ? AND %s`, []interface{}{userID}, page, "f.created_at", "f.from_user_id")
+
+	followers := []*weibo.Follower{}
+	if err := ur.db.SelectContext(ctx, &followers, query, args...); err != nil {
+		return nil, err
+	}
 	return followers, nil
 }
@@ -1,12 +1,12 @@
 package storage
 
 import (
+	"cache"
+	"context"
 	"database/sql"
-	"encoding/json"
-	"fmt"
+	"time"
 	"weibo"
 
-	"github.com/gomodule/redigo/redis"
 	"github.com/jmoiron/sqlx"
 )
 
@@ -14,18 +14,45 @@ var _ weibo.WeiboRepository = new(WeiboRepository)
 
 // 仓库
 type WeiboRepository struct {
-	db          *sqlx.DB
-	redisClient redis.Conn
+	db     dbtx
+	loader *cache.Loader // 事务中为nil, 不读缓存
+	cache  *cacheInvalidator
+
+	// 单条微博的缓存时间
+	WeiboTTL time.Duration
 }
 
-func NewWeiboRepository(db *sqlx.DB, redisClient redis.Conn) *WeiboRepository {
-	return &WeiboRepository{db: db, redisClient: redisClient}
+const defaultWeiboTTL = 60 * time.Second
+
+func NewWeiboRepository(db *sqlx.DB, c cache.Cache) *WeiboRepository {
+	return &WeiboRepository{
+		db:       db,
+		loader:   cache.NewLoader(c),
+		cache:    &cacheInvalidator{cache: c},
+		WeiboTTL: defaultWeiboTTL,
+	}
 }
 
 //根据id查找微博
-func (wb *WeiboRepository) GetWeiboByID(weiboID int64) (*weibo.Weibo, error) {
+func (wb *WeiboRepository) GetWeiboByID(ctx context.Context, weiboID int64) (*weibo.Weibo, error) {
+	if wb.loader == nil {
+		return wb.getWeiboByID(ctx, weiboID)
+	}
+
+	// 不存在的微博也会缓存, 插入时删除对应的缓存
+	var w *weibo.Weibo
+	err := wb.loader.Load(ctx, weiboKey(weiboID), wb.WeiboTTL, &w, func(ctx context.Context) (interface{}, error) {
+		return wb.getWeiboByID(ctx, weiboID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (wb *WeiboRepository) getWeiboByID(ctx context.Context, weiboID int64) (*weibo.Weibo, error) {
 	var weibo weibo.Weibo
-	if err := wb.db.Get(&weibo, "SELECT * FROM `weibos` WHERE `id` = ?", weiboID); err != nil {
+	if err := wb.db.GetContext(ctx, &weibo, "SELECT * FROM `weibos` WHERE `id` = ?", weiboID); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
@@ -34,33 +61,48 @@ func (wb *WeiboRepository) GetWeiboByID(weiboID int64) (*weibo.Weibo, error) {
 	return &weibo, nil
 }
 
-//
-func (wb *WeiboRepository) InsertWeibo(weibo *weibo.Weibo) (int64, error) {
-	_, err := wb.db.NamedExec("INSERT INTO `weibos`(id, user_id) VALUES(:id, :user_id)", weibo)
-	return weibo.ID, err
+// 保存微博, id由调用方生成
+func (wb *WeiboRepository) InsertWeibo(ctx context.Context, weibo *weibo.Weibo) error {
+	_, err := wb.db.NamedExecContext(ctx, "INSERT INTO `weibos`(id, user_id, account, content, like_num, comment_num, repost_num, repost_of_id, repost_root_id, pulled, created_at) VALUES(:id, :user_id, :account, :content, :like_num, :comment_num, :repost_num, :repost_of_id, :repost_root_id, :pulled, :created_at)", weibo)
+	if err != nil {
+		return err
+	}
+	return wb.cache.Del(weiboKey(weibo.ID))
 }
 
 // 删除微博
-func (wb *WeiboRepository) DeleteWeibo(weibo *weibo.Weibo) error {
-	_, err := wb.db.Exec("DELETE FROM `weibos` WHERE id = ?", weibo.ID)
-	return err
+func (wb *WeiboRepository) DeleteWeibo(ctx context.Context, weibo *weibo.Weibo) error {
+	if _, err := wb.db.ExecContext(ctx, "DELETE FROM `weibos` WHERE id = ?", weibo.ID); err != nil {
+		return err
+	}
+	return wb.cache.Del(weiboKey(weibo.ID))
 }
 
 // 保存点赞记录
-func (wb *WeiboRepository) CreateGivelike(givelike *weibo.Givelike) error {
-	_, err := wb.db.NamedExec("INSERT INTO `givelike`(user_id, weibo_id) VALUES(:user_id, :weibo_id)", givelike)
-	return err
+func (wb *WeiboRepository) CreateGivelike(ctx context.Context, givelike *weibo.Givelike) error {
+	_, err := wb.db.NamedExecContext(ctx, "INSERT INTO `givelike`(user_id, weibo_id, created_at) VALUES(:user_id, :weibo_id, :created_at)", givelike)
+	return duplicateError(err)
 }
 
 // 增加点赞数
-func (wb *WeiboRepository) AddLikeNumByWeiboID(weiboID int64, num int32) error {
-	_, err := wb.db.Exec("UPDATE `weibos` SET like_num = like_num + ? WHERE id = ?", num, weiboID)
-	return err
+func (wb *WeiboRepository) AddLikeNumByWeiboID(ctx context.Context, weiboID int64, num int32) error {
+	if _, err := wb.db.ExecContext(ctx, "UPDATE `weibos` SET like_num = like_num + ? WHERE id = ?", num, weiboID); err != nil {
+		return err
+	}
+	return wb.cache.Del(weiboKey(weiboID))
+}
+
+// 增加转发数
+func (wb *WeiboRepository) AddRepostNumByWeiboID(ctx context.Context, weiboID int64, num int32) error {
+	if _, err := wb.db.ExecContext(ctx, "UPDATE `weibos` SET repost_num = repost_num + ? WHERE id = ?", num, weiboID); err != nil {
+		return err
+	}
+	return wb.cache.Del(weiboKey(weiboID))
 }
 
-func (wb *WeiboRepository) GetGivelikeByUseIDAndWeiboID(userID int64, weiboID int64) (*weibo.Givelike, error) {
+func (wb *WeiboRepository) GetGivelikeByUseIDAndWeiboID(ctx context.Context, userID int64, weiboID int64) (*weibo.Givelike, error) {
 	var givelike weibo.Givelike
-	if err := wb.db.Get(&givelike, "SELECT * FROM `givelike` WHERE `user_id` = ? AND weibo_id = ?", userID, weiboID); err != nil {
+	if err := wb.db.GetContext(ctx, &givelike, "SELECT * FROM `givelike` WHERE `user_id` = ? AND weibo_id = ?", userID, weiboID); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
@@ -69,9 +111,9 @@ func (wb *WeiboRepository) GetGivelikeByUseIDAndWeiboID(userID int64, weiboID in
 	return &givelike, nil
 }
 
-func (wb *WeiboRepository) CollectByUseIDAndWeiboID(userID int64, weiboID int64) (*weibo.Collect, error) {
+func (wb *WeiboRepository) CollectByUseIDAndWeiboID(ctx context.Context, userID int64, weiboID int64) (*weibo.Collect, error) {
 	var collect weibo.Collect
-	if err := wb.db.Get(&collect, "SELECT * FROM `collect` WHERE `user_id` = ? AND weibo_id = ?", userID, weiboID); err != nil {
+	if err := wb.db.GetContext(ctx, &collect, "SELECT * FROM `collect` WHERE `user_id` = ? AND weibo_id = ?", userID, weiboID); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
@@ -80,87 +122,56 @@ func (wb *WeiboRepository) CollectByUseIDAndWeiboID(userID int64, weiboID int64)
 	return &collect, nil
 }
 
-func (wb *WeiboRepository) CreateCollect(collect *weibo.Collect) error {
-	_, err := wb.db.NamedExec("INSERT INTO `collect`(user_id, weibo_id, created_at) VALUES(:user_id, :weibo_id, :created_at)", collect)
-	if err != nil {
-		return err
-	}
-	return nil
+func (wb *WeiboRepository) CreateCollect(ctx context.Context, collect *weibo.Collect) error {
+	_, err := wb.db.NamedExecContext(ctx, "INSERT INTO `collect`(user_id, weibo_id, created_at) VALUES(:user_id, :weibo_id, :created_at)", collect)
+	return duplicateError(err)
 }
 
-func (wb *WeiboRepository) CreateComment(comment *weibo.Comment) error {
-	_, err := wb.db.NamedExec("INSERT INTO `weibos`(user_id, weibo_id) VALUES(:user_id, :weibo_id)", comment)
-	if err != nil {
+// 增加微博的评论数
+func (wb *WeiboRepository) AddCommentNumByWeiboID(ctx context.Context, weiboID int64, num int32) error {
+	if _, err := wb.db.ExecContext(ctx, "UPDATE `weibos` SET comment_num = comment_num + ? WHERE id = ?", num, weiboID); err != nil {
 		return err
 	}
-	return err
+	return wb.cache.Del(weiboKey(weiboID))
 }
 
-func (wb *WeiboRepository) AddCommentNumByWeiboID(weiboID int64, num int32) error {
-	_, err := wb.db.Exec("UPDATE `weibos` SET Comment_num = weibo_id_num + ? WHERE id = ?", num, weiboID)
-	return err
-}
-
-func (wb *WeiboRepository) GetCommentByID(commentID int64) (*weibo.Comment, error) {
-	var weibo weibo.Comment
-	if err := wb.db.Get(&weibo, "SELECT * FROM `comment` WHERE `weiboID` = ?", commentID); err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil
-		}
-		return nil, err
-	}
-	return &weibo, nil
-}
-
-// 删除收藏
-func (wb *WeiboRepository) DeleteComment(weiboID int64) error {
-	_, err := wb.db.Exec("UPDATE `comment` SET weibo_id = weibo_id + ? WHERE id = ?", weiboID)
-	return err
-}
-
-func (wb *WeiboRepository) GetWeibosByUserTimelines(userID int64, offset, limit int64) ([]*weibo.WeiboWithUser, error) {
+// 根据id批量查询微博和作者头像
+func (wb *WeiboRepository) GetWeibosByIDs(ctx context.Context, weiboIDs []int64) ([]*weibo.WeiboWithUser, error) {
 	weibos := []*weibo.WeiboWithUser{}
-	key := fmt.Sprintf("weibos:%d", userID)
-
-	data, err := redis.Bytes(wb.redisClient.Do("GET", key))
-	if err == nil {
-		if err = json.Unmarshal(data, &weibos); err != nil {
-			return nil, err
-		}
+	if len(weiboIDs) == 0 {
 		return weibos, nil
 	}
 
-	if err != redis.ErrNil {
+	query, args, err := sqlx.In("SELECT w.*, u.avatar FROM weibos w INNER JOIN users u ON w.user_id = u.id WHERE w.id IN (?)", weiboIDs)
+	if err != nil {
 		return nil, err
 	}
-
-	query := `
-		SELECT w.*, u.avatar FROM weibos w
-		INNER JOIN timeline t ON w.id = t.weibo_id AND t.user_id = ?
-		INNER JOIN users u ON w.user_id = u.id
-		ORDER BY t.weibo_created_at DESC LIMIT ?, ?
-	`
-	if err := wb.db.Select(&weibos, query, userID, offset, limit); err != nil {
+	if err := wb.db.SelectContext(ctx, &weibos, query, args...); err != nil {
 		return nil, err
 	}
+	return weibos, nil
+}
 
-	data, err = json.Marshal(weibos)
-	if err != nil {
-		return nil, err
-	}
+// 查询用户所关注的账号以拉模式发布的微博
+func (wb *WeiboRepository) GetPullTimeLinesByUserID(ctx context.Context, userID int64, page weibo.Page) ([]*weibo.TimeLine, error) {
+	query, args := pageQuery(`
+		SELECT w.id AS weibo_id, w.user_id AS weibo_user_id, w.created_at AS weibo_created_at, f.from_user_id AS user_id FROM weibos w
+		INNER JOIN following f ON f.to_user_id = w.user_id AND f.from_user_id = ?
+		WHERE w.pulled = 1 AND %s`, []interface{}{userID}, page, "w.created_at", "w.id")
 
-	_, err = wb.redisClient.Do("SETEX", key, 600, data)
-	if err != nil {
+	timelines := []*weibo.TimeLine{}
+	if err := wb.db.SelectContext(ctx, &timelines, query, args...); err != nil {
 		return nil, err
 	}
-
-	return weibos, nil
+	return timelines, nil
 }
 
-func (wb *WeiboRepository) GetWeibosByAccountOrContent(accountOrContent string, offset, perPage int64) ([]*weibo.Weibo, error) {
+func (wb *WeiboRepository) GetWeibosByAccountOrContent(ctx context.Context, accountOrContent string, page weibo.Page) ([]*weibo.Weibo, error) {
+	query, args := pageQuery("SELECT * FROM `weibos` WHERE (`account` = ? OR `content` LIKE ? ESCAPE '\\\\') AND %s",
+		[]interface{}{accountOrContent, "%" + escapeLike(accountOrContent) + "%"}, page, "created_at", "id")
+
 	weibos := []*weibo.Weibo{}
-	if err := wb.db.Select(&weibos, "SELECT * FROM `weibos` WHERE `account` = ? OR `content` LIKE ? ORDER BY created_at DESC LIMIT ?, ?",
-		accountOrContent, "%"+accountOrContent+"%", offset, perPage); err != nil {
+	if err := wb.db.SelectContext(ctx, &weibos, query, args...); err != nil {
 		return nil, err
 	}
 	return weibos, nil
@@ -0,0 +1,90 @@
+package weibo
+
+// 屏蔽关系, 屏蔽之后双方不能再关注、评论、点赞、提到和私信对方
+type Block struct {
+	// 屏蔽者的id
+	UserID int64 `json:"user_id" db:"user_id"`
+	// 被屏蔽者的id
+	BlockedUserID int64 `json:"blocked_user_id" db:"blocked_user_id"`
+	// 屏蔽时间
+	CreatedAt int64 `json:"created_at" db:"created_at"`
+}
+
+// 屏蔽列表中的用户
+type BlockedUser struct {
+	ID      int64  `json:"id" db:"id"`
+	Account string `json:"account" db:"account"`
+	Avatar  string `json:"avatar" db:"avatar"`
+	// 屏蔽的时间
+	BlockedAt int64 `json:"blocked_at" db:"blocked_at"`
+}
+
+// 去掉屏蔽关系中的人发布的微博, 以及转发他们的微博
+func filterBlockedWeibos(weibos []*WeiboWithUser, blocked map[int64]bool) []*WeiboWithUser {
+	if len(blocked) == 0 {
+		return weibos
+	}
+	filtered := make([]*WeiboWithUser, 0, len(weibos))
+	for _, weibo := range weibos {
+		if !blockedWeibo(weibo, blocked) {
+			filtered = append(filtered, weibo)
+		}
+	}
+	return filtered
+}
+
+func blockedWeibo(weibo *WeiboWithUser, blocked map[int64]bool) bool {
+	return blocked[weibo.UserID] || (weibo.RepostOf != nil && blocked[weibo.RepostOf.UserID])
+}
+
+func filterBlockedSearchWeibos(weibos []*Weibo, blocked map[int64]bool) []*Weibo {
+	if len(blocked) == 0 {
+		return weibos
+	}
+	filtered := make([]*Weibo, 0, len(weibos))
+	for _, weibo := range weibos {
+		if !blocked[weibo.UserID] {
+			filtered = append(filtered, weibo)
+		}
+	}
+	return filtered
+}
+
+func filterBlockedUsers(users []*User, blocked map[int64]bool) []*User {
+	if len(blocked) == 0 {
+		return users
+	}
+	filtered := make([]*User, 0, len(users))
+	for _, user := range users {
+		if !blocked[user.ID] {
+			filtered = append(filtered, user)
+		}
+	}
+	return filtered
+}
+
+func filterBlockedFollowers(followers []*Follower, blocked map[int64]bool) []*Follower {
+	if len(blocked) == 0 {
+		return followers
+	}
+	filtered := make([]*Follower, 0, len(followers))
+	for _, follower := range followers {
+		if !blocked[follower.ID] {
+			filtered = append(filtered, follower)
+		}
+	}
+	return filtered
+}
+
+func filterBlockedMentions(mentions []*Mention, blocked map[int64]bool) []*Mention {
+	if len(blocked) == 0 {
+		return mentions
+	}
+	filtered := make([]*Mention, 0, len(mentions))
+	for _, mention := range mentions {
+		if !blocked[mention.AuthorID] {
+			filtered = append(filtered, mention)
+		}
+	}
+	return filtered
+}
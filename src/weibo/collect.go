@@ -2,6 +2,6 @@ package weibo
 
 type Collect struct {
 	UserID    int64 `json:"user_id" db:"user_id"`
-	WeiboID   int64 `json:"weibo_id" db:"weibo_id"`
+	WeiboID   int64 `json:"weibo_id,string" db:"weibo_id"`
 	CreatedAt int64 `json:"created_at" db:"created_at"`
 }
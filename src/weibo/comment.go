@@ -1,9 +1,55 @@
 package weibo
 
+// 评论, 直接评论微博的评论是楼层的第一条, 回复都挂在所在楼层下
 type Comment struct {
-	ID        int64  `json:"id" db:"id"`
-	UserID    int64  `json:"user_id" db:"user_id"`
-	WeiboID   int64  `json:"weibo_id" db:"weibo_id"`
+	ID      int64 `json:"id,string" db:"id"`
+	UserID  int64 `json:"user_id" db:"user_id"`
+	WeiboID int64 `json:"weibo_id,string" db:"weibo_id"`
+	// 回复的评论id, 直接评论微博时为0
+	ParentID int64 `json:"parent_id,string" db:"parent_id"`
+	// 所在楼层第一条评论的id, 直接评论微博时为0
+	RootID    int64  `json:"root_id,string" db:"root_id"`
+	ReplyNum  int32  `json:"reply_num" db:"reply_num"` // 冗余字段, 楼中的回复数
 	Content   string `json:"content" db:"content"`
 	CreatedAt int64  `json:"created_at" db:"created_at"`
 }
+
+// 是否是楼中的回复
+func (c *Comment) IsReply() bool {
+	return c.RootID != 0
+}
+
+type CommentWithUser struct {
+	Comment
+	Account string `json:"account" db:"account"`
+	Avatar  string `json:"avatar" db:"avatar"`
+}
+
+// 微博下评论的排序方式
+type CommentSort string
+
+const (
+	// 按评论时间倒序
+	CommentSortTime CommentSort = "time"
+	// 按楼中的回复数倒序, 分页游标中的CreatedAt保存的是回复数
+	CommentSortHot CommentSort = "hot"
+)
+
+// 解析排序方式, 为空时按时间排序
+func ParseCommentSort(s string) (CommentSort, error) {
+	switch CommentSort(s) {
+	case "", CommentSortTime:
+		return CommentSortTime, nil
+	case CommentSortHot:
+		return CommentSortHot, nil
+	}
+	return "", ErrInvalidCommentSort
+}
+
+// 评论在列表中的位置, 用来生成下一页的游标
+func (c *Comment) cursor(sort CommentSort) *Cursor {
+	if sort == CommentSortHot {
+		return &Cursor{CreatedAt: int64(c.ReplyNum), ID: c.ID}
+	}
+	return &Cursor{CreatedAt: c.CreatedAt, ID: c.ID}
+}
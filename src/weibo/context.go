@@ -0,0 +1,45 @@
+package weibo
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	viewerKey
+)
+
+// 在ctx中保存请求id, 日志中会带上它
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey, requestID)
+}
+
+// 取出请求id, 没有时返回空字符串
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey).(string)
+	return requestID
+}
+
+// 在ctx中保存当前登录的用户
+func WithViewer(ctx context.Context, user *User) context.Context {
+	return context.WithValue(ctx, viewerKey, user)
+}
+
+// 取出当前登录的用户, 没有登录时返回nil
+func Viewer(ctx context.Context) *User {
+	user, _ := ctx.Value(viewerKey).(*User)
+	return user
+}
+
+// 记录日志, 有请求id时加在前面
+func logf(ctx context.Context, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	if requestID := RequestID(ctx); requestID != "" {
+		message = "[" + requestID + "] " + message
+	}
+	log.Print(message)
+}
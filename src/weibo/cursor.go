@@ -0,0 +1,76 @@
+package weibo
+
+import (
+	"encoding/base64"
+	"fmt"
+)
+
+// 分页游标, 列表按 (CreatedAt, ID) 倒序排列, 下一页从游标之后的记录开始
+type Cursor struct {
+	CreatedAt int64
+	ID        int64
+}
+
+// 编码成对外使用的不透明字符串
+func (c *Cursor) Encode() string {
+	if c == nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", c.CreatedAt, c.ID)))
+}
+
+// 解析游标, 空字符串返回nil
+func DecodeCursor(token string) (*Cursor, error) {
+	if len(token) == 0 {
+		return nil, nil
+	}
+
+	buf, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor Cursor
+	if _, err := fmt.Sscanf(string(buf), "%d:%d", &cursor.CreatedAt, &cursor.ID); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// 按页码分页时最多跳过的记录数, 再往后只能用游标翻页
+const MaxPageOffset int64 = 10000
+
+// 分页参数, Cursor不为空时从游标之后开始取, 否则跳过Offset条
+type Page struct {
+	Cursor *Cursor
+	Offset int64
+	Limit  int64
+}
+
+// 根据游标或者页码生成分页参数, 游标优先, 页码从1开始
+func ParsePage(cursor string, page, perPage int64) (Page, error) {
+	c, err := DecodeCursor(cursor)
+	if err != nil {
+		return Page{}, err
+	}
+	if c != nil {
+		return Page{Cursor: c, Limit: perPage}, nil
+	}
+
+	if page < 1 {
+		page = 1
+	}
+	// 先比较页码再相乘, 避免页码很大时溢出
+	if perPage > 0 && page-1 > MaxPageOffset/perPage {
+		return Page{}, ErrInvalidPage
+	}
+	return Page{Offset: (page - 1) * perPage, Limit: perPage}, nil
+}
+
+// 取满一页时用最后一条记录生成下一页的游标, 否则说明没有下一页了
+func nextCursor(page Page, count int, last func() *Cursor) string {
+	if count == 0 || int64(count) < page.Limit {
+		return ""
+	}
+	return last().Encode()
+}
@@ -0,0 +1,54 @@
+package weibo
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCursor(t *testing.T) {
+	cursor := &Cursor{CreatedAt: 1546300800, ID: 42}
+	decoded, err := DecodeCursor(cursor.Encode())
+	if err != nil || *decoded != *cursor {
+		t.Fatal("游标编码后无法还原", decoded, err)
+	}
+
+	if _, err := DecodeCursor("not a cursor"); err != ErrInvalidCursor {
+		t.Fatal("无效的游标应该返回错误", err)
+	}
+}
+
+func TestParsePage(t *testing.T) {
+	page, err := ParsePage("", 3, 15)
+	if err != nil || page.Cursor != nil || page.Offset != 30 || page.Limit != 15 {
+		t.Fatal("按页码分页的参数不正确", page, err)
+	}
+
+	page, _ = ParsePage("", 0, 15)
+	if page.Offset != 0 {
+		t.Fatal("页码小于1时应该从第一页开始", page)
+	}
+
+	page, err = ParsePage("", MaxPageOffset/15+1, 15)
+	if err != nil || page.Offset != MaxPageOffset/15*15 {
+		t.Fatal("没有超过最大偏移量的页码应该可以使用", page, err)
+	}
+	for _, p := range []int64{MaxPageOffset/15 + 2, math.MaxInt64 / 10, math.MaxInt64} {
+		if _, err := ParsePage("", p, 15); err != ErrInvalidPage {
+			t.Fatal("页码太大时应该返回错误", p, err)
+		}
+	}
+
+	token := (&Cursor{CreatedAt: 100, ID: 7}).Encode()
+	page, err = ParsePage(token, 3, 15)
+	if err != nil || page.Cursor == nil || page.Cursor.ID != 7 || page.Offset != 0 {
+		t.Fatal("有游标时应该忽略页码", page, err)
+	}
+
+	if next := nextCursor(Page{Limit: 2}, 1, nil); next != "" {
+		t.Fatal("不满一页时没有下一页", next)
+	}
+	next := nextCursor(Page{Limit: 2}, 2, func() *Cursor { return &Cursor{CreatedAt: 100, ID: 7} })
+	if next != token {
+		t.Fatal("下一页的游标不正确", next)
+	}
+}
@@ -0,0 +1,105 @@
+package weibo
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// 错误的类型, 决定了调用方应该怎么处理这个错误
+type ErrorKind string
+
+const (
+	NotFound        ErrorKind = "not_found"
+	AlreadyExists   ErrorKind = "already_exists"
+	Forbidden       ErrorKind = "forbidden"
+	InvalidArgument ErrorKind = "invalid_argument"
+	Unauthenticated ErrorKind = "unauthenticated"
+	// 请求在截止时间之前没有处理完
+	Timeout ErrorKind = "timeout"
+	// 不是上面几种的错误都算作内部错误
+	Internal ErrorKind = "internal"
+)
+
+// 业务错误, Code是稳定的错误码, 客户端可以根据它做判断, Message是默认的提示
+type Error struct {
+	Kind    ErrorKind
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func newError(kind ErrorKind, code, message string) *Error {
+	return &Error{Kind: kind, Code: code, Message: message}
+}
+
+// 仓库写入时违反唯一约束, 可能被包装过, 用errors.Cause判断
+// 服务在并发请求写入同一条记录时把它转换为对应的AlreadyExists错误
+var ErrDuplicate = errors.New("duplicate entry")
+
+var (
+	ErrUserNotFound    = newError(NotFound, "user_not_found", "用户不存在")
+	ErrWeiboNotFound   = newError(NotFound, "weibo_not_found", "这条微博不存在")
+	ErrCommentNotFound = newError(NotFound, "comment_not_found", "这条评论不存在")
+	ErrTopicNotFound   = newError(NotFound, "topic_not_found", "这个话题不存在")
+	ErrMuteNotFound    = newError(NotFound, "mute_not_found", "这条静音设置不存在")
+
+	ErrAccountExists    = newError(AlreadyExists, "account_exists", "账号名已经被使用了")
+	ErrAlreadyFollowing = newError(AlreadyExists, "already_following", "关注过目标用户")
+	ErrAlreadyLiked     = newError(AlreadyExists, "already_liked", "已点赞过该微博")
+	ErrAlreadyCollected = newError(AlreadyExists, "already_collected", "已收藏过该微博")
+	ErrAlreadyBlocked   = newError(AlreadyExists, "already_blocked", "已经屏蔽过该用户")
+	ErrAlreadyMuted     = newError(AlreadyExists, "already_muted", "已经静音过了")
+
+	ErrNotWeiboOwner   = newError(Forbidden, "not_weibo_owner", "不是你的微博不可以删除")
+	ErrNotCommentOwner = newError(Forbidden, "not_comment_owner", "不是你的评论不可以删除")
+	ErrMessageDenied   = newError(Forbidden, "message_denied", "对方只接收关注了自己的人的私信")
+	ErrBlocked         = newError(Forbidden, "blocked", "你和对方之间存在屏蔽关系")
+
+	ErrNotFollowing   = newError(InvalidArgument, "not_following", "没有关注过目标用户")
+	ErrFollowSelf     = newError(InvalidArgument, "follow_self", "不能关注自己")
+	ErrEmptyContent   = newError(InvalidArgument, "empty_content", "内容不能为空")
+	ErrInvalidCursor  = newError(InvalidArgument, "invalid_cursor", "无效的分页游标")
+	ErrInvalidPage    = newError(InvalidArgument, "invalid_page", "页码太大, 请使用游标翻页")
+	ErrEmptySearchKey = newError(InvalidArgument, "empty_search_key", "搜索内容不能为空")
+
+	ErrInvalidCommentSort = newError(InvalidArgument, "invalid_comment_sort", "不支持的评论排序方式")
+
+	ErrMessageSelf              = newError(InvalidArgument, "message_self", "不能给自己发私信")
+	ErrBlockSelf                = newError(InvalidArgument, "block_self", "不能屏蔽自己")
+	ErrNotBlocked               = newError(InvalidArgument, "not_blocked", "没有屏蔽过该用户")
+	ErrInvalidMessagePermission = newError(InvalidArgument, "invalid_message_permission", "不支持的私信权限")
+	ErrMuteSelf                 = newError(InvalidArgument, "mute_self", "不能静音自己")
+	ErrInvalidMuteKeyword       = newError(InvalidArgument, "invalid_mute_keyword", "关键词不能为空, 最多32个字")
+	ErrInvalidMuteDuration      = newError(InvalidArgument, "invalid_mute_duration", "不支持的静音时长")
+	ErrTooManyMutes             = newError(InvalidArgument, "too_many_mutes", "静音的账号和关键词太多了")
+
+	ErrUnauthenticated = newError(Unauthenticated, "unauthenticated", "先登录")
+	ErrWrongPassword   = newError(Unauthenticated, "wrong_password", "密码错误")
+
+	ErrTimeout = newError(Timeout, "timeout", "请求超时, 请稍后重试")
+)
+
+// 取出错误链中的业务错误, 没有时返回nil
+// ctx超时导致的错误当作ErrTimeout
+func AsError(err error) *Error {
+	cause := errors.Cause(err)
+	if e, ok := cause.(*Error); ok {
+		return e
+	}
+	if cause == context.DeadlineExceeded {
+		return ErrTimeout
+	}
+	return nil
+}
+
+// 错误的类型, 不是业务错误时返回Internal
+func KindOf(err error) ErrorKind {
+	if e := AsError(err); e != nil {
+		return e.Kind
+	}
+	return Internal
+}
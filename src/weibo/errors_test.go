@@ -0,0 +1,45 @@
+package weibo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestErrorKind(t *testing.T) {
+	err := errors.Wrap(ErrWeiboNotFound, "点赞失败")
+	if KindOf(err) != NotFound {
+		t.Fatal("包装过的业务错误类型不对", KindOf(err))
+	}
+	if e := AsError(err); e == nil || e.Code != "weibo_not_found" {
+		t.Fatal("没有取出业务错误", e)
+	}
+
+	if KindOf(errors.New("数据库连接失败")) != Internal {
+		t.Fatal("普通错误应该是内部错误")
+	}
+	if AsError(nil) != nil {
+		t.Fatal("nil不是业务错误")
+	}
+
+	if KindOf(errors.Wrap(context.DeadlineExceeded, "查询微博失败")) != Timeout {
+		t.Fatal("ctx超时应该是Timeout")
+	}
+	if KindOf(errors.Wrap(context.Canceled, "查询微博失败")) != Internal {
+		t.Fatal("ctx取消不是超时")
+	}
+}
+
+func TestContextValues(t *testing.T) {
+	ctx := context.Background()
+	if RequestID(ctx) != "" || Viewer(ctx) != nil {
+		t.Fatal("空的ctx中不应该有请求id和当前用户")
+	}
+
+	user := &User{ID: 1}
+	ctx = WithViewer(WithRequestID(ctx, "abc"), user)
+	if RequestID(ctx) != "abc" || Viewer(ctx) != user {
+		t.Fatal("没有取出ctx中的值", RequestID(ctx), Viewer(ctx))
+	}
+}
@@ -0,0 +1,240 @@
+package weibo
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// 推送给在线用户的事件种类
+type EventType string
+
+const (
+	// 关注的人发布了新微博, 已经写入timeline
+	EventTimeline EventType = "timeline"
+	// 收到新的通知
+	EventNotification EventType = "notification"
+	// 未读通知数或者未读私信数变化
+	EventUnreadCount EventType = "unread_count"
+)
+
+// 推送给一个用户的事件, id是snowflake id, 断线重连时补发比Last-Event-ID大的事件
+type Event struct {
+	ID     int64           `json:"id"`
+	UserID int64           `json:"user_id"`
+	Type   EventType       `json:"type"`
+	Data   json.RawMessage `json:"data"`
+}
+
+func NewEvent(id, userID int64, typ EventType, data interface{}) (*Event, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "序列化事件失败")
+	}
+	return &Event{ID: id, UserID: userID, Type: typ, Data: raw}, nil
+}
+
+// 未读数事件的内容
+type UnreadCount struct {
+	Notifications int64 `json:"notifications"`
+	Messages      int64 `json:"messages"`
+}
+
+// 事件的发布和订阅, 多个实例部署时发布的事件要送到所有实例上的订阅者
+type EventBroker interface {
+	Publish(ctx context.Context, events ...*Event) error
+	// 订阅用户的事件, ctx结束时关闭返回的channel
+	// lastEventID不为0时先补发保留的事件中比它大的
+	Subscribe(ctx context.Context, userID, lastEventID int64) (<-chan *Event, error)
+	// 在任意实例上有连接的用户, 推送新微博时只给他们生成事件
+	Online(ctx context.Context, userIDs []int64) (map[int64]bool, error)
+}
+
+// 本进程中的订阅者, 把事件分发给对应用户的所有连接
+type EventHub struct {
+	// 每个订阅者缓冲的事件数, 写满时断开订阅, 客户端重连后补发
+	Buffer int
+
+	mu   sync.Mutex
+	subs map[int64]map[chan *Event]struct{}
+}
+
+func NewEventHub() *EventHub {
+	return &EventHub{
+		Buffer: 64,
+		subs:   map[int64]map[chan *Event]struct{}{},
+	}
+}
+
+// 分发事件给本进程中订阅了的连接, 不会阻塞
+func (h *EventHub) Dispatch(events ...*Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, event := range events {
+		for ch := range h.subs[event.UserID] {
+			select {
+			case ch <- event:
+			default:
+				// 读得太慢的连接直接断开, 不能拖慢其他订阅者
+				h.remove(event.UserID, ch)
+				close(ch)
+			}
+		}
+	}
+}
+
+// 订阅用户的事件, history返回需要补发的事件, 在订阅之后调用, 中间发布的事件不会丢失
+func (h *EventHub) Subscribe(ctx context.Context, userID int64, history func() ([]*Event, error)) (<-chan *Event, error) {
+	ch := make(chan *Event, h.Buffer)
+	h.mu.Lock()
+	if h.subs[userID] == nil {
+		h.subs[userID] = map[chan *Event]struct{}{}
+	}
+	h.subs[userID][ch] = struct{}{}
+	h.mu.Unlock()
+
+	unsubscribe := func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.remove(userID, ch)
+	}
+
+	missed, err := history()
+	if err != nil {
+		unsubscribe()
+		return nil, err
+	}
+
+	out := make(chan *Event)
+	go func() {
+		defer close(out)
+		defer unsubscribe()
+
+		// 补发的事件可能在订阅之后又收到一次
+		sent := make(map[int64]bool, len(missed))
+		for _, event := range missed {
+			sent[event.ID] = true
+			select {
+			case out <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+		for {
+			select {
+			case event, ok := <-ch:
+				if !ok {
+					return
+				}
+				if sent[event.ID] {
+					continue
+				}
+				select {
+				case out <- event:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// 调用时必须持有锁
+func (h *EventHub) remove(userID int64, ch chan *Event) {
+	subs := h.subs[userID]
+	delete(subs, ch)
+	if len(subs) == 0 {
+		delete(h.subs, userID)
+	}
+}
+
+// 本进程中有连接的用户
+func (h *EventHub) Users() []int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	users := make([]int64, 0, len(h.subs))
+	for userID := range h.subs {
+		users = append(users, userID)
+	}
+	return users
+}
+
+// 本进程中有连接的用户, 单机运行时就是全部在线的用户
+func (h *EventHub) Online(ctx context.Context, userIDs []int64) (map[int64]bool, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	online := make(map[int64]bool, len(userIDs))
+	for _, userID := range userIDs {
+		if len(h.subs[userID]) > 0 {
+			online[userID] = true
+		}
+	}
+	return online, nil
+}
+
+// 在线的连接数
+func (h *EventHub) Len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := 0
+	for _, subs := range h.subs {
+		n += len(subs)
+	}
+	return n
+}
+
+// 进程内的事件推送, 用于单机运行和测试
+type MemoryEventBroker struct {
+	*EventHub
+
+	// 每个用户保留的最近事件数
+	History int
+
+	mu      sync.Mutex
+	history map[int64][]*Event
+}
+
+func NewMemoryEventBroker() *MemoryEventBroker {
+	return &MemoryEventBroker{
+		EventHub: NewEventHub(),
+		History:  100,
+		history:  map[int64][]*Event{},
+	}
+}
+
+func (b *MemoryEventBroker) Publish(ctx context.Context, events ...*Event) error {
+	b.mu.Lock()
+	for _, event := range events {
+		history := append(b.history[event.UserID], event)
+		if len(history) > b.History {
+			history = history[len(history)-b.History:]
+		}
+		b.history[event.UserID] = history
+	}
+	b.mu.Unlock()
+
+	b.Dispatch(events...)
+	return nil
+}
+
+func (b *MemoryEventBroker) Subscribe(ctx context.Context, userID, lastEventID int64) (<-chan *Event, error) {
+	return b.EventHub.Subscribe(ctx, userID, func() ([]*Event, error) {
+		if lastEventID == 0 {
+			return nil, nil
+		}
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		var missed []*Event
+		for _, event := range b.history[userID] {
+			if event.ID > lastEventID {
+				missed = append(missed, event)
+			}
+		}
+		return missed, nil
+	})
+}
@@ -0,0 +1,101 @@
+package weibo
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func newTestEvent(t *testing.T, id, userID int64) *Event {
+	t.Helper()
+	event, err := NewEvent(id, userID, EventUnreadCount, &UnreadCount{Notifications: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return event
+}
+
+func receive(t *testing.T, events <-chan *Event) *Event {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("没有收到事件")
+		return nil
+	}
+}
+
+func TestMemoryEventBroker(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	broker := NewMemoryEventBroker()
+	broker.History = 2
+
+	events, err := broker.Subscribe(ctx, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.Publish(ctx, newTestEvent(t, 10, 1), newTestEvent(t, 11, 2), newTestEvent(t, 12, 1), newTestEvent(t, 13, 1)); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int64{10, 12, 13} {
+		if event := receive(t, events); event.ID != id || event.UserID != 1 {
+			t.Fatal("收到的事件不对", event)
+		}
+	}
+
+	// 重连时只能补发保留的事件中比Last-Event-ID大的
+	resumed, err := broker.Subscribe(ctx, 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int64{12, 13} {
+		if event := receive(t, resumed); event.ID != id {
+			t.Fatal("补发的事件不对", event)
+		}
+	}
+	if broker.Len() != 2 {
+		t.Fatal("订阅的连接数不对", broker.Len())
+	}
+
+	cancel()
+	if _, ok := <-events; ok {
+		t.Fatal("ctx结束后应该关闭channel")
+	}
+	<-resumed
+	deadline := time.Now().Add(time.Second)
+	for broker.Len() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("ctx结束后应该取消订阅", broker.Len())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestEventHubSlowSubscriber(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hub := NewEventHub()
+	hub.Buffer = 1
+
+	events, err := hub.Subscribe(ctx, 1, func() ([]*Event, error) { return nil, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 没有读取时缓冲写满, 之后的事件让订阅断开
+	for id := int64(1); id <= 5; id++ {
+		hub.Dispatch(newTestEvent(t, id, 1))
+	}
+	deadline := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return
+			}
+		case <-deadline:
+			t.Fatal("读得太慢的订阅应该被断开")
+		}
+	}
+}
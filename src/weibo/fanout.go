@@ -0,0 +1,402 @@
+package weibo
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// 把微博推送到粉丝的timeline
+	FanoutPublish = "publish"
+	// 从粉丝的timeline中删除微博
+	FanoutDelete = "delete"
+)
+
+// 微博扩散任务
+type FanoutJob struct {
+	ID             string `json:"id"`
+	Kind           string `json:"kind"`
+	WeiboID        int64  `json:"weibo_id"`
+	WeiboUserID    int64  `json:"weibo_user_id"`
+	WeiboCreatedAt int64  `json:"weibo_created_at"`
+	// 已经处理完的最后一个粉丝id, 重试时从这里继续
+	Cursor int64 `json:"cursor"`
+	// 已经失败的次数
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"last_error,omitempty"`
+	// 入队时间, 单位毫秒
+	EnqueuedAt int64 `json:"enqueued_at"`
+}
+
+func NewFanoutJob(kind string, weibo *Weibo) *FanoutJob {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return &FanoutJob{
+		ID:             hex.EncodeToString(buf),
+		Kind:           kind,
+		WeiboID:        weibo.ID,
+		WeiboUserID:    weibo.UserID,
+		WeiboCreatedAt: weibo.CreatedAt,
+		EnqueuedAt:     time.Now().UnixNano() / int64(time.Millisecond),
+	}
+}
+
+// 扩散任务队列
+type FanoutQueue interface {
+	Enqueue(job *FanoutJob) error
+	// 等待并取出一个任务, timeout内没有任务时返回nil
+	Dequeue(timeout time.Duration) (*FanoutJob, error)
+	// 在delay之后重新投递任务
+	Retry(job *FanoutJob, delay time.Duration) error
+	// 超过重试次数的任务放入死信队列
+	DeadLetter(job *FanoutJob) error
+	// 等待处理的任务数
+	Len() (int64, error)
+}
+
+// 扩散任务的运行状态
+type FanoutStats struct {
+	Processed    int64 `json:"processed"`
+	Retried      int64 `json:"retried"`
+	DeadLettered int64 `json:"dead_lettered"`
+	Pending      int64 `json:"pending"`
+	// 最近完成的任务从入队到完成的耗时, 单位毫秒
+	LastLagMillis int64 `json:"last_lag_ms"`
+	MaxLagMillis  int64 `json:"max_lag_ms"`
+}
+
+// 从队列中取出扩散任务, 批量写入或删除粉丝的timeline
+type FanoutWorker struct {
+	queue        FanoutQueue
+	userRepo     UserRepository
+	weiboRepo    WeiboRepository
+	muteRepo     MuteRepository
+	timelineRepo TimeLineRepository
+	ids          IDGenerator
+	events       EventBroker
+
+	// 同时处理任务的goroutine数
+	Concurrency int
+	// 每批处理的粉丝数
+	BatchSize int64
+	// 最多尝试的次数, 超过后进入死信队列
+	MaxAttempts int
+	// 第一次重试的等待时间, 之后每次翻倍
+	RetryDelay time.Duration
+
+	stats FanoutStats
+	stop  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// weiboRepo用于检查微博是否已经删除, muteRepo用于按粉丝的静音过滤推送, events为nil时不推送, 这时muteRepo可以传nil
+func NewFanoutWorker(queue FanoutQueue, userRepo UserRepository, weiboRepo WeiboRepository, muteRepo MuteRepository, timelineRepo TimeLineRepository, ids IDGenerator, events EventBroker) *FanoutWorker {
+	return &FanoutWorker{
+		queue:        queue,
+		userRepo:     userRepo,
+		weiboRepo:    weiboRepo,
+		muteRepo:     muteRepo,
+		timelineRepo: timelineRepo,
+		ids:          ids,
+		events:       events,
+		Concurrency:  4,
+		BatchSize:    500,
+		MaxAttempts:  5,
+		RetryDelay:   time.Second,
+	}
+}
+
+func (w *FanoutWorker) Start() {
+	w.stop = make(chan struct{})
+	for i := 0; i < w.Concurrency; i++ {
+		w.wg.Add(1)
+		go w.loop()
+	}
+}
+
+// 等待正在处理的任务完成后退出
+func (w *FanoutWorker) Stop() {
+	close(w.stop)
+	w.wg.Wait()
+}
+
+func (w *FanoutWorker) Stats() FanoutStats {
+	stats := FanoutStats{
+		Processed:     atomic.LoadInt64(&w.stats.Processed),
+		Retried:       atomic.LoadInt64(&w.stats.Retried),
+		DeadLettered:  atomic.LoadInt64(&w.stats.DeadLettered),
+		LastLagMillis: atomic.LoadInt64(&w.stats.LastLagMillis),
+		MaxLagMillis:  atomic.LoadInt64(&w.stats.MaxLagMillis),
+	}
+	pending, err := w.queue.Len()
+	if err != nil {
+		log.Printf("获取扩散队列长度失败: %v\n", err)
+	}
+	stats.Pending = pending
+	return stats
+}
+
+func (w *FanoutWorker) loop() {
+	defer w.wg.Done()
+	for {
+		select {
+		case <-w.stop:
+			return
+		default:
+		}
+
+		job, err := w.queue.Dequeue(time.Second)
+		if err != nil {
+			log.Printf("获取扩散任务失败: %v\n", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		if job == nil {
+			continue
+		}
+
+		// 任务不属于某个请求, 用任务id代替请求id, 停止worker时会等待任务完成, 不取消
+		w.handle(WithRequestID(context.Background(), "fanout-"+job.ID), job)
+	}
+}
+
+func (w *FanoutWorker) handle(ctx context.Context, job *FanoutJob) {
+	if err := w.Process(ctx, job); err != nil {
+		job.Attempts++
+		job.LastError = err.Error()
+
+		if job.Attempts >= w.MaxAttempts {
+			log.Printf("扩散任务 %s 失败 %d 次, 放入死信队列: %v\n", job.ID, job.Attempts, err)
+			atomic.AddInt64(&w.stats.DeadLettered, 1)
+			if err := w.queue.DeadLetter(job); err != nil {
+				log.Printf("扩散任务 %s 放入死信队列失败: %v\n", job.ID, err)
+			}
+			return
+		}
+
+		delay := w.RetryDelay << uint(job.Attempts-1)
+		atomic.AddInt64(&w.stats.Retried, 1)
+		if err := w.queue.Retry(job, delay); err != nil {
+			log.Printf("扩散任务 %s 重新投递失败: %v\n", job.ID, err)
+		}
+		return
+	}
+
+	lag := time.Now().UnixNano()/int64(time.Millisecond) - job.EnqueuedAt
+	atomic.AddInt64(&w.stats.Processed, 1)
+	atomic.StoreInt64(&w.stats.LastLagMillis, lag)
+	for {
+		max := atomic.LoadInt64(&w.stats.MaxLagMillis)
+		if lag <= max || atomic.CompareAndSwapInt64(&w.stats.MaxLagMillis, max, lag) {
+			break
+		}
+	}
+}
+
+// 执行一个扩散任务, 成功处理的批次会记录在job.Cursor中
+func (w *FanoutWorker) Process(ctx context.Context, job *FanoutJob) error {
+	switch job.Kind {
+	case FanoutPublish:
+		return w.publish(ctx, job)
+	case FanoutDelete:
+		return w.delete(ctx, job)
+	}
+	return errors.Errorf("未知的扩散任务类型: %s", job.Kind)
+}
+
+// 发布和删除任务互相独立并且都会重试, 删除任务可能先执行完
+// 每一批写入之前和全部写入之后检查微博是否已经删除, 已经删除时停止扩散并清理已经写入的timeline
+func (w *FanoutWorker) publish(ctx context.Context, job *FanoutJob) error {
+	for {
+		deleted, err := w.weiboDeleted(ctx, job.WeiboID)
+		if err != nil {
+			return err
+		}
+		if deleted {
+			return w.delete(ctx, job)
+		}
+
+		followerIDs, err := w.userRepo.GetUserFollowerIDs(ctx, job.WeiboUserID, job.Cursor, w.BatchSize)
+		if err != nil {
+			return errors.Wrap(err, "获取粉丝信息失败")
+		}
+		if len(followerIDs) == 0 {
+			return nil
+		}
+
+		timelines := make([]*TimeLine, 0, len(followerIDs))
+		for _, followerID := range followerIDs {
+			id, err := w.ids.NextID()
+			if err != nil {
+				return errors.Wrap(err, "生成timeline的id失败")
+			}
+			timelines = append(timelines, &TimeLine{
+				ID:             id,
+				UserID:         followerID,
+				WeiboUserID:    job.WeiboUserID,
+				WeiboID:        job.WeiboID,
+				WeiboCreatedAt: job.WeiboCreatedAt,
+			})
+		}
+		if err := w.timelineRepo.BatchCreateTimeLines(ctx, timelines); err != nil {
+			return errors.Wrap(err, "批量写入粉丝的timeline失败")
+		}
+		w.push(ctx, job.WeiboID, timelines)
+
+		job.Cursor = followerIDs[len(followerIDs)-1]
+		if int64(len(followerIDs)) < w.BatchSize {
+			break
+		}
+	}
+
+	deleted, err := w.weiboDeleted(ctx, job.WeiboID)
+	if err != nil || !deleted {
+		return err
+	}
+	return w.delete(ctx, job)
+}
+
+func (w *FanoutWorker) weiboDeleted(ctx context.Context, weiboID int64) (bool, error) {
+	weibo, err := w.weiboRepo.GetWeiboByID(ctx, weiboID)
+	if err != nil {
+		return false, errors.Wrap(err, "查询微博失败")
+	}
+	return weibo == nil, nil
+}
+
+// 只推送给在线的粉丝, 和读取首页时一样跳过静音了这条微博的粉丝
+// 不在线的粉丝重连时不补发新微博, 刷新首页就能看到
+// 失败时只记录日志, 不重试这一批
+func (w *FanoutWorker) push(ctx context.Context, weiboID int64, timelines []*TimeLine) {
+	if w.events == nil {
+		return
+	}
+	userIDs := make([]int64, 0, len(timelines))
+	for _, timeline := range timelines {
+		userIDs = append(userIDs, timeline.UserID)
+	}
+	online, err := w.events.Online(ctx, userIDs)
+	if err != nil {
+		logf(ctx, "推送新微博失败: %v\n", err)
+		return
+	}
+	if len(online) == 0 {
+		return
+	}
+
+	weibo, err := weiboWithRepost(ctx, w.weiboRepo, weiboID)
+	if err != nil {
+		logf(ctx, "推送新微博失败: %v\n", err)
+		return
+	}
+	// 微博已经删除
+	if weibo == nil {
+		return
+	}
+	onlineIDs := make([]int64, 0, len(online))
+	for userID := range online {
+		onlineIDs = append(onlineIDs, userID)
+	}
+	filters, err := muteFilters(ctx, w.muteRepo, onlineIDs)
+	if err != nil {
+		logf(ctx, "推送新微博失败: %v\n", err)
+		return
+	}
+
+	events := make([]*Event, 0, len(online))
+	for _, timeline := range timelines {
+		if !online[timeline.UserID] || filters[timeline.UserID].MutesWeibo(weibo) {
+			continue
+		}
+		id, err := w.ids.NextID()
+		if err != nil {
+			logf(ctx, "生成事件id失败: %v\n", err)
+			return
+		}
+		event, err := NewEvent(id, timeline.UserID, EventTimeline, timeline)
+		if err != nil {
+			logf(ctx, "推送新微博失败: %v\n", err)
+			return
+		}
+		events = append(events, event)
+	}
+	if err := w.events.Publish(ctx, events...); err != nil {
+		logf(ctx, "推送新微博失败: %v\n", err)
+	}
+}
+
+func (w *FanoutWorker) delete(ctx context.Context, job *FanoutJob) error {
+	for {
+		n, err := w.timelineRepo.DeleteTimeLinesByWeiboID(ctx, job.WeiboID, w.BatchSize)
+		if err != nil {
+			return errors.Wrap(err, "批量删除粉丝timeline中的微博失败")
+		}
+		if n < w.BatchSize {
+			return nil
+		}
+	}
+}
+
+// 进程内的扩散任务队列, 用于单机运行和测试
+type MemoryFanoutQueue struct {
+	jobs chan *FanoutJob
+
+	mu   sync.Mutex
+	dead []*FanoutJob
+}
+
+func NewMemoryFanoutQueue(size int) *MemoryFanoutQueue {
+	return &MemoryFanoutQueue{jobs: make(chan *FanoutJob, size)}
+}
+
+func (q *MemoryFanoutQueue) Enqueue(job *FanoutJob) error {
+	select {
+	case q.jobs <- job:
+		return nil
+	default:
+		return errors.New("扩散队列已满")
+	}
+}
+
+func (q *MemoryFanoutQueue) Dequeue(timeout time.Duration) (*FanoutJob, error) {
+	select {
+	case job := <-q.jobs:
+		return job, nil
+	case <-time.After(timeout):
+		return nil, nil
+	}
+}
+
+func (q *MemoryFanoutQueue) Retry(job *FanoutJob, delay time.Duration) error {
+	time.AfterFunc(delay, func() {
+		if err := q.Enqueue(job); err != nil {
+			log.Printf("扩散任务 %s 重新投递失败: %v\n", job.ID, err)
+		}
+	})
+	return nil
+}
+
+func (q *MemoryFanoutQueue) DeadLetter(job *FanoutJob) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.dead = append(q.dead, job)
+	return nil
+}
+
+// 死信队列中的任务
+func (q *MemoryFanoutQueue) DeadJobs() []*FanoutJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]*FanoutJob(nil), q.dead...)
+}
+
+func (q *MemoryFanoutQueue) Len() (int64, error) {
+	return int64(len(q.jobs)), nil
+}
@@ -0,0 +1,258 @@
+package weibo
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type MockTimeLineRepository struct {
+	mu        sync.Mutex
+	timelines map[int64][]*TimeLine
+	// 前failures次批量写入返回错误
+	failures int
+	// 每次批量写入之后调用
+	afterBatch func()
+}
+
+func (r *MockTimeLineRepository) GetTimeLinesByUserID(ctx context.Context, userID int64, page Page) ([]*TimeLine, error) {
+	return nil, nil
+}
+func (r *MockTimeLineRepository) GetRecentlyWeiboIDsByUserID(ctx context.Context, userID int64, limit int32) ([]*TimeLine, error) {
+	return nil, nil
+}
+func (r *MockTimeLineRepository) BatchCreateTimeLines(ctx context.Context, timelines []*TimeLine) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failures > 0 {
+		r.failures--
+		return errors.New("写入失败")
+	}
+	if r.timelines == nil {
+		r.timelines = map[int64][]*TimeLine{}
+	}
+	for _, timeline := range timelines {
+		r.timelines[timeline.UserID] = append(r.timelines[timeline.UserID], timeline)
+	}
+	if r.afterBatch != nil {
+		r.afterBatch()
+	}
+	return nil
+}
+func (r *MockTimeLineRepository) DeleteWeiboByUserIDAndWeiboUserID(ctx context.Context, userID int64, weiboUserID int64) error {
+	return nil
+}
+func (r *MockTimeLineRepository) DeleteWeiboByUserIDAndWeiboID(ctx context.Context, userID int64, weiboID int64) error {
+	return nil
+}
+func (r *MockTimeLineRepository) CreateTimeLine(ctx context.Context, timeline *TimeLine) error {
+	return nil
+}
+func (r *MockTimeLineRepository) DeleteTimeLinesByWeiboID(ctx context.Context, weiboID int64, limit int64) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var n int64
+	for userID, timelines := range r.timelines {
+		kept := timelines[:0]
+		for _, timeline := range timelines {
+			if timeline.WeiboID == weiboID && n < limit {
+				n++
+				continue
+			}
+			kept = append(kept, timeline)
+		}
+		r.timelines[userID] = kept
+	}
+	return n, nil
+}
+
+func (r *MockTimeLineRepository) count(weiboID int64) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, timelines := range r.timelines {
+		for _, timeline := range timelines {
+			if timeline.WeiboID == weiboID {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// 只实现扩散用到的方法
+type MockWeiboRepository struct {
+	WeiboRepository
+	mu     sync.Mutex
+	weibos map[int64]*WeiboWithUser
+}
+
+func (r *MockWeiboRepository) GetWeiboByID(ctx context.Context, weiboID int64) (*Weibo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if weibo, ok := r.weibos[weiboID]; ok {
+		copied := weibo.Weibo
+		return &copied, nil
+	}
+	return nil, nil
+}
+
+func (r *MockWeiboRepository) delete(weiboID int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.weibos, weiboID)
+}
+
+func (r *MockWeiboRepository) GetWeibosByIDs(ctx context.Context, weiboIDs []int64) ([]*WeiboWithUser, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	weibos := []*WeiboWithUser{}
+	for _, weiboID := range weiboIDs {
+		if weibo, ok := r.weibos[weiboID]; ok {
+			copied := *weibo
+			weibos = append(weibos, &copied)
+		}
+	}
+	return weibos, nil
+}
+
+type MockMuteRepository struct {
+	MuteRepository
+	mu    sync.Mutex
+	mutes []*Mute
+	// 每次批量查询的用户
+	queries [][]int64
+}
+
+func (r *MockMuteRepository) GetMutesByUserIDs(ctx context.Context, userIDs []int64) ([]*Mute, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.queries = append(r.queries, userIDs)
+	mutes := []*Mute{}
+	for _, mute := range r.mutes {
+		for _, userID := range userIDs {
+			if mute.UserID == userID {
+				mutes = append(mutes, mute)
+			}
+		}
+	}
+	return mutes, nil
+}
+
+func TestFanoutWorker(t *testing.T) {
+	userRepo := &MockUserRepository{followers: map[int64][]int64{1: {2, 3, 4, 5, 6, 7, 8}}}
+	weiboRepo := &MockWeiboRepository{weibos: map[int64]*WeiboWithUser{100: {Weibo: Weibo{ID: 100, UserID: 1}}}}
+	timelineRepo := &MockTimeLineRepository{failures: 1}
+	queue := NewMemoryFanoutQueue(10)
+
+	worker := NewFanoutWorker(queue, userRepo, weiboRepo, nil, timelineRepo, newTestSnowflake(t), nil)
+	worker.BatchSize = 3
+	worker.RetryDelay = time.Millisecond
+	worker.Start()
+	defer worker.Stop()
+
+	weibo := &Weibo{ID: 100, UserID: 1, CreatedAt: time.Now().Unix()}
+	queue.Enqueue(NewFanoutJob(FanoutPublish, weibo))
+	waitFor(t, func() bool { return timelineRepo.count(100) == 7 })
+
+	queue.Enqueue(NewFanoutJob(FanoutDelete, weibo))
+	waitFor(t, func() bool { return timelineRepo.count(100) == 0 })
+
+	stats := worker.Stats()
+	if stats.Processed != 2 || stats.Retried != 1 || stats.Pending != 0 {
+		t.Fatal("扩散任务的统计不正确", stats)
+	}
+}
+
+func TestFanoutWorkerDeadLetter(t *testing.T) {
+	userRepo := &MockUserRepository{followers: map[int64][]int64{1: {2}}}
+	weiboRepo := &MockWeiboRepository{weibos: map[int64]*WeiboWithUser{100: {Weibo: Weibo{ID: 100, UserID: 1}}}}
+	timelineRepo := &MockTimeLineRepository{failures: 100}
+	queue := NewMemoryFanoutQueue(10)
+
+	worker := NewFanoutWorker(queue, userRepo, weiboRepo, nil, timelineRepo, newTestSnowflake(t), nil)
+	worker.MaxAttempts = 3
+	worker.RetryDelay = time.Millisecond
+	worker.Start()
+	defer worker.Stop()
+
+	queue.Enqueue(NewFanoutJob(FanoutPublish, &Weibo{ID: 100, UserID: 1}))
+	waitFor(t, func() bool { return len(queue.DeadJobs()) == 1 })
+
+	job := queue.DeadJobs()[0]
+	if job.Attempts != 3 || job.LastError == "" {
+		t.Fatal("死信队列中的任务信息不正确", job)
+	}
+}
+
+// 删除任务先执行完时, 发布任务不能把已经删除的微博写回粉丝的timeline
+func TestFanoutWorkerDeletedWeibo(t *testing.T) {
+	ctx := context.Background()
+	userRepo := &MockUserRepository{followers: map[int64][]int64{1: {2, 3, 4, 5, 6, 7, 8}}}
+	weiboRepo := &MockWeiboRepository{weibos: map[int64]*WeiboWithUser{}}
+	timelineRepo := &MockTimeLineRepository{}
+	worker := NewFanoutWorker(NewMemoryFanoutQueue(10), userRepo, weiboRepo, nil, timelineRepo, newTestSnowflake(t), nil)
+	worker.BatchSize = 3
+
+	// 开始之前已经删除
+	if err := worker.Process(ctx, NewFanoutJob(FanoutPublish, &Weibo{ID: 100, UserID: 1})); err != nil {
+		t.Fatal(err)
+	}
+	if timelineRepo.count(100) != 0 {
+		t.Fatal("已经删除的微博不应该写入timeline")
+	}
+
+	// 写入第一批之后被删除, 删除任务没有看到这一批
+	weiboRepo.weibos[101] = &WeiboWithUser{Weibo: Weibo{ID: 101, UserID: 1}}
+	timelineRepo.afterBatch = func() { weiboRepo.delete(101) }
+	if err := worker.Process(ctx, NewFanoutJob(FanoutPublish, &Weibo{ID: 101, UserID: 1})); err != nil {
+		t.Fatal(err)
+	}
+	if timelineRepo.count(101) != 0 {
+		t.Fatal("扩散期间删除的微博应该从timeline中清理掉")
+	}
+}
+
+// 只给在线的粉丝推送, 每批只查询一次他们的静音
+func TestFanoutWorkerPush(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	userRepo := &MockUserRepository{followers: map[int64][]int64{1: {2, 3, 4, 5}}}
+	weiboRepo := &MockWeiboRepository{weibos: map[int64]*WeiboWithUser{100: {Weibo: Weibo{ID: 100, UserID: 1, Content: "hello"}}}}
+	muteRepo := &MockMuteRepository{mutes: []*Mute{{UserID: 3, MutedUserID: 1}}}
+	timelineRepo := &MockTimeLineRepository{}
+	events := NewMemoryEventBroker()
+	for _, userID := range []int64{2, 3} {
+		if _, err := events.Subscribe(ctx, userID, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	worker := NewFanoutWorker(NewMemoryFanoutQueue(10), userRepo, weiboRepo, muteRepo, timelineRepo, newTestSnowflake(t), events)
+	if err := worker.Process(ctx, NewFanoutJob(FanoutPublish, &weiboRepo.weibos[100].Weibo)); err != nil {
+		t.Fatal(err)
+	}
+	if timelineRepo.count(100) != 4 {
+		t.Fatal("所有粉丝的timeline都应该写入")
+	}
+	if len(muteRepo.queries) != 1 || len(muteRepo.queries[0]) != 2 {
+		t.Fatal("只应该批量查询在线粉丝的静音", muteRepo.queries)
+	}
+	events.mu.Lock()
+	defer events.mu.Unlock()
+	if len(events.history[2]) != 1 || len(events.history[3]) != 0 || len(events.history[4]) != 0 {
+		t.Fatal("推送的事件不对", events.history)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("等待超时")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,96 @@
+package weibo
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// 生成全局唯一的id, 微博、评论和timeline的id在插入之前生成
+type IDGenerator interface {
+	NextID() (int64, error)
+}
+
+// snowflake的位数分配: 41位毫秒时间戳 + 10位worker id + 12位序号
+// 同一个worker生成的id严格递增, 不同worker生成的id大致按时间排序
+const (
+	snowflakeWorkerBits   = 10
+	snowflakeSequenceBits = 12
+
+	MaxWorkerID       = 1<<snowflakeWorkerBits - 1
+	snowflakeMaxSeq   = 1<<snowflakeSequenceBits - 1
+	snowflakeTimeBits = snowflakeWorkerBits + snowflakeSequenceBits
+)
+
+// id中的时间戳从这个时间开始计算, 可以使用到2089年
+var snowflakeEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+var ErrClockBackwards = errors.New("系统时钟回拨, 暂时无法生成id")
+
+// 按时间排序的64位id
+// 多个实例部署时每个实例必须使用不同的worker id, 否则会生成重复的id
+type Snowflake struct {
+	workerID int64
+
+	// 时钟回拨不超过这个时间时等待时钟追上来, 超过时返回ErrClockBackwards
+	MaxClockBackwards time.Duration
+
+	mu       sync.Mutex
+	lastTime int64
+	sequence int64
+	now      func() time.Time
+	sleep    func(time.Duration)
+}
+
+func NewSnowflake(workerID int64) (*Snowflake, error) {
+	if workerID < 0 || workerID > MaxWorkerID {
+		return nil, errors.Errorf("worker id必须在0到%d之间: %d", MaxWorkerID, workerID)
+	}
+	return &Snowflake{
+		workerID:          workerID,
+		MaxClockBackwards: 10 * time.Millisecond,
+		now:               time.Now,
+		sleep:             time.Sleep,
+	}, nil
+}
+
+func (s *Snowflake) NextID() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.millis()
+	if now < s.lastTime {
+		backwards := time.Duration(s.lastTime-now) * time.Millisecond
+		if backwards > s.MaxClockBackwards {
+			return 0, errors.Wrapf(ErrClockBackwards, "回拨了 %v", backwards)
+		}
+		s.sleep(backwards)
+		if now = s.millis(); now < s.lastTime {
+			return 0, errors.Wrapf(ErrClockBackwards, "等待 %v 后仍然落后", backwards)
+		}
+	}
+
+	if now == s.lastTime {
+		s.sequence = (s.sequence + 1) & snowflakeMaxSeq
+		// 这一毫秒的序号用完了, 等到下一毫秒
+		for s.sequence == 0 && now <= s.lastTime {
+			s.sleep(time.Duration(s.lastTime-now+1) * time.Millisecond)
+			now = s.millis()
+		}
+	} else {
+		s.sequence = 0
+	}
+	s.lastTime = now
+
+	return now<<snowflakeTimeBits | s.workerID<<snowflakeSequenceBits | s.sequence, nil
+}
+
+func (s *Snowflake) millis() int64 {
+	return s.now().Sub(snowflakeEpoch).Nanoseconds() / int64(time.Millisecond)
+}
+
+// 从id中取出生成的时间
+func SnowflakeTime(id int64) time.Time {
+	return snowflakeEpoch.Add(time.Duration(id>>snowflakeTimeBits) * time.Millisecond)
+}
@@ -0,0 +1,88 @@
+package weibo
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func newTestSnowflake(t *testing.T) *Snowflake {
+	t.Helper()
+	s, err := NewSnowflake(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSnowflake(t *testing.T) {
+	if _, err := NewSnowflake(MaxWorkerID + 1); err == nil {
+		t.Fatal("worker id超出范围时应该报错")
+	}
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s, _ := NewSnowflake(MaxWorkerID)
+	s.now = func() time.Time { return now }
+	// 等待时推进时钟
+	s.sleep = func(d time.Duration) { now = now.Add(d) }
+
+	// 同一毫秒内序号递增, 序号用完后等到下一毫秒
+	var last int64
+	for i := 0; i < snowflakeMaxSeq+10; i++ {
+		id, err := s.NextID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id <= last {
+			t.Fatal("id没有递增", i, last, id)
+		}
+		last = id
+	}
+	if got := SnowflakeTime(last); !got.Equal(now) || now.Sub(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) != time.Millisecond {
+		t.Fatal("id中的时间不正确", got, now)
+	}
+	if worker := last >> snowflakeSequenceBits & MaxWorkerID; worker != MaxWorkerID {
+		t.Fatal("id中的worker id不正确", worker)
+	}
+
+	// 小的回拨等待时钟追上来
+	now = now.Add(-5 * time.Millisecond)
+	id, err := s.NextID()
+	if err != nil || id <= last {
+		t.Fatal("小的时钟回拨应该等待", err)
+	}
+	last = id
+
+	// 大的回拨直接报错, 不能生成重复的id
+	now = now.Add(-time.Second)
+	if _, err := s.NextID(); errors.Cause(err) != ErrClockBackwards {
+		t.Fatal("大的时钟回拨应该报错", err)
+	}
+	now = now.Add(2 * time.Second)
+	if id, err := s.NextID(); err != nil || id <= last {
+		t.Fatal("时钟恢复后应该可以继续生成", err)
+	}
+}
+
+// 超过2^53的id在json中用字符串表示, 否则javascript客户端读取时会丢失精度
+func TestSnowflakeIDsInJSON(t *testing.T) {
+	id := int64(1)<<60 + 1
+	data, err := json.Marshal(&Weibo{ID: id, RepostOfID: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if fields["id"] != "1152921504606846977" || fields["repost_of_id"] != "1152921504606846977" {
+		t.Fatal("id应该编码为字符串", string(data))
+	}
+
+	var decoded Weibo
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded.ID != id {
+		t.Fatal("字符串形式的id应该可以解析", decoded.ID, err)
+	}
+}
@@ -1,60 +1,200 @@
 package weibo
 
+import "context"
+
 type UserRepository interface {
-	GetUserByAccount(account string) (*User, error)
-	CreateUser(user *User) error
+	GetUserByAccount(ctx context.Context, account string) (*User, error)
+	CreateUser(ctx context.Context, user *User) error
+	// 更新用户的密码哈希
+	UpdatePassword(ctx context.Context, userID int64, password, salt string) error
+	// 更新谁可以给用户发私信
+	UpdateMessagePermission(ctx context.Context, userID int64, permission MessagePermission) error
 	// 根据用户id查询相应用户信息
-	GetUserByID(userID int64) (*User, error)
+	GetUserByID(ctx context.Context, userID int64) (*User, error)
 	// 增加用户所关注的人数
-	AddFollowingNumByUserID(userID int64, num int32) error
+	AddFollowingNumByUserID(ctx context.Context, userID int64, num int32) error
 	// 增加用户的粉丝数
-	AddFollowerNumByUserID(userID int64, num int32) error
+	AddFollowerNumByUserID(ctx context.Context, userID int64, num int32) error
 
 	// 查询某个用户关注另一个用户的记录
-	GetFollowing(fromUserID, toUserID int64) (*Following, error)
+	GetFollowing(ctx context.Context, fromUserID, toUserID int64) (*Following, error)
 	// 记录关注信息
-	CreateFollowing(following *Following) error
+	CreateFollowing(ctx context.Context, following *Following) error
 	// 删除关注信息
-	DeleteFollowing(following *Following) error
+	DeleteFollowing(ctx context.Context, following *Following) error
 	// 增加微博数量
-	AddWeiboNumByUserID(userID int64, num int32) error
+	AddWeiboNumByUserID(ctx context.Context, userID int64, num int32) error
 
 	// 获取用户所粉丝
-	GetUserFollowers(userID int64) ([]*Following, error)
+	GetUserFollowers(ctx context.Context, userID int64) ([]*Following, error)
+
+	// 分页获取粉丝的信息, 按关注时间倒序
+	GetUserFollowers2(ctx context.Context, userID int64, page Page) ([]*Follower, error)
+
+	// 按id顺序分批获取粉丝的id, 返回id大于afterID的最多limit个
+	GetUserFollowerIDs(ctx context.Context, userID, afterID, limit int64) ([]int64, error)
 
-	GetUserFollowers2(userID int64) ([]*Follower, error)
+	// 按账号前缀搜索用户, 按注册时间倒序
+	GetUsersByAccount(ctx context.Context, account string, page Page) ([]*User, error)
 
-	GetUsersByAccount(account string, offset, perPage int64) ([]*User, error)
+	// 根据账号批量精确查询用户, 不存在的账号忽略, 不保证顺序
+	GetUsersByAccounts(ctx context.Context, accounts []string) ([]*User, error)
 }
 
 type WeiboRepository interface {
-	GetWeiboByID(weiboID int64) (*Weibo, error)
-	InsertWeibo(weibo *Weibo) (int64, error)
-	DeleteWeibo(weibo *Weibo) error
-	CreateGivelike(giveLike *Givelike) error
-	AddLikeNumByWeiboID(weiboID int64, num int32) error
-	GetGivelikeByUseIDAndWeiboID(userID int64, weiboID int64) (*Givelike, error)
-	CollectByUseIDAndWeiboID(userID int64, weiboID int64) (*Collect, error)
-	CreateCollect(collect *Collect) error
-	CreateComment(comment *Comment) error
-	AddCommentNumByWeiboID(weiboID int64, num int32) error
-	GetCommentByID(commentID int64) (*Comment, error)
-	DeleteComment(weiboID int64) error
-	// 根据用户Timelines找微博
-	GetWeibosByUserTimelines(userID int64, offset, limit int64) ([]*WeiboWithUser, error)
+	GetWeiboByID(ctx context.Context, weiboID int64) (*Weibo, error)
+	// 保存微博, id由调用方生成
+	InsertWeibo(ctx context.Context, weibo *Weibo) error
+	DeleteWeibo(ctx context.Context, weibo *Weibo) error
+	CreateGivelike(ctx context.Context, giveLike *Givelike) error
+	AddLikeNumByWeiboID(ctx context.Context, weiboID int64, num int32) error
+	// 增加转发数, 微博已经删除时忽略
+	AddRepostNumByWeiboID(ctx context.Context, weiboID int64, num int32) error
+	GetGivelikeByUseIDAndWeiboID(ctx context.Context, userID int64, weiboID int64) (*Givelike, error)
+	CollectByUseIDAndWeiboID(ctx context.Context, userID int64, weiboID int64) (*Collect, error)
+	CreateCollect(ctx context.Context, collect *Collect) error
+	AddCommentNumByWeiboID(ctx context.Context, weiboID int64, num int32) error
+	// 根据id批量查询微博和作者头像, 已经删除的微博不会返回, 不保证顺序
+	GetWeibosByIDs(ctx context.Context, weiboIDs []int64) ([]*WeiboWithUser, error)
+	// 查询用户所关注的账号以拉模式发布的微博, 按发布时间倒序
+	GetPullTimeLinesByUserID(ctx context.Context, userID int64, page Page) ([]*TimeLine, error)
 	// 根据账号或者内容搜索微博
-	GetWeibosByAccountOrContent(accountOrContent string, offset, perPage int64) ([]*Weibo, error)
+	GetWeibosByAccountOrContent(ctx context.Context, accountOrContent string, page Page) ([]*Weibo, error)
+}
+
+type CommentRepository interface {
+	// 保存评论, id由调用方生成
+	CreateComment(ctx context.Context, comment *Comment) error
+	GetCommentByID(ctx context.Context, commentID int64) (*Comment, error)
+	// 删除评论, 删除楼层的第一条评论时楼中的回复一起删除, 返回删除的评论数
+	DeleteComment(ctx context.Context, commentID int64) (int64, error)
+	// 增加楼中的回复数, 评论已经删除时忽略
+	AddReplyNumByCommentID(ctx context.Context, commentID int64, num int32) error
+	// 分页查询直接评论微博的评论和评论者的信息
+	GetCommentsByWeiboID(ctx context.Context, weiboID int64, sort CommentSort, page Page) ([]*CommentWithUser, error)
+	// 分页查询楼中的回复和回复者的信息, 按时间倒序
+	GetRepliesByRootID(ctx context.Context, rootID int64, page Page) ([]*CommentWithUser, error)
+	// 根据id批量查询评论和评论者的信息, 已经删除的评论不会返回, 不保证顺序
+	GetCommentsByIDs(ctx context.Context, commentIDs []int64) ([]*CommentWithUser, error)
+}
+
+type TopicRepository interface {
+	// 保存话题, 已经存在的话题不会重复创建, 返回所有话题, 不保证顺序
+	CreateTopics(ctx context.Context, names []string, createdAt int64) ([]*Topic, error)
+	GetTopicByName(ctx context.Context, name string) (*Topic, error)
+	// 根据id批量查询话题, 不保证顺序
+	GetTopicsByIDs(ctx context.Context, topicIDs []int64) ([]*Topic, error)
+	// 增加话题下的微博数
+	AddWeiboNumByTopicIDs(ctx context.Context, topicIDs []int64, num int32) error
+	// 保存微博和话题的关联
+	CreateWeiboTopics(ctx context.Context, weiboTopics []*WeiboTopic) error
+	// 查询微博关联的话题
+	GetTopicsByWeiboID(ctx context.Context, weiboID int64) ([]*Topic, error)
+	// 删除微博和话题的关联
+	DeleteWeiboTopicsByWeiboID(ctx context.Context, weiboID int64) error
+	// 分页查询话题下的微博和作者头像, 按发布时间倒序
+	GetWeibosByTopicID(ctx context.Context, topicID int64, page Page) ([]*WeiboWithUser, error)
+	// 微博数最多的话题
+	GetTopTopics(ctx context.Context, limit int64) ([]*Topic, error)
+}
+
+type MentionRepository interface {
+	// 保存提到用户的记录, id由调用方生成
+	CreateMentions(ctx context.Context, mentions []*Mention) error
+	// 删除微博和微博下的评论中提到用户的记录
+	DeleteMentionsByWeiboID(ctx context.Context, weiboID int64) error
+	// 分页查询提到某个用户的记录, 按时间倒序, 所在的微博或者评论已经删除的不返回
+	GetMentionsByUserID(ctx context.Context, userID int64, page Page) ([]*Mention, error)
+}
+
+type NotificationRepository interface {
+	// 增加一条通知并记录触发的用户, 已经有同一个聚合的未读通知时合并进去并把n.ID改为它的id, 否则用n.ID创建
+	AddNotification(ctx context.Context, n *Notification) error
+	// 查询聚合到通知中的用户, 按最近一次触发的时间倒序
+	GetNotificationActors(ctx context.Context, notificationIDs []int64) ([]*NotificationActor, error)
+	// 分页查询用户的通知, 按最近一次聚合的时间倒序
+	GetNotificationsByUserID(ctx context.Context, userID int64, page Page) ([]*Notification, error)
+	// 用户的未读通知数
+	CountUnreadNotifications(ctx context.Context, userID int64) (int64, error)
+	// 把用户的全部通知标记为已读
+	MarkNotificationsRead(ctx context.Context, userID int64) error
+}
+
+type MessageRepository interface {
+	// 保存私信, id由调用方生成
+	CreateMessage(ctx context.Context, message *Message) error
+	// 保存会话的最后一条私信, 会话已经存在时更新最后一条私信并增加未读数, 否则用c.ID创建
+	SaveConversation(ctx context.Context, c *Conversation) error
+	// 分页查询用户的会话和对方的信息, 按最后一条私信的时间倒序
+	GetConversationsByUserID(ctx context.Context, userID int64, page Page) ([]*ConversationWithUser, error)
+	// 分页查询两个人之间的私信, 按时间倒序
+	GetMessages(ctx context.Context, userID, peerID int64, page Page) ([]*Message, error)
+	// 把会话的未读数清零
+	MarkConversationRead(ctx context.Context, userID, peerID int64) error
+	// 用户所有会话的未读数之和
+	CountUnreadMessages(ctx context.Context, userID int64) (int64, error)
+}
+
+type BlockRepository interface {
+	CreateBlock(ctx context.Context, block *Block) error
+	DeleteBlock(ctx context.Context, block *Block) error
+	// userID屏蔽了blockedUserID时返回屏蔽记录, 否则返回nil
+	GetBlock(ctx context.Context, userID, blockedUserID int64) (*Block, error)
+	// 两个人中任意一方屏蔽了另一方
+	IsBlocked(ctx context.Context, userID, otherUserID int64) (bool, error)
+	// 用户屏蔽了的人和屏蔽了用户的人的id, 列表中过滤掉他们的内容
+	GetBlockRelatedUserIDs(ctx context.Context, userID int64) ([]int64, error)
+	// 分页查询用户屏蔽了的人, 按屏蔽时间倒序
+	GetBlockedUsers(ctx context.Context, userID int64, page Page) ([]*BlockedUser, error)
+}
+
+type MuteRepository interface {
+	// 同一个用户对同一个账号或者关键词只能有一条静音, 重复时返回错误
+	CreateMute(ctx context.Context, mute *Mute) error
+	// 删除用户的一条静音, 返回删除的条数
+	DeleteMute(ctx context.Context, userID, muteID int64) (int64, error)
+	// 删除用户已经过期的静音
+	DeleteExpiredMutes(ctx context.Context, userID, now int64) error
+	// 用户全部的静音, 包括已经过期的, 按创建时间倒序, 读取timeline时用于过滤
+	GetMutesByUserID(ctx context.Context, userID int64) ([]*Mute, error)
+	// 多个用户全部的静音, 推送新微博时按接收者批量过滤
+	GetMutesByUserIDs(ctx context.Context, userIDs []int64) ([]*Mute, error)
 }
 
 type TimeLineRepository interface {
+	// 查询某个用户的timeline, 按微博发布时间倒序
+	GetTimeLinesByUserID(ctx context.Context, userID int64, page Page) ([]*TimeLine, error)
 	// 查询某个用户最近七天的微博id
-	GetRecentlyWeiboIDsByUserID(userID int64, limit int32) ([]*TimeLine, error)
-	// 批量把一批微博id插入到某个用户的timeline中
-	BatchCreateTimeLines(timelines []*TimeLine) error
+	GetRecentlyWeiboIDsByUserID(ctx context.Context, userID int64, limit int32) ([]*TimeLine, error)
+	// 批量把一批微博id插入到某个用户的timeline中, timeline的id由调用方生成
+	BatchCreateTimeLines(ctx context.Context, timelines []*TimeLine) error
 	// 删除某个用户的timeline中某人发的微博
-	DeleteWeiboByUserIDAndWeiboUserID(userID int64, weiboUserID int64) error
+	DeleteWeiboByUserIDAndWeiboUserID(ctx context.Context, userID int64, weiboUserID int64) error
 	// 删除某个用户的timeline中的某条微博
-	DeleteWeiboByUserIDAndWeiboID(userID int64, weiboID int64) error
+	DeleteWeiboByUserIDAndWeiboID(ctx context.Context, userID int64, weiboID int64) error
 	// 插入新的timeline
-	CreateTimeLine(timeline *TimeLine) error
+	CreateTimeLine(ctx context.Context, timeline *TimeLine) error
+	// 从所有用户的timeline中删除某条微博, 每次最多删除limit行, 返回删除的行数
+	DeleteTimeLinesByWeiboID(ctx context.Context, weiboID int64, limit int64) (int64, error)
+}
+
+// 同一个事务中使用的一组仓库
+type Repositories struct {
+	Users         UserRepository
+	Weibos        WeiboRepository
+	Comments      CommentRepository
+	Topics        TopicRepository
+	Mentions      MentionRepository
+	Notifications NotificationRepository
+	Messages      MessageRepository
+	Blocks        BlockRepository
+	Mutes         MuteRepository
+	TimeLines     TimeLineRepository
+}
+
+// 工作单元, 让一组仓库操作在同一个事务中原子地执行
+type UnitOfWork interface {
+	// 在事务中执行fn, fn返回nil时提交事务, 否则回滚, ctx结束时事务也会回滚
+	// 仓库产生的缓存失效操作会推迟到事务提交之后再执行
+	Do(ctx context.Context, fn func(repos *Repositories) error) error
 }
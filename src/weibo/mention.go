@@ -0,0 +1,95 @@
+package weibo
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// 账号最多的字数, 和users表中account的长度一致
+	MaxAccountLength = 16
+	// 一条微博或者评论中最多记录提到的账号数, 超出的仍然显示成链接
+	MaxMentions = 10
+)
+
+// 微博或者评论中提到某个用户的记录
+type Mention struct {
+	ID        int64 `json:"id,string" db:"id"`
+	UserID    int64 `json:"user_id" db:"user_id"` // 被提到的用户
+	AuthorID  int64 `json:"author_id" db:"author_id"`
+	WeiboID   int64 `json:"weibo_id,string" db:"weibo_id"`
+	CommentID int64 `json:"comment_id,string" db:"comment_id"` // 在微博中提到时为0
+	CreatedAt int64 `json:"created_at" db:"created_at"`
+}
+
+// 是否在评论中提到
+func (m *Mention) InComment() bool {
+	return m.CommentID != 0
+}
+
+// "@我的"列表中的一项, 在评论中提到时Comment是这条评论, Weibo是评论所在的微博
+type MentionItem struct {
+	*Mention
+	Weibo   *WeiboWithUser   `json:"weibo"`
+	Comment *CommentWithUser `json:"comment,omitempty"`
+}
+
+// @后面连续的字母、数字、_和-是账号, 前面紧挨着英文字母或者数字时不算, 例如邮箱地址
+func splitMentions(text string) []ContentSegment {
+	segments := []ContentSegment{}
+	start := 0 // 还没有切分出去的普通文本的开始位置
+	for i := 0; i < len(text); {
+		at := strings.IndexByte(text[i:], '@')
+		if at < 0 {
+			break
+		}
+		at += i
+		end := at + 1
+		for end < len(text) {
+			r, size := utf8.DecodeRuneInString(text[end:])
+			if !isAccountRune(r) {
+				break
+			}
+			end += size
+		}
+
+		account := text[at+1 : end]
+		prev, _ := utf8.DecodeLastRuneInString(text[:at])
+		if account == "" || utf8.RuneCountInString(account) > MaxAccountLength || (at > 0 && prev < utf8.RuneSelf && isAccountRune(prev)) {
+			i = end
+			continue
+		}
+		if at > start {
+			segments = append(segments, ContentSegment{Text: text[start:at]})
+		}
+		segments = append(segments, ContentSegment{Text: text[at:end], Mention: account})
+		start = end
+		i = end
+	}
+	if start < len(text) {
+		segments = append(segments, ContentSegment{Text: text[start:]})
+	}
+	return segments
+}
+
+func isAccountRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-'
+}
+
+// 内容中提到的账号, 去掉重复的, 按出现的顺序最多返回MaxMentions个
+func ParseMentions(content string) []string {
+	seen := map[string]bool{}
+	accounts := []string{}
+	for _, segment := range SplitContent(content) {
+		if segment.Mention == "" || seen[segment.Mention] {
+			continue
+		}
+		seen[segment.Mention] = true
+		accounts = append(accounts, segment.Mention)
+		if len(accounts) == MaxMentions {
+			break
+		}
+	}
+	return accounts
+}
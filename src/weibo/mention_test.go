@@ -0,0 +1,38 @@
+package weibo
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMentions(t *testing.T) {
+	tests := []struct {
+		content  string
+		accounts []string
+	}{
+		{"没有提到", []string{}},
+		{"@张三 你好 @li_si-2:", []string{"张三", "li_si-2"}},
+		{"@重复 @重复", []string{"重复"}},
+		{"你好@王五", []string{"王五"}},
+		// 邮箱和空的账号不算, 话题中的@也不算
+		{"a@b.com @ @@c", []string{"c"}},
+		{"#@话题# @d", []string{"d"}},
+		{"@" + strings.Repeat("a", MaxAccountLength+1), []string{}},
+		{"@a @b @c @d @e @f @g @h @i @j @k", []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"}},
+	}
+	for _, test := range tests {
+		if got := ParseMentions(test.content); !reflect.DeepEqual(got, test.accounts) {
+			t.Errorf("%q 中提到的账号应该是 %v, 实际是 %v", test.content, test.accounts, got)
+		}
+	}
+}
+
+func TestSplitContentMentions(t *testing.T) {
+	content := "#话题#@张三, 你好"
+	segments := SplitContent(content)
+	want := []ContentSegment{{Text: "#话题#", Topic: "话题"}, {Text: "@张三", Mention: "张三"}, {Text: ", 你好"}}
+	if !reflect.DeepEqual(segments, want) {
+		t.Fatal("切分的结果不对", segments)
+	}
+}
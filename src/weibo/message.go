@@ -0,0 +1,47 @@
+package weibo
+
+// 谁可以给用户发私信
+type MessagePermission string
+
+const (
+	// 所有人
+	MessageAnyone MessagePermission = "anyone"
+	// 只有关注了自己的人
+	MessageFollowers MessagePermission = "followers"
+)
+
+func ParseMessagePermission(s string) (MessagePermission, error) {
+	switch p := MessagePermission(s); p {
+	case MessageAnyone, MessageFollowers:
+		return p, nil
+	}
+	return "", ErrInvalidMessagePermission
+}
+
+// 私信
+type Message struct {
+	ID         int64  `json:"id,string" db:"id"`
+	FromUserID int64  `json:"from_user_id" db:"from_user_id"`
+	ToUserID   int64  `json:"to_user_id" db:"to_user_id"`
+	Content    string `json:"content" db:"content"`
+	CreatedAt  int64  `json:"created_at" db:"created_at"`
+}
+
+// 会话, 两个人之间的私信在双方各有一个会话, 分别记录自己的未读数
+type Conversation struct {
+	ID     int64 `json:"id,string" db:"id"`
+	UserID int64 `json:"user_id" db:"user_id"`
+	PeerID int64 `json:"peer_id" db:"peer_id"` // 对方的用户id
+	// 最后一条私信, 会话列表中显示
+	LastMessageID int64  `json:"last_message_id,string" db:"last_message_id"`
+	LastContent   string `json:"last_content" db:"last_content"`
+	UnreadNum     int32  `json:"unread_num" db:"unread_num"`
+	UpdatedAt     int64  `json:"updated_at" db:"updated_at"`
+}
+
+// 会话和对方的信息
+type ConversationWithUser struct {
+	Conversation
+	PeerAccount string `json:"peer_account" db:"peer_account"`
+	PeerAvatar  string `json:"peer_avatar" db:"peer_avatar"`
+}
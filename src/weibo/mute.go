@@ -0,0 +1,109 @@
+package weibo
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// 关键词的最大长度
+const MaxMuteKeywordLength = 32
+
+// 可以选择的静音时长, 空字符串表示永久静音
+var muteDurations = map[string]time.Duration{
+	"":    0,
+	"1d":  24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+	"30d": 30 * 24 * time.Hour,
+}
+
+func ParseMuteDuration(s string) (time.Duration, error) {
+	d, ok := muteDurations[s]
+	if !ok {
+		return 0, ErrInvalidMuteDuration
+	}
+	return d, nil
+}
+
+// 静音, 被静音的账号和包含关键词的微博不出现在自己的首页和通知中, 对方不会知道
+// 静音账号时MutedUserID不为0, 静音关键词时Keyword不为空
+type Mute struct {
+	ID           int64  `json:"id,string" db:"id"`
+	UserID       int64  `json:"user_id" db:"user_id"`
+	MutedUserID  int64  `json:"muted_user_id" db:"muted_user_id"`
+	MutedAccount string `json:"muted_account" db:"muted_account"`
+	// 保存时转为小写, 匹配时不区分大小写
+	Keyword string `json:"keyword" db:"keyword"`
+	// 过期时间, 0表示永久静音
+	ExpiresAt int64 `json:"expires_at" db:"expires_at"`
+	CreatedAt int64 `json:"created_at" db:"created_at"`
+}
+
+func (m *Mute) Expired(now int64) bool {
+	return m.ExpiresAt != 0 && m.ExpiresAt <= now
+}
+
+// 同一个账号或者同一个关键词只能有一条静音
+func (m *Mute) sameTarget(other *Mute) bool {
+	return m.MutedUserID == other.MutedUserID && m.Keyword == other.Keyword
+}
+
+// 去掉首尾空白并转为小写
+func normalizeMuteKeyword(keyword string) (string, error) {
+	keyword = strings.ToLower(strings.TrimSpace(keyword))
+	if keyword == "" || utf8.RuneCountInString(keyword) > MaxMuteKeywordLength {
+		return "", ErrInvalidMuteKeyword
+	}
+	return keyword, nil
+}
+
+// 按一个用户没有过期的静音过滤微博和通知, 读取时构造一次, 逐条匹配
+type MuteFilter struct {
+	users    map[int64]bool
+	keywords []string
+}
+
+func NewMuteFilter(mutes []*Mute, now int64) *MuteFilter {
+	f := &MuteFilter{users: map[int64]bool{}}
+	for _, mute := range mutes {
+		if mute.Expired(now) {
+			continue
+		}
+		if mute.MutedUserID != 0 {
+			f.users[mute.MutedUserID] = true
+		} else {
+			f.keywords = append(f.keywords, mute.Keyword)
+		}
+	}
+	return f
+}
+
+func (f *MuteFilter) Empty() bool {
+	return len(f.users) == 0 && len(f.keywords) == 0
+}
+
+func (f *MuteFilter) MutesUser(userID int64) bool {
+	return f.users[userID]
+}
+
+// 内容中是否包含静音的关键词
+func (f *MuteFilter) MutesContent(content string) bool {
+	if len(f.keywords) == 0 {
+		return false
+	}
+	content = strings.ToLower(content)
+	for _, keyword := range f.keywords {
+		if strings.Contains(content, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// 作者被静音或者内容包含关键词, 转发的微博同时检查原微博
+func (f *MuteFilter) MutesWeibo(weibo *WeiboWithUser) bool {
+	if f.MutesUser(weibo.UserID) || f.MutesContent(weibo.Content) {
+		return true
+	}
+	return weibo.RepostOf != nil && f.MutesWeibo(weibo.RepostOf)
+}
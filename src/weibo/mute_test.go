@@ -0,0 +1,55 @@
+package weibo
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMuteFilter(t *testing.T) {
+	mutes := []*Mute{
+		{MutedUserID: 2},
+		{Keyword: "剧透"},
+		{Keyword: "spoiler"},
+		// 已经过期的不生效
+		{MutedUserID: 3, ExpiresAt: 100},
+		{Keyword: "过期", ExpiresAt: 100},
+		{MutedUserID: 4, ExpiresAt: 200},
+	}
+	f := NewMuteFilter(mutes, 100)
+
+	tests := []struct {
+		weibo *WeiboWithUser
+		muted bool
+	}{
+		{&WeiboWithUser{Weibo: Weibo{UserID: 1, Content: "普通的微博"}}, false},
+		{&WeiboWithUser{Weibo: Weibo{UserID: 2, Content: "普通的微博"}}, true},
+		{&WeiboWithUser{Weibo: Weibo{UserID: 3, Content: "普通的微博"}}, false},
+		{&WeiboWithUser{Weibo: Weibo{UserID: 4, Content: "普通的微博"}}, true},
+		{&WeiboWithUser{Weibo: Weibo{UserID: 1, Content: "不要剧透!"}}, true},
+		{&WeiboWithUser{Weibo: Weibo{UserID: 1, Content: "Big SPOILER ahead"}}, true},
+		{&WeiboWithUser{Weibo: Weibo{UserID: 1, Content: "已经过期的关键词"}}, false},
+		// 转发的原微博被静音时转发也不显示
+		{&WeiboWithUser{Weibo: Weibo{UserID: 1, Content: "转发"}, RepostOf: &WeiboWithUser{Weibo: Weibo{UserID: 2}}}, true},
+		{&WeiboWithUser{Weibo: Weibo{UserID: 1, Content: "转发"}, RepostOf: &WeiboWithUser{Weibo: Weibo{UserID: 5, Content: "剧透"}}}, true},
+	}
+	for _, test := range tests {
+		if got := f.MutesWeibo(test.weibo); got != test.muted {
+			t.Errorf("用户 %d 的微博 %q 是否静音应该是 %v", test.weibo.UserID, test.weibo.Content, test.muted)
+		}
+	}
+
+	if !NewMuteFilter(nil, 100).Empty() || !NewMuteFilter(mutes[3:5], 100).Empty() {
+		t.Fatal("没有生效的静音时过滤器应该为空")
+	}
+}
+
+func TestNormalizeMuteKeyword(t *testing.T) {
+	if keyword, err := normalizeMuteKeyword("  Spoiler "); err != nil || keyword != "spoiler" {
+		t.Fatal("关键词应该去掉空白并转为小写", keyword, err)
+	}
+	for _, keyword := range []string{"", "   ", strings.Repeat("长", MaxMuteKeywordLength+1)} {
+		if _, err := normalizeMuteKeyword(keyword); err != ErrInvalidMuteKeyword {
+			t.Errorf("%q 不是有效的关键词", keyword)
+		}
+	}
+}
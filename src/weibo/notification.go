@@ -0,0 +1,92 @@
+package weibo
+
+import "fmt"
+
+// 通知的种类
+type NotificationKind string
+
+const (
+	NotifyFollow  NotificationKind = "follow"
+	NotifyLike    NotificationKind = "like"
+	NotifyCollect NotificationKind = "collect"
+	NotifyComment NotificationKind = "comment"
+	NotifyMention NotificationKind = "mention"
+)
+
+// 通知中的动作, 用于生成通知的文字
+var notificationActions = map[NotificationKind]string{
+	NotifyFollow:  "关注了你",
+	NotifyLike:    "赞了你的微博",
+	NotifyCollect: "收藏了你的微博",
+	NotifyComment: "评论了你的微博",
+	NotifyMention: "提到了你",
+}
+
+// 通知, 同一个用户收到的同一种、同一条微博的未读通知聚合成一条
+type Notification struct {
+	ID       int64            `json:"id,string" db:"id"`
+	UserID   int64            `json:"user_id" db:"user_id"` // 接收通知的用户
+	Kind     NotificationKind `json:"kind" db:"kind"`
+	TargetID int64            `json:"target_id,string" db:"target_id"` // 相关的微博id, 关注时为0
+	// 最近一个触发通知的用户
+	ActorID      int64  `json:"actor_id" db:"actor_id"`
+	ActorAccount string `json:"actor_account" db:"actor_account"`
+	// 聚合的次数, 同一个人多次评论按多次计算
+	ActorNum  int32 `json:"actor_num" db:"actor_num"`
+	ReadKey   int64 `json:"-" db:"read_key"` // 未读时为0, 已读时等于id, 保证每个聚合最多一条未读通知
+	CreatedAt int64 `json:"created_at" db:"created_at"`
+	UpdatedAt int64 `json:"updated_at" db:"updated_at"` // 最近一次聚合的时间, 列表按它排序
+}
+
+func (n *Notification) IsRead() bool {
+	return n.ReadKey != 0
+}
+
+// 通知的文字, 例如 "张三等5人赞了你的微博"
+func (n *Notification) Text() string {
+	if n.ActorNum > 1 {
+		return fmt.Sprintf("%s等%d人%s", n.ActorAccount, n.ActorNum, notificationActions[n.Kind])
+	}
+	return n.ActorAccount + notificationActions[n.Kind]
+}
+
+// 聚合到通知中的一个用户, 静音或者屏蔽这个用户之后在读取时从通知中去掉
+type NotificationActor struct {
+	NotificationID int64  `json:"notification_id,string" db:"notification_id"`
+	ActorID        int64  `json:"actor_id" db:"actor_id"`
+	ActorAccount   string `json:"actor_account" db:"actor_account"`
+	// 这个用户触发的次数
+	ActorNum  int32 `json:"actor_num" db:"actor_num"`
+	UpdatedAt int64 `json:"updated_at" db:"updated_at"`
+}
+
+// 去掉隐藏的用户之后重新计算最近的用户和聚合的次数, 全部被隐藏时返回nil
+// actors按最近一次触发的时间倒序, 为空时只按通知中记录的最近一个用户判断
+func (n *Notification) withoutActors(actors []*NotificationActor, hidden func(userID int64) bool) *Notification {
+	if len(actors) == 0 {
+		actors = []*NotificationActor{{NotificationID: n.ID, ActorID: n.ActorID, ActorAccount: n.ActorAccount, ActorNum: n.ActorNum}}
+	}
+	visible := *n
+	visible.ActorNum = 0
+	for _, actor := range actors {
+		if hidden(actor.ActorID) {
+			continue
+		}
+		if visible.ActorNum == 0 {
+			visible.ActorID = actor.ActorID
+			visible.ActorAccount = actor.ActorAccount
+		}
+		visible.ActorNum += actor.ActorNum
+	}
+	if visible.ActorNum == 0 {
+		return nil
+	}
+	return &visible
+}
+
+// 通知列表中的一项
+type NotificationItem struct {
+	*Notification
+	Read bool   `json:"read"`
+	Text string `json:"text"`
+}
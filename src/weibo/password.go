@@ -0,0 +1,192 @@
+package weibo
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 密码哈希算法
+// 生成的哈希是自描述的编码字符串, 其中记录了算法和参数, 例如:
+//
+//	bcrypt:   $2a$10$<salt+hash>
+//	argon2id: $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+type PasswordHasher interface {
+	// 生成密码的哈希
+	Hash(password string) (string, error)
+	// 校验密码是否与哈希匹配, 支持所有已知的算法
+	Verify(encoded, password string) (bool, error)
+	// 已有的哈希是否需要用当前的算法和参数重新生成
+	NeedsRehash(encoded string) bool
+}
+
+var ErrUnknownPasswordHash = errors.New("无法识别的密码哈希格式")
+
+// 校验时接受的argon2id参数上限, 防止损坏的哈希让登录占用过多的内存和CPU
+const (
+	MaxArgon2Time    = 16
+	MaxArgon2Memory  = 1024 * 1024 // 单位KiB
+	MaxArgon2SaltLen = 64
+	MaxArgon2KeyLen  = 64
+)
+
+// bcrypt
+type BcryptHasher struct {
+	Cost int
+}
+
+func NewBcryptHasher(cost int) *BcryptHasher {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		cost = bcrypt.DefaultCost
+	}
+	return &BcryptHasher{Cost: cost}
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	buf, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+func (h *BcryptHasher) Verify(encoded, password string) (bool, error) {
+	return verifyPassword(encoded, password)
+}
+
+func (h *BcryptHasher) NeedsRehash(encoded string) bool {
+	if !isBcryptHash(encoded) {
+		return true
+	}
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.Cost
+}
+
+// argon2id
+type Argon2idHasher struct {
+	Time    uint32
+	Memory  uint32 // 单位KiB
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+// 使用RFC 9106推荐的低内存配置
+func NewArgon2idHasher() *Argon2idHasher {
+	return &Argon2idHasher{
+		Time:    3,
+		Memory:  64 * 1024,
+		Threads: 2,
+		SaltLen: 16,
+		KeyLen:  32,
+	}
+}
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", errors.Wrap(err, "生成随机盐失败")
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Time, h.Memory, h.Threads, h.KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Time, h.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h *Argon2idHasher) Verify(encoded, password string) (bool, error) {
+	return verifyPassword(encoded, password)
+}
+
+func (h *Argon2idHasher) NeedsRehash(encoded string) bool {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return params.Time != h.Time || params.Memory != h.Memory || params.Threads != h.Threads ||
+		uint32(len(salt)) != h.SaltLen || uint32(len(key)) != h.KeyLen
+}
+
+func verifyPassword(encoded, password string) (bool, error) {
+	switch {
+	case isBcryptHash(encoded):
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, nil
+		}
+		return err == nil, err
+
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		params, salt, key, err := decodeArgon2id(encoded)
+		if err != nil {
+			return false, err
+		}
+		other := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+		return subtle.ConstantTimeCompare(key, other) == 1, nil
+	}
+
+	return false, ErrUnknownPasswordHash
+}
+
+func isBcryptHash(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func decodeArgon2id(encoded string) (params *Argon2idHasher, salt, key []byte, err error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, nil, nil, ErrUnknownPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, nil, nil, errors.Errorf("不支持的argon2版本: %s", parts[2])
+	}
+
+	params = &Argon2idHasher{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		return nil, nil, nil, errors.Wrap(err, "argon2参数格式错误")
+	}
+	// argon2.IDKey遇到t=0或者p=0会panic
+	if params.Time == 0 || params.Time > MaxArgon2Time || params.Threads == 0 ||
+		params.Memory < 8*uint32(params.Threads) || params.Memory > MaxArgon2Memory {
+		return nil, nil, nil, errors.Errorf("argon2参数超出范围: %s", parts[3])
+	}
+
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, nil, nil, errors.Wrap(err, "argon2盐格式错误")
+	}
+	if len(salt) == 0 || len(salt) > MaxArgon2SaltLen {
+		return nil, nil, nil, errors.Errorf("argon2盐的长度超出范围: %d", len(salt))
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, nil, nil, errors.Wrap(err, "argon2哈希格式错误")
+	}
+	if len(key) == 0 || len(key) > MaxArgon2KeyLen {
+		return nil, nil, nil, errors.Errorf("argon2哈希的长度超出范围: %d", len(key))
+	}
+	params.SaltLen = uint32(len(salt))
+	params.KeyLen = uint32(len(key))
+	return params, salt, key, nil
+}
+
+// 旧版本用 sha1(password + salt) 保存密码, 盐单独存放在salt字段
+func isLegacyPasswordHash(user *User) bool {
+	return len(user.Salt) > 0 && !strings.HasPrefix(user.Password, "$")
+}
+
+func verifyLegacyPassword(user *User, password string) bool {
+	hash := sha1.New()
+	hash.Write([]byte(password + user.Salt))
+	return subtle.ConstantTimeCompare([]byte(user.Password), []byte(hex.EncodeToString(hash.Sum(nil)))) == 1
+}
@@ -0,0 +1,111 @@
+package weibo
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestPasswordHashers(t *testing.T) {
+	hashers := []PasswordHasher{
+		NewBcryptHasher(4),
+		&Argon2idHasher{Time: 1, Memory: 1024, Threads: 1, SaltLen: 16, KeyLen: 32},
+	}
+
+	for _, hasher := range hashers {
+		encoded, err := hasher.Hash("123123")
+		if err != nil {
+			t.Fatal("生成哈希失败", err)
+		}
+
+		ok, err := hasher.Verify(encoded, "123123")
+		if err != nil || !ok {
+			t.Fatal("正确的密码校验失败", encoded, err)
+		}
+
+		ok, err = hasher.Verify(encoded, "123456")
+		if err != nil || ok {
+			t.Fatal("错误的密码校验通过", encoded, err)
+		}
+
+		if hasher.NeedsRehash(encoded) {
+			t.Fatal("相同参数的哈希不需要重新生成", encoded)
+		}
+	}
+
+	// 参数或者算法变化时需要重新生成
+	encoded, _ := NewBcryptHasher(4).Hash("123123")
+	if !NewBcryptHasher(5).NeedsRehash(encoded) {
+		t.Fatal("bcrypt的cost变化时需要重新生成哈希")
+	}
+	if !NewArgon2idHasher().NeedsRehash(encoded) {
+		t.Fatal("算法变化时需要重新生成哈希")
+	}
+	if ok, err := NewArgon2idHasher().Verify(encoded, "123123"); err != nil || !ok {
+		t.Fatal("应该能校验其它算法生成的哈希", err)
+	}
+}
+
+func TestLoginUpgradesLegacyPassword(t *testing.T) {
+	ctx := context.Background()
+	repo := &MockUserRepository{}
+	hash := sha1.Sum([]byte("123123" + "a1b2c3d4"))
+	repo.CreateUser(ctx, &User{
+		Account:  "legacy",
+		Password: hex.EncodeToString(hash[:]),
+		Salt:     "a1b2c3d4",
+	})
+
+	service := NewService(Dependencies{Repositories: Repositories{Users: repo}, Hasher: NewBcryptHasher(4)})
+	if _, err := service.Login(ctx, "legacy", "123456"); err == nil {
+		t.Fatal("错误的密码不应该登录成功")
+	}
+	if repo.users["legacy"].Salt == "" {
+		t.Fatal("登录失败时不应该升级密码哈希")
+	}
+
+	if _, err := service.Login(ctx, "legacy", "123123"); err != nil {
+		t.Fatal("旧的sha1密码登录失败", err)
+	}
+
+	user := repo.users["legacy"]
+	if user.Salt != "" || !strings.HasPrefix(user.Password, "$2a$") {
+		t.Fatal("登录成功后密码哈希没有升级", user.Password)
+	}
+
+	if _, err := service.Login(ctx, "legacy", "123123"); err != nil {
+		t.Fatal("升级后的密码登录失败", err)
+	}
+}
+
+func TestLoginWithBrokenPasswordHash(t *testing.T) {
+	ctx := context.Background()
+	repo := &MockUserRepository{}
+	service := NewService(Dependencies{Repositories: Repositories{Users: repo}, Hasher: NewBcryptHasher(4)})
+
+	// 算法不认识和参数损坏的哈希都按密码错误处理
+	salt, key := "c2FsdHNhbHRzYWx0c2FsdA", "a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U"
+	for i, password := range []string{
+		"$unknown$xxx",
+		"$argon2id$v=19$m=x$salt$key",
+		"$2a$10$short",
+		// argon2.IDKey会panic或者占用过多资源的参数
+		"$argon2id$v=19$m=65536,t=0,p=2$" + salt + "$" + key,
+		"$argon2id$v=19$m=65536,t=3,p=0$" + salt + "$" + key,
+		"$argon2id$v=19$m=8,t=3,p=2$" + salt + "$" + key,
+		"$argon2id$v=19$m=4294967295,t=3,p=2$" + salt + "$" + key,
+		"$argon2id$v=19$m=65536,t=1000000,p=2$" + salt + "$" + key,
+		"$argon2id$v=19$m=65536,t=3,p=2$$" + key,
+		"$argon2id$v=19$m=65536,t=3,p=2$" + salt + "$",
+		"$argon2id$v=19$m=65536,t=3,p=2$" + strings.Repeat("c2Fs", 30) + "$" + key,
+		"$argon2id$v=19$m=65536,t=3,p=2$" + salt + "$" + strings.Repeat("a2V5", 100),
+	} {
+		account := "broken" + string(rune('a'+i))
+		repo.CreateUser(ctx, &User{Account: account, Password: password})
+		if _, err := service.Login(ctx, account, "123123"); err != ErrWrongPassword {
+			t.Fatal("无法校验的密码哈希应该返回密码错误", password, err)
+		}
+	}
+}
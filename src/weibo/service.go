@@ -1,10 +1,7 @@
 package weibo
 
 import (
-	"crypto/rand"
-	"crypto/sha1"
-	"encoding/hex"
-	"log"
+	"context"
 	"time"
 
 	"github.com/pkg/errors"
@@ -15,427 +12,1927 @@ type Service struct {
 	userRepo     UserRepository
 	timelineRepo TimeLineRepository
 	weiboRepo    WeiboRepository
+	commentRepo  CommentRepository
+	topicRepo    TopicRepository
+	mentionRepo  MentionRepository
+	noticeRepo   NotificationRepository
+	messageRepo  MessageRepository
+	blockRepo    BlockRepository
+	muteRepo     MuteRepository
+	uow          UnitOfWork
+	hasher       PasswordHasher
+	fanoutQueue  FanoutQueue
+	ids          IDGenerator
+	trends       *Trending
+	events       EventBroker
+
+	// 粉丝数达到这个值的用户发布微博时不再推送给粉丝, 由粉丝读取时拉取
+	PullThreshold int32
+	// 关注时复制到自己timeline中的对方最近的微博数
+	FollowBackfill int32
+	// 首页展示的粉丝数
+	FollowerPreviewSize int64
+	// 首页展示的话题数
+	TopicPreviewSize int64
+	// 每个用户最多静音的账号和关键词数
+	MaxMutes int
+	// 读取一页首页timeline时为补齐被屏蔽和静音的微博最多扫描的条数
+	TimelineScanLimit int64
 }
 
-func NewService(userRepo UserRepository, weiboRepo WeiboRepository, timelineRepo TimeLineRepository) *Service {
+// 服务依赖的仓库和组件, 只用到一部分功能时(例如测试)其余的可以不填
+type Dependencies struct {
+	Repositories
+	UnitOfWork  UnitOfWork
+	Hasher      PasswordHasher
+	FanoutQueue FanoutQueue
+	IDs         IDGenerator
+	Trends      *Trending
+	Events      EventBroker
+}
+
+func NewService(deps Dependencies) *Service {
 	return &Service{
-		userRepo:     userRepo,
-		timelineRepo: timelineRepo,
-		weiboRepo:    weiboRepo,
+		userRepo:     deps.Users,
+		timelineRepo: deps.TimeLines,
+		weiboRepo:    deps.Weibos,
+		commentRepo:  deps.Comments,
+		topicRepo:    deps.Topics,
+		mentionRepo:  deps.Mentions,
+		noticeRepo:   deps.Notifications,
+		messageRepo:  deps.Messages,
+		blockRepo:    deps.Blocks,
+		muteRepo:     deps.Mutes,
+		uow:          deps.UnitOfWork,
+		hasher:       deps.Hasher,
+		fanoutQueue:  deps.FanoutQueue,
+		ids:          deps.IDs,
+		trends:       deps.Trends,
+		events:       deps.Events,
+
+		PullThreshold:       10000,
+		FollowBackfill:      30,
+		FollowerPreviewSize: 20,
+		TopicPreviewSize:    10,
+		MaxMutes:            100,
+		TimelineScanLimit:   200,
 	}
 }
 
 // 注册功能
-func (s *Service) Register(account, avatar, password string) (*User, error) {
-	existsUser, err := s.userRepo.GetUserByAccount(account)
+func (s *Service) Register(ctx context.Context, account, avatar, password string) (*User, error) {
+	existsUser, err := s.userRepo.GetUserByAccount(ctx, account)
 	if err != nil {
 		return nil, errors.Wrap(err, "查询同名账号失败")
 	}
 
 	if existsUser != nil {
-		return nil, errors.New("账号名已经被使用了")
+		return nil, ErrAccountExists
 	}
 
 	user := &User{
-		Account:   account,
-		Avatar:    avatar,
-		CreatedAt: time.Now().Unix(),
+		Account:           account,
+		Avatar:            avatar,
+		MessagePermission: MessageAnyone,
+		CreatedAt:         time.Now().Unix(),
 	}
 
-	// 生成随机的盐
-	buf := make([]byte, 4)
-	rand.Read(buf)
-	user.Salt = hex.EncodeToString(buf)
-
-	// 用密码加盐生成哈希
-	hash := sha1.New()
-	hash.Write([]byte(password + user.Salt))
-	buf = hash.Sum(nil)
-	user.Password = hex.EncodeToString(buf)
+	// 哈希中已经包含了随机盐, 不再单独保存
+	hash, err := s.hasher.Hash(password)
+	if err != nil {
+		return nil, errors.Wrap(err, "生成密码哈希失败")
+	}
+	user.Password = hash
 
-	if err := s.userRepo.CreateUser(user); err != nil {
+	if err := s.userRepo.CreateUser(ctx, user); err != nil {
 		return nil, errors.Wrap(err, "保存用户信息失败")
 	}
 
 	return user, nil
 }
 
-func (s *Service) Login(account, password string) (user *User, err error) {
-	existsUser, err := s.userRepo.GetUserByAccount(account)
+func (s *Service) Login(ctx context.Context, account, password string) (user *User, err error) {
+	existsUser, err := s.userRepo.GetUserByAccount(ctx, account)
 	if err != nil {
 		return nil, err
 	}
 
 	if existsUser == nil {
-		return nil, errors.New("用户不存在")
+		return nil, ErrUserNotFound
+	}
+
+	var ok bool
+	if isLegacyPasswordHash(existsUser) {
+		ok = verifyLegacyPassword(existsUser, password)
+	} else {
+		ok, err = s.hasher.Verify(existsUser.Password, password)
+		if err != nil {
+			// 保存的哈希损坏或者算法不认识, 当作密码错误, 不把内部错误返回给客户端
+			logf(ctx, "用户 %d 的密码哈希无法校验: %v\n", existsUser.ID, err)
+			return nil, ErrWrongPassword
+		}
+	}
+
+	if !ok {
+		return nil, ErrWrongPassword
 	}
 
-	hash := sha1.New()
-	hash.Write([]byte(password + existsUser.Salt))
-	if existsUser.Password == hex.EncodeToString(hash.Sum(nil)) {
-		return existsUser, nil
+	// 旧的sha1哈希或者参数过时的哈希, 在登录成功时用当前算法重新生成
+	if isLegacyPasswordHash(existsUser) || s.hasher.NeedsRehash(existsUser.Password) {
+		if err := s.rehashPassword(ctx, existsUser, password); err != nil {
+			logf(ctx, "用户 %d 的密码哈希升级失败: %v\n", existsUser.ID, err)
+		}
 	}
 
-	return nil, errors.New("密码错误")
+	return existsUser, nil
 }
 
-func (s *Service) Follow(user *User, targetUserID int64) error {
-	targetUser, err := s.userRepo.GetUserByID(targetUserID)
+func (s *Service) rehashPassword(ctx context.Context, user *User, password string) error {
+	hash, err := s.hasher.Hash(password)
 	if err != nil {
-		return errors.Wrap(err, "查询目标用户失败")
+		return err
 	}
-	if targetUser == nil {
-		return errors.New("要关注的用户不存在")
+
+	if err := s.userRepo.UpdatePassword(ctx, user.ID, hash, ""); err != nil {
+		return err
+	}
+
+	user.Password = hash
+	user.Salt = ""
+	return nil
+}
+
+func (s *Service) Follow(ctx context.Context, user *User, targetUserID int64) error {
+	if user.ID == targetUserID {
+		return ErrFollowSelf
 	}
 
-	following, err := s.userRepo.GetFollowing(user.ID, targetUserID)
+	targetUser, err := s.userRepo.GetUserByID(ctx, targetUserID)
 	if err != nil {
-		return errors.Wrap(err, "无法查询当前用户是否已关注过目标用户")
+		return errors.Wrap(err, "查询目标用户失败")
 	}
-	if following != nil {
-		return errors.New("关注过目标用户")
+	if targetUser == nil {
+		return ErrUserNotFound
 	}
 
-	following = &Following{
-		FromUserID: user.ID,
-		ToUserID:   targetUserID,
+	return s.do(ctx, func(repos *Repositories, out *outbox) error {
+		// 和屏蔽在同一个事务中检查, 屏蔽之后不会再关注成功
+		if err := checkBlocked(ctx, repos.Blocks, user.ID, targetUserID); err != nil {
+			return err
+		}
+		following, err := repos.Users.GetFollowing(ctx, user.ID, targetUserID)
+		if err != nil {
+			return errors.Wrap(err, "无法查询当前用户是否已关注过目标用户")
+		}
+		if following != nil {
+			return ErrAlreadyFollowing
+		}
+
+		following = &Following{
+			FromUserID: user.ID,
+			ToUserID:   targetUserID,
+			CreatedAt:  time.Now().Unix(),
+		}
+		if err := repos.Users.CreateFollowing(ctx, following); err != nil {
+			return errors.Wrap(err, "关注信息保存失败")
+		}
+
+		if err := repos.Users.AddFollowingNumByUserID(ctx, user.ID, 1); err != nil {
+			return errors.Wrap(err, "用户所关注的人数增加失败")
+		}
+
+		// 拉模式账号的微博在读取时合并, 不需要写入timeline
+		if !IsPullAccount(targetUser, s.PullThreshold) {
+			timeLines, err := repos.TimeLines.GetRecentlyWeiboIDsByUserID(ctx, targetUserID, s.FollowBackfill)
+			if err != nil {
+				return errors.Wrap(err, "目标用户最近的微博获取失败")
+			}
+
+			for _, timeline := range timeLines {
+				timeline.UserID = user.ID
+				if timeline.ID, err = s.ids.NextID(); err != nil {
+					return errors.Wrap(err, "生成timeline的id失败")
+				}
+			}
+			if err := repos.TimeLines.BatchCreateTimeLines(ctx, timeLines); err != nil {
+				return errors.Wrap(err, "当前用户的时间线更新失败")
+			}
+		}
+
+		if err := repos.Users.AddFollowerNumByUserID(ctx, targetUserID, 1); err != nil {
+			return errors.Wrap(err, "用户的粉丝数增加失败")
+		}
+
+		return s.notify(ctx, repos, out, targetUserID, NotifyFollow, 0, user, "", following.CreatedAt)
+	})
+}
+
+func (s *Service) UnFollow(ctx context.Context, user *User, toUserID int64) error {
+	return s.uow.Do(ctx, func(repos *Repositories) error {
+		following, err := repos.Users.GetFollowing(ctx, user.ID, toUserID)
+		if err != nil {
+			return errors.Wrap(err, "无法查询当前用户是否已关注过目标用户")
+		}
+		if following == nil {
+			return ErrNotFollowing
+		}
+		return unfollow(ctx, repos, following)
+	})
+}
+
+// 删除关注关系, 同时更新双方的计数和关注者的timeline
+func unfollow(ctx context.Context, repos *Repositories, following *Following) error {
+	// 删除关注关系
+	if err := repos.Users.DeleteFollowing(ctx, following); err != nil {
+		return errors.Wrap(err, "删除关注关系失败")
+	}
+
+	// 更新关注数和粉丝数
+	if err := repos.Users.AddFollowingNumByUserID(ctx, following.FromUserID, -1); err != nil {
+		return errors.Wrap(err, "用户关注人数减少失败")
 	}
-	if err := s.userRepo.CreateFollowing(following); err != nil {
-		return errors.Wrap(err, "关注信息保存失败")
+
+	if err := repos.Users.AddFollowerNumByUserID(ctx, following.ToUserID, -1); err != nil {
+		return errors.Wrap(err, "用户的粉丝取消关注失败")
 	}
 
-	if err := s.userRepo.AddFollowingNumByUserID(user.ID, 1); err != nil {
-		return errors.Wrap(err, "用户所关注的人数增加失败")
+	// 从当前用户的timeline中删除被关注者的微博
+	if err := repos.TimeLines.DeleteWeiboByUserIDAndWeiboUserID(ctx, following.FromUserID, following.ToUserID); err != nil {
+		return errors.Wrap(err, "从当前用户的timeline中删除被关注者的微博失败")
 	}
 
-	timeLines, err := s.timelineRepo.GetRecentlyWeiboIDsByUserID(targetUserID, 30)
+	return nil
+}
+
+// 双方之间任意一方屏蔽了另一方时返回ErrBlocked, 要在写入关系的事务中用事务中的仓库检查
+func checkBlocked(ctx context.Context, blocks BlockRepository, userID, otherUserID int64) error {
+	if userID == otherUserID {
+		return nil
+	}
+	blocked, err := blocks.IsBlocked(ctx, userID, otherUserID)
 	if err != nil {
-		return errors.Wrap(err, "目标用户最近的微博获取失败")
+		return errors.Wrap(err, "查询屏蔽关系失败")
 	}
+	if blocked {
+		return ErrBlocked
+	}
+	return nil
+}
 
-	for _, timeline := range timeLines {
-		timeline.UserID = user.ID
+// 和用户有屏蔽关系的人, 列表中过滤掉他们的内容, 事务中要传入事务中的仓库
+func blockedUsers(ctx context.Context, blocks BlockRepository, userID int64) (map[int64]bool, error) {
+	userIDs, err := blocks.GetBlockRelatedUserIDs(ctx, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询屏蔽关系失败")
 	}
-	if err := s.timelineRepo.BatchCreateTimeLines(timeLines); err != nil {
-		return errors.Wrap(err, "当前用户的时间线更新失败")
+	blocked := make(map[int64]bool, len(userIDs))
+	for _, id := range userIDs {
+		blocked[id] = true
 	}
+	return blocked, nil
+}
 
-	if err := s.userRepo.AddFollowerNumByUserID(targetUserID, 1); err != nil {
-		return errors.Wrap(err, "用户的粉丝数增加失败")
+// 和当前登录用户有屏蔽关系的人, 没有登录时不过滤
+func (s *Service) viewerBlockedUsers(ctx context.Context) (map[int64]bool, error) {
+	viewer := Viewer(ctx)
+	if viewer == nil {
+		return nil, nil
 	}
+	return blockedUsers(ctx, s.blockRepo, viewer.ID)
+}
 
-	return nil
+// 用户当前生效的静音, 事务中要传入事务中的仓库
+func muteFilter(ctx context.Context, mutes MuteRepository, userID int64) (*MuteFilter, error) {
+	list, err := mutes.GetMutesByUserID(ctx, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询静音列表失败")
+	}
+	return NewMuteFilter(list, time.Now().Unix()), nil
 }
 
-func (s *Service) UnFollow(user *User, toUserID int64) error {
-	following, err := s.userRepo.GetFollowing(user.ID, toUserID)
+// 按用户分组构造静音过滤, 没有静音的用户得到空的过滤
+func muteFilters(ctx context.Context, mutes MuteRepository, userIDs []int64) (map[int64]*MuteFilter, error) {
+	list, err := mutes.GetMutesByUserIDs(ctx, userIDs)
 	if err != nil {
-		return errors.Wrap(err, "无法查询当前用户是否已关注过目标用户")
+		return nil, errors.Wrap(err, "查询静音列表失败")
+	}
+	byUser := make(map[int64][]*Mute, len(userIDs))
+	for _, mute := range list {
+		byUser[mute.UserID] = append(byUser[mute.UserID], mute)
 	}
-	if following != nil {
-		return errors.New("关注过目标用户")
+	now := time.Now().Unix()
+	filters := make(map[int64]*MuteFilter, len(userIDs))
+	for _, userID := range userIDs {
+		filters[userID] = NewMuteFilter(byUser[userID], now)
 	}
+	return filters, nil
+}
 
-	// 删除关注关系
-	if err := s.userRepo.DeleteFollowing(following); err != nil {
-		return errors.Wrap(err, "删除关注关系失败")
+func (s *Service) PublishWeibo(ctx context.Context, user *User, weibo *Weibo) error {
+	// 数据有效性的检查
+	if len(weibo.Content) == 0 {
+		return ErrEmptyContent
 	}
+	return s.publish(ctx, user, weibo, nil)
+}
 
-	// 更新关注数和粉丝数
-	if err := s.userRepo.AddFollowingNumByUserID(user.ID, -1); err != nil {
-		return errors.Wrap(err, "用户关注人数减少失败")
+// 转发微博, comment是转发时附带的评论, 可以为空
+// 转发的微博也是一条微博, 和发布的微博一样推送给粉丝
+func (s *Service) Repost(ctx context.Context, user *User, weiboID int64, comment string) (*Weibo, error) {
+	original, err := s.weiboRepo.GetWeiboByID(ctx, weiboID)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询微博失败")
+	}
+	if original == nil {
+		return nil, ErrWeiboNotFound
 	}
 
-	if err := s.userRepo.AddFollowingNumByUserID(toUserID, -1); err != nil {
-		return errors.Wrap(err, "用户的粉丝取消关注失败")
+	// 转发一条转发的微博时, 转发链的起点仍然是最初的原创微博
+	rootID := original.ID
+	if original.IsRepost() {
+		rootID = original.RepostRootID
 	}
 
-	// 从当前用户的timeline中删除被关注者的微博
-	if err := s.timelineRepo.DeleteWeiboByUserIDAndWeiboUserID(user.ID, toUserID); err != nil {
-		return errors.Wrap(err, "从当前用户的timeline中删除被关注者的微博失败")
+	weibo := &Weibo{
+		UserID:       user.ID,
+		Account:      user.Account,
+		Content:      comment,
+		RepostOfID:   original.ID,
+		RepostRootID: rootID,
+		CreatedAt:    time.Now().Unix(),
+	}
+	err = s.publish(ctx, user, weibo, func(repos *Repositories) error {
+		if err := checkBlocked(ctx, repos.Blocks, user.ID, original.UserID); err != nil {
+			return err
+		}
+		return addRepostNum(ctx, repos, weibo, 1)
+	})
+	if err != nil {
+		return nil, err
 	}
+	return weibo, nil
+}
 
+// 转发数同时计在直接转发的微博和转发链最初的原创微博上
+func addRepostNum(ctx context.Context, repos *Repositories, weibo *Weibo, num int32) error {
+	if err := repos.Weibos.AddRepostNumByWeiboID(ctx, weibo.RepostOfID, num); err != nil {
+		return errors.Wrap(err, "更新转发数失败")
+	}
+	if weibo.RepostRootID != weibo.RepostOfID {
+		if err := repos.Weibos.AddRepostNumByWeiboID(ctx, weibo.RepostRootID, num); err != nil {
+			return errors.Wrap(err, "更新原微博的转发数失败")
+		}
+	}
 	return nil
 }
 
-func (s *Service) PublishWeibo(user *User, weibo *Weibo) error {
-	// 数据有效性的检查
-	//weiboID, err := s.userRepo.ExamineData(user.ID)
+// 保存微博内容中的话题和微博的关联, 返回话题的id
+func addTopics(ctx context.Context, repos *Repositories, weibo *Weibo) ([]int64, error) {
+	names := ParseTopics(weibo.Content)
+	if len(names) == 0 {
+		return nil, nil
+	}
 
-	// 把微博插入到数据库， 成功后获取微博的id
-	weiboID, err := s.weiboRepo.InsertWeibo(weibo)
+	topics, err := repos.Topics.CreateTopics(ctx, names, weibo.CreatedAt)
 	if err != nil {
-		return errors.Wrap(err, "获取微博id失败")
+		return nil, errors.Wrap(err, "保存话题失败")
+	}
+	topicIDs := make([]int64, 0, len(topics))
+	weiboTopics := make([]*WeiboTopic, 0, len(topics))
+	for _, topic := range topics {
+		topicIDs = append(topicIDs, topic.ID)
+		weiboTopics = append(weiboTopics, &WeiboTopic{TopicID: topic.ID, WeiboID: weibo.ID, WeiboCreatedAt: weibo.CreatedAt})
+	}
+	if err := repos.Topics.CreateWeiboTopics(ctx, weiboTopics); err != nil {
+		return nil, errors.Wrap(err, "保存微博的话题失败")
+	}
+	if err := repos.Topics.AddWeiboNumByTopicIDs(ctx, topicIDs, 1); err != nil {
+		return nil, errors.Wrap(err, "增加话题的微博数失败")
+	}
+	return topicIDs, nil
+}
+
+// 保存内容中提到用户的记录, 不存在的账号和作者自己忽略
+func (s *Service) addMentions(ctx context.Context, repos *Repositories, out *outbox, author *User, weiboID, commentID int64, content string, createdAt int64) error {
+	accounts := ParseMentions(content)
+	if len(accounts) == 0 {
+		return nil
+	}
+
+	users, err := repos.Users.GetUsersByAccounts(ctx, accounts)
+	if err != nil {
+		return errors.Wrap(err, "查询提到的用户失败")
 	}
+	// 和作者有屏蔽关系的人不会收到提到
+	blocked, err := blockedUsers(ctx, repos.Blocks, author.ID)
+	if err != nil {
+		return err
+	}
+	mentions := make([]*Mention, 0, len(users))
+	for _, user := range users {
+		if user.ID == author.ID || blocked[user.ID] {
+			continue
+		}
+		mentionID, err := s.ids.NextID()
+		if err != nil {
+			return errors.Wrap(err, "生成提到用户记录的id失败")
+		}
+		mentions = append(mentions, &Mention{
+			ID:        mentionID,
+			UserID:    user.ID,
+			AuthorID:  author.ID,
+			WeiboID:   weiboID,
+			CommentID: commentID,
+			CreatedAt: createdAt,
+		})
+	}
+	if len(mentions) == 0 {
+		return nil
+	}
+	if err := repos.Mentions.CreateMentions(ctx, mentions); err != nil {
+		return errors.Wrap(err, "保存提到用户的记录失败")
+	}
+	for _, mention := range mentions {
+		if err := s.notify(ctx, repos, out, mention.UserID, NotifyMention, weiboID, author, content, createdAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	// 在自己的timeline中增加这条微博
-	newTimeline := &TimeLine{
-		UserID:         user.ID,
-		WeiboUserID:    user.ID,
-		WeiboID:        weiboID,
-		WeiboCreatedAt: weibo.CreatedAt,
+// 给用户发送通知, 聚合到同一条未读通知中, 用户自己触发的不通知
+// content是评论或者提到用户的内容, 接收者静音了触发者或者内容中的关键词时也不通知
+func (s *Service) notify(ctx context.Context, repos *Repositories, out *outbox, userID int64, kind NotificationKind, targetID int64, actor *User, content string, createdAt int64) error {
+	if userID == actor.ID {
+		return nil
 	}
-	if err := s.timelineRepo.CreateTimeLine(newTimeline); err != nil {
-		return errors.Wrap(err, "保存微博到当前用户的timeline失败")
+	mutes, err := muteFilter(ctx, repos.Mutes, userID)
+	if err != nil {
+		return err
+	}
+	if mutes.MutesUser(actor.ID) || mutes.MutesContent(content) {
+		return nil
+	}
+	notificationID, err := s.ids.NextID()
+	if err != nil {
+		return errors.Wrap(err, "生成通知id失败")
+	}
+	n := &Notification{
+		ID:           notificationID,
+		UserID:       userID,
+		Kind:         kind,
+		TargetID:     targetID,
+		ActorID:      actor.ID,
+		ActorAccount: actor.Account,
+		ActorNum:     1,
+		CreatedAt:    createdAt,
+		UpdatedAt:    createdAt,
+	}
+	if err := repos.Notifications.AddNotification(ctx, n); err != nil {
+		return errors.Wrap(err, "保存通知失败")
+	}
+	out.notifications = append(out.notifications, n)
+	return nil
+}
+
+// 事务中产生的需要推送给在线用户的事件, 事务提交之后再推送
+type outbox struct {
+	notifications []*Notification
+	timelines     []*TimeLine
+	// 未读私信数变化的用户
+	unread []int64
+}
+
+// 在事务中执行fn, 提交成功之后推送fn中产生的事件
+func (s *Service) do(ctx context.Context, fn func(repos *Repositories, out *outbox) error) error {
+	out := &outbox{}
+	if err := s.uow.Do(ctx, func(repos *Repositories) error { return fn(repos, out) }); err != nil {
+		return err
 	}
+	s.push(ctx, out)
+	return nil
+}
 
-	// 增加自己的微博数量
-	if err := s.userRepo.AddWeiboNumByUserID(user.ID, 1); err != nil {
-		return errors.Wrap(err, "用户的微博数增加失败")
+// 推送事件失败不影响已经提交的操作, 只记录日志, 客户端刷新时仍然能看到
+func (s *Service) push(ctx context.Context, out *outbox) {
+	if s.events == nil {
+		return
 	}
 
-	// 查询当前用户的粉丝， 这里只查id就可以了
-	followings, err := s.userRepo.GetUserFollowers(user.ID)
+	var events []*Event
+	add := func(userID int64, typ EventType, data interface{}) error {
+		id, err := s.ids.NextID()
+		if err != nil {
+			return errors.Wrap(err, "生成事件id失败")
+		}
+		event, err := NewEvent(id, userID, typ, data)
+		if err != nil {
+			return err
+		}
+		events = append(events, event)
+		return nil
+	}
+	err := func() error {
+		for _, timeline := range out.timelines {
+			if err := add(timeline.UserID, EventTimeline, timeline); err != nil {
+				return err
+			}
+		}
+
+		unread := append([]int64{}, out.unread...)
+		for _, n := range out.notifications {
+			if err := add(n.UserID, EventNotification, &NotificationItem{Notification: n, Read: n.IsRead(), Text: n.Text()}); err != nil {
+				return err
+			}
+			unread = append(unread, n.UserID)
+		}
+		pushed := make(map[int64]bool, len(unread))
+		for _, userID := range unread {
+			if pushed[userID] {
+				continue
+			}
+			pushed[userID] = true
+			count, err := s.unreadCount(ctx, userID)
+			if err != nil {
+				return err
+			}
+			if err := add(userID, EventUnreadCount, count); err != nil {
+				return err
+			}
+		}
 
-	// 遍历粉丝，向他们的timeline中增加这条微博
-	for _, following := range followings {
-		newTimeline.UserID = following.FromUserID
-		if err := s.timelineRepo.CreateTimeLine(newTimeline); err != nil {
-			log.Printf("时间线插入失败: %+v\n", newTimeline)
+		if len(events) == 0 {
+			return nil
 		}
+		return s.events.Publish(ctx, events...)
+	}()
+	if err != nil {
+		logf(ctx, "推送事件失败: %v\n", err)
 	}
+}
 
-	return nil
+func (s *Service) unreadCount(ctx context.Context, userID int64) (*UnreadCount, error) {
+	notifications, err := s.noticeRepo.CountUnreadNotifications(ctx, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询未读通知数失败")
+	}
+	messages, err := s.messageRepo.CountUnreadMessages(ctx, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询未读私信数失败")
+	}
+	return &UnreadCount{Notifications: notifications, Messages: messages}, nil
 }
 
-func (s *Service) DeleteWeibo(user *User, weiboID int64) error {
-	//从数据库中查找是否有这条微博id
-	weibo, err := s.weiboRepo.GetWeiboByID(weiboID)
+// 删除微博和话题的关联, 话题本身保留
+func removeTopics(ctx context.Context, repos *Repositories, weiboID int64) error {
+	topics, err := repos.Topics.GetTopicsByWeiboID(ctx, weiboID)
 	if err != nil {
-		return errors.Wrap(err, "获取微博id失败")
+		return errors.Wrap(err, "查询微博的话题失败")
 	}
-	if weibo == nil || weibo.UserID != user.ID {
-		return errors.New("微博不存在")
+	if len(topics) == 0 {
+		return nil
 	}
 
-	//在自己的微博列表中删除这条微博
-	if err = s.weiboRepo.DeleteWeibo(weibo); err != nil {
-		return errors.Wrap(err, "删除微博失败")
+	topicIDs := make([]int64, 0, len(topics))
+	for _, topic := range topics {
+		topicIDs = append(topicIDs, topic.ID)
+	}
+	if err := repos.Topics.DeleteWeiboTopicsByWeiboID(ctx, weiboID); err != nil {
+		return errors.Wrap(err, "删除微博的话题失败")
+	}
+	if err := repos.Topics.AddWeiboNumByTopicIDs(ctx, topicIDs, -1); err != nil {
+		return errors.Wrap(err, "减少话题的微博数失败")
 	}
+	return nil
+}
 
-	//减少发布的微博数量
-	if err = s.userRepo.AddWeiboNumByUserID(user.ID, -1); err != nil {
-		return errors.Wrap(err, "用户的微博数减少失败")
+// 保存微博并推送给粉丝, fn在同一个事务中执行
+func (s *Service) publish(ctx context.Context, user *User, weibo *Weibo, fn func(repos *Repositories) error) error {
+	// id在插入之前生成, 保存微博和timeline时已经知道微博的id
+	weiboID, err := s.ids.NextID()
+	if err != nil {
+		return errors.Wrap(err, "生成微博id失败")
+	}
+	timelineID, err := s.ids.NextID()
+	if err != nil {
+		return errors.Wrap(err, "生成timeline的id失败")
 	}
+	weibo.ID = weiboID
 
-	//把全部粉丝中的这条微博删掉
-	followers, err := s.userRepo.GetUserFollowers(user.ID)
+	// session中的用户信息可能是旧的, 重新查询粉丝数
+	author, err := s.userRepo.GetUserByID(ctx, user.ID)
 	if err != nil {
-		return errors.Wrap(err, "获取粉丝信息失败")
+		return errors.Wrap(err, "查询用户信息失败")
 	}
+	weibo.Pulled = author != nil && IsPullAccount(author, s.PullThreshold)
+
+	var topicIDs []int64
+	err = s.do(ctx, func(repos *Repositories, out *outbox) error {
+		if err := repos.Weibos.InsertWeibo(ctx, weibo); err != nil {
+			return errors.Wrap(err, "保存微博失败")
+		}
+
+		// 在自己的timeline中增加这条微博
+		newTimeline := &TimeLine{
+			ID:             timelineID,
+			UserID:         user.ID,
+			WeiboUserID:    user.ID,
+			WeiboID:        weiboID,
+			WeiboCreatedAt: weibo.CreatedAt,
+		}
+		if err := repos.TimeLines.CreateTimeLine(ctx, newTimeline); err != nil {
+			return errors.Wrap(err, "保存微博到当前用户的timeline失败")
+		}
+		// 推送到自己的其他连接, 和读取首页时一样按自己的静音过滤
+		saved, err := weiboWithRepost(ctx, repos.Weibos, weiboID)
+		if err != nil {
+			return err
+		}
+		mutes, err := muteFilter(ctx, repos.Mutes, user.ID)
+		if err != nil {
+			return err
+		}
+		if saved != nil && !mutes.MutesWeibo(saved) {
+			out.timelines = append(out.timelines, newTimeline)
+		}
+
+		// 增加自己的微博数量
+		if err := repos.Users.AddWeiboNumByUserID(ctx, user.ID, 1); err != nil {
+			return errors.Wrap(err, "用户的微博数增加失败")
+		}
+
+		if topicIDs, err = addTopics(ctx, repos, weibo); err != nil {
+			return err
+		}
+		if err := s.addMentions(ctx, repos, out, user, weibo.ID, 0, weibo.Content, weibo.CreatedAt); err != nil {
+			return err
+		}
 
-	for _, follower := range followers {
-		if err := s.timelineRepo.DeleteWeiboByUserIDAndWeiboID(follower.FromUserID, weiboID); err != nil {
-			log.Printf("删除用户 %d 的timeline中的微博 %d 失败\n", follower.FromUserID, weiboID)
+		if fn != nil {
+			return fn(repos)
 		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	if err := s.timelineRepo.DeleteWeiboByUserIDAndWeiboID(user.ID, weiboID); err != nil {
-		log.Printf("删除用户 %d 的timeline中的微博 %d 失败\n", user.ID, weiboID)
+	s.recordTrend(ctx, func(t *Trending) error {
+		if err := t.RecordTopics(ctx, topicIDs); err != nil {
+			return err
+		}
+		if !weibo.IsRepost() {
+			return nil
+		}
+		repostIDs := []int64{weibo.RepostOfID}
+		if weibo.RepostRootID != weibo.RepostOfID {
+			repostIDs = append(repostIDs, weibo.RepostRootID)
+		}
+		return t.RecordRepost(ctx, repostIDs)
+	})
+
+	if weibo.Pulled {
+		return nil
 	}
+
+	// 向粉丝的timeline中增加这条微博由后台任务完成
+	s.enqueueFanout(ctx, NewFanoutJob(FanoutPublish, weibo))
 	return nil
 }
 
-func (s *Service) Givelike(user *User, weiboID int64) error {
-	weibo, err := s.weiboRepo.GetWeiboByID(weiboID)
+func (s *Service) DeleteWeibo(ctx context.Context, user *User, weiboID int64) error {
+	//从数据库中查找是否有这条微博id
+	weibo, err := s.weiboRepo.GetWeiboByID(ctx, weiboID)
 	if err != nil {
-		return errors.Wrap(err, "查询微博失败")
+		return errors.Wrap(err, "获取微博id失败")
 	}
-
 	if weibo == nil {
-		return errors.Wrap(err, "这条微博不存在")
+		return ErrWeiboNotFound
 	}
+	if weibo.UserID != user.ID {
+		return ErrNotWeiboOwner
+	}
+
+	err = s.uow.Do(ctx, func(repos *Repositories) error {
+		//在自己的微博列表中删除这条微博
+		if err := repos.Weibos.DeleteWeibo(ctx, weibo); err != nil {
+			return errors.Wrap(err, "删除微博失败")
+		}
+
+		//减少发布的微博数量
+		if err := repos.Users.AddWeiboNumByUserID(ctx, user.ID, -1); err != nil {
+			return errors.Wrap(err, "用户的微博数减少失败")
+		}
+
+		if err := repos.TimeLines.DeleteWeiboByUserIDAndWeiboID(ctx, user.ID, weiboID); err != nil {
+			return errors.Wrapf(err, "删除用户 %d 的timeline中的微博 %d 失败", user.ID, weiboID)
+		}
+
+		if err := removeTopics(ctx, repos, weiboID); err != nil {
+			return err
+		}
+
+		if err := repos.Mentions.DeleteMentionsByWeiboID(ctx, weiboID); err != nil {
+			return errors.Wrap(err, "删除微博中提到用户的记录失败")
+		}
 
-	givelike, err := s.weiboRepo.GetGivelikeByUseIDAndWeiboID(user.ID, weibo.ID)
+		// 删除转发的微博时减少原微博的转发数, 删除原微博时转发的微博保留, 读取时显示原微博已经删除
+		if weibo.IsRepost() {
+			return addRepostNum(ctx, repos, weibo, -1)
+		}
+		return nil
+	})
 	if err != nil {
-		return errors.Wrap(err, "点赞错误")
+		return err
+	}
+
+	//把全部粉丝中的这条微博删掉, 微博已经删除, 在此之前粉丝也看不到这条微博
+	s.enqueueFanout(ctx, NewFanoutJob(FanoutDelete, weibo))
+	return nil
+}
+
+// 记录行为的热度, 行为已经保存成功, 失败时只记录日志
+func (s *Service) recordTrend(ctx context.Context, fn func(t *Trending) error) {
+	if s.trends == nil {
+		return
+	}
+	if err := fn(s.trends); err != nil {
+		logf(ctx, "记录热度失败: %v\n", err)
 	}
+}
 
-	if givelike != nil {
-		return errors.New("已点赞过该微博")
+// 微博已经保存成功, 扩散任务入队失败时只记录日志
+func (s *Service) enqueueFanout(ctx context.Context, job *FanoutJob) {
+	if err := s.fanoutQueue.Enqueue(job); err != nil {
+		logf(ctx, "微博 %d 的扩散任务入队失败: %v\n", job.WeiboID, err)
 	}
+}
 
-	if err := s.weiboRepo.AddLikeNumByWeiboID(weibo.ID, 1); err != nil {
-		return errors.Wrap(err, "点赞失败")
+func (s *Service) Givelike(ctx context.Context, user *User, weiboID int64) error {
+	weibo, err := s.weiboRepo.GetWeiboByID(ctx, weiboID)
+	if err != nil {
+		return errors.Wrap(err, "查询微博失败")
 	}
 
-	newGivelike := &Givelike{
-		UserID:  user.ID,
-		WeiboID: weibo.ID,
+	if weibo == nil {
+		return ErrWeiboNotFound
 	}
 
-	if err := s.weiboRepo.CreateGivelike(newGivelike); err != nil {
-		return errors.Wrap(err, "保存点赞记录到当前用户微博失败")
+	err = s.do(ctx, func(repos *Repositories, out *outbox) error {
+		if err := checkBlocked(ctx, repos.Blocks, user.ID, weibo.UserID); err != nil {
+			return err
+		}
+		givelike, err := repos.Weibos.GetGivelikeByUseIDAndWeiboID(ctx, user.ID, weibo.ID)
+		if err != nil {
+			return errors.Wrap(err, "点赞错误")
+		}
+
+		if givelike != nil {
+			return ErrAlreadyLiked
+		}
+
+		newGivelike := &Givelike{
+			UserID:    user.ID,
+			WeiboID:   weibo.ID,
+			CreatedAt: time.Now().Unix(),
+		}
+
+		// 并发点赞同一条微博时由(user_id, weibo_id)主键拦住, 先保存记录再加点赞数
+		if err := repos.Weibos.CreateGivelike(ctx, newGivelike); err != nil {
+			if errors.Cause(err) == ErrDuplicate {
+				return ErrAlreadyLiked
+			}
+			return errors.Wrap(err, "保存点赞记录到当前用户微博失败")
+		}
+
+		if err := repos.Weibos.AddLikeNumByWeiboID(ctx, weibo.ID, 1); err != nil {
+			return errors.Wrap(err, "点赞失败")
+		}
+
+		return s.notify(ctx, repos, out, weibo.UserID, NotifyLike, weibo.ID, user, "", newGivelike.CreatedAt)
+	})
+	if err != nil {
+		return err
 	}
 
+	s.recordTrend(ctx, func(t *Trending) error { return t.RecordLike(ctx, weibo.ID) })
 	return nil
 }
 
-func (s *Service) Collect(user *User, weiboID int64) error {
+func (s *Service) Collect(ctx context.Context, user *User, weiboID int64) error {
 	// 判断微博是否存在
-	weibo, err := s.weiboRepo.GetWeiboByID(weiboID)
+	weibo, err := s.weiboRepo.GetWeiboByID(ctx, weiboID)
 	if err != nil {
 		return errors.Wrap(err, "查询微博失败")
 	}
 
 	if weibo == nil {
-		return errors.Wrap(err, "这条微博不存在")
+		return ErrWeiboNotFound
 	}
 
 	// 是否有收藏记录
-	collect, err := s.weiboRepo.CollectByUseIDAndWeiboID(user.ID, weibo.ID)
+	collect, err := s.weiboRepo.CollectByUseIDAndWeiboID(ctx, user.ID, weibo.ID)
 	if err != nil {
 		return errors.Wrap(err, "收藏失败")
 	}
 
 	if collect != nil {
-		return errors.New("已收藏过该微博")
+		return ErrAlreadyCollected
 	}
 
 	newCollect := &Collect{
-		UserID:  user.ID,
-		WeiboID: weibo.ID,
+		UserID:    user.ID,
+		WeiboID:   weibo.ID,
+		CreatedAt: time.Now().Unix(),
 	}
 
 	// 保存收藏记录
-	if err := s.weiboRepo.CreateCollect(newCollect); err != nil {
-		return errors.Wrap(err, "保存收藏记录到当前用户微博失败")
-	}
+	return s.do(ctx, func(repos *Repositories, out *outbox) error {
+		// 并发收藏同一条微博时由(user_id, weibo_id)主键拦住
+		if err := repos.Weibos.CreateCollect(ctx, newCollect); err != nil {
+			if errors.Cause(err) == ErrDuplicate {
+				return ErrAlreadyCollected
+			}
+			return errors.Wrap(err, "保存收藏记录到当前用户微博失败")
+		}
 
-	return nil
+		return s.notify(ctx, repos, out, weibo.UserID, NotifyCollect, weibo.ID, user, "", newCollect.CreatedAt)
+	})
 }
 
 // 收藏： n个用户-n个微博(many to many) 需要关联表
 // 评论： 1条微博-n个评论(one to many)
 
-func (s *Service) PostComment(user *User, weiboID int64, commentContent string) error {
+// 评论微博, 评论是所在楼层的第一条
+func (s *Service) PostComment(ctx context.Context, user *User, weiboID int64, commentContent string) (*Comment, error) {
+	if len(commentContent) == 0 {
+		return nil, ErrEmptyContent
+	}
+
 	// 判断微博存在与否
-	weibo, err := s.weiboRepo.GetWeiboByID(weiboID)
+	weibo, err := s.weiboRepo.GetWeiboByID(ctx, weiboID)
 	if err != nil {
-		return errors.Wrap(err, "查询微博失败")
+		return nil, errors.Wrap(err, "查询微博失败")
+	}
+
+	if weibo == nil {
+		return nil, ErrWeiboNotFound
+	}
+
+	return s.postComment(ctx, user, weibo, nil, commentContent)
+}
+
+// 回复评论, 回复和被回复的评论在同一个楼层
+func (s *Service) ReplyComment(ctx context.Context, user *User, commentID int64, commentContent string) (*Comment, error) {
+	if len(commentContent) == 0 {
+		return nil, ErrEmptyContent
+	}
+
+	parent, err := s.commentRepo.GetCommentByID(ctx, commentID)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询评论失败")
+	}
+	if parent == nil {
+		return nil, ErrCommentNotFound
 	}
 
+	weibo, err := s.weiboRepo.GetWeiboByID(ctx, parent.WeiboID)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询微博失败")
+	}
 	if weibo == nil {
-		return errors.Wrap(err, "这条微博不存在")
+		return nil, ErrWeiboNotFound
 	}
 
-	// 在微博中增加评论记录
-	if err := s.weiboRepo.AddCommentNumByWeiboID(weiboID, 1); err != nil {
-		return errors.Wrap(err, "评论失败")
+	return s.postComment(ctx, user, weibo, parent, commentContent)
+}
+
+// 保存评论, parent为nil时直接评论微博
+func (s *Service) postComment(ctx context.Context, user *User, weibo *Weibo, parent *Comment, commentContent string) (*Comment, error) {
+	commentID, err := s.ids.NextID()
+	if err != nil {
+		return nil, errors.Wrap(err, "生成评论id失败")
 	}
 
 	newComment := &Comment{
+		ID:        commentID,
 		UserID:    user.ID,
 		WeiboID:   weibo.ID,
 		Content:   commentContent,
 		CreatedAt: time.Now().Unix(),
 	}
-	// 保存评论记录到库
-	if err := s.weiboRepo.CreateComment(newComment); err != nil {
-		return errors.Wrap(err, "保存评论记录到当前用户微博失败")
+	if parent != nil {
+		newComment.ParentID = parent.ID
+		newComment.RootID = parent.ID
+		if parent.IsReply() {
+			newComment.RootID = parent.RootID
+		}
 	}
 
-	return nil
+	err = s.do(ctx, func(repos *Repositories, out *outbox) error {
+		// 微博的作者和被回复的评论者屏蔽了当前用户时都不能评论
+		if err := checkBlocked(ctx, repos.Blocks, user.ID, weibo.UserID); err != nil {
+			return err
+		}
+		if parent != nil {
+			if err := checkBlocked(ctx, repos.Blocks, user.ID, parent.UserID); err != nil {
+				return err
+			}
+		}
+
+		// 在微博中增加评论记录
+		if err := repos.Weibos.AddCommentNumByWeiboID(ctx, weibo.ID, 1); err != nil {
+			return errors.Wrap(err, "评论失败")
+		}
+
+		if newComment.IsReply() {
+			if err := repos.Comments.AddReplyNumByCommentID(ctx, newComment.RootID, 1); err != nil {
+				return errors.Wrap(err, "增加楼中回复数失败")
+			}
+		}
+
+		// 保存评论记录到库
+		if err := repos.Comments.CreateComment(ctx, newComment); err != nil {
+			return errors.Wrap(err, "保存评论记录失败")
+		}
+
+		if err := s.notify(ctx, repos, out, weibo.UserID, NotifyComment, weibo.ID, user, commentContent, newComment.CreatedAt); err != nil {
+			return err
+		}
+		return s.addMentions(ctx, repos, out, user, weibo.ID, commentID, commentContent, newComment.CreatedAt)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.recordTrend(ctx, func(t *Trending) error { return t.RecordComment(ctx, weibo.ID) })
+	return newComment, nil
 }
 
-func (s *Service) DeleteComment(user *User, commentID int64) error {
-	comment, err := s.weiboRepo.GetCommentByID(commentID)
+// 删除评论, 删除楼层的第一条评论时楼中的回复一起删除
+func (s *Service) DeleteComment(ctx context.Context, user *User, commentID int64) error {
+	comment, err := s.commentRepo.GetCommentByID(ctx, commentID)
 	if err != nil {
-		return errors.Wrap(err, "获取微博id失败")
+		return errors.Wrap(err, "查询评论失败")
 	}
 	if comment == nil {
-		return errors.New("微博不存在")
+		return ErrCommentNotFound
 	}
 
 	// 当前用户是否可以删除这条评论
 	if user.ID != comment.UserID {
-		return errors.New("不是你的微博不可以删除")
+		return ErrNotCommentOwner
 	}
 
-	if err = s.weiboRepo.DeleteComment(commentID); err != nil {
-		return errors.Wrap(err, "删除评论失败")
-	}
+	return s.uow.Do(ctx, func(repos *Repositories) error {
+		deleted, err := repos.Comments.DeleteComment(ctx, commentID)
+		if err != nil {
+			return errors.Wrap(err, "删除评论失败")
+		}
+		// 已经被并发的请求删除了, 评论数不用再减少
+		if deleted == 0 {
+			return nil
+		}
 
-	if err = s.weiboRepo.AddCommentNumByWeiboID(comment.WeiboID, -1); err != nil {
-		return errors.Wrap(err, "微博评论数减少失败")
-	}
+		if comment.IsReply() {
+			if err := repos.Comments.AddReplyNumByCommentID(ctx, comment.RootID, -1); err != nil {
+				return errors.Wrap(err, "减少楼中回复数失败")
+			}
+		}
 
-	return nil
-}
+		if err := repos.Weibos.AddCommentNumByWeiboID(ctx, comment.WeiboID, -int32(deleted)); err != nil {
+			return errors.Wrap(err, "微博评论数减少失败")
+		}
 
-func (s *Service) WeiboList(user *User, page, perPage int64) (*User, []*Follower, []*WeiboWithUser, error) {
-	// INNER JOIN
-	// LEFT JOIN
-	// RIGHT JOIN
-	// SELECT w.* FROM `weibos` w INNER JOIN `timelines` t ON w.id = t.weibo_id AND t.user_id = ? ORDER BY t.created_at DESC LIMIT ?,?
+		return nil
+	})
+}
 
-	offset := (page - 1) * perPage
-	weibos, err := s.weiboRepo.GetWeibosByUserTimelines(user.ID, offset, perPage)
-	log.Println("weibos:", weibos)
+// 微博下直接评论微博的评论
+func (s *Service) CommentList(ctx context.Context, weiboID int64, sort CommentSort, page Page) ([]*CommentWithUser, string, error) {
+	weibo, err := s.weiboRepo.GetWeiboByID(ctx, weiboID)
 	if err != nil {
-		return nil, nil, nil, errors.Wrap(err, "查询微博失败")
+		return nil, "", errors.Wrap(err, "查询微博失败")
 	}
-
-	if len(weibos) == 0 {
-		return user, nil, []*WeiboWithUser{}, nil
+	if weibo == nil {
+		return nil, "", ErrWeiboNotFound
 	}
 
-	user, err = s.userRepo.GetUserByID(user.ID)
+	comments, err := s.commentRepo.GetCommentsByWeiboID(ctx, weiboID, sort, page)
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, "", errors.Wrap(err, "查询评论失败")
 	}
+	next := nextCursor(page, len(comments), func() *Cursor { return comments[len(comments)-1].cursor(sort) })
+	return comments, next, nil
+}
 
-	followers, err := s.userRepo.GetUserFollowers2(user.ID)
+// 评论详情: 评论所在楼层的第一条评论和楼中的回复
+func (s *Service) CommentDetail(ctx context.Context, commentID int64, page Page) (*CommentWithUser, []*CommentWithUser, string, error) {
+	comment, err := s.commentRepo.GetCommentByID(ctx, commentID)
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, nil, "", errors.Wrap(err, "查询评论失败")
 	}
+	if comment != nil && comment.IsReply() {
+		comment, err = s.commentRepo.GetCommentByID(ctx, comment.RootID)
+		if err != nil {
+			return nil, nil, "", errors.Wrap(err, "查询评论失败")
+		}
+	}
+	if comment == nil {
+		return nil, nil, "", ErrCommentNotFound
+	}
+
+	root := &CommentWithUser{Comment: *comment}
+	// 评论者不存在时只显示评论内容
+	author, err := s.userRepo.GetUserByID(ctx, comment.UserID)
+	if err != nil {
+		return nil, nil, "", errors.Wrap(err, "查询评论者失败")
+	}
+	if author != nil {
+		root.Account = author.Account
+		root.Avatar = author.Avatar
+	}
+
+	replies, err := s.commentRepo.GetRepliesByRootID(ctx, comment.ID, page)
+	if err != nil {
+		return nil, nil, "", errors.Wrap(err, "查询楼中回复失败")
+	}
+	next := nextCursor(page, len(replies), func() *Cursor { return replies[len(replies)-1].cursor(CommentSortTime) })
+	return root, replies, next, nil
+}
+
+// 话题页: 话题和话题下的微博, 按发布时间倒序
+func (s *Service) TopicWeibos(ctx context.Context, name string, page Page) (*Topic, []*WeiboWithUser, string, error) {
+	topic, err := s.topicRepo.GetTopicByName(ctx, name)
+	if err != nil {
+		return nil, nil, "", errors.Wrap(err, "查询话题失败")
+	}
+	if topic == nil {
+		return nil, nil, "", ErrTopicNotFound
+	}
+
+	weibos, err := s.topicRepo.GetWeibosByTopicID(ctx, topic.ID, page)
+	if err != nil {
+		return nil, nil, "", errors.Wrap(err, "查询话题下的微博失败")
+	}
+	next := nextCursor(page, len(weibos), func() *Cursor {
+		last := weibos[len(weibos)-1]
+		return &Cursor{CreatedAt: last.CreatedAt, ID: last.ID}
+	})
+	if err := attachReposts(ctx, s.weiboRepo, weibos); err != nil {
+		return nil, nil, "", err
+	}
+	blocked, err := s.viewerBlockedUsers(ctx)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return topic, filterBlockedWeibos(weibos, blocked), next, nil
+}
+
+// 微博数最多的话题, 显示在首页的侧边栏
+func (s *Service) TopTopics(ctx context.Context) ([]*Topic, error) {
+	topics, err := s.topicRepo.GetTopTopics(ctx, s.TopicPreviewSize)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询话题失败")
+	}
+	return topics, nil
+}
+
+// 热门话题, 按滑动窗口内被提到的次数排序
+func (s *Service) TrendingTopics(ctx context.Context, limit int64) ([]*TrendingTopic, error) {
+	if s.trends == nil {
+		return []*TrendingTopic{}, nil
+	}
+	scores, err := s.trends.Top(ctx, TrendTopics, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询热门话题失败")
+	}
+
+	topicIDs := make([]int64, 0, len(scores))
+	for _, score := range scores {
+		topicIDs = append(topicIDs, score.ID)
+	}
+	topics, err := s.topicRepo.GetTopicsByIDs(ctx, topicIDs)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询话题失败")
+	}
+
+	topicByID := make(map[int64]*Topic, len(topics))
+	for _, topic := range topics {
+		topicByID[topic.ID] = topic
+	}
+	trending := make([]*TrendingTopic, 0, len(scores))
+	for _, score := range scores {
+		if topic, ok := topicByID[score.ID]; ok {
+			trending = append(trending, &TrendingTopic{Topic: topic, Score: score.Score})
+		}
+	}
+	return trending, nil
+}
+
+// 热门微博, 按滑动窗口内的点赞、评论和转发排序, 已经删除的微博会被跳过
+func (s *Service) HotWeibos(ctx context.Context, limit int64) ([]*WeiboWithUser, error) {
+	if s.trends == nil {
+		return []*WeiboWithUser{}, nil
+	}
+	scores, err := s.trends.Top(ctx, TrendWeibos, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询热门微博失败")
+	}
+
+	weiboIDs := make([]int64, 0, len(scores))
+	for _, score := range scores {
+		weiboIDs = append(weiboIDs, score.ID)
+	}
+	weibos, err := s.weiboRepo.GetWeibosByIDs(ctx, weiboIDs)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询微博失败")
+	}
+
+	weiboByID := make(map[int64]*WeiboWithUser, len(weibos))
+	for _, weibo := range weibos {
+		weiboByID[weibo.ID] = weibo
+	}
+	ordered := make([]*WeiboWithUser, 0, len(weibos))
+	for _, weiboID := range weiboIDs {
+		if weibo, ok := weiboByID[weiboID]; ok {
+			ordered = append(ordered, weibo)
+		}
+	}
+	if err := attachReposts(ctx, s.weiboRepo, ordered); err != nil {
+		return nil, err
+	}
+	blocked, err := s.viewerBlockedUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return filterBlockedWeibos(ordered, blocked), nil
+}
+
+// 首页: 当前用户的信息, 粉丝列表的第一页和timeline中的微博
+func (s *Service) WeiboList(ctx context.Context, user *User, page Page) (*User, []*Follower, []*WeiboWithUser, string, error) {
+	// INNER JOIN
+	// LEFT JOIN
+	// RIGHT JOIN
+	// SELECT w.* FROM `weibos` w INNER JOIN `timelines` t ON w.id = t.weibo_id AND t.user_id = ? ORDER BY t.created_at DESC LIMIT ?,?
 
-	return user, followers, weibos, nil
-}
-
-func (s *Service) FollowersShow(user *User, page, perPage int64) (*User, []*WeiboWithUser, error) {
-	offset := (page - 1) * perPage
-	weibos, err := s.weiboRepo.GetWeibosByUserTimelines(user.ID, offset, perPage)
-	log.Println("weibos:", weibos)
+	weibos, next, err := s.homeTimeline(ctx, user.ID, page)
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "查询微博失败")
+		return nil, nil, nil, "", errors.Wrap(err, "查询微博失败")
 	}
 
 	if len(weibos) == 0 {
-		return user, []*WeiboWithUser{}, nil
+		return user, nil, []*WeiboWithUser{}, next, nil
 	}
 
-	user, err = s.userRepo.GetUserByID(user.ID)
+	user, err = s.userRepo.GetUserByID(ctx, user.ID)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, "", err
+	}
+	if user == nil {
+		return nil, nil, nil, "", ErrUserNotFound
+	}
+
+	followers, err := s.userRepo.GetUserFollowers2(ctx, user.ID, Page{Limit: s.FollowerPreviewSize})
+	if err != nil {
+		return nil, nil, nil, "", err
+	}
+	blocked, err := blockedUsers(ctx, s.blockRepo, user.ID)
+	if err != nil {
+		return nil, nil, nil, "", err
 	}
 
-	return user, weibos, nil
+	return user, filterBlockedFollowers(followers, blocked), weibos, next, nil
+}
+
+// 读取用户首页的timeline, 合并推送到timeline中的微博和所关注的拉模式账号的微博
+// 屏蔽和静音在读取时过滤, 一页被过滤掉的部分从后面继续补齐
+// 扫描超过TimelineScanLimit条时返回不满的一页, 游标指向扫描停止的位置
+func (s *Service) homeTimeline(ctx context.Context, userID int64, page Page) ([]*WeiboWithUser, string, error) {
+	if page.Limit <= 0 {
+		return []*WeiboWithUser{}, "", nil
+	}
+	// 关注关系在屏蔽时已经解除, 这里过滤的是转发的被屏蔽者的微博
+	blocked, err := blockedUsers(ctx, s.blockRepo, userID)
+	if err != nil {
+		return nil, "", err
+	}
+	// 静音在读取时过滤, 缓存的timeline不受影响, 取消静音之后马上恢复
+	mutes, err := muteFilter(ctx, s.muteRepo, userID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	weibos := make([]*WeiboWithUser, 0, page.Limit)
+	cursor, offset := page.Cursor, page.Offset
+	var scanned int64
+	for {
+		// 两边各取到这一页的末尾, 合并后再跳过offset
+		window := Page{Cursor: cursor, Limit: offset + page.Limit}
+		timelines, err := s.timeLineWindow(ctx, userID, window)
+		if err != nil {
+			return nil, "", err
+		}
+		// 合并之后不够一页说明两边都已经取完了
+		exhausted := int64(len(timelines)) < window.Limit
+		if offset >= int64(len(timelines)) {
+			return weibos, "", nil
+		}
+		timelines = timelines[offset:]
+		if int64(len(timelines)) > page.Limit {
+			timelines = timelines[:page.Limit]
+		}
+		offset = 0
+
+		weiboByID, err := s.timeLineWeibos(ctx, timelines)
+		if err != nil {
+			return nil, "", err
+		}
+		// 游标按timeline生成, 已经删除和被过滤的微博不影响翻页
+		for i, timeline := range timelines {
+			cursor = &Cursor{CreatedAt: timeline.WeiboCreatedAt, ID: timeline.WeiboID}
+			weibo, ok := weiboByID[timeline.WeiboID]
+			if !ok || blockedWeibo(weibo, blocked) || mutes.MutesWeibo(weibo) {
+				continue
+			}
+			weibos = append(weibos, weibo)
+			if int64(len(weibos)) < page.Limit {
+				continue
+			}
+			if exhausted && i == len(timelines)-1 {
+				return weibos, "", nil
+			}
+			return weibos, cursor.Encode(), nil
+		}
+		if exhausted {
+			return weibos, "", nil
+		}
+		scanned += int64(len(timelines))
+		if scanned >= s.TimelineScanLimit {
+			return weibos, cursor.Encode(), nil
+		}
+	}
+}
+
+// 按游标取一段合并后的timeline, 关闭拉模式之前发布的微博也要合并, 不按PullThreshold跳过
+func (s *Service) timeLineWindow(ctx context.Context, userID int64, window Page) ([]*TimeLine, error) {
+	timelines, err := s.timelineRepo.GetTimeLinesByUserID(ctx, userID, window)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询timeline失败")
+	}
+	pulled, err := s.weiboRepo.GetPullTimeLinesByUserID(ctx, userID, window)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询关注的拉模式账号的微博失败")
+	}
+	return MergeTimeLines(timelines, pulled), nil
+}
+
+// 查询timeline中的微博并填充转发的原创微博, 已经删除的微博不在结果中
+func (s *Service) timeLineWeibos(ctx context.Context, timelines []*TimeLine) (map[int64]*WeiboWithUser, error) {
+	weiboIDs := make([]int64, 0, len(timelines))
+	for _, timeline := range timelines {
+		weiboIDs = append(weiboIDs, timeline.WeiboID)
+	}
+	weibos, err := s.weiboRepo.GetWeibosByIDs(ctx, weiboIDs)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询微博失败")
+	}
+	if err := attachReposts(ctx, s.weiboRepo, weibos); err != nil {
+		return nil, err
+	}
+	weiboByID := make(map[int64]*WeiboWithUser, len(weibos))
+	for _, weibo := range weibos {
+		weiboByID[weibo.ID] = weibo
+	}
+	return weiboByID, nil
+}
+
+// 查询微博并填充转发的原创微博, 推送之前按接收者的静音过滤, 微博已经删除时返回nil
+func weiboWithRepost(ctx context.Context, weiboRepo WeiboRepository, weiboID int64) (*WeiboWithUser, error) {
+	weibos, err := weiboRepo.GetWeibosByIDs(ctx, []int64{weiboID})
+	if err != nil {
+		return nil, errors.Wrap(err, "查询微博失败")
+	}
+	if len(weibos) == 0 {
+		return nil, nil
+	}
+	if err := attachReposts(ctx, weiboRepo, weibos); err != nil {
+		return nil, err
+	}
+	return weibos[0], nil
+}
+
+// 给转发的微博填充转发的原创微博
+func attachReposts(ctx context.Context, weiboRepo WeiboRepository, weibos []*WeiboWithUser) error {
+	rootIDs := []int64{}
+	for _, weibo := range weibos {
+		if weibo.IsRepost() {
+			rootIDs = append(rootIDs, weibo.RepostRootID)
+		}
+	}
+	if len(rootIDs) == 0 {
+		return nil
+	}
+
+	roots, err := weiboRepo.GetWeibosByIDs(ctx, rootIDs)
+	if err != nil {
+		return errors.Wrap(err, "查询转发的原微博失败")
+	}
+	rootByID := make(map[int64]*WeiboWithUser, len(roots))
+	for _, root := range roots {
+		rootByID[root.ID] = root
+	}
+	for _, weibo := range weibos {
+		if weibo.IsRepost() {
+			weibo.RepostOf = rootByID[weibo.RepostRootID]
+		}
+	}
+	return nil
+}
+
+func (s *Service) FollowersShow(ctx context.Context, user *User, page Page) (*User, []*WeiboWithUser, string, error) {
+	weibos, next, err := s.homeTimeline(ctx, user.ID, page)
+	if err != nil {
+		return nil, nil, "", errors.Wrap(err, "查询微博失败")
+	}
+
+	if len(weibos) == 0 {
+		return user, []*WeiboWithUser{}, next, nil
+	}
+
+	user, err = s.userRepo.GetUserByID(ctx, user.ID)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if user == nil {
+		return nil, nil, "", ErrUserNotFound
+	}
+
+	return user, weibos, next, nil
+}
+
+// 粉丝列表, 按关注时间倒序
+func (s *Service) FollowerList(ctx context.Context, userID int64, page Page) ([]*Follower, string, error) {
+	followers, err := s.userRepo.GetUserFollowers2(ctx, userID, page)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "查询粉丝失败")
+	}
+
+	next := nextCursor(page, len(followers), func() *Cursor {
+		last := followers[len(followers)-1]
+		return &Cursor{CreatedAt: last.FollowedAt, ID: last.ID}
+	})
+	blocked, err := s.viewerBlockedUsers(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	return filterBlockedFollowers(followers, blocked), next, nil
 }
 
 //搜索微博
-func (s *Service) SearchWeibo(accountOrContent string, page, perPage int64) ([]*Weibo, error) {
-	offset := (page - 1) * perPage
-	weibos, err := s.weiboRepo.GetWeibosByAccountOrContent(accountOrContent, offset, perPage)
+func (s *Service) SearchWeibo(ctx context.Context, accountOrContent string, page Page) ([]*Weibo, string, error) {
+	if len(accountOrContent) == 0 {
+		return nil, "", ErrEmptySearchKey
+	}
+
+	weibos, err := s.weiboRepo.GetWeibosByAccountOrContent(ctx, accountOrContent, page)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "搜索微博失败")
+	}
+
+	next := nextCursor(page, len(weibos), func() *Cursor {
+		last := weibos[len(weibos)-1]
+		return &Cursor{CreatedAt: last.CreatedAt, ID: last.ID}
+	})
+	blocked, err := s.viewerBlockedUsers(ctx)
 	if err != nil {
-		return nil, errors.Wrap(err, "搜索微博失败")
+		return nil, "", err
 	}
+	return filterBlockedSearchWeibos(weibos, blocked), next, nil
+	//s.weiboRepo.CountWeibos(ctx, accountOrContent)
+}
 
-	if len(weibos) != 0 {
-		return []*Weibo{}, nil
+func (s *Service) SearchUser(ctx context.Context, account string, page Page) ([]*User, string, error) {
+	if len(account) == 0 {
+		return nil, "", ErrEmptySearchKey
 	}
 
-	return weibos, nil
-	//s.weiboRepo.CountWeibos(accountOrContent)
+	users, err := s.userRepo.GetUsersByAccount(ctx, account, page)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "搜索用户失败")
+	}
+
+	next := nextCursor(page, len(users), func() *Cursor {
+		last := users[len(users)-1]
+		return &Cursor{CreatedAt: last.CreatedAt, ID: last.ID}
+	})
+	blocked, err := s.viewerBlockedUsers(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	return filterBlockedUsers(users, blocked), next, nil
+}
+
+// 用户的通知, 按最近一次聚合的时间倒序
+func (s *Service) Notifications(ctx context.Context, user *User, page Page) ([]*NotificationItem, string, error) {
+	notifications, err := s.noticeRepo.GetNotificationsByUserID(ctx, user.ID, page)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "查询通知失败")
+	}
+	next := nextCursor(page, len(notifications), func() *Cursor {
+		last := notifications[len(notifications)-1]
+		return &Cursor{CreatedAt: last.UpdatedAt, ID: last.ID}
+	})
+
+	// 静音和屏蔽之前产生的通知在读取时过滤
+	mutes, err := muteFilter(ctx, s.muteRepo, user.ID)
+	if err != nil {
+		return nil, "", err
+	}
+	blocked, err := blockedUsers(ctx, s.blockRepo, user.ID)
+	if err != nil {
+		return nil, "", err
+	}
+	if !mutes.Empty() || len(blocked) > 0 {
+		hidden := func(userID int64) bool { return blocked[userID] || mutes.MutesUser(userID) }
+		if notifications, err = s.filterNotifications(ctx, notifications, hidden, mutes); err != nil {
+			return nil, "", err
+		}
+	}
+	items := make([]*NotificationItem, 0, len(notifications))
+	for _, n := range notifications {
+		items = append(items, &NotificationItem{Notification: n, Read: n.IsRead(), Text: n.Text()})
+	}
+	return items, next, nil
 }
 
-func (s *Service) SearchUser(account string, page, perPage int64) ([]*User, error) {
-	offset := (page - 1) * perPage
-	users, err := s.userRepo.GetUsersByAccount(account, offset, perPage)
+// 从聚合的通知中去掉隐藏的用户, 只剩隐藏的用户时整条去掉
+// 相关的微博包含静音的关键词时也整条去掉
+func (s *Service) filterNotifications(ctx context.Context, notifications []*Notification, hidden func(userID int64) bool, mutes *MuteFilter) ([]*Notification, error) {
+	notificationIDs := make([]int64, 0, len(notifications))
+	weiboIDs := []int64{}
+	for _, n := range notifications {
+		notificationIDs = append(notificationIDs, n.ID)
+		if n.TargetID != 0 {
+			weiboIDs = append(weiboIDs, n.TargetID)
+		}
+	}
+	actors, err := s.noticeRepo.GetNotificationActors(ctx, notificationIDs)
 	if err != nil {
-		return nil, errors.Wrap(err, "搜索用户失败")
+		return nil, errors.Wrap(err, "查询通知中的用户失败")
+	}
+	actorsByID := make(map[int64][]*NotificationActor, len(notifications))
+	for _, actor := range actors {
+		actorsByID[actor.NotificationID] = append(actorsByID[actor.NotificationID], actor)
+	}
+	weibos, err := s.weiboRepo.GetWeibosByIDs(ctx, weiboIDs)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询通知相关的微博失败")
+	}
+	mutedWeibos := map[int64]bool{}
+	for _, weibo := range weibos {
+		if mutes.MutesContent(weibo.Content) {
+			mutedWeibos[weibo.ID] = true
+		}
+	}
+
+	filtered := make([]*Notification, 0, len(notifications))
+	for _, n := range notifications {
+		if mutedWeibos[n.TargetID] {
+			continue
+		}
+		if visible := n.withoutActors(actorsByID[n.ID], hidden); visible != nil {
+			filtered = append(filtered, visible)
+		}
+	}
+	return filtered, nil
+}
+
+// 未读通知数, 显示在导航栏
+func (s *Service) UnreadNotificationCount(ctx context.Context, user *User) (int64, error) {
+	count, err := s.noticeRepo.CountUnreadNotifications(ctx, user.ID)
+	if err != nil {
+		return 0, errors.Wrap(err, "查询未读通知数失败")
+	}
+	return count, nil
+}
+
+// 把全部通知标记为已读, 之后同样的动作会产生新的通知
+func (s *Service) MarkNotificationsRead(ctx context.Context, user *User) error {
+	if err := s.noticeRepo.MarkNotificationsRead(ctx, user.ID); err != nil {
+		return errors.Wrap(err, "标记通知已读失败")
+	}
+	// 同一个用户的其他连接也要更新未读数
+	s.push(ctx, &outbox{unread: []int64{user.ID}})
+	return nil
+}
+
+// 根据账号查询用户的主页信息, 内容中提到的账号链接到这里
+func (s *Service) UserProfile(ctx context.Context, account string) (*User, error) {
+	user, err := s.userRepo.GetUserByAccount(ctx, account)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询用户失败")
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	return user, nil
+}
+
+// "@我的": 提到当前用户的微博和评论, 按时间倒序
+func (s *Service) Mentions(ctx context.Context, user *User, page Page) ([]*MentionItem, string, error) {
+	mentions, err := s.mentionRepo.GetMentionsByUserID(ctx, user.ID, page)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "查询提到我的记录失败")
+	}
+	// 游标按提到的记录生成, 查询之后被删除的微博和评论不影响翻页
+	next := nextCursor(page, len(mentions), func() *Cursor {
+		last := mentions[len(mentions)-1]
+		return &Cursor{CreatedAt: last.CreatedAt, ID: last.ID}
+	})
+	// 屏蔽之前提到我的记录在读取时过滤
+	blocked, err := blockedUsers(ctx, s.blockRepo, user.ID)
+	if err != nil {
+		return nil, "", err
+	}
+	mentions = filterBlockedMentions(mentions, blocked)
+
+	weiboIDs := make([]int64, 0, len(mentions))
+	commentIDs := []int64{}
+	for _, mention := range mentions {
+		weiboIDs = append(weiboIDs, mention.WeiboID)
+		if mention.InComment() {
+			commentIDs = append(commentIDs, mention.CommentID)
+		}
+	}
+	weibos, err := s.weiboRepo.GetWeibosByIDs(ctx, weiboIDs)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "查询微博失败")
+	}
+	if err := attachReposts(ctx, s.weiboRepo, weibos); err != nil {
+		return nil, "", err
+	}
+	comments, err := s.commentRepo.GetCommentsByIDs(ctx, commentIDs)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "查询评论失败")
+	}
+
+	weiboByID := make(map[int64]*WeiboWithUser, len(weibos))
+	for _, weibo := range weibos {
+		weiboByID[weibo.ID] = weibo
+	}
+	commentByID := make(map[int64]*CommentWithUser, len(comments))
+	for _, comment := range comments {
+		commentByID[comment.ID] = comment
+	}
+	items := make([]*MentionItem, 0, len(mentions))
+	for _, mention := range mentions {
+		item := &MentionItem{Mention: mention, Weibo: weiboByID[mention.WeiboID]}
+		if item.Weibo == nil {
+			continue
+		}
+		if mention.InComment() {
+			if item.Comment = commentByID[mention.CommentID]; item.Comment == nil {
+				continue
+			}
+		}
+		items = append(items, item)
+	}
+	return items, next, nil
+}
+
+// 发私信, 对方设置了只接收粉丝的私信时必须先关注对方
+func (s *Service) SendMessage(ctx context.Context, user *User, toUserID int64, content string) (*Message, error) {
+	if len(content) == 0 {
+		return nil, ErrEmptyContent
+	}
+	if toUserID == user.ID {
+		return nil, ErrMessageSelf
+	}
+
+	toUser, err := s.userRepo.GetUserByID(ctx, toUserID)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询用户失败")
+	}
+	if toUser == nil {
+		return nil, ErrUserNotFound
+	}
+	if toUser.MessagePermission == MessageFollowers {
+		following, err := s.userRepo.GetFollowing(ctx, user.ID, toUserID)
+		if err != nil {
+			return nil, errors.Wrap(err, "查询关注关系失败")
+		}
+		if following == nil {
+			return nil, ErrMessageDenied
+		}
+	}
+
+	// 私信和双方的会话各需要一个id
+	ids := make([]int64, 3)
+	for i := range ids {
+		if ids[i], err = s.ids.NextID(); err != nil {
+			return nil, errors.Wrap(err, "生成私信id失败")
+		}
+	}
+	message := &Message{
+		ID:         ids[0],
+		FromUserID: user.ID,
+		ToUserID:   toUserID,
+		Content:    content,
+		CreatedAt:  time.Now().Unix(),
+	}
+
+	err = s.do(ctx, func(repos *Repositories, out *outbox) error {
+		if err := checkBlocked(ctx, repos.Blocks, user.ID, toUserID); err != nil {
+			return err
+		}
+		if err := repos.Messages.CreateMessage(ctx, message); err != nil {
+			return errors.Wrap(err, "保存私信失败")
+		}
+
+		// 发送方的会话不增加未读数
+		conversations := []*Conversation{
+			{ID: ids[1], UserID: user.ID, PeerID: toUserID},
+			{ID: ids[2], UserID: toUserID, PeerID: user.ID, UnreadNum: 1},
+		}
+		for _, c := range conversations {
+			c.LastMessageID = message.ID
+			c.LastContent = message.Content
+			c.UpdatedAt = message.CreatedAt
+			if err := repos.Messages.SaveConversation(ctx, c); err != nil {
+				return errors.Wrap(err, "更新会话失败")
+			}
+		}
+		out.unread = append(out.unread, toUserID)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// 用户的会话, 按最后一条私信的时间倒序
+func (s *Service) Conversations(ctx context.Context, user *User, page Page) ([]*ConversationWithUser, string, error) {
+	conversations, err := s.messageRepo.GetConversationsByUserID(ctx, user.ID, page)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "查询会话失败")
+	}
+	next := nextCursor(page, len(conversations), func() *Cursor {
+		last := conversations[len(conversations)-1]
+		return &Cursor{CreatedAt: last.UpdatedAt, ID: last.ID}
+	})
+	return conversations, next, nil
+}
+
+// 和对方之间的私信, 按时间倒序
+func (s *Service) Messages(ctx context.Context, user *User, peerID int64, page Page) (*User, []*Message, string, error) {
+	peer, err := s.userRepo.GetUserByID(ctx, peerID)
+	if err != nil {
+		return nil, nil, "", errors.Wrap(err, "查询用户失败")
+	}
+	if peer == nil {
+		return nil, nil, "", ErrUserNotFound
+	}
+
+	messages, err := s.messageRepo.GetMessages(ctx, user.ID, peerID, page)
+	if err != nil {
+		return nil, nil, "", errors.Wrap(err, "查询私信失败")
+	}
+	next := nextCursor(page, len(messages), func() *Cursor {
+		last := messages[len(messages)-1]
+		return &Cursor{CreatedAt: last.CreatedAt, ID: last.ID}
+	})
+	return peer, messages, next, nil
+}
+
+// 把和对方的会话标记为已读
+func (s *Service) MarkConversationRead(ctx context.Context, user *User, peerID int64) error {
+	if err := s.messageRepo.MarkConversationRead(ctx, user.ID, peerID); err != nil {
+		return errors.Wrap(err, "标记会话已读失败")
+	}
+	s.push(ctx, &outbox{unread: []int64{user.ID}})
+	return nil
+}
+
+// 所有会话的未读私信数, 显示在导航栏
+func (s *Service) UnreadMessageCount(ctx context.Context, user *User) (int64, error) {
+	count, err := s.messageRepo.CountUnreadMessages(ctx, user.ID)
+	if err != nil {
+		return 0, errors.Wrap(err, "查询未读私信数失败")
+	}
+	return count, nil
+}
+
+// 当前的未读通知数和未读私信数, 建立推送连接时先发送一次
+func (s *Service) UnreadCount(ctx context.Context, user *User) (*UnreadCount, error) {
+	return s.unreadCount(ctx, user.ID)
+}
+
+// 订阅推送给当前用户的事件, lastEventID是客户端收到的最后一个事件, 重连时补发之后的事件
+func (s *Service) Subscribe(ctx context.Context, user *User, lastEventID int64) (<-chan *Event, error) {
+	if s.events == nil {
+		return nil, errors.New("没有配置事件推送")
+	}
+	events, err := s.events.Subscribe(ctx, user.ID, lastEventID)
+	if err != nil {
+		return nil, errors.Wrap(err, "订阅事件失败")
+	}
+	return events, nil
+}
+
+// 设置谁可以给自己发私信
+func (s *Service) SetMessagePermission(ctx context.Context, user *User, permission string) error {
+	p, err := ParseMessagePermission(permission)
+	if err != nil {
+		return err
+	}
+	if err := s.userRepo.UpdateMessagePermission(ctx, user.ID, p); err != nil {
+		return errors.Wrap(err, "更新私信权限失败")
+	}
+	user.MessagePermission = p
+	return nil
+}
+
+// 屏蔽用户, 双方之间的关注关系一起解除, 之后双方不能再互相关注、评论、点赞、提到和私信
+func (s *Service) Block(ctx context.Context, user *User, targetUserID int64) error {
+	if user.ID == targetUserID {
+		return ErrBlockSelf
+	}
+
+	targetUser, err := s.userRepo.GetUserByID(ctx, targetUserID)
+	if err != nil {
+		return errors.Wrap(err, "查询目标用户失败")
+	}
+	if targetUser == nil {
+		return ErrUserNotFound
+	}
+
+	return s.uow.Do(ctx, func(repos *Repositories) error {
+		block, err := repos.Blocks.GetBlock(ctx, user.ID, targetUserID)
+		if err != nil {
+			return errors.Wrap(err, "查询屏蔽记录失败")
+		}
+		if block != nil {
+			return ErrAlreadyBlocked
+		}
+
+		block = &Block{
+			UserID:        user.ID,
+			BlockedUserID: targetUserID,
+			CreatedAt:     time.Now().Unix(),
+		}
+		if err := repos.Blocks.CreateBlock(ctx, block); err != nil {
+			return errors.Wrap(err, "保存屏蔽记录失败")
+		}
+
+		// 解除两个方向的关注
+		for _, ids := range [][2]int64{{user.ID, targetUserID}, {targetUserID, user.ID}} {
+			following, err := repos.Users.GetFollowing(ctx, ids[0], ids[1])
+			if err != nil {
+				return errors.Wrap(err, "查询关注关系失败")
+			}
+			if following == nil {
+				continue
+			}
+			if err := unfollow(ctx, repos, following); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// 取消屏蔽, 之前解除的关注关系不会恢复
+func (s *Service) Unblock(ctx context.Context, user *User, targetUserID int64) error {
+	block, err := s.blockRepo.GetBlock(ctx, user.ID, targetUserID)
+	if err != nil {
+		return errors.Wrap(err, "查询屏蔽记录失败")
+	}
+	if block == nil {
+		return ErrNotBlocked
+	}
+	if err := s.blockRepo.DeleteBlock(ctx, block); err != nil {
+		return errors.Wrap(err, "删除屏蔽记录失败")
+	}
+	return nil
+}
+
+// 当前用户屏蔽了的人, 按屏蔽时间倒序
+func (s *Service) BlockedUsers(ctx context.Context, user *User, page Page) ([]*BlockedUser, string, error) {
+	users, err := s.blockRepo.GetBlockedUsers(ctx, user.ID, page)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "查询屏蔽列表失败")
+	}
+	next := nextCursor(page, len(users), func() *Cursor {
+		last := users[len(users)-1]
+		return &Cursor{CreatedAt: last.BlockedAt, ID: last.ID}
+	})
+	return users, next, nil
+}
+
+// 静音账号, d为0时永久静音, 对方的微博和触发的通知不再出现, 关注关系不变
+func (s *Service) MuteUser(ctx context.Context, user *User, targetUserID int64, d time.Duration) (*Mute, error) {
+	if user.ID == targetUserID {
+		return nil, ErrMuteSelf
+	}
+
+	targetUser, err := s.userRepo.GetUserByID(ctx, targetUserID)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询目标用户失败")
+	}
+	if targetUser == nil {
+		return nil, ErrUserNotFound
+	}
+
+	return s.addMute(ctx, user, &Mute{MutedUserID: targetUser.ID, MutedAccount: targetUser.Account}, d)
+}
+
+// 静音关键词, 内容中包含关键词的微博不再出现, 不区分大小写
+func (s *Service) MuteKeyword(ctx context.Context, user *User, keyword string, d time.Duration) (*Mute, error) {
+	keyword, err := normalizeMuteKeyword(keyword)
+	if err != nil {
+		return nil, err
+	}
+	return s.addMute(ctx, user, &Mute{Keyword: keyword}, d)
+}
+
+func (s *Service) addMute(ctx context.Context, user *User, mute *Mute, d time.Duration) (*Mute, error) {
+	if d < 0 {
+		return nil, ErrInvalidMuteDuration
+	}
+
+	muteID, err := s.ids.NextID()
+	if err != nil {
+		return nil, errors.Wrap(err, "生成静音id失败")
+	}
+	now := time.Now().Unix()
+	mute.ID = muteID
+	mute.UserID = user.ID
+	mute.CreatedAt = now
+	if d > 0 {
+		mute.ExpiresAt = now + int64(d/time.Second)
+	}
+
+	err = s.uow.Do(ctx, func(repos *Repositories) error {
+		// 过期的静音已经不生效, 添加时清理掉, 不占用数量
+		if err := repos.Mutes.DeleteExpiredMutes(ctx, user.ID, now); err != nil {
+			return errors.Wrap(err, "清理过期的静音失败")
+		}
+		mutes, err := repos.Mutes.GetMutesByUserID(ctx, user.ID)
+		if err != nil {
+			return errors.Wrap(err, "查询静音列表失败")
+		}
+		for _, m := range mutes {
+			if m.sameTarget(mute) {
+				return ErrAlreadyMuted
+			}
+		}
+		if len(mutes) >= s.MaxMutes {
+			return ErrTooManyMutes
+		}
+
+		// 并发添加同一条静音时由唯一索引拦住
+		if err := repos.Mutes.CreateMute(ctx, mute); err != nil {
+			if errors.Cause(err) == ErrDuplicate {
+				return ErrAlreadyMuted
+			}
+			return errors.Wrap(err, "保存静音失败")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mute, nil
+}
+
+// 取消静音
+func (s *Service) Unmute(ctx context.Context, user *User, muteID int64) error {
+	deleted, err := s.muteRepo.DeleteMute(ctx, user.ID, muteID)
+	if err != nil {
+		return errors.Wrap(err, "删除静音失败")
+	}
+	if deleted == 0 {
+		return ErrMuteNotFound
+	}
+	return nil
+}
+
+// 当前生效的静音, 按添加时间倒序
+func (s *Service) Mutes(ctx context.Context, user *User) ([]*Mute, error) {
+	mutes, err := s.muteRepo.GetMutesByUserID(ctx, user.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "查询静音列表失败")
+	}
+	now := time.Now().Unix()
+	active := make([]*Mute, 0, len(mutes))
+	for _, mute := range mutes {
+		if !mute.Expired(now) {
+			active = append(active, mute)
+		}
 	}
-	return users, nil
+	return active, nil
 }
 
 // func (s *Service) GetUserProfile(userID int64) (*User, error) {
@@ -0,0 +1,1187 @@
+package weibo_test
+
+import (
+	"context"
+	"reflect"
+	"storage/memory"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"weibo"
+
+	"github.com/pkg/errors"
+)
+
+// 使用内存存储的服务, 扩散任务在后台执行, 热度排行需要手动重新计算
+func newMemoryService(t *testing.T) (*weibo.Service, *weibo.Trending, *weibo.MemoryEventBroker) {
+	t.Helper()
+	store := memory.NewStore()
+	repos := weibo.Repositories{
+		Users:         memory.NewUserRepository(store),
+		Weibos:        memory.NewWeiboRepository(store),
+		Comments:      memory.NewCommentRepository(store),
+		Topics:        memory.NewTopicRepository(store),
+		Mentions:      memory.NewMentionRepository(store),
+		Notifications: memory.NewNotificationRepository(store),
+		Messages:      memory.NewMessageRepository(store),
+		Blocks:        memory.NewBlockRepository(store),
+		Mutes:         memory.NewMuteRepository(store),
+		TimeLines:     memory.NewTimeLineRepository(store),
+	}
+	queue := weibo.NewMemoryFanoutQueue(100)
+	ids, err := weibo.NewSnowflake(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trends := weibo.NewTrending(weibo.NewMemoryTrendStore())
+	events := weibo.NewMemoryEventBroker()
+	service := weibo.NewService(weibo.Dependencies{
+		Repositories: repos,
+		UnitOfWork:   memory.NewUnitOfWork(store),
+		Hasher:       weibo.NewBcryptHasher(4),
+		FanoutQueue:  queue,
+		IDs:          ids,
+		Trends:       trends,
+		Events:       events,
+	})
+
+	worker := weibo.NewFanoutWorker(queue, repos.Users, repos.Weibos, repos.Mutes, repos.TimeLines, ids, events)
+	worker.Start()
+	t.Cleanup(worker.Stop)
+	return service, trends, events
+}
+
+// 等待扩散任务完成, 返回满足条件的timeline
+func waitTimeline(t *testing.T, service *weibo.Service, user *weibo.User, ok func(weibos []*weibo.WeiboWithUser) bool) []*weibo.WeiboWithUser {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		_, weibos, _, err := service.FollowersShow(context.Background(), user, weibo.Page{Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok(weibos) {
+			return weibos
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("扩散任务没有完成", weibos)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// 用内存存储把关注, 发布, 扩散和读取timeline串起来
+func TestServiceWithMemoryStorage(t *testing.T) {
+	ctx := context.Background()
+	service, _, _ := newMemoryService(t)
+
+	alice, err := service.Register(ctx, "alice", "alice.jpg", "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := service.Register(ctx, "bob", "bob.jpg", "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := &weibo.Weibo{UserID: alice.ID, Account: alice.Account, Content: "关注之前发的", CreatedAt: time.Now().Unix() - 10}
+	if err := service.PublishWeibo(ctx, alice, before); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.Follow(ctx, bob, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.Follow(ctx, bob, alice.ID); err != weibo.ErrAlreadyFollowing {
+		t.Fatal("重复关注应该返回ErrAlreadyFollowing", err)
+	}
+
+	after := &weibo.Weibo{UserID: alice.ID, Account: alice.Account, Content: "关注之后发的", CreatedAt: time.Now().Unix()}
+	if err := service.PublishWeibo(ctx, alice, after); err != nil {
+		t.Fatal(err)
+	}
+
+	// 等待扩散任务写入bob的timeline
+	timeline := waitTimeline(t, service, bob, func(weibos []*weibo.WeiboWithUser) bool { return len(weibos) == 2 })
+	if timeline[0].ID != after.ID || timeline[1].ID != before.ID || timeline[0].Avatar != "alice.jpg" {
+		t.Fatal("timeline的顺序不对", timeline)
+	}
+
+	followers, _, err := service.FollowerList(ctx, alice.ID, weibo.Page{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(followers) != 1 || followers[0].ID != bob.ID {
+		t.Fatal("粉丝列表不对", followers)
+	}
+
+	// 请求已经取消时不会保存
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	err = service.PublishWeibo(canceled, alice, &weibo.Weibo{UserID: alice.ID, Account: alice.Account, Content: "取消", CreatedAt: time.Now().Unix()})
+	if errors.Cause(err) != context.Canceled {
+		t.Fatal("请求取消后应该返回context.Canceled", err)
+	}
+	weibos, _, err := service.SearchWeibo(ctx, "取消", weibo.Page{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(weibos) != 0 {
+		t.Fatal("取消的请求不应该保存微博", weibos)
+	}
+}
+
+// 粉丝数降到阈值以下之后, 以拉模式发布的微博仍然在粉丝的首页中
+func TestPullTimeLine(t *testing.T) {
+	ctx := context.Background()
+	service, _, _ := newMemoryService(t)
+	service.PullThreshold = 2
+
+	users := map[string]*weibo.User{}
+	for _, account := range []string{"star", "bob", "carol"} {
+		user, err := service.Register(ctx, account, account+".jpg", "123456")
+		if err != nil {
+			t.Fatal(err)
+		}
+		users[account] = user
+	}
+	star, bob := users["star"], users["bob"]
+	for _, fan := range []*weibo.User{bob, users["carol"]} {
+		if err := service.Follow(ctx, fan, star.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pulled := &weibo.Weibo{UserID: star.ID, Account: star.Account, Content: "拉模式发的", CreatedAt: time.Now().Unix() - 10}
+	if err := service.PublishWeibo(ctx, star, pulled); err != nil {
+		t.Fatal(err)
+	}
+	if !pulled.Pulled {
+		t.Fatal("粉丝数达到阈值时应该以拉模式发布")
+	}
+
+	if err := service.UnFollow(ctx, users["carol"], star.ID); err != nil {
+		t.Fatal(err)
+	}
+	pushed := &weibo.Weibo{UserID: star.ID, Account: star.Account, Content: "推模式发的", CreatedAt: time.Now().Unix()}
+	if err := service.PublishWeibo(ctx, star, pushed); err != nil {
+		t.Fatal(err)
+	}
+	if pushed.Pulled {
+		t.Fatal("粉丝数低于阈值时应该推送")
+	}
+
+	timeline := waitTimeline(t, service, bob, func(weibos []*weibo.WeiboWithUser) bool { return len(weibos) == 2 })
+	if timeline[0].ID != pushed.ID || timeline[1].ID != pulled.ID {
+		t.Fatal("拉模式发布的微博不应该消失", timeline)
+	}
+
+	// 关闭拉模式之后也能读到之前没有推送的微博
+	service.PullThreshold = 0
+	timeline = waitTimeline(t, service, bob, func(weibos []*weibo.WeiboWithUser) bool { return len(weibos) == 2 })
+	if timeline[1].ID != pulled.ID {
+		t.Fatal("关闭拉模式之后拉模式发布的微博不应该消失", timeline)
+	}
+}
+
+func TestRepost(t *testing.T) {
+	ctx := context.Background()
+	service, _, _ := newMemoryService(t)
+	register := func(account string) *weibo.User {
+		user, err := service.Register(ctx, account, account+".jpg", "123456")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return user
+	}
+	alice, bob, carol := register("alice"), register("bob"), register("carol")
+	if err := service.Follow(ctx, carol, bob.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	original := &weibo.Weibo{UserID: alice.ID, Account: alice.Account, Content: "原创", CreatedAt: time.Now().Unix() - 10}
+	if err := service.PublishWeibo(ctx, alice, original); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.Repost(ctx, bob, original.ID+1, ""); err != weibo.ErrWeiboNotFound {
+		t.Fatal("转发不存在的微博应该返回ErrWeiboNotFound", err)
+	}
+
+	// 转发的评论可以为空
+	repost, err := service.Repost(ctx, bob, original.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repost.RepostOfID != original.ID || repost.RepostRootID != original.ID {
+		t.Fatal("转发的微博没有记录原微博", repost)
+	}
+	// 转发一条转发的微博, 转发链的起点不变
+	chained, err := service.Repost(ctx, carol, repost.ID, "转发的转发")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chained.RepostOfID != repost.ID || chained.RepostRootID != original.ID {
+		t.Fatal("转发链记录的不对", chained)
+	}
+
+	// 转发推送给了转发者的粉丝, 并带上原微博
+	timeline := waitTimeline(t, service, carol, func(weibos []*weibo.WeiboWithUser) bool { return len(weibos) == 2 })
+	if timeline[0].ID != chained.ID || timeline[1].ID != repost.ID {
+		t.Fatal("转发的微博没有推送给粉丝", timeline)
+	}
+	root := timeline[1].RepostOf
+	if root == nil || root.ID != original.ID || root.Avatar != "alice.jpg" || root.RepostNum != 2 {
+		t.Fatal("转发的微博没有带上原微博, 或者原微博的转发数不对", root)
+	}
+	if timeline[0].RepostOf == nil || timeline[0].RepostOf.ID != original.ID {
+		t.Fatal("转发链中的微博应该带上最初的原创微博", timeline[0].RepostOf)
+	}
+
+	// 删除转发的微博时减少转发数
+	if err := service.DeleteWeibo(ctx, carol, chained.ID); err != nil {
+		t.Fatal(err)
+	}
+	// 删除原微博后转发的微博保留
+	if err := service.DeleteWeibo(ctx, alice, original.ID); err != nil {
+		t.Fatal(err)
+	}
+	timeline = waitTimeline(t, service, carol, func(weibos []*weibo.WeiboWithUser) bool { return len(weibos) == 1 })
+	if timeline[0].ID != repost.ID || timeline[0].RepostNum != 0 || timeline[0].RepostOf != nil {
+		t.Fatal("删除后的转发不对", timeline[0])
+	}
+	if _, err := service.Repost(ctx, bob, original.ID, ""); err != weibo.ErrWeiboNotFound {
+		t.Fatal("原微博删除后不能再转发", err)
+	}
+}
+
+func TestCommentThreads(t *testing.T) {
+	ctx := context.Background()
+	service, _, _ := newMemoryService(t)
+	alice, err := service.Register(ctx, "alice", "alice.jpg", "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := service.Register(ctx, "bob", "bob.jpg", "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &weibo.Weibo{UserID: alice.ID, Account: alice.Account, Content: "hello", CreatedAt: time.Now().Unix()}
+	if err := service.PublishWeibo(ctx, alice, w); err != nil {
+		t.Fatal(err)
+	}
+	commentNum := func() int32 {
+		t.Helper()
+		_, weibos, _, err := service.FollowersShow(ctx, alice, weibo.Page{Limit: 10})
+		if err != nil || len(weibos) != 1 {
+			t.Fatal("查询微博失败", weibos, err)
+		}
+		return weibos[0].CommentNum
+	}
+
+	if _, err := service.PostComment(ctx, bob, w.ID+1, "评论"); err != weibo.ErrWeiboNotFound {
+		t.Fatal("评论不存在的微博应该返回ErrWeiboNotFound", err)
+	}
+	first, err := service.PostComment(ctx, bob, w.ID, "沙发")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := service.PostComment(ctx, alice, w.ID, "板凳")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := service.ReplyComment(ctx, alice, first.ID, "回复沙发")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 回复楼中的回复, 仍然在同一个楼层
+	replyToReply, err := service.ReplyComment(ctx, bob, reply.ID, "回复的回复")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replyToReply.ParentID != reply.ID || replyToReply.RootID != first.ID || replyToReply.WeiboID != w.ID {
+		t.Fatal("回复的楼层不对", replyToReply)
+	}
+	if n := commentNum(); n != 4 {
+		t.Fatal("评论数不对", n)
+	}
+
+	hot, _, err := service.CommentList(ctx, w.ID, weibo.CommentSortHot, weibo.Page{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hot) != 2 || hot[0].ID != first.ID || hot[0].ReplyNum != 2 || hot[0].Account != "bob" || hot[1].ID != second.ID {
+		t.Fatal("按回复数排序的评论不对", hot)
+	}
+	// 第一页取满时返回下一页的游标
+	page, next, err := service.CommentList(ctx, w.ID, weibo.CommentSortHot, weibo.Page{Limit: 1})
+	if err != nil || len(page) != 1 || next == "" {
+		t.Fatal("第一页的评论不对", page, next, err)
+	}
+	nextPage, err := weibo.ParsePage(next, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _, err = service.CommentList(ctx, w.ID, weibo.CommentSortHot, nextPage)
+	if err != nil || len(page) != 1 || page[0].ID != second.ID {
+		t.Fatal("第二页的评论不对", page, err)
+	}
+
+	// 从楼中的回复进入详情页时显示整个楼层
+	root, replies, _, err := service.CommentDetail(ctx, reply.ID, weibo.Page{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.ID != first.ID || root.Avatar != "bob.jpg" || len(replies) != 2 || replies[0].ID != replyToReply.ID {
+		t.Fatal("评论详情不对", root, replies)
+	}
+
+	if err := service.DeleteComment(ctx, alice, replyToReply.ID); err != weibo.ErrNotCommentOwner {
+		t.Fatal("不能删除别人的评论", err)
+	}
+	if err := service.DeleteComment(ctx, bob, replyToReply.ID); err != nil {
+		t.Fatal(err)
+	}
+	root, _, _, err = service.CommentDetail(ctx, first.ID, weibo.Page{Limit: 10})
+	if err != nil || root.ReplyNum != 1 {
+		t.Fatal("删除回复后楼中的回复数不对", root, err)
+	}
+	// 删除楼层的第一条评论时楼中的回复一起删除
+	if err := service.DeleteComment(ctx, bob, first.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := service.CommentDetail(ctx, reply.ID, weibo.Page{Limit: 10}); err != weibo.ErrCommentNotFound {
+		t.Fatal("楼中的回复应该一起删除", err)
+	}
+	if n := commentNum(); n != 1 {
+		t.Fatal("删除后的评论数不对", n)
+	}
+}
+
+func TestTopics(t *testing.T) {
+	ctx := context.Background()
+	service, _, _ := newMemoryService(t)
+	alice, err := service.Register(ctx, "alice", "alice.jpg", "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := &weibo.Weibo{UserID: alice.ID, Account: alice.Account, Content: "#周末# 去爬山", CreatedAt: time.Now().Unix() - 10}
+	if err := service.PublishWeibo(ctx, alice, first); err != nil {
+		t.Fatal(err)
+	}
+	second := &weibo.Weibo{UserID: alice.ID, Account: alice.Account, Content: "#周末##天气# 下雨了", CreatedAt: time.Now().Unix()}
+	if err := service.PublishWeibo(ctx, alice, second); err != nil {
+		t.Fatal(err)
+	}
+	// 转发时附带的评论中的话题也会保存
+	repost, err := service.Repost(ctx, alice, first.ID, "#天气# 还是晴天好")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	topic, weibos, _, err := service.TopicWeibos(ctx, "天气", weibo.Page{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if topic.WeiboNum != 2 || len(weibos) != 2 || weibos[0].ID != repost.ID || weibos[1].ID != second.ID {
+		t.Fatal("话题下的微博不对", topic, weibos)
+	}
+	if weibos[0].RepostOf == nil || weibos[0].RepostOf.ID != first.ID {
+		t.Fatal("话题下转发的微博应该带上原微博", weibos[0].RepostOf)
+	}
+
+	top, err := service.TopTopics(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(top) != 2 {
+		t.Fatal("热门话题不对", top)
+	}
+
+	if err := service.DeleteWeibo(ctx, alice, second.ID); err != nil {
+		t.Fatal(err)
+	}
+	topic, weibos, _, err = service.TopicWeibos(ctx, "周末", weibo.Page{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if topic.WeiboNum != 1 || len(weibos) != 1 || weibos[0].ID != first.ID {
+		t.Fatal("删除微博后话题下的微博不对", topic, weibos)
+	}
+
+	if _, _, _, err := service.TopicWeibos(ctx, "不存在", weibo.Page{Limit: 10}); err != weibo.ErrTopicNotFound {
+		t.Fatal("不存在的话题应该返回ErrTopicNotFound", err)
+	}
+}
+
+func TestTrends(t *testing.T) {
+	ctx := context.Background()
+	service, trends, _ := newMemoryService(t)
+	alice, err := service.Register(ctx, "alice", "alice.jpg", "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := service.Register(ctx, "bob", "bob.jpg", "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	liked := &weibo.Weibo{UserID: alice.ID, Account: alice.Account, Content: "#周末# 去爬山", CreatedAt: time.Now().Unix()}
+	if err := service.PublishWeibo(ctx, alice, liked); err != nil {
+		t.Fatal(err)
+	}
+	commented := &weibo.Weibo{UserID: alice.ID, Account: alice.Account, Content: "#周末# #天气# 下雨了", CreatedAt: time.Now().Unix()}
+	if err := service.PublishWeibo(ctx, alice, commented); err != nil {
+		t.Fatal(err)
+	}
+	deleted := &weibo.Weibo{UserID: alice.ID, Account: alice.Account, Content: "会被删除", CreatedAt: time.Now().Unix()}
+	if err := service.PublishWeibo(ctx, alice, deleted); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.Givelike(ctx, bob, liked.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.PostComment(ctx, bob, commented.ID, "评论"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.Repost(ctx, bob, deleted.ID, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.DeleteWeibo(ctx, alice, deleted.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := trends.Recompute(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	topics, err := service.TrendingTopics(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(topics) != 2 || topics[0].Name != "周末" || topics[0].Score != 2 || topics[1].Name != "天气" {
+		t.Fatal("热门话题不对", topics)
+	}
+
+	// 评论比点赞的热度高, 已经删除的微博不会出现
+	weibos, err := service.HotWeibos(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(weibos) != 2 || weibos[0].ID != commented.ID || weibos[1].ID != liked.ID {
+		t.Fatal("热门微博不对", weibos)
+	}
+}
+
+func TestMentions(t *testing.T) {
+	ctx := context.Background()
+	service, _, _ := newMemoryService(t)
+	alice, err := service.Register(ctx, "alice", "alice.jpg", "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := service.Register(ctx, "bob", "bob.jpg", "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 不存在的账号和自己不记录
+	mentioned := &weibo.Weibo{UserID: alice.ID, Account: alice.Account, Content: "@bob @alice @nobody 周末去爬山", CreatedAt: time.Now().Unix() - 10}
+	if err := service.PublishWeibo(ctx, alice, mentioned); err != nil {
+		t.Fatal(err)
+	}
+	comment, err := service.PostComment(ctx, alice, mentioned.ID, "@bob 别忘了")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted := &weibo.Weibo{UserID: alice.ID, Account: alice.Account, Content: "@bob 会被删除", CreatedAt: time.Now().Unix()}
+	if err := service.PublishWeibo(ctx, alice, deleted); err != nil {
+		t.Fatal(err)
+	}
+
+	items, _, err := service.Mentions(ctx, bob, weibo.Page{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 3 || items[1].Comment == nil || items[1].Comment.ID != comment.ID || items[1].Weibo.ID != mentioned.ID {
+		t.Fatal("提到我的微博和评论不对", items)
+	}
+	items, _, err = service.Mentions(ctx, alice, weibo.Page{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 0 {
+		t.Fatal("提到自己不应该记录", items)
+	}
+
+	if err := service.DeleteWeibo(ctx, alice, deleted.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.DeleteComment(ctx, alice, comment.ID); err != nil {
+		t.Fatal(err)
+	}
+	items, _, err = service.Mentions(ctx, bob, weibo.Page{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Weibo.ID != mentioned.ID || items[0].Comment != nil {
+		t.Fatal("删除的微博和评论不应该出现在提到我的列表中", items)
+	}
+
+	if _, err := service.UserProfile(ctx, "nobody"); err != weibo.ErrUserNotFound {
+		t.Fatal("不存在的账号应该返回ErrUserNotFound", err)
+	}
+}
+
+func TestNotifications(t *testing.T) {
+	ctx := context.Background()
+	service, _, _ := newMemoryService(t)
+	alice, err := service.Register(ctx, "alice", "alice.jpg", "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := service.Register(ctx, "bob", "bob.jpg", "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	carol, err := service.Register(ctx, "carol", "carol.jpg", "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := &weibo.Weibo{UserID: alice.ID, Account: alice.Account, Content: "周末去爬山", CreatedAt: time.Now().Unix()}
+	if err := service.PublishWeibo(ctx, alice, w); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.Follow(ctx, bob, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.Givelike(ctx, bob, w.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.Givelike(ctx, carol, w.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.Collect(ctx, bob, w.ID); err != nil {
+		t.Fatal(err)
+	}
+	// 自己评论自己的微博不通知
+	if _, err := service.PostComment(ctx, alice, w.ID, "@bob 一起去"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.PostComment(ctx, carol, w.ID, "好啊"); err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := service.UnreadNotificationCount(ctx, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 4 {
+		t.Fatal("未读通知数不对", count)
+	}
+	items, _, err := service.Notifications(ctx, alice, weibo.Page{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	texts := map[weibo.NotificationKind]string{}
+	for _, item := range items {
+		if item.Read {
+			t.Fatal("新的通知应该是未读的", item)
+		}
+		texts[item.Kind] = item.Text
+	}
+	want := map[weibo.NotificationKind]string{
+		weibo.NotifyFollow:  "bob关注了你",
+		weibo.NotifyLike:    "carol等2人赞了你的微博",
+		weibo.NotifyCollect: "bob收藏了你的微博",
+		weibo.NotifyComment: "carol评论了你的微博",
+	}
+	if !reflect.DeepEqual(texts, want) {
+		t.Fatal("通知的文字不对", texts)
+	}
+
+	items, _, err = service.Notifications(ctx, bob, weibo.Page{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Kind != weibo.NotifyMention || items[0].TargetID != w.ID {
+		t.Fatal("被提到的用户应该收到通知", items)
+	}
+
+	if err := service.MarkNotificationsRead(ctx, alice); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := service.UnreadNotificationCount(ctx, alice); err != nil || count != 0 {
+		t.Fatal("标记已读之后未读通知数应该为0", count, err)
+	}
+}
+
+func TestMessages(t *testing.T) {
+	ctx := context.Background()
+	service, _, _ := newMemoryService(t)
+	alice, err := service.Register(ctx, "alice", "alice.jpg", "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := service.Register(ctx, "bob", "bob.jpg", "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := service.SendMessage(ctx, alice, alice.ID, "你好"); err != weibo.ErrMessageSelf {
+		t.Fatal("不能给自己发私信", err)
+	}
+	if _, err := service.SendMessage(ctx, alice, bob.ID+1000, "你好"); err != weibo.ErrUserNotFound {
+		t.Fatal("不能给不存在的用户发私信", err)
+	}
+
+	// 只接收粉丝的私信时需要先关注
+	if err := service.SetMessagePermission(ctx, bob, "followers"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.SendMessage(ctx, alice, bob.ID, "你好"); err != weibo.ErrMessageDenied {
+		t.Fatal("没有关注时应该拒绝私信", err)
+	}
+	if err := service.Follow(ctx, alice, bob.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.SendMessage(ctx, alice, bob.ID, "你好"); err != nil {
+		t.Fatal(err)
+	}
+	// bob没有关注alice, 但alice接收所有人的私信
+	if _, err := service.SendMessage(ctx, bob, alice.ID, "你好呀"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.SendMessage(ctx, alice, bob.ID, "周末有空吗"); err != nil {
+		t.Fatal(err)
+	}
+
+	if count, err := service.UnreadMessageCount(ctx, bob); err != nil || count != 2 {
+		t.Fatal("未读私信数不对", count, err)
+	}
+	conversations, _, err := service.Conversations(ctx, bob, weibo.Page{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conversations) != 1 || conversations[0].PeerAccount != "alice" || conversations[0].LastContent != "周末有空吗" || conversations[0].UnreadNum != 2 {
+		t.Fatal("会话不对", conversations)
+	}
+
+	peer, messages, _, err := service.Messages(ctx, bob, alice.ID, weibo.Page{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peer.ID != alice.ID || len(messages) != 3 || messages[0].Content != "周末有空吗" || messages[1].FromUserID != bob.ID {
+		t.Fatal("私信不对", messages)
+	}
+
+	if err := service.MarkConversationRead(ctx, bob, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := service.UnreadMessageCount(ctx, bob); err != nil || count != 0 {
+		t.Fatal("标记已读之后未读私信数应该为0", count, err)
+	}
+	if count, err := service.UnreadMessageCount(ctx, alice); err != nil || count != 1 {
+		t.Fatal("对方的未读私信数不对", count, err)
+	}
+}
+
+func TestBlocks(t *testing.T) {
+	ctx := context.Background()
+	service, _, _ := newMemoryService(t)
+	users := map[string]*weibo.User{}
+	for _, account := range []string{"alice", "bob", "carol"} {
+		user, err := service.Register(ctx, account, account+".jpg", "123456")
+		if err != nil {
+			t.Fatal(err)
+		}
+		users[account] = user
+	}
+	alice, bob, carol := users["alice"], users["bob"], users["carol"]
+	for _, f := range [][2]*weibo.User{{alice, bob}, {bob, alice}, {alice, carol}} {
+		if err := service.Follow(ctx, f[0], f[1].ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	aliceWeibo := &weibo.Weibo{UserID: alice.ID, Account: alice.Account, Content: "alice的微博", CreatedAt: time.Now().Unix()}
+	if err := service.PublishWeibo(ctx, alice, aliceWeibo); err != nil {
+		t.Fatal(err)
+	}
+	bobWeibo := &weibo.Weibo{UserID: bob.ID, Account: bob.Account, Content: "bob的微博", CreatedAt: time.Now().Unix()}
+	if err := service.PublishWeibo(ctx, bob, bobWeibo); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.Repost(ctx, carol, bobWeibo.ID, "转发bob"); err != nil {
+		t.Fatal(err)
+	}
+	waitTimeline(t, service, alice, func(weibos []*weibo.WeiboWithUser) bool { return len(weibos) == 3 })
+
+	// 屏蔽之前bob提到alice和点赞的记录, 屏蔽之后在读取时过滤
+	if err := service.PublishWeibo(ctx, bob, &weibo.Weibo{UserID: bob.ID, Account: bob.Account, Content: "@alice 看这里", CreatedAt: time.Now().Unix()}); err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range []*weibo.User{carol, bob} {
+		if err := service.Givelike(ctx, user, aliceWeibo.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := service.Block(ctx, alice, alice.ID); err != weibo.ErrBlockSelf {
+		t.Fatal("不能屏蔽自己", err)
+	}
+	if err := service.Block(ctx, alice, bob.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.Block(ctx, alice, bob.ID); err != weibo.ErrAlreadyBlocked {
+		t.Fatal("重复屏蔽应该返回ErrAlreadyBlocked", err)
+	}
+
+	// 两个方向的关注都解除了
+	for account, want := range map[string][2]int32{"alice": {1, 0}, "bob": {0, 0}} {
+		user, err := service.UserProfile(ctx, account)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.FollowingNum != want[0] || user.FollowerNum != want[1] {
+			t.Fatal("屏蔽之后的关注数和粉丝数不对", account, user.FollowingNum, user.FollowerNum)
+		}
+	}
+
+	// bob的微博和转发bob的微博都不在timeline中
+	_, weibos, _, err := service.FollowersShow(ctx, alice, weibo.Page{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(weibos) != 1 || weibos[0].ID != aliceWeibo.ID {
+		t.Fatal("屏蔽之后的timeline不对", weibos)
+	}
+
+	if err := service.Follow(ctx, bob, alice.ID); err != weibo.ErrBlocked {
+		t.Fatal("被屏蔽之后不能关注", err)
+	}
+	if err := service.Givelike(ctx, bob, aliceWeibo.ID); err != weibo.ErrBlocked {
+		t.Fatal("被屏蔽之后不能点赞", err)
+	}
+	if _, err := service.PostComment(ctx, bob, aliceWeibo.ID, "评论"); err != weibo.ErrBlocked {
+		t.Fatal("被屏蔽之后不能评论", err)
+	}
+	if _, err := service.Repost(ctx, bob, aliceWeibo.ID, ""); err != weibo.ErrBlocked {
+		t.Fatal("被屏蔽之后不能转发", err)
+	}
+	if _, err := service.SendMessage(ctx, alice, bob.ID, "你好"); err != weibo.ErrBlocked {
+		t.Fatal("屏蔽对方之后也不能给对方发私信", err)
+	}
+
+	// 提到alice不会产生记录
+	mention := &weibo.Weibo{UserID: bob.ID, Account: bob.Account, Content: "你好 @alice", CreatedAt: time.Now().Unix()}
+	if err := service.PublishWeibo(ctx, bob, mention); err != nil {
+		t.Fatal(err)
+	}
+	items, _, err := service.Mentions(ctx, alice, weibo.Page{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 0 {
+		t.Fatal("被屏蔽的人提到自己不应该有记录", items)
+	}
+	notifications, _, err := service.Notifications(ctx, alice, weibo.Page{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notifications) != 1 || notifications[0].Text != "carol赞了你的微博" {
+		t.Fatal("屏蔽之后的通知不对", notifications)
+	}
+
+	// 按当前用户过滤搜索结果, 其他人不受影响
+	viewer := weibo.WithViewer(ctx, alice)
+	found, _, err := service.SearchUser(viewer, "bob", weibo.Page{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 0 {
+		t.Fatal("搜索结果中不应该有屏蔽的人", found)
+	}
+	results, _, err := service.SearchWeibo(viewer, "bob的微博", weibo.Page{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 0 {
+		t.Fatal("搜索结果中不应该有屏蔽的人的微博", results)
+	}
+	found, _, err = service.SearchUser(weibo.WithViewer(ctx, carol), "bob", weibo.Page{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 {
+		t.Fatal("没有屏蔽关系的人应该能搜到", found)
+	}
+
+	blocked, _, err := service.BlockedUsers(ctx, alice, weibo.Page{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocked) != 1 || blocked[0].ID != bob.ID || blocked[0].Account != "bob" {
+		t.Fatal("屏蔽列表不对", blocked)
+	}
+
+	if err := service.Unblock(ctx, alice, bob.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.Unblock(ctx, alice, bob.ID); err != weibo.ErrNotBlocked {
+		t.Fatal("没有屏蔽时应该返回ErrNotBlocked", err)
+	}
+	if err := service.Follow(ctx, bob, alice.ID); err != nil {
+		t.Fatal("取消屏蔽之后可以重新关注", err)
+	}
+}
+
+func TestMutes(t *testing.T) {
+	ctx := context.Background()
+	service, _, _ := newMemoryService(t)
+	users := map[string]*weibo.User{}
+	for _, account := range []string{"alice", "bob", "carol"} {
+		user, err := service.Register(ctx, account, account+".jpg", "123456")
+		if err != nil {
+			t.Fatal(err)
+		}
+		users[account] = user
+	}
+	alice, bob, carol := users["alice"], users["bob"], users["carol"]
+	for _, f := range [][2]*weibo.User{{alice, bob}, {alice, carol}, {bob, alice}} {
+		if err := service.Follow(ctx, f[0], f[1].ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	aliceWeibo := &weibo.Weibo{UserID: alice.ID, Account: alice.Account, Content: "alice的微博", CreatedAt: time.Now().Unix()}
+	bobWeibo := &weibo.Weibo{UserID: bob.ID, Account: bob.Account, Content: "bob的微博", CreatedAt: time.Now().Unix()}
+	carolWeibo := &weibo.Weibo{UserID: carol.ID, Account: carol.Account, Content: "前方剧透", CreatedAt: time.Now().Unix()}
+	for _, w := range []*weibo.Weibo{aliceWeibo, bobWeibo, carolWeibo} {
+		if err := service.PublishWeibo(ctx, users[w.Account], w); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitTimeline(t, service, alice, func(weibos []*weibo.WeiboWithUser) bool { return len(weibos) == 3 })
+
+	if _, err := service.MuteUser(ctx, alice, alice.ID, 0); err != weibo.ErrMuteSelf {
+		t.Fatal("不能静音自己", err)
+	}
+	if _, err := service.MuteKeyword(ctx, alice, "  ", 0); err != weibo.ErrInvalidMuteKeyword {
+		t.Fatal("关键词不能为空", err)
+	}
+	if _, err := service.MuteUser(ctx, alice, bob.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.MuteUser(ctx, alice, bob.ID, 24*time.Hour); err != weibo.ErrAlreadyMuted {
+		t.Fatal("重复静音应该返回ErrAlreadyMuted", err)
+	}
+	keyword, err := service.MuteKeyword(ctx, alice, "剧透", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyword.ExpiresAt != keyword.CreatedAt+24*3600 {
+		t.Fatal("静音的过期时间不对", keyword)
+	}
+
+	// 关注关系不变, 首页中不再显示
+	_, weibos, _, err := service.FollowersShow(ctx, alice, weibo.Page{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(weibos) != 1 || weibos[0].ID != aliceWeibo.ID || weibos[0].Account != "alice" {
+		t.Fatal("静音之后的首页不对", weibos)
+	}
+	if profile, err := service.UserProfile(ctx, "alice"); err != nil || profile.FollowingNum != 2 {
+		t.Fatal("静音不应该取消关注", profile, err)
+	}
+
+	// 静音之前bob关注alice的通知也不显示, 之后bob的点赞和包含关键词的评论不产生通知
+	if err := service.Givelike(ctx, bob, aliceWeibo.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := service.PostComment(ctx, carol, aliceWeibo.ID, "我来剧透一下"); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := service.UnreadNotificationCount(ctx, alice); err != nil || count != 1 {
+		t.Fatal("静音之后不应该产生新的通知", count, err)
+	}
+	if _, err := service.PostComment(ctx, carol, aliceWeibo.ID, "写得好"); err != nil {
+		t.Fatal(err)
+	}
+	items, _, err := service.Notifications(ctx, alice, weibo.Page{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Kind != weibo.NotifyComment || items[0].ActorID != carol.ID {
+		t.Fatal("静音之后的通知不对", items)
+	}
+
+	mutes, err := service.Mutes(ctx, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mutes) != 2 || mutes[0].Keyword != "剧透" || mutes[1].MutedAccount != "bob" {
+		t.Fatal("静音列表不对", mutes)
+	}
+	service.MaxMutes = 2
+	if _, err := service.MuteKeyword(ctx, alice, "广告", 0); err != weibo.ErrTooManyMutes {
+		t.Fatal("超过数量限制时应该返回ErrTooManyMutes", err)
+	}
+
+	// 取消静音之后马上恢复
+	if err := service.Unmute(ctx, alice, mutes[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.Unmute(ctx, alice, mutes[1].ID); err != weibo.ErrMuteNotFound {
+		t.Fatal("已经取消的静音应该返回ErrMuteNotFound", err)
+	}
+	_, weibos, _, err = service.FollowersShow(ctx, alice, weibo.Page{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(weibos) != 2 || weibos[0].ID != bobWeibo.ID {
+		t.Fatal("取消静音之后的首页不对", weibos)
+	}
+}
+
+// 整页都被静音时从后面继续补齐, 游标跳过被过滤的微博
+func TestMutedTimeLinePage(t *testing.T) {
+	ctx := context.Background()
+	service, _, _ := newMemoryService(t)
+	users := map[string]*weibo.User{}
+	for _, account := range []string{"alice", "bob", "carol"} {
+		user, err := service.Register(ctx, account, account+".jpg", "123456")
+		if err != nil {
+			t.Fatal(err)
+		}
+		users[account] = user
+	}
+	alice, bob, carol := users["alice"], users["bob"], users["carol"]
+	for _, followee := range []*weibo.User{bob, carol} {
+		if err := service.Follow(ctx, alice, followee.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// bob的微博在前, carol的微博更新, 排在首页的第一页
+	bobWeibos := []*weibo.Weibo{}
+	for _, author := range []*weibo.User{bob, bob, bob, carol, carol, carol} {
+		w := &weibo.Weibo{UserID: author.ID, Account: author.Account, Content: author.Account + "的微博", CreatedAt: time.Now().Unix()}
+		if err := service.PublishWeibo(ctx, author, w); err != nil {
+			t.Fatal(err)
+		}
+		if author == bob {
+			bobWeibos = append(bobWeibos, w)
+		}
+	}
+	waitTimeline(t, service, alice, func(weibos []*weibo.WeiboWithUser) bool { return len(weibos) == 6 })
+	if _, err := service.MuteUser(ctx, alice, carol.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	_, weibos, next, err := service.FollowersShow(ctx, alice, weibo.Page{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(weibos) != 2 || weibos[0].ID != bobWeibos[2].ID || weibos[1].ID != bobWeibos[1].ID || next == "" {
+		t.Fatal("整页被静音时应该从后面补齐", weibos, next)
+	}
+	page, err := weibo.ParsePage(next, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, weibos, next, err = service.FollowersShow(ctx, alice, page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(weibos) != 1 || weibos[0].ID != bobWeibos[0].ID || next != "" {
+		t.Fatal("最后一页不对", weibos, next)
+	}
+
+	// 按页码翻页时也一样
+	_, weibos, next, err = service.FollowersShow(ctx, alice, weibo.Page{Offset: 2, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(weibos) != 2 || weibos[0].ID != bobWeibos[2].ID || next == "" {
+		t.Fatal("按页码翻页时应该从后面补齐", weibos, next)
+	}
+
+	// 扫描的条数达到上限时返回不满的一页, 从游标继续
+	service.TimelineScanLimit = 2
+	_, weibos, next, err = service.FollowersShow(ctx, alice, weibo.Page{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(weibos) != 0 || next == "" {
+		t.Fatal("扫描达到上限时应该返回游标", weibos, next)
+	}
+	page, err = weibo.ParsePage(next, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, weibos, next, err = service.FollowersShow(ctx, alice, page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(weibos) != 1 || weibos[0].ID != bobWeibos[2].ID || next == "" {
+		t.Fatal("从扫描停止的位置继续时不对", weibos, next)
+	}
+}
+
+// 静音聚合通知中的一部分用户时只去掉这些用户, 相关微博包含静音关键词的通知整条去掉
+func TestMutedNotificationActors(t *testing.T) {
+	ctx := context.Background()
+	service, _, _ := newMemoryService(t)
+	users := map[string]*weibo.User{}
+	for _, account := range []string{"alice", "bob", "carol"} {
+		user, err := service.Register(ctx, account, account+".jpg", "123456")
+		if err != nil {
+			t.Fatal(err)
+		}
+		users[account] = user
+	}
+	alice, bob, carol := users["alice"], users["bob"], users["carol"]
+
+	w := &weibo.Weibo{UserID: alice.ID, Account: alice.Account, Content: "新片观后感", CreatedAt: time.Now().Unix()}
+	if err := service.PublishWeibo(ctx, alice, w); err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range []*weibo.User{bob, carol} {
+		if err := service.Givelike(ctx, user, w.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := service.Follow(ctx, bob, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	likes := func() []*weibo.NotificationItem {
+		items, _, err := service.Notifications(ctx, alice, weibo.Page{Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := []*weibo.NotificationItem{}
+		for _, item := range items {
+			if item.Kind == weibo.NotifyLike {
+				found = append(found, item)
+			}
+		}
+		return found
+	}
+	if items := likes(); len(items) != 1 || items[0].Text != "carol等2人赞了你的微博" {
+		t.Fatal("聚合的通知不对", items)
+	}
+
+	// 静音最近的用户之后显示之前的用户
+	if _, err := service.MuteUser(ctx, alice, carol.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+	if items := likes(); len(items) != 1 || items[0].ActorID != bob.ID || items[0].Text != "bob赞了你的微博" {
+		t.Fatal("静音之后聚合的通知不对", items)
+	}
+
+	if _, err := service.MuteKeyword(ctx, alice, "观后感", 0); err != nil {
+		t.Fatal(err)
+	}
+	items, _, err := service.Notifications(ctx, alice, weibo.Page{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Kind != weibo.NotifyFollow {
+		t.Fatal("相关微博包含静音关键词的通知应该去掉", items)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	service, _, _ := newMemoryService(t)
+	alice, err := service.Register(ctx, "alice", "alice.jpg", "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := service.Register(ctx, "bob", "bob.jpg", "123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := service.Subscribe(ctx, alice, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next := func(typ weibo.EventType) *weibo.Event {
+		t.Helper()
+		select {
+		case event := <-events:
+			if event.Type != typ || event.UserID != alice.ID {
+				t.Fatal("收到的事件不对", event.Type, string(event.Data))
+			}
+			return event
+		case <-time.After(2 * time.Second):
+			t.Fatal("没有收到事件", typ)
+			return nil
+		}
+	}
+
+	if err := service.Follow(ctx, bob, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	next(weibo.EventNotification)
+	last := next(weibo.EventUnreadCount)
+	if string(last.Data) != `{"notifications":1,"messages":0}` {
+		t.Fatal("未读数不对", string(last.Data))
+	}
+
+	if _, err := service.SendMessage(ctx, bob, alice.ID, "你好"); err != nil {
+		t.Fatal(err)
+	}
+	if event := next(weibo.EventUnreadCount); string(event.Data) != `{"notifications":1,"messages":1}` {
+		t.Fatal("收到私信后的未读数不对", string(event.Data))
+	}
+
+	// 关注的人发布的微博由扩散任务推送
+	if err := service.Follow(ctx, alice, bob.ID); err != nil {
+		t.Fatal(err)
+	}
+	w := &weibo.Weibo{UserID: bob.ID, Account: bob.Account, Content: "hello", CreatedAt: time.Now().Unix()}
+	if err := service.PublishWeibo(ctx, bob, w); err != nil {
+		t.Fatal(err)
+	}
+	if event := next(weibo.EventTimeline); !strings.Contains(string(event.Data), `"weibo_id":"`+strconv.FormatInt(w.ID, 10)+`"`) {
+		t.Fatal("新微博事件不对", string(event.Data))
+	}
+
+	// 断线重连时补发错过的事件
+	resumed, err := service.Subscribe(ctx, alice, last.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []weibo.EventType
+	for len(types) < 2 {
+		select {
+		case event := <-resumed:
+			types = append(types, event.Type)
+		case <-time.After(2 * time.Second):
+			t.Fatal("没有补发事件", types)
+		}
+	}
+	if !reflect.DeepEqual(types, []weibo.EventType{weibo.EventUnreadCount, weibo.EventTimeline}) {
+		t.Fatal("补发的事件不对", types)
+	}
+
+	// 静音的关键词和首页一样不推送
+	if _, err := service.MuteKeyword(ctx, alice, "广告", 0); err != nil {
+		t.Fatal(err)
+	}
+	muted := &weibo.Weibo{UserID: bob.ID, Account: bob.Account, Content: "广告", CreatedAt: time.Now().Unix()}
+	shown := &weibo.Weibo{UserID: bob.ID, Account: bob.Account, Content: "正常的微博", CreatedAt: time.Now().Unix()}
+	for _, w := range []*weibo.Weibo{muted, shown} {
+		if err := service.PublishWeibo(ctx, bob, w); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if event := next(weibo.EventTimeline); !strings.Contains(string(event.Data), `"weibo_id":"`+strconv.FormatInt(shown.ID, 10)+`"`) {
+		t.Fatal("静音的微博不应该推送", string(event.Data))
+	}
+}
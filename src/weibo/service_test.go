@@ -1,41 +1,94 @@
 package weibo
 
-import "testing"
+import (
+	"context"
+	"testing"
+)
 
-type MockUserRepository struct{}
+type MockUserRepository struct {
+	users map[string]*User
+	// 用户id -> 粉丝id, 按id升序
+	followers map[int64][]int64
+}
 
-func (r *MockUserRepository) GetUserByAccount(account string) (*User, error) {
+func (r *MockUserRepository) GetUserByAccount(ctx context.Context, account string) (*User, error) {
 	if account == "exists" {
 		return &User{ID: 999, Account: "exists"}, nil
 	}
+	return r.users[account], nil
+}
+func (r *MockUserRepository) CreateUser(ctx context.Context, user *User) error {
+	user.ID = int64(len(r.users) + 1)
+	if r.users == nil {
+		r.users = map[string]*User{}
+	}
+	r.users[user.Account] = user
+	return nil
+}
+func (r *MockUserRepository) UpdatePassword(ctx context.Context, userID int64, password, salt string) error {
+	for _, user := range r.users {
+		if user.ID == userID {
+			user.Password = password
+			user.Salt = salt
+		}
+	}
+	return nil
+}
+func (r *MockUserRepository) UpdateMessagePermission(ctx context.Context, userID int64, permission MessagePermission) error {
+	return nil
+}
+func (r *MockUserRepository) GetUserByID(ctx context.Context, userID int64) (*User, error) {
+	return nil, nil
+}
+func (r *MockUserRepository) AddFollowingNumByUserID(ctx context.Context, userID int64, num int32) error {
+	return nil
+}
+func (r *MockUserRepository) AddFollowerNumByUserID(ctx context.Context, userID int64, num int32) error {
+	return nil
+}
+func (r *MockUserRepository) GetFollowing(ctx context.Context, fromUserID, toUserID int64) (*Following, error) {
 	return nil, nil
 }
-func (r *MockUserRepository) CreateUser(user *User) error {
-	user.ID = 1
+func (r *MockUserRepository) CreateFollowing(ctx context.Context, following *Following) error {
 	return nil
 }
-func (r *MockUserRepository) GetUserByID(userID int64) (*User, error)               { return nil, nil }
-func (r *MockUserRepository) AddFollowingNumByUserID(userID int64, num int32) error { return nil }
-func (r *MockUserRepository) AddFollowerNumByUserID(userID int64, num int32) error  { return nil }
-func (r *MockUserRepository) GetFollowing(fromUserID, toUserID int64) (*Following, error) {
+func (r *MockUserRepository) DeleteFollowing(ctx context.Context, following *Following) error {
+	return nil
+}
+func (r *MockUserRepository) AddWeiboNumByUserID(ctx context.Context, userID int64, num int32) error {
+	return nil
+}
+func (r *MockUserRepository) GetUserFollowers(ctx context.Context, userID int64) ([]*Following, error) {
+	return nil, nil
+}
+func (r *MockUserRepository) GetUserFollowers2(ctx context.Context, userID int64, page Page) ([]*Follower, error) {
+	return nil, nil
+}
+func (r *MockUserRepository) GetUserFollowerIDs(ctx context.Context, userID, afterID, limit int64) ([]int64, error) {
+	ids := []int64{}
+	for _, id := range r.followers[userID] {
+		if id > afterID && int64(len(ids)) < limit {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+func (r *MockUserRepository) GetUsersByAccount(ctx context.Context, account string, page Page) ([]*User, error) {
 	return nil, nil
 }
-func (r *MockUserRepository) CreateFollowing(following *Following) error          { return nil }
-func (r *MockUserRepository) DeleteFollowing(following *Following) error          { return nil }
-func (r *MockUserRepository) AddWeiboNumByUserID(userID int64, num int32) error   { return nil }
-func (r *MockUserRepository) GetUserFollowers(userID int64) ([]*Following, error) { return nil, nil }
-func (r *MockUserRepository) GetUsersByAccount(account string, offset, perPage int64) ([]*User, error) {
+func (r *MockUserRepository) GetUsersByAccounts(ctx context.Context, accounts []string) ([]*User, error) {
 	return nil, nil
 }
 
 func TestRegister(t *testing.T) {
-	service := NewService(&MockUserRepository{}, nil, nil)
-	_, err := service.Register("exists", "xxx.jpg", "123123")
-	if err == nil {
+	ctx := context.Background()
+	service := NewService(Dependencies{Repositories: Repositories{Users: &MockUserRepository{}}, Hasher: NewBcryptHasher(4)})
+	_, err := service.Register(ctx, "exists", "xxx.jpg", "123123")
+	if err != ErrAccountExists {
 		t.Fatal("账号是否重复的判断有问题")
 	}
 
-	user, err := service.Register("hc", "xxx.jpg", "123123")
+	user, err := service.Register(ctx, "hc", "xxx.jpg", "123123")
 	if err != nil {
 		t.Fatal("注册失败", err)
 	}
@@ -46,13 +99,22 @@ func TestRegister(t *testing.T) {
 }
 
 func TestLogin(t *testing.T) {
-	Service := NewService(&MockUserRepository{}, nil, nil)
-	_, err := Service.Login("exists", "...")
+	ctx := context.Background()
+	Service := NewService(Dependencies{Repositories: Repositories{Users: &MockUserRepository{}}, Hasher: NewBcryptHasher(4)})
+	_, err := Service.Login(ctx, "exists", "...")
 	if err == nil {
 		t.Fatal("账号不为空")
 	}
 
-	user, err := Service.Login(",,,", "...")
+	if _, err := Service.Register(ctx, ",,,", "xxx.jpg", "..."); err != nil {
+		t.Fatal("注册失败", err)
+	}
+
+	if _, err := Service.Login(ctx, ",,,", "xxx"); err != ErrWrongPassword {
+		t.Fatal("密码错误时不应该登录成功")
+	}
+
+	user, err := Service.Login(ctx, ",,,", "...")
 	if err != nil {
 		t.Fatal("登录失败", err)
 	}
@@ -64,12 +126,12 @@ func TestLogin(t *testing.T) {
 
 // func TestFollow(t *testing.T) {
 // 	Service := NewService(&MockUserRepository{}, nil, nil)
-// 	_, err := Service.Follow("exists", "...")
+// 	_, err := Service.Follow(ctx, "exists", "...")
 // 	if err == nil {
 // 		t.Fatal("账号不为空")
 // 	}
 
-// 	following, err := Service.Follow(user, targetUserID)
+// 	following, err := Service.Follow(ctx, user, targetUserID)
 // 	if err != nil {
 // 		t.Fatal("关注错误", err)
 // 	}
@@ -1,13 +1,45 @@
 package weibo
 
+import "sort"
+
 type TimeLine struct {
-	ID int64 `json:"id" db:"id"`
+	ID int64 `json:"id,string" db:"id"`
 	// timeline的拥有者的id
 	UserID int64 `json:"user_id" db:"user_id"`
 	// 微博发布者的id
 	WeiboUserID int64 `json:"weibo_user_id" db:"weibo_user_id"`
 	// weibo的id
-	WeiboID int64 `json:"weibo_id" db:"weibo_id"`
+	WeiboID int64 `json:"weibo_id,string" db:"weibo_id"`
 	// weibo的发布时间
 	WeiboCreatedAt int64 `json:"weibo_created_at" db:"weibo_created_at"`
 }
+
+// 粉丝数达到阈值的用户是拉模式账号: 发布的微博不推送到粉丝的timeline,
+// 而是在粉丝读取timeline时和推送过来的微博合并, threshold <= 0 时不启用拉模式
+// 是否推送在发布时按微博记录(Weibo.Pulled), 粉丝数之后的变化不影响已经发布的微博
+func IsPullAccount(user *User, threshold int32) bool {
+	return threshold > 0 && user.FollowerNum >= threshold
+}
+
+// 合并多个按发布时间倒序的timeline, 去掉重复的微博
+func MergeTimeLines(lists ...[]*TimeLine) []*TimeLine {
+	seen := map[int64]bool{}
+	merged := []*TimeLine{}
+	for _, list := range lists {
+		for _, timeline := range list {
+			if seen[timeline.WeiboID] {
+				continue
+			}
+			seen[timeline.WeiboID] = true
+			merged = append(merged, timeline)
+		}
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool {
+		if merged[i].WeiboCreatedAt != merged[j].WeiboCreatedAt {
+			return merged[i].WeiboCreatedAt > merged[j].WeiboCreatedAt
+		}
+		return merged[i].WeiboID > merged[j].WeiboID
+	})
+	return merged
+}
@@ -0,0 +1,27 @@
+package weibo
+
+import "testing"
+
+func TestMergeTimeLines(t *testing.T) {
+	pushed := []*TimeLine{
+		{WeiboID: 5, WeiboCreatedAt: 500},
+		{WeiboID: 3, WeiboCreatedAt: 300},
+		{WeiboID: 1, WeiboCreatedAt: 100},
+	}
+	pulled := []*TimeLine{
+		{WeiboID: 6, WeiboCreatedAt: 300},
+		{WeiboID: 3, WeiboCreatedAt: 300},
+		{WeiboID: 2, WeiboCreatedAt: 200},
+	}
+
+	merged := MergeTimeLines(pushed, pulled)
+	expected := []int64{5, 6, 3, 2, 1}
+	if len(merged) != len(expected) {
+		t.Fatal("合并后的条数不正确", len(merged))
+	}
+	for i, timeline := range merged {
+		if timeline.WeiboID != expected[i] {
+			t.Fatal("合并后的顺序不正确", i, timeline.WeiboID)
+		}
+	}
+}
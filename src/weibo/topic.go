@@ -0,0 +1,98 @@
+package weibo
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// 话题名最多的字数, 和topics表中name的长度一致
+const MaxTopicLength = 32
+
+// 话题, 微博内容中 #话题# 形式的标记
+type Topic struct {
+	ID        int64  `json:"id" db:"id"`
+	Name      string `json:"name" db:"name"`
+	WeiboNum  int32  `json:"weibo_num" db:"weibo_num"` // 冗余字段
+	CreatedAt int64  `json:"created_at" db:"created_at"`
+}
+
+// 微博和话题的关联, 冗余微博的发布时间用于话题下的微博按时间分页
+type WeiboTopic struct {
+	TopicID        int64 `json:"topic_id" db:"topic_id"`
+	WeiboID        int64 `json:"weibo_id,string" db:"weibo_id"`
+	WeiboCreatedAt int64 `json:"weibo_created_at" db:"weibo_created_at"`
+}
+
+// 微博内容中的一段, Topic不为空时这一段是 #Topic#, Mention不为空时这一段是 @Mention
+type ContentSegment struct {
+	Text    string
+	Topic   string
+	Mention string
+}
+
+// 把微博内容切分成普通文本、话题和提到的账号, 所有段的Text拼起来就是原来的内容
+// 话题中的@不算提到账号
+func SplitContent(content string) []ContentSegment {
+	segments := []ContentSegment{}
+	for _, segment := range splitTopics(content) {
+		if segment.Topic != "" {
+			segments = append(segments, segment)
+			continue
+		}
+		segments = append(segments, splitMentions(segment.Text)...)
+	}
+	return segments
+}
+
+// 两个#之间去掉首尾空白后不为空, 不超过MaxTopicLength个字并且不换行时才是话题
+func splitTopics(content string) []ContentSegment {
+	segments := []ContentSegment{}
+	text := 0 // 还没有切分出去的普通文本的开始位置
+	for i := 0; i < len(content); {
+		start := strings.IndexByte(content[i:], '#')
+		if start < 0 {
+			break
+		}
+		start += i
+		end := strings.IndexByte(content[start+1:], '#')
+		if end < 0 {
+			break
+		}
+		end += start + 1
+
+		name := strings.TrimSpace(content[start+1 : end])
+		if !validTopic(name) {
+			// 结尾的#可能是下一个话题的开始
+			i = end
+			continue
+		}
+		if start > text {
+			segments = append(segments, ContentSegment{Text: content[text:start]})
+		}
+		segments = append(segments, ContentSegment{Text: content[start : end+1], Topic: name})
+		text = end + 1
+		i = end + 1
+	}
+	if text < len(content) {
+		segments = append(segments, ContentSegment{Text: content[text:]})
+	}
+	return segments
+}
+
+func validTopic(name string) bool {
+	return len(name) > 0 && utf8.RuneCountInString(name) <= MaxTopicLength && !strings.ContainsAny(name, "\r\n")
+}
+
+// 微博内容中的话题, 去掉重复的, 按出现的顺序返回
+func ParseTopics(content string) []string {
+	seen := map[string]bool{}
+	names := []string{}
+	for _, segment := range SplitContent(content) {
+		if segment.Topic == "" || seen[segment.Topic] {
+			continue
+		}
+		seen[segment.Topic] = true
+		names = append(names, segment.Topic)
+	}
+	return names
+}
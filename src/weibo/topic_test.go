@@ -0,0 +1,38 @@
+package weibo
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTopics(t *testing.T) {
+	tests := []struct {
+		content string
+		topics  []string
+	}{
+		{"没有话题", []string{}},
+		{"#今天# 天气不错 #周末#", []string{"今天", "周末"}},
+		{"# 首尾空白 #", []string{"首尾空白"}},
+		{"#重复##重复#", []string{"重复"}},
+		// 空的和换行的不是话题, 结尾的#作为下一个话题的开始
+		{"## #a#", []string{"a"}},
+		{"#换\n行#话题#", []string{"话题"}},
+		{"#没有结尾", []string{}},
+		{"#" + strings.Repeat("长", MaxTopicLength+1) + "#", []string{}},
+	}
+	for _, test := range tests {
+		if got := ParseTopics(test.content); !reflect.DeepEqual(got, test.topics) {
+			t.Errorf("%q 中的话题应该是 %v, 实际是 %v", test.content, test.topics, got)
+		}
+	}
+}
+
+func TestSplitContent(t *testing.T) {
+	content := "开头 #话题# 结尾##"
+	segments := SplitContent(content)
+	want := []ContentSegment{{Text: "开头 "}, {Text: "#话题#", Topic: "话题"}, {Text: " 结尾##"}}
+	if !reflect.DeepEqual(segments, want) {
+		t.Fatal("切分的结果不对", segments)
+	}
+}
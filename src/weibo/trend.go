@@ -0,0 +1,251 @@
+package weibo
+
+import (
+	"context"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 热度排行榜的种类
+type TrendKind string
+
+const (
+	// 热门话题, 按话题被提到的次数计算
+	TrendTopics TrendKind = "topics"
+	// 热门微博, 按点赞、评论和转发计算
+	TrendWeibos TrendKind = "weibos"
+)
+
+// 排行榜中的一项, ID是话题或者微博的id
+type TrendScore struct {
+	ID    int64   `json:"id,string"`
+	Score float64 `json:"score"`
+}
+
+// 热门话题和热度
+type TrendingTopic struct {
+	*Topic
+	Score float64 `json:"score"`
+}
+
+// 保存热度计数和排行榜
+type TrendStore interface {
+	// 在时间桶中增加计数, 时间桶在ttl之后过期
+	Incr(ctx context.Context, kind TrendKind, bucket, id int64, delta float64, ttl time.Duration) error
+	// 按权重合并时间桶生成新的排行榜, 只保留前size名
+	Rebuild(ctx context.Context, kind TrendKind, buckets []int64, weights []float64, size int64) error
+	// 排行榜的前limit名, 按热度倒序
+	Top(ctx context.Context, kind TrendKind, limit int64) ([]*TrendScore, error)
+}
+
+// 热度排行: 行为计数按时间桶累加, 定期把滑动窗口内的时间桶按时间指数衰减后合并成排行榜
+type Trending struct {
+	store TrendStore
+
+	// 时间桶的长度
+	BucketSize time.Duration
+	// 滑动窗口的长度, 窗口之外的计数不再参与排行
+	Window time.Duration
+	// 热度衰减一半需要的时间
+	HalfLife time.Duration
+	// 重新计算排行榜的间隔
+	Interval time.Duration
+	// 排行榜保留的条数
+	Size int64
+
+	// 各种行为增加的热度
+	TopicWeight   float64
+	LikeWeight    float64
+	CommentWeight float64
+	RepostWeight  float64
+
+	now  func() time.Time
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewTrending(store TrendStore) *Trending {
+	return &Trending{
+		store:         store,
+		BucketSize:    5 * time.Minute,
+		Window:        24 * time.Hour,
+		HalfLife:      2 * time.Hour,
+		Interval:      time.Minute,
+		Size:          1000,
+		TopicWeight:   1,
+		LikeWeight:    1,
+		CommentWeight: 2,
+		RepostWeight:  3,
+		now:           time.Now,
+	}
+}
+
+// 话题被微博提到
+func (t *Trending) RecordTopics(ctx context.Context, topicIDs []int64) error {
+	return t.record(ctx, TrendTopics, topicIDs, t.TopicWeight)
+}
+
+// 微博被点赞
+func (t *Trending) RecordLike(ctx context.Context, weiboID int64) error {
+	return t.record(ctx, TrendWeibos, []int64{weiboID}, t.LikeWeight)
+}
+
+// 微博被评论
+func (t *Trending) RecordComment(ctx context.Context, weiboID int64) error {
+	return t.record(ctx, TrendWeibos, []int64{weiboID}, t.CommentWeight)
+}
+
+// 微博被转发
+func (t *Trending) RecordRepost(ctx context.Context, weiboIDs []int64) error {
+	return t.record(ctx, TrendWeibos, weiboIDs, t.RepostWeight)
+}
+
+func (t *Trending) record(ctx context.Context, kind TrendKind, ids []int64, weight float64) error {
+	bucket := t.bucket(t.now())
+	// 多保留一个桶, 重新计算时窗口最早的桶还没有过期
+	ttl := t.Window + t.BucketSize
+	for _, id := range ids {
+		if err := t.store.Incr(ctx, kind, bucket, id, weight, ttl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Trending) bucket(at time.Time) int64 {
+	return at.UnixNano() / int64(t.BucketSize)
+}
+
+// 重新计算所有排行榜, 越早的时间桶权重越小, 每过HalfLife减半
+func (t *Trending) Recompute(ctx context.Context) error {
+	current := t.bucket(t.now())
+	n := int64(t.Window / t.BucketSize)
+	if n < 1 {
+		n = 1
+	}
+	buckets := make([]int64, 0, n)
+	weights := make([]float64, 0, n)
+	for age := int64(0); age < n; age++ {
+		buckets = append(buckets, current-age)
+		weights = append(weights, math.Pow(0.5, float64(time.Duration(age)*t.BucketSize)/float64(t.HalfLife)))
+	}
+
+	for _, kind := range []TrendKind{TrendTopics, TrendWeibos} {
+		if err := t.store.Rebuild(ctx, kind, buckets, weights, t.Size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 排行榜的前limit名
+func (t *Trending) Top(ctx context.Context, kind TrendKind, limit int64) ([]*TrendScore, error) {
+	return t.store.Top(ctx, kind, limit)
+}
+
+// 在后台定期重新计算排行榜, 启动时先计算一次
+func (t *Trending) Start() {
+	t.stop = make(chan struct{})
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		ticker := time.NewTicker(t.Interval)
+		defer ticker.Stop()
+		for {
+			if err := t.Recompute(context.Background()); err != nil {
+				log.Printf("重新计算热度排行失败: %v\n", err)
+			}
+			select {
+			case <-t.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (t *Trending) Stop() {
+	close(t.stop)
+	t.wg.Wait()
+}
+
+// 进程内的热度计数, 用于单机运行和测试
+type MemoryTrendStore struct {
+	mu      sync.Mutex
+	buckets map[TrendKind]map[int64]map[int64]float64 // 时间桶 -> id -> 计数
+	boards  map[TrendKind][]*TrendScore
+}
+
+func NewMemoryTrendStore() *MemoryTrendStore {
+	return &MemoryTrendStore{
+		buckets: map[TrendKind]map[int64]map[int64]float64{},
+		boards:  map[TrendKind][]*TrendScore{},
+	}
+}
+
+func (s *MemoryTrendStore) Incr(ctx context.Context, kind TrendKind, bucket, id int64, delta float64, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.buckets[kind] == nil {
+		s.buckets[kind] = map[int64]map[int64]float64{}
+	}
+	if s.buckets[kind][bucket] == nil {
+		s.buckets[kind][bucket] = map[int64]float64{}
+	}
+	s.buckets[kind][bucket][id] += delta
+	return nil
+}
+
+// 不在buckets中的时间桶已经滑出窗口, 重新计算时删除
+func (s *MemoryTrendStore) Rebuild(ctx context.Context, kind TrendKind, buckets []int64, weights []float64, size int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	scores := map[int64]float64{}
+	inWindow := map[int64]bool{}
+	for i, bucket := range buckets {
+		inWindow[bucket] = true
+		for id, count := range s.buckets[kind][bucket] {
+			scores[id] += count * weights[i]
+		}
+	}
+	for bucket := range s.buckets[kind] {
+		if !inWindow[bucket] {
+			delete(s.buckets[kind], bucket)
+		}
+	}
+
+	board := make([]*TrendScore, 0, len(scores))
+	for id, score := range scores {
+		board = append(board, &TrendScore{ID: id, Score: score})
+	}
+	sort.Slice(board, func(i, j int) bool {
+		if board[i].Score != board[j].Score {
+			return board[i].Score > board[j].Score
+		}
+		return board[i].ID > board[j].ID
+	})
+	if int64(len(board)) > size {
+		board = board[:size]
+	}
+	s.boards[kind] = board
+	return nil
+}
+
+func (s *MemoryTrendStore) Top(ctx context.Context, kind TrendKind, limit int64) ([]*TrendScore, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	board := s.boards[kind]
+	if int64(len(board)) > limit {
+		board = board[:limit]
+	}
+	top := make([]*TrendScore, 0, len(board))
+	for _, score := range board {
+		copied := *score
+		top = append(top, &copied)
+	}
+	return top, nil
+}
@@ -0,0 +1,62 @@
+package weibo
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestTrending(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	trending := NewTrending(NewMemoryTrendStore())
+	trending.now = func() time.Time { return now }
+
+	// 两小时前的3次点赞衰减一半后不如现在的2次
+	for i := 0; i < 3; i++ {
+		if err := trending.RecordLike(ctx, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now = now.Add(trending.HalfLife)
+	for i := 0; i < 2; i++ {
+		if err := trending.RecordLike(ctx, 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := trending.RecordTopics(ctx, []int64{7}); err != nil {
+		t.Fatal(err)
+	}
+	if err := trending.Recompute(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	top, err := trending.Top(ctx, TrendWeibos, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(top) != 2 || top[0].ID != 2 || top[1].ID != 1 || math.Abs(top[1].Score-1.5) > 1e-9 {
+		t.Fatal("热门微博的排序或者衰减不对", top[0], top[1])
+	}
+	topics, err := trending.Top(ctx, TrendTopics, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(topics) != 1 || topics[0].ID != 7 {
+		t.Fatal("热门话题不对", topics)
+	}
+
+	// 滑出窗口后不再参与排行
+	now = now.Add(trending.Window)
+	if err := trending.Recompute(ctx); err != nil {
+		t.Fatal(err)
+	}
+	top, err = trending.Top(ctx, TrendWeibos, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(top) != 0 {
+		t.Fatal("窗口之外的计数不应该参与排行", top)
+	}
+}
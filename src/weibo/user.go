@@ -11,10 +11,14 @@ type User struct {
 	FollowerNum  int32  `json:"follower_num" db:"follower_num"`
 	WeiboNum     int32  `json:"weibo_num" db:"weibo_num"`
 	CreatedAt    int64  `json:"created_at" db:"created_at"`
+	// 谁可以给这个用户发私信
+	MessagePermission MessagePermission `json:"message_permission" db:"message_permission"`
 }
 
 type Follower struct {
 	ID      int64  `json:"id" db:"id"`
 	Account string `json:"account" db:"account"`
 	Avatar  string `json:"avatar" db:"avatar"`
+	// 关注的时间
+	FollowedAt int64 `json:"followed_at" db:"followed_at"`
 }
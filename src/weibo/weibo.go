@@ -1,16 +1,30 @@
 package weibo
 
 type Weibo struct {
-	ID         int64  `json:"id" db:"id"`
+	ID         int64  `json:"id,string" db:"id"`
 	UserID     int64  `json:"user_id" db:"user_id"`
 	Account    string `json:"account" db:"account"` // 冗余字段
 	Content    string `json:"content" db:"content"`
 	LikeNum    int32  `json:"like_num" db:"like_num"`
 	CommentNum int32  `json:"comment_num" db:"comment_num"` // 冗余字段
-	CreatedAt  int64  `json:"created_at" db:"created_at"`
+	RepostNum  int32  `json:"repost_num" db:"repost_num"`   // 冗余字段
+	// 转发的微博id, 原创的微博为0
+	RepostOfID int64 `json:"repost_of_id,string" db:"repost_of_id"`
+	// 转发链最开始的原创微博id, 原创的微博为0
+	RepostRootID int64 `json:"repost_root_id,string" db:"repost_root_id"`
+	// 发布时作者是拉模式账号, 没有推送到粉丝的timeline, 读取时合并
+	Pulled    bool  `json:"pulled" db:"pulled"`
+	CreatedAt int64 `json:"created_at" db:"created_at"`
+}
+
+// 是否是转发的微博
+func (w *Weibo) IsRepost() bool {
+	return w.RepostOfID != 0
 }
 
 type WeiboWithUser struct {
 	Weibo
 	Avatar string `json:"avatar" db:"avatar"`
+	// 转发的原创微博, 由服务在读取时填充, 原微博已经删除时为nil
+	RepostOf *WeiboWithUser `json:"repost_of,omitempty" db:"-"`
 }